@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/workset"
+	"github.com/spf13/cobra"
+)
+
+var adviseCmd = &cobra.Command{
+	Use:   "advise",
+	Short: "Recommend cache and read settings from the files mounts read",
+	Long: `Show the working set of the configured directory, the files mounts of it
+read in the last days as recorded with mount.working_set, and recommend
+cache.max_size, mount.stream_readahead, the files to read in blocks and
+mount.io_rules patterns of files worth keeping cached, as configuration to
+paste into the config file.
+
+A running mount saves what it records every few minutes and on unmount.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		days, _ := cmd.Flags().GetInt("days")
+		top, _ := cmd.Flags().GetInt("top")
+		if days <= 0 {
+			return fmt.Errorf("--days must be positive")
+		}
+
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		file, err := workset.Path(cfg.API.DirectoryID)
+		if err != nil {
+			return err
+		}
+		set, err := workset.Load(file)
+		if err != nil {
+			return err
+		}
+		if len(set.Files) == 0 {
+			return fmt.Errorf("no working set recorded for directory %s yet; mount it with mount.working_set on and use it for a while", cfg.API.DirectoryID)
+		}
+
+		now := time.Now()
+		a := workset.Advise(set, cfg, time.Duration(days)*24*time.Hour, now)
+		if a.Files == 0 {
+			return fmt.Errorf("no files of directory %s read in the last %d days", cfg.API.DirectoryID, days)
+		}
+
+		fmt.Printf("Working set of directory %s, last %d days (recorded since %s):\n",
+			cfg.API.DirectoryID, days, set.Since.Local().Format("2006-01-02"))
+		fmt.Printf("  %-8s %d (%s), opened %d times, %s read\n", "files:", a.Files, formatSize(a.Size), a.Opens, formatSize(a.Read))
+		if cfg.Cache.Enabled {
+			fmt.Printf("  %-8s %s of them kept in the content cache, cache.max_size is %s\n", "cache:", formatSize(a.Cached), formatSize(cfg.Cache.MaxSize))
+		}
+
+		if top > 0 {
+			fmt.Println("\nMost opened:")
+			fmt.Printf("  %6s %4s %9s %9s %5s  %s\n", "OPENS", "DAYS", "SIZE", "READ", "PART", "PATH")
+			for _, f := range a.Hot[:min(top, len(a.Hot))] {
+				fmt.Printf("  %6d %4d %9s %9s %4.0f%%  %s\n", f.Opens, f.Days, formatSize(f.Size), formatSize(f.BytesRead), f.Coverage()*100, f.Path)
+			}
+		}
+
+		fmt.Println()
+		advice := adviceConfig(cfg, a)
+		if advice == "" {
+			fmt.Println("The current settings fit the working set.")
+			return nil
+		}
+		fmt.Println("Recommended settings:")
+		fmt.Println()
+		fmt.Print(advice)
+		return nil
+	},
+}
+
+// adviceConfig returns the changes a suggests as configuration, with
+// the reasons as comments, or "" if it suggests none.
+func adviceConfig(cfg *config.Config, a workset.Advice) string {
+	var mount, cache strings.Builder
+
+	if a.Readahead > 0 {
+		fmt.Fprintf(&mount, "  # Large files are read %s in a row on average.\n", formatSize(a.ReadaheadRun))
+		fmt.Fprintf(&mount, "  stream_readahead: %d  # %s\n", a.Readahead, formatSize(a.Readahead))
+	}
+	if len(a.BlockExtensions) > 0 || a.BlockSize > 0 {
+		fmt.Fprintf(&mount, "  # Large files are read %s at a time here and there.\n", formatSize(a.BlockRun))
+		if a.BlockSize > 0 {
+			fmt.Fprintf(&mount, "  block_size: %d  # %s\n", a.BlockSize, formatSize(a.BlockSize))
+		}
+		if len(a.BlockExtensions) > 0 {
+			exts := append(append([]string(nil), cfg.Mount.BlockExtensions...), a.BlockExtensions...)
+			fmt.Fprintf(&mount, "  block_extensions: [%s]\n", strings.Join(exts, ", "))
+		}
+	}
+	if len(a.Patterns) > 0 {
+		mount.WriteString("  # Read on several days; kept cached while others are evicted.\n")
+		mount.WriteString("  io_rules:\n")
+		// The rules configured already come first, as they did.
+		for _, entry := range cfg.Mount.RawIORules {
+			patterns := make([]string, 0, len(entry))
+			for pattern := range entry {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			for _, pattern := range patterns {
+				fmt.Fprintf(&mount, "    - %q: %s\n", pattern, entry[pattern])
+			}
+		}
+		for _, pin := range a.Patterns {
+			fmt.Fprintf(&mount, "    - %q: cache-priority-high  # %d files, read on up to %d days\n", pin.Pattern, pin.Files, pin.Days)
+		}
+	}
+	if a.CacheSize > 0 {
+		fmt.Fprintf(&cache, "  # The working set takes %s of the cache.\n", formatSize(a.Cached))
+		fmt.Fprintf(&cache, "  max_size: %d  # %s\n", a.CacheSize, formatSize(a.CacheSize))
+	}
+
+	var b strings.Builder
+	if mount.Len() > 0 {
+		b.WriteString("mount:\n")
+		b.WriteString(mount.String())
+	}
+	if cache.Len() > 0 {
+		b.WriteString("cache:\n")
+		b.WriteString(cache.String())
+	}
+	return b.String()
+}
+
+func init() {
+	rootCmd.AddCommand(adviseCmd)
+	adviseCmd.Flags().Int("days", 7, "consider the files read in this many last days")
+	adviseCmd.Flags().Int("top", 10, "list this many of the most opened files, 0 for none")
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local content cache",
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export <archive>",
+	Short: "Write the cached files to an archive",
+	Long: `Write the files cached for the configured directory in cache.directory,
+with the listings kept of their folders, to a tar archive ("-" for standard
+output), compressed with gzip when the name ends in .gz or .tgz. Import it
+on other machines with "cache import" to start them with a warm cache.
+
+Mounts and syncs may keep running; files they replace or evict meanwhile
+are left out.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCacheDir()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		name := args[0]
+		var stats cache.ArchiveStats
+		if name == "-" {
+			stats, err = c.Export(os.Stdout)
+		} else {
+			// Written next to the archive and renamed into place, so an
+			// interrupted export leaves no truncated archive behind.
+			var f *os.File
+			f, err = os.CreateTemp(filepath.Dir(name), ".koneksi-export-*")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+
+			stats, err = exportArchive(c, f, strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz"))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), name)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export the cache: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Exported %d files (%s) and %d folder listings\n", stats.Files, formatSize(stats.Bytes), stats.Listings)
+		return nil
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Add the cached files of an archive to the cache",
+	Long: `Add the files and folder listings of an archive written by "cache export"
+("-" for standard input, gzip-compressed or not) to cache.directory. The
+archive must be of the configured directory. Files already cached in the
+same or a newer version are left alone, and cache.max_size applies as
+usual.
+
+Imported files are checked against the server on first use like any other
+cached file, so an outdated archive costs downloads, never stale content.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCacheDir()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		br := bufio.NewReader(in)
+		in = br
+		if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return err
+			}
+			in = zr
+		}
+
+		stats, err := c.Import(in)
+		if err != nil {
+			return fmt.Errorf("failed to import the cache: %w", err)
+		}
+
+		fmt.Printf("Imported %d files (%s) and %d folder listings, %d files already cached\n",
+			stats.Files, formatSize(stats.Bytes), stats.Listings, stats.Skipped)
+		return nil
+	},
+}
+
+// openCacheDir opens the configured cache directory for the configured
+// remote directory.
+func openCacheDir() (*cache.Cache, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if !cfg.Cache.Enabled || cfg.Cache.Directory == "" {
+		return nil, errors.New("no cache directory: cache.enabled is off or cache.directory is not set")
+	}
+	return cache.New(&cfg.Cache, cfg.API.DirectoryID)
+}
+
+// exportArchive exports the cache to w, compressed with gzip if compress
+// is set.
+func exportArchive(c *cache.Cache, w io.Writer, compress bool) (cache.ArchiveStats, error) {
+	if !compress {
+		return c.Export(w)
+	}
+	zw := gzip.NewWriter(w)
+	stats, err := c.Export(zw)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	return stats, err
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var catCmd = &cobra.Command{
+	Use:   "cat <remote-path>...",
+	Short: "Write the content of remote files to standard output",
+	Long: `Write the content of one or more remote files to standard output, one
+after the other, without mounting. Content is streamed, so it can be piped
+straight into other tools:
+
+  koneksi-drive cat /backups/home.tar.gz | tar -xz
+  koneksi-drive cat /dumps/app.sql | psql app
+
+--offset and --count select part of each file.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		offset, _ := cmd.Flags().GetInt64("offset")
+		count, _ := cmd.Flags().GetInt64("count")
+		if offset < 0 {
+			return fmt.Errorf("--offset must not be negative")
+		}
+
+		storage, _, _, err := newStorage()
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range args {
+			p := remotePath([]string{arg})
+			info, err := storage.Stat(context.Background(), p)
+			if err != nil {
+				return err
+			}
+			if info.IsDir {
+				return fmt.Errorf("%s is a folder", p)
+			}
+			if offset >= info.Size || count == 0 {
+				continue
+			}
+
+			body, partial, err := api.ReadFrom(context.Background(), storage, p, offset)
+			if err != nil {
+				return err
+			}
+			var r io.Reader = body
+			if !partial && offset > 0 {
+				if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+					body.Close()
+					return fmt.Errorf("failed to read %s: %w", p, err)
+				}
+			}
+			if count > 0 {
+				r = io.LimitReader(r, count)
+			}
+			_, err = io.Copy(os.Stdout, r)
+			body.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+
+	catCmd.Flags().Int64("offset", 0, "Start at this byte of each file")
+	catCmd.Flags().Int64("count", -1, "Write at most this many bytes of each file (-1 for all)")
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// preflight checks, before a CI mount, that the server answers and the
+// directory can be listed and, unless mounting read-only, written with
+// the configured credentials, so a misconfigured job fails right away
+// instead of at its first file operation or with a silently read-only
+// mount.
+func preflight(cfg *config.Config) error {
+	if cfg.Mount.Offline {
+		return nil
+	}
+	// Built as the mount builds it, so the checks go through the same
+	// layers and encryption.
+	v, err := vault.Open(&cfg.Encryption)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	storage, client, err := newStorageFor(cfg, cfg.API.DirectoryID, v)
+	if err != nil {
+		return err
+	}
+	dirs := []string{cfg.Mount.RemotePath}
+	if len(cfg.Mount.Binds) > 0 {
+		dirs = dirs[:0]
+		for _, b := range cfg.Mount.Binds {
+			dirs = append(dirs, b.Path)
+		}
+	}
+	for _, dir := range dirs {
+		if _, err := storage.List(context.Background(), dir); err != nil {
+			return fmt.Errorf("preflight failed: cannot list %s of directory %s: %w", dir, cfg.API.DirectoryID, err)
+		}
+	}
+	// Without the Koneksi API there is no token to check.
+	if cfg.Mount.ReadOnly || cfg.Mount.Mirror || cfg.Mount.OverlayDir != "" || client == nil {
+		return nil
+	}
+	caps, err := client.Capabilities()
+	if err != nil {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+	if !caps.Write {
+		return fmt.Errorf("preflight failed: the access token cannot write to directory %s; mount with --readonly", cfg.API.DirectoryID)
+	}
+	role, err := client.Role(context.Background())
+	if err != nil && !errors.Is(err, api.ErrRoleUnsupported) {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+	if err == nil && !api.RoleCanWrite(role) {
+		return fmt.Errorf("preflight failed: you are a %s of directory %s and cannot write to it; mount with --readonly", role, cfg.API.DirectoryID)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/backend"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// hooksTimeout is how long commands wait for hooks still running before
+// they exit.
+const hooksTimeout = time.Minute
+
+// newClient loads the configuration and creates an API client for commands
+// that talk to Koneksi directly instead of going through a mount.
+func newClient() (*api.Client, *config.Config, error) {
+	cfg, v, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newClientFor(cfg, cfg.API.DirectoryID, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, cfg, nil
+}
+
+// newClientFor creates an API client for the directory directoryID, with
+// the credentials and settings of cfg.
+func newClientFor(cfg *config.Config, directoryID string, v *vault.Vault) (*api.Client, error) {
+	_, client, err := newStorageFor(cfg, directoryID, v)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, api.ErrNoAPI
+	}
+	return client, nil
+}
+
+// newStorage loads the configuration and opens the storage of the
+// configured backend, for commands that work on files without a mount.
+// client is nil without the Koneksi API.
+func newStorage() (api.StorageBackend, *api.Client, *config.Config, error) {
+	cfg, v, err := loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	storage, client, err := newStorageFor(cfg, cfg.API.DirectoryID, v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return storage, client, cfg, nil
+}
+
+// newStorageFor opens the storage of the directory directoryID, with the
+// backend, layers and settings of cfg.
+func newStorageFor(cfg *config.Config, directoryID string, v *vault.Vault) (api.StorageBackend, *api.Client, error) {
+	storage, client, err := backend.Open(cfg, directoryID, v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return storage, client, nil
+}
+
+// loadConfig loads the configuration and the encryption keys it names.
+func loadConfig() (*config.Config, *vault.Vault, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	v, err := vault.Open(&cfg.Encryption)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	return cfg, v, nil
+}
+
+// requireWrite fails early when the access token cannot write, rather than
+// letting the first upload fail halfway through a transfer. Without the
+// Koneksi API there is no token to check.
+func requireWrite(client *api.Client) error {
+	if client == nil {
+		return nil
+	}
+	caps, err := client.Capabilities()
+	if err != nil {
+		return err
+	}
+	if !caps.Write {
+		return fmt.Errorf("the access token does not allow writing (missing %s scope)", api.ScopeWrite)
+	}
+	role, err := client.Role(context.Background())
+	if errors.Is(err, api.ErrRoleUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !api.RoleCanWrite(role) {
+		return fmt.Errorf("you are a %s of this directory and cannot write to it", role)
+	}
+	return nil
+}
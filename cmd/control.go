@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/fs"
+)
+
+// serveControl serves the control socket of the mount at socket until
+// the returned server is closed, for commands such as remount that change
+// the running mount. Only the user running the mount can connect.
+//
+//	GET  /options  the options remount changes, as in effect
+//	POST /remount  apply the fs.RemountOptions in the body
+func serveControl(socket string, kfs *fs.KoneksiFS) (*http.Server, error) {
+	// Left by a mount that crashed; the instance lock is ours now.
+	os.Remove(socket)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/options", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, kfs.Options())
+	})
+	mux.HandleFunc("/remount", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "remount needs POST"})
+			return
+		}
+		var change fs.RemountOptions
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&change); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		opts, err := kfs.Remount(change)
+		switch {
+		case errors.Is(err, fs.ErrRemount):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, opts)
+		}
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("control socket failed", "error", err)
+		}
+	}()
+	slog.Debug("serving control socket", "path", socket)
+	return srv, nil
+}
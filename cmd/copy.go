@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/transfer"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var copyCmd = &cobra.Command{
+	Use:   "copy <remote>:<path> <remote>:<path>",
+	Short: "Copy a file or folder between Koneksi directories",
+	Long: `Copy a remote file or folder tree to another Koneksi directory, or to
+another place in the same one. Remotes are the names configured under
+"remotes", or directory IDs; an empty name means api.directory_id, as in
+":/docs".
+
+The server copies files itself when it can. Otherwise their content is
+streamed from one directory to the other without being stored on local
+disk. Files already up to date are skipped, and an interrupted copy
+resumes when the same command is run again.
+
+A file copied onto an existing folder is placed inside it.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		srcDir, src, err := parseRemote(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		dstDir, dst, err := parseRemote(cfg, args[1])
+		if err != nil {
+			return err
+		}
+
+		opts, err := transferOptions(cmd, "copy", path.Base(src))
+		if err != nil {
+			return err
+		}
+
+		v, err := vault.Open(&cfg.Encryption)
+		if err != nil {
+			return fmt.Errorf("failed to load encryption keys: %w", err)
+		}
+		from, _, err := newStorageFor(cfg, srcDir, v)
+		if err != nil {
+			return err
+		}
+		to, toClient, err := newStorageFor(cfg, dstDir, v)
+		if err != nil {
+			return err
+		}
+		if err := requireWrite(toClient); err != nil {
+			return err
+		}
+
+		opts.Hooks = hooks.New(cfg.Hooks, dstDir, "copy")
+		defer opts.Hooks.Close(hooksTimeout)
+
+		t := transfer.New(srcDir, from, upload.New(to, &cfg.Upload), opts)
+		summary, err := t.Copy(context.Background(), dstDir, to, src, dst)
+		if summary != nil {
+			printTransferSummary(summary, "copied")
+		}
+		return err
+	},
+}
+
+// parseRemote splits a "<remote>:<path>" argument into the directory ID
+// the remote names and the path.
+func parseRemote(cfg *config.Config, arg string) (directoryID, p string, err error) {
+	name, p, ok := strings.Cut(arg, ":")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not of the form <remote>:<path>", arg)
+	}
+	// Configuration keys are read in lower case.
+	switch id, named := cfg.Remotes[strings.ToLower(name)]; {
+	case name == "":
+		directoryID = cfg.API.DirectoryID
+	case named:
+		directoryID = id
+	default:
+		directoryID = name
+	}
+	return directoryID, remotePath([]string{p}), nil
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+
+	addTransferFlags(copyCmd)
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var cryptCmd = &cobra.Command{
+	Use:   "crypt",
+	Short: "Manage encrypted content on the server",
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey [remote-path]",
+	Short: "Re-encrypt files to the current keys",
+	Long: `Re-encrypt the files below a remote path (the whole directory by
+default) that are not encrypted to the current keys, for example after
+encryption.identity_file was replaced and the old key listed in
+encryption.retired_identity_files, or after encryption.recipients changed.
+
+Files written through a mount or uploaded are always encrypted to the
+current keys, so files rotate by themselves as they change; rekey handles
+the rest. Each file is downloaded, decrypted with any configured key,
+checked to be unchanged on the server, and uploaded again. Files already
+encrypted to the current keys are skipped, so an interrupted rekey picks up
+where it stopped when run again. Once it reports nothing left, the retired
+keys can be removed.
+
+A file is taken to be encrypted to the current keys when a current key can
+decrypt it and it has as many recipients as uploads now get; --force
+re-encrypts every file, for when a recipient was replaced by another.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+		client, cfg, err := newClient()
+		if err != nil {
+			return err
+		}
+		if !cfg.Encryption.Enabled {
+			return errors.New("encryption is not enabled (encryption.enabled)")
+		}
+		if !dryRun {
+			if err := requireWrite(client); err != nil {
+				return err
+			}
+		}
+
+		r := &rekeyer{
+			client:   client,
+			uploader: upload.New(client, &cfg.Upload),
+			dryRun:   dryRun,
+			force:    force,
+			sem:      make(chan struct{}, max(concurrency, 1)),
+		}
+		err = api.Walk(context.Background(), client, remotePath(args), func(p string, info api.FileInfo) error {
+			if !info.IsDir && client.Encrypted(p) {
+				r.start(p)
+			}
+			return nil
+		})
+		r.wg.Wait()
+
+		verb := "re-encrypted"
+		if dryRun {
+			verb = "to re-encrypt"
+		}
+		fmt.Printf("%d files %s, %d already current, %d failed\n", r.rekeyed, verb, r.current, r.failed)
+		if err == nil && r.failed > 0 {
+			err = fmt.Errorf("%d files could not be re-encrypted", r.failed)
+		}
+		return err
+	},
+}
+
+var markCmd = &cobra.Command{
+	Use:   "mark <remote-folder>",
+	Short: "Encrypt a folder and everything below it",
+	Long: `Create the marker file ` + api.EncryptionMarker + ` in a remote folder, so that
+with encryption.markers its content is encrypted from then on, by every
+client configured with the same keys. The folder is created if missing.
+
+Files already in the folder are not converted and could no longer be read,
+so mark new or empty folders; --force marks a folder that is not empty.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+
+		client, cfg, err := newClient()
+		if err != nil {
+			return err
+		}
+		if !cfg.Encryption.Enabled || !cfg.Encryption.Markers {
+			return errors.New("marker files are not used (encryption.enabled and encryption.markers)")
+		}
+		if err := requireWrite(client); err != nil {
+			return err
+		}
+
+		dir := remotePath(args)
+		files, err := client.List(context.Background(), dir)
+		if api.IsNotFound(err) {
+			err = client.Mkdir(context.Background(), dir)
+		}
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.Name == api.EncryptionMarker {
+				fmt.Printf("%s is already encrypted\n", dir)
+				return nil
+			}
+		}
+		if len(files) > 0 && !force {
+			return fmt.Errorf("%s is not empty: the %d entries in it would no longer be readable (use --force to mark it anyway)", dir, len(files))
+		}
+		if err := client.Write(context.Background(), path.Join(dir, api.EncryptionMarker), "", strings.NewReader("")); err != nil {
+			return err
+		}
+		fmt.Printf("%s is encrypted from now on\n", dir)
+		return nil
+	},
+}
+
+var wrapKeyCmd = &cobra.Command{
+	Use:   "wrap-key",
+	Short: "Create a content key wrapped by the key management service",
+	Long: `Create the key content is encrypted with and have the key management
+service configured in encryption.kms wrap it, writing the result to
+encryption.kms.wrapped_key_file. The key itself is never written anywhere:
+mounts and transfers have the service unwrap it when they start, so only
+clients the service lets use its key can read the content.
+
+With --identity, the first key in an age identity file is wrapped instead,
+for example to move content encrypted with a local key to the service;
+remove encryption.identity_file afterwards and delete the file once it is
+no longer needed. The public key printed can be given to other setups as
+one of encryption.recipients.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		identityFile, _ := cmd.Flags().GetString("identity")
+
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if cfg.Encryption.KMS.Provider == "" {
+			return errors.New("no key management service is configured (encryption.kms.provider)")
+		}
+
+		var id *age.X25519Identity
+		if identityFile != "" {
+			ids, err := vault.LoadIdentities(identityFile)
+			if err != nil {
+				return err
+			}
+			for _, candidate := range ids {
+				if x, ok := candidate.(*age.X25519Identity); ok {
+					id = x
+					break
+				}
+			}
+			if id == nil {
+				return fmt.Errorf("%s holds no X25519 key", identityFile)
+			}
+		} else if id, err = age.GenerateX25519Identity(); err != nil {
+			return err
+		}
+
+		if err := vault.WrapIdentity(&cfg.Encryption.KMS, id); err != nil {
+			return err
+		}
+		fmt.Printf("wrapped key written to %s\npublic key: %s\n", cfg.Encryption.KMS.WrappedKeyFile, id.Recipient())
+		return nil
+	},
+}
+
+// rekeyer re-encrypts files in parallel.
+type rekeyer struct {
+	client   *api.Client
+	uploader *upload.Uploader
+	dryRun   bool
+	force    bool
+	sem      chan struct{}
+	wg       sync.WaitGroup
+
+	mu                       sync.Mutex
+	rekeyed, current, failed int
+}
+
+func (r *rekeyer) start(p string) {
+	r.sem <- struct{}{}
+	r.wg.Add(1)
+	go func() {
+		defer func() {
+			<-r.sem
+			r.wg.Done()
+		}()
+
+		rekeyed, err := r.rekey(p)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		switch {
+		case err != nil:
+			r.failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+		case rekeyed:
+			r.rekeyed++
+			if r.dryRun {
+				fmt.Println(p)
+			} else {
+				fmt.Printf("re-encrypted %s\n", p)
+			}
+		default:
+			r.current++
+		}
+	}()
+}
+
+// rekey re-encrypts the file p unless it is current, and reports whether
+// it did.
+func (r *rekeyer) rekey(p string) (bool, error) {
+	before, err := r.client.Stat(context.Background(), p)
+	if err != nil {
+		return false, err
+	}
+	h, err := r.client.Inspect(context.Background(), p)
+	if err != nil {
+		return false, err
+	}
+	if h.Current && !r.force {
+		return false, nil
+	}
+	if r.dryRun {
+		return true, nil
+	}
+
+	// Decrypt the whole file before uploading anything, so content that
+	// fails to decrypt halfway never replaces the stored copy.
+	tmp, err := os.CreateTemp("", "koneksi-rekey-*")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	body, err := r.client.Read(context.Background(), p)
+	if err != nil {
+		return false, err
+	}
+	size, err := io.Copy(tmp, body)
+	body.Close()
+	if err != nil {
+		return false, err
+	}
+
+	now, err := r.client.Stat(context.Background(), p)
+	if err != nil {
+		return false, err
+	}
+	if now.Size != before.Size || !now.Modified.Equal(before.Modified) {
+		return false, errors.New("changed on the server meanwhile; run rekey again")
+	}
+	_, err = r.uploader.Upload(context.Background(), p, tmp, size, nil)
+	return err == nil, err
+}
+
+func init() {
+	rootCmd.AddCommand(cryptCmd)
+	cryptCmd.AddCommand(rekeyCmd)
+	cryptCmd.AddCommand(markCmd)
+	cryptCmd.AddCommand(wrapKeyCmd)
+
+	rekeyCmd.Flags().Bool("dry-run", false, "List the files that would be re-encrypted")
+	rekeyCmd.Flags().Bool("force", false, "Re-encrypt every file, current or not")
+	rekeyCmd.Flags().Int("concurrency", 4, "Number of files re-encrypted in parallel")
+
+	markCmd.Flags().Bool("force", false, "Mark a folder that is not empty")
+
+	wrapKeyCmd.Flags().StringP("identity", "i", "", "age identity file whose key to wrap instead of a new one")
+}
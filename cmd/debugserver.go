@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/fs"
+)
+
+// serveDebug serves profiles and runtime variables of the mount on addr
+// until the returned server is closed:
+//
+//	/debug/pprof/  CPU, heap, goroutine and other profiles
+//	/debug/vars    expvar, including the mount's session and memory use
+//
+// Anyone who can reach addr can read them, so it should be a loopback
+// address.
+func serveDebug(addr string, kfs *fs.KoneksiFS) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(ln.Addr().String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsLoopback() {
+			slog.Warn("debug endpoint is reachable from other hosts", "addr", ln.Addr())
+		}
+	}
+
+	expvar.Publish("session", expvar.Func(func() any { return kfs.Session() }))
+	expvar.Publish("memory", expvar.Func(func() any { return kfs.MemoryUsage() }))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("debug endpoint failed", "error", err)
+		}
+	}()
+	slog.Info("serving debug endpoint", "addr", ln.Addr())
+	return srv, nil
+}
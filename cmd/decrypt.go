@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [input]",
+	Short: "Decrypt files encrypted by a mount or transfer, without the server",
+	Long: `Decrypt content stored with encryption.enabled, for example files
+downloaded from the server by other means or a copy of the directory. The
+content is in the age format (https://age-encryption.org), so
+"age --decrypt -i <identity file>" decrypts it as well.
+
+The input defaults to standard input and the output, set with --output, to
+standard output. An input folder is decrypted file by file into the output
+folder, keeping its layout; files that are not encrypted are reported and
+skipped. With --filenames and --filename-key, the names of the files and
+folders are decrypted as well; names that are not encrypted are kept. No
+configuration or server is needed: the identity file and filename settings
+default to those in the encryption section if a configuration file is
+found, and without --identity, a key wrapped by a key management service
+(encryption.kms) is used as well.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		identityFile, _ := cmd.Flags().GetString("identity")
+		output, _ := cmd.Flags().GetString("output")
+		filenames, _ := cmd.Flags().GetString("filenames")
+		filenameKey, _ := cmd.Flags().GetString("filename-key")
+		if filenames == "" {
+			filenames = viper.GetString("encryption.filenames")
+		}
+		if filenameKey == "" {
+			filenameKey = viper.GetString("encryption.filename_key_file")
+		}
+		var identities []age.Identity
+		var err error
+		if identityFile != "" {
+			identities, err = vault.LoadIdentities(identityFile)
+		} else {
+			var enc config.EncryptionConfig
+			if err := viper.UnmarshalKey("encryption", &enc); err != nil {
+				return err
+			}
+			if enc.IdentityFile == "" && enc.KMS.Provider == "" {
+				return errors.New("an identity file is required: pass --identity or set encryption.identity_file")
+			}
+			identities, err = vault.LoadKeys(&enc)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 || args[0] == "-" {
+			return decryptStream(os.Stdin, output, identities)
+		}
+		info, err := os.Stat(args[0])
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return decryptStream(f, output, identities)
+		}
+		if output == "" {
+			return errors.New("--output is required to decrypt a folder")
+		}
+		names, err := vault.OpenNames(&config.EncryptionConfig{
+			Enabled:         true,
+			Filenames:       filenames,
+			FilenameKeyFile: filenameKey,
+		})
+		if err != nil {
+			return err
+		}
+		return decryptTree(args[0], output, identities, names)
+	},
+}
+
+// decryptStream decrypts r into the file output, or standard output if
+// empty.
+func decryptStream(r io.Reader, output string, identities []age.Identity) error {
+	plain, err := vault.Decrypt(r, identities)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = io.Copy(os.Stdout, plain)
+		return err
+	}
+	return decryptFile(plain, output)
+}
+
+// decryptFile writes plain to path, leaving no partial file behind if
+// decryption fails halfway, as it does for tampered content.
+func decryptFile(plain io.Reader, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := io.Copy(tmp, plain); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// decryptTree decrypts the files below src into dst, and their names too
+// if names is not nil.
+func decryptTree(src, dst string, identities []age.Identity, names *vault.Names) error {
+	var decrypted, skipped int
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, plainRel(rel, names))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		plain, err := vault.Decrypt(f, identities)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipped %s: %v\n", rel, err)
+			skipped++
+			return nil
+		}
+		if err := decryptFile(plain, target); err != nil {
+			return err
+		}
+		decrypted++
+		return nil
+	})
+	fmt.Fprintf(os.Stderr, "decrypted %d files, skipped %d\n", decrypted, skipped)
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("%d files could not be decrypted", skipped)
+	}
+	return err
+}
+
+// plainRel decrypts the names in the relative path rel, keeping those
+// that are not encrypted.
+func plainRel(rel string, names *vault.Names) string {
+	if names == nil || rel == "." {
+		return rel
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, name := range parts {
+		if plain, err := names.Decrypt(name); err == nil {
+			parts[i] = plain
+		}
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+
+	decryptCmd.Flags().StringP("identity", "i", "", "age identity file (default encryption.identity_file)")
+	decryptCmd.Flags().StringP("output", "o", "", "output file or folder (default standard output)")
+	decryptCmd.Flags().String("filenames", "", "filename encryption mode of a folder (default encryption.filenames)")
+	decryptCmd.Flags().String("filename-key", "", "filename key file (default encryption.filename_key_file)")
+}
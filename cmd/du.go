@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var duCmd = &cobra.Command{
+	Use:   "du [path]",
+	Short: "Summarize remote disk usage",
+	Long: `Summarize disk usage of a remote directory using directory listings,
+without mounting. Entries are printed largest-first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxDepth, _ := cmd.Flags().GetInt("max-depth")
+		all, _ := cmd.Flags().GetBool("all")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		rawBytes, _ := cmd.Flags().GetBool("bytes")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+		storage, _, _, err := newStorage()
+		if err != nil {
+			return err
+		}
+
+		root, err := newUsageScanner(storage, concurrency).Scan(remotePath(args))
+		if err != nil {
+			return err
+		}
+
+		root = root.prune(maxDepth, !all)
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(root)
+		}
+
+		printDu(root, rawBytes)
+		return nil
+	},
+}
+
+// printDu prints entries depth-first with children before their parent,
+// the same order du(1) uses.
+func printDu(entry *usageEntry, rawBytes bool) {
+	for _, child := range entry.Children {
+		printDu(child, rawBytes)
+	}
+
+	fmt.Printf("%-10s %s\n", sizeLabel(entry.Size, rawBytes), entry.Path)
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+
+	duCmd.Flags().IntP("max-depth", "d", 1, "Print totals only N levels below the path (-1 for unlimited)")
+	duCmd.Flags().BoolP("all", "a", false, "Include files, not just directories")
+	duCmd.Flags().Bool("json", false, "Output as JSON")
+	duCmd.Flags().BoolP("bytes", "b", false, "Print sizes in bytes")
+	duCmd.Flags().Int("concurrency", 8, "Number of directory listings to fetch in parallel")
+}
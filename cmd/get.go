@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get <remote-path> [local-path]",
+	Short: "Download a file or folder",
+	Long: `Download a remote file or folder tree without mounting. Files are
+transferred in parallel and local files already up to date are skipped.
+An interrupted download resumes when the same command is run again,
+continuing partially downloaded files where they stopped.
+
+The local path defaults to the remote name in the current directory. A
+file downloaded onto an existing directory is placed inside it.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src := remotePath(args[:1])
+		opts, err := transferOptions(cmd, "get", path.Base(src))
+		if err != nil {
+			return err
+		}
+
+		dst := path.Base(src)
+		if len(args) > 1 {
+			dst = args[1]
+		} else if src == "/" {
+			return fmt.Errorf("a local path is required to download the root folder")
+		}
+
+		storage, _, cfg, err := newStorage()
+		if err != nil {
+			return err
+		}
+		opts.Hooks = hooks.New(cfg.Hooks, cfg.API.DirectoryID, "get")
+		defer opts.Hooks.Close(hooksTimeout)
+
+		t := transfer.New(cfg.API.DirectoryID, storage, nil, opts)
+		summary, err := t.Get(context.Background(), src, dst)
+		if summary != nil {
+			printTransferSummary(summary, "downloaded")
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+
+	addTransferFlags(getCmd)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/instance"
+	"github.com/spf13/cobra"
+)
+
+// processInterval is how often a mount checks whether the processes its
+// lifetime is bound to are still running.
+const processInterval = time.Second
+
+// watchParent returns a channel that is closed once the process that
+// started this one exits, which reparents it.
+func watchParent() <-chan struct{} {
+	gone := make(chan struct{})
+	parent := os.Getppid()
+	go func() {
+		for os.Getppid() == parent {
+			time.Sleep(processInterval)
+		}
+		close(gone)
+	}()
+	return gone
+}
+
+// watchProcess returns a channel that is closed once process pid exits.
+func watchProcess(pid int) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		for processRunning(pid) {
+			time.Sleep(processInterval)
+		}
+		close(gone)
+	}()
+	return gone
+}
+
+// processRunning reports whether process pid exists, including processes
+// of other users this one may not signal.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// startWatchdog starts a watchdog process that unmounts mountpoint if
+// this process dies without unmounting it, even when killed with SIGKILL,
+// so it does not leave a mountpoint every access to fails with
+// "transport endpoint is not connected". The watchdog notices through the
+// returned pipe being closed, which happens when this process exits;
+// after a clean unmount it finds nothing to clean up and exits as well.
+func startWatchdog(mountpoint string) (*os.File, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	watchdog := exec.Command(exe, "watchdog", mountpoint)
+	watchdog.ExtraFiles = []*os.File{r}
+	// Its own session, so the interrupt of a terminal or a process
+	// group stopping the mount does not stop the watchdog too.
+	watchdog.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := watchdog.Start(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	go watchdog.Wait()
+	return w, nil
+}
+
+var watchdogCmd = &cobra.Command{
+	Use:    "watchdog <mountpoint>",
+	Short:  "Unmount a mountpoint once the mount process that started this one dies",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		signal.Ignore(os.Interrupt, syscall.SIGHUP)
+
+		// The mount process holds the other end of the pipe until it
+		// exits, however it does.
+		pipe := os.NewFile(3, "watchdog")
+		if pipe == nil {
+			return fmt.Errorf("no pipe to the mount process")
+		}
+		io.Copy(io.Discard, pipe)
+
+		_, err := instance.CleanStale(args[0])
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchdogCmd)
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "List a remote folder, optionally with who its entries are shared with",
+	Long: `List the entries of a remote folder, or a single file, without mounting.
+
+With --shares, each entry also shows its owner, its public links and the
+users and groups it is shared with, as the server reports them, to audit
+what is shared. --shared-only leaves out the entries shared with no one.
+Inside a mount, the same is available as the user.koneksi.owner,
+user.koneksi.shared_with and user.koneksi.links extended attributes.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shares, _ := cmd.Flags().GetBool("shares")
+		sharedOnly, _ := cmd.Flags().GetBool("shared-only")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		shares = shares || sharedOnly
+
+		storage, client, _, err := newStorage()
+		if err != nil {
+			return err
+		}
+
+		target := remotePath(args)
+		info, err := storage.Stat(context.Background(), target)
+		if err != nil {
+			return err
+		}
+		files := []api.FileInfo{*info}
+		if info.IsDir {
+			if files, err = storage.List(context.Background(), target); err != nil {
+				return err
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+		entries := make([]lsEntry, len(files))
+		for i, f := range files {
+			entries[i].FileInfo = f
+			if entries[i].Path == "" {
+				entries[i].Path = path.Join(target, f.Name)
+			}
+		}
+		if shares {
+			if client == nil {
+				return fmt.Errorf("--shares: %w", api.ErrNoAPI)
+			}
+			if err := fetchSharing(client, entries, concurrency); err != nil {
+				return err
+			}
+		}
+		if sharedOnly {
+			shared := entries[:0]
+			for _, e := range entries {
+				if e.Sharing.Shared() {
+					shared = append(shared, e)
+				}
+			}
+			entries = shared
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+
+		if shares {
+			fmt.Printf("%9s  %-16s  %-20s  %5s  %-30s  %s\n", "SIZE", "MODIFIED", "OWNER", "LINKS", "SHARED WITH", "NAME")
+		} else {
+			fmt.Printf("%9s  %-16s  %s\n", "SIZE", "MODIFIED", "NAME")
+		}
+		for _, e := range entries {
+			size, name := formatSize(e.Size), e.Name
+			if e.IsDir {
+				size, name = "-", name+"/"
+			}
+			modified := e.Modified.Local().Format("2006-01-02 15:04")
+			if !shares {
+				fmt.Printf("%9s  %-16s  %s\n", size, modified, name)
+				continue
+			}
+			grants := make([]string, len(e.Sharing.SharedWith))
+			for i, g := range e.Sharing.SharedWith {
+				grants[i] = fmt.Sprintf("%s (%s)", g.Principal, g.Role)
+			}
+			fmt.Printf("%9s  %-16s  %-20s  %5d  %-30s  %s\n", size, modified, orDash(e.Sharing.Owner),
+				len(e.Sharing.Links), orDash(strings.Join(grants, ", ")), name)
+		}
+		return nil
+	},
+}
+
+// lsEntry is an entry listed by ls, with its sharing if asked for.
+type lsEntry struct {
+	api.FileInfo
+	Sharing *api.Sharing `json:"sharing,omitempty"`
+}
+
+// fetchSharing asks the server who each of entries is shared with,
+// concurrency at a time.
+func fetchSharing(client *api.Client, entries []lsEntry, concurrency int) error {
+	sem := make(chan struct{}, max(concurrency, 1))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(e *lsEntry, err *error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			e.Sharing, *err = client.Sharing(context.Background(), e.Path)
+		}(&entries[i], &errs[i])
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, api.ErrSharingUnsupported):
+			return fmt.Errorf("the server does not tell who files are shared with")
+		case err != nil:
+			return fmt.Errorf("failed to get sharing of %s: %w", entries[i].Path, err)
+		}
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().Bool("shares", false, "Show the owner, public links and users and groups each entry is shared with")
+	lsCmd.Flags().Bool("shared-only", false, "List only entries shared with someone or having a public link; implies --shares")
+	lsCmd.Flags().Bool("json", false, "Output as JSON")
+	lsCmd.Flags().Int("concurrency", 8, "Number of sharing requests to send in parallel")
+}
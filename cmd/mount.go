@@ -1,14 +1,20 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/koneksi/koneksi-drive/internal/config"
 	"github.com/koneksi/koneksi-drive/internal/fs"
+	"github.com/koneksi/koneksi-drive/internal/instance"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -19,6 +25,17 @@ var mountCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		mountpoint := args[0]
+		ci, _ := cmd.Flags().GetBool("ci")
+		if ci {
+			// Errors from here on are not about how the command is used.
+			cmd.SilenceUsage = true
+		}
+
+		// A previous instance that crashed leaves the mountpoint
+		// unusable until it is unmounted.
+		if _, err := instance.CleanStale(mountpoint); err != nil {
+			return err
+		}
 
 		// Ensure mountpoint exists
 		if err := os.MkdirAll(mountpoint, 0755); err != nil {
@@ -30,12 +47,40 @@ var mountCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path: %w", err)
 		}
+		if err := instance.CheckMountpoint(absMount); err != nil {
+			return err
+		}
 
 		// Load configuration
 		cfg, err := config.Load()
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
 		}
+		if verify, _ := cmd.Flags().GetBool("verify-uploads"); verify {
+			cfg.Upload.Verify = true
+		}
+		if ci {
+			cfg.ApplyCI()
+			if err := preflight(cfg); err != nil {
+				return err
+			}
+		}
+		supervisor, _ := cmd.Flags().GetInt("supervisor-pid")
+		if supervisor != 0 && (supervisor < 0 || !processRunning(supervisor)) {
+			return fmt.Errorf("supervising process %d is not running", supervisor)
+		}
+		if !cfg.Mount.NonEmpty {
+			if err := instance.CheckEmpty(absMount); err != nil {
+				return err
+			}
+		}
+
+		// Refuse to mount the same directory twice on this host
+		lock, err := instance.Acquire(cfg.API.DirectoryID, absMount)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
 
 		// Create and mount filesystem
 		kfs, err := fs.NewKoneksiFS(cfg)
@@ -43,39 +88,261 @@ var mountCmd = &cobra.Command{
 			return fmt.Errorf("failed to create filesystem: %w", err)
 		}
 
-		fmt.Printf("Mounting Koneksi storage at %s...\n", absMount)
+		if !ci {
+			fmt.Printf("Mounting Koneksi storage at %s...\n", absMount)
+		}
+		if cfg.Mount.AutoUnmount {
+			watchdog, err := startWatchdog(absMount)
+			if err != nil {
+				slog.Warn("failed to start watchdog, the mount is not unmounted if this process dies", "error", err)
+			} else {
+				defer watchdog.Close()
+			}
+		}
 		
 		if err := kfs.Mount(absMount); err != nil {
 			return fmt.Errorf("failed to mount filesystem: %w", err)
 		}
 
-		fmt.Println("Filesystem mounted successfully. Press Ctrl+C to unmount.")
+		if ci {
+			slog.Info("mounted", "mountpoint", absMount, "directory", cfg.API.DirectoryID, "readonly", kfs.ReadOnly())
+		} else {
+			fmt.Println("Filesystem mounted successfully. Press Ctrl+C to unmount.")
+		}
+		go sendTelemetry(cfg)
+
+		// The debug endpoint and the status API are closed after the
+		// final uploads, so they can be watched, and before unmounting.
+		var servers []*http.Server
+		if addr := cfg.Mount.DebugAddr; addr != "" {
+			srv, err := serveDebug(addr, kfs)
+			if err != nil {
+				slog.Warn("failed to start debug endpoint", "addr", addr, "error", err)
+			} else {
+				servers = append(servers, srv)
+			}
+		}
+
+		// Publish traffic figures for the status command and the
+		// status API.
+		// Set once the status API serves, which calls current meanwhile.
+		var statusAddr atomic.Pointer[string]
+		current := func() mountStatus {
+			addr := ""
+			if p := statusAddr.Load(); p != nil {
+				addr = *p
+			}
+			return mountStatus{
+				DirectoryID: cfg.API.DirectoryID,
+				Mountpoint:  absMount,
+				PID:         os.Getpid(),
+				ReadOnly:    kfs.ReadOnly(),
+				Caps:        kfs.Capabilities(),
+				APIVersion:  kfs.APIVersion(),
+				Unreachable: kfs.Unreachable(),
+				Updated:     time.Now(),
+				Session:     kfs.Session(),
+				Memory:      kfs.MemoryUsage(),
+				StatusAddr:  addr,
+			}
+		}
+		if addr := cfg.Mount.StatusAddr; addr != "" {
+			recent := newRecentLog(slog.Default().Handler())
+			slog.SetDefault(slog.New(recent))
+			srv, err := serveStatusAPI(addr, kfs, current, recent)
+			if err != nil {
+				slog.Warn("failed to start status API", "addr", addr, "error", err)
+			} else {
+				statusAddr.Store(&srv.Addr)
+				servers = append(servers, srv)
+			}
+		}
+		// remount changes options through the control socket.
+		if srv, err := serveControl(lock.ControlPath(), kfs); err != nil {
+			slog.Warn("failed to start control socket, the mount cannot be remounted", "error", err)
+		} else {
+			servers = append(servers, srv)
+		}
+		publish := func() {
+			if err := lock.WriteStatus(current()); err != nil {
+				slog.Debug("failed to publish mount status", "error", err)
+			}
+		}
+		publish()
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
 
-		// Wait for interrupt signal
+		// Wait for interrupt signal. SIGUSR1 logs internal state and
+		// SIGUSR2 flushes pending writes and cached metadata. CI mounts
+		// also unmount when the job that started them ends, and mounts
+		// with a supervisor when it does.
+		force, _ := cmd.Flags().GetBool("force")
+		refused := false
+		unflushed := false
+		var parentGone <-chan struct{}
+		if ci {
+			parentGone = watchParent()
+		}
+		var supervisorGone <-chan struct{}
+		if supervisor != 0 {
+			supervisorGone = watchProcess(supervisor)
+		}
 		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				publish()
+			case <-parentGone:
+				slog.Info("parent process exited, unmounting")
+				unflushed = !flushBeforeUnmount(kfs, cfg.Mount.FlushTimeout, ci)
+				break wait
+			case <-supervisorGone:
+				slog.Info("supervising process exited, unmounting", "pid", supervisor)
+				unflushed = !flushBeforeUnmount(kfs, cfg.Mount.FlushTimeout, ci)
+				break wait
+			case sig := <-sigChan:
+				switch sig {
+				case syscall.SIGUSR1:
+					kfs.LogStats()
+				case syscall.SIGUSR2:
+					kfs.Flush()
+				default:
+					// Changes not uploaded would be lost: unmount only
+					// once they are, with --force or on a second
+					// interrupt.
+					if refused {
+						fmt.Fprintln(os.Stderr, "\nUnmounting with changes not uploaded.")
+						break wait
+					}
+					if flushBeforeUnmount(kfs, cfg.Mount.FlushTimeout, ci) {
+						break wait
+					}
+					if force {
+						if ci {
+							slog.Warn("unmounting anyway (--force)")
+						} else {
+							fmt.Fprintln(os.Stderr, "Unmounting anyway (--force).")
+						}
+						break wait
+					}
+					if ci {
+						// Nobody is there to interrupt again, so the
+						// job fails instead.
+						unflushed = true
+						break wait
+					}
+					refused = true
+					kfs.Resume()
+					fmt.Fprintln(os.Stderr, "Not unmounting, so they are not lost; they are retried when the files are closed or on SIGUSR2. Interrupt again to unmount anyway.")
+				}
+			}
+		}
 
-		fmt.Println("\nUnmounting filesystem...")
+		for _, srv := range servers {
+			srv.Close()
+		}
+		if !ci {
+			fmt.Println("\nUnmounting filesystem...")
+		}
 		if err := kfs.Unmount(); err != nil {
 			return fmt.Errorf("failed to unmount: %w", err)
 		}
+		recordTelemetry(cfg, kfs.Session())
 
-		fmt.Println("Filesystem unmounted successfully.")
+		if ci {
+			slog.Info("unmounted", "session", kfs.Session())
+		} else {
+			fmt.Println("Filesystem unmounted successfully.")
+			fmt.Println("\nSession summary:")
+			printSession(os.Stdout, kfs.Session())
+		}
+		if unflushed {
+			return errors.New("unmounted with changes not uploaded")
+		}
 		return nil
 	},
 }
 
+// flushBeforeUnmount stops the mount from accepting changes and uploads
+// the pending changes of open files, waiting at most timeout, and reports
+// whether all were uploaded. CI mounts log the progress instead of
+// printing it.
+func flushBeforeUnmount(kfs *fs.KoneksiFS, timeout time.Duration, ci bool) bool {
+	if pending := kfs.Pending(); len(pending) > 0 {
+		if ci {
+			slog.Info("uploading changes to open files", "files", len(pending))
+		} else {
+			fmt.Printf("\nUploading changes to %d open files...\n", len(pending))
+		}
+	}
+	pending := kfs.Drain(timeout)
+	if len(pending) == 0 {
+		return true
+	}
+
+	if ci {
+		slog.Error("changes could not be uploaded", "files", pending)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Changes to %d files could not be uploaded:\n", len(pending))
+	for _, p := range pending {
+		fmt.Fprintf(os.Stderr, "  %s\n", p)
+	}
+	return false
+}
+
 func init() {
 	rootCmd.AddCommand(mountCmd)
 	
 	mountCmd.Flags().Bool("readonly", false, "Mount filesystem as read-only")
+	mountCmd.Flags().String("remote-path", "/", "Remote folder to mount as the root, e.g. /projects/2024/q3")
+	mountCmd.Flags().StringArray("map", nil, "Show a remote folder as a folder at the root, as name=/remote/path (repeatable); the root holds nothing else")
 	mountCmd.Flags().Bool("allow-other", false, "Allow other users to access the filesystem")
+	mountCmd.Flags().String("root-mode", "", "Permissions of the mountpoint while mounted, e.g. 0750")
+	mountCmd.Flags().String("root-owner", "", "Owner of the mountpoint while mounted, as user[:group]")
+	mountCmd.Flags().Bool("nonempty", false, "Mount over a mountpoint that has files, hiding them while mounted")
+	mountCmd.Flags().Bool("auto-unmount-on-exit", false, "Unmount from a watchdog process if this process dies, even when killed")
+	mountCmd.Flags().Int("supervisor-pid", 0, "Unmount when the process with this PID exits")
 	mountCmd.Flags().String("cache-dir", "", "Directory for caching files (default: temp dir)")
 	mountCmd.Flags().Duration("cache-ttl", 0, "Cache time-to-live (0 to disable caching)")
+	mountCmd.Flags().Bool("verify-uploads", false, "Check the stored content of every upload against the written data")
+	mountCmd.Flags().String("staging-dir", "", "Directory for staging files being written (default: cache or temp dir)")
+	mountCmd.Flags().String("overlay", "", "Keep all changes in this local directory instead of writing them to the remote directory")
+	mountCmd.Flags().Bool("offline", false, "Serve only cached files and folders, read-only, without contacting the server")
+	mountCmd.Flags().Bool("mirror", false, "Keep a full copy of the directory in the cache in the background and serve only from it, read-only")
+	mountCmd.Flags().Bool("append-only", false, "Allow adding files and folders but never changing, replacing or deleting existing ones")
+	mountCmd.Flags().Int("preload-depth", 0, "List this many directory levels in the background after mounting")
+	mountCmd.Flags().Duration("op-timeout", 0, "Fail operations such as listings with ETIMEDOUT when the server takes longer (default 1m, 0 for no limit)")
+	mountCmd.Flags().Bool("hard", false, "Retry operations until the server answers or they are interrupted, instead of failing them after --op-timeout")
+	mountCmd.Flags().Bool("force", false, "Unmount on interrupt even if pending changes could not be uploaded")
+	mountCmd.Flags().String("trace-path", "", "Log all operations and API calls on paths matching this glob, whatever the log level")
+	mountCmd.Flags().String("consistency", "default", "How closely to follow changes made by others: strict, default or relaxed")
+	mountCmd.Flags().String("debug-addr", "", "Serve pprof profiles and expvar variables on this address, e.g. localhost:6060")
+	mountCmd.Flags().String("status-addr", "", "Serve the read-only status API on this address, e.g. localhost:7070")
+	mountCmd.Flags().Bool("ci", false, "Run unattended in a CI pipeline: check the server first, fail fast, log JSON and unmount when the parent process exits")
 	
 	viper.BindPFlag("mount.readonly", mountCmd.Flags().Lookup("readonly"))
+	viper.BindPFlag("mount.remote_path", mountCmd.Flags().Lookup("remote-path"))
+	viper.BindPFlag("mount.map", mountCmd.Flags().Lookup("map"))
 	viper.BindPFlag("mount.allow_other", mountCmd.Flags().Lookup("allow-other"))
-	viper.BindPFlag("mount.cache_dir", mountCmd.Flags().Lookup("cache-dir"))
-	viper.BindPFlag("mount.cache_ttl", mountCmd.Flags().Lookup("cache-ttl"))
+	viper.BindPFlag("mount.root_mode", mountCmd.Flags().Lookup("root-mode"))
+	viper.BindPFlag("mount.root_owner", mountCmd.Flags().Lookup("root-owner"))
+	viper.BindPFlag("mount.nonempty", mountCmd.Flags().Lookup("nonempty"))
+	viper.BindPFlag("mount.auto_unmount", mountCmd.Flags().Lookup("auto-unmount-on-exit"))
+	viper.BindPFlag("cache.directory", mountCmd.Flags().Lookup("cache-dir"))
+	viper.BindPFlag("cache.ttl", mountCmd.Flags().Lookup("cache-ttl"))
+	viper.BindPFlag("mount.staging_dir", mountCmd.Flags().Lookup("staging-dir"))
+	viper.BindPFlag("mount.overlay_dir", mountCmd.Flags().Lookup("overlay"))
+	viper.BindPFlag("mount.offline", mountCmd.Flags().Lookup("offline"))
+	viper.BindPFlag("mount.mirror", mountCmd.Flags().Lookup("mirror"))
+	viper.BindPFlag("mount.append_only", mountCmd.Flags().Lookup("append-only"))
+	viper.BindPFlag("mount.preload_depth", mountCmd.Flags().Lookup("preload-depth"))
+	viper.BindPFlag("mount.op_timeout", mountCmd.Flags().Lookup("op-timeout"))
+	viper.BindPFlag("mount.hard", mountCmd.Flags().Lookup("hard"))
+	viper.BindPFlag("mount.trace_path", mountCmd.Flags().Lookup("trace-path"))
+	viper.BindPFlag("mount.consistency", mountCmd.Flags().Lookup("consistency"))
+	viper.BindPFlag("mount.debug_addr", mountCmd.Flags().Lookup("debug-addr"))
+	viper.BindPFlag("mount.status_addr", mountCmd.Flags().Lookup("status-addr"))
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressBar reports transfer progress. On a terminal it redraws a single
+// status line on stderr; otherwise it prints one line per finished file.
+type progressBar struct {
+	verb string // printed before file names, e.g. "put"
+	name string // shown for a single file, which has no relative path
+	tty  bool
+
+	files int
+	total int64
+	bytes atomic.Int64
+	start time.Time
+
+	mu       sync.Mutex
+	finished int
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+func newProgressBar(verb, name string) *progressBar {
+	return &progressBar{verb: verb, name: name, tty: isTerminal(os.Stderr)}
+}
+
+func (p *progressBar) Start(files int, bytes int64) {
+	p.files = files
+	p.total = bytes
+	p.start = time.Now()
+
+	if !p.tty || files == 0 {
+		return
+	}
+
+	p.stop = make(chan struct{})
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.drawLocked()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (p *progressBar) Transferred(n int64) {
+	p.bytes.Add(n)
+}
+
+func (p *progressBar) FileDone(rel string, size int64, skipped bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finished++
+	name := rel
+	if name == "" {
+		name = p.name
+	}
+
+	switch {
+	case err != nil:
+		p.clearLocked()
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+	case p.tty || skipped:
+	default:
+		fmt.Printf("%-6s %s (%s)\n", p.verb, name, formatSize(size))
+	}
+
+	if p.tty {
+		p.drawLocked()
+	}
+}
+
+func (p *progressBar) Finish() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stopped.Wait()
+	}
+	if p.tty && p.files > 0 {
+		p.mu.Lock()
+		p.drawLocked()
+		p.mu.Unlock()
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *progressBar) drawLocked() {
+	const width = 30
+
+	done := p.bytes.Load()
+	if done > p.total {
+		done = p.total
+	}
+	ratio := 1.0
+	if p.total > 0 {
+		ratio = float64(done) / float64(p.total)
+	}
+
+	filled := int(ratio * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	var rate int64
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = int64(float64(done) / elapsed)
+	}
+
+	fmt.Fprintf(os.Stderr, "\r[%s] %3.0f%%  %s/%s  %d/%d files  %s/s\033[K",
+		bar, ratio*100, formatSize(done), formatSize(p.total), p.finished, p.files, formatSize(rate))
+}
+
+func (p *progressBar) clearLocked() {
+	if p.tty {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/notify"
+	"github.com/koneksi/koneksi-drive/internal/transfer"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+var putCmd = &cobra.Command{
+	Use:   "put <local-path> [remote-path]",
+	Short: "Upload a file or directory",
+	Long: `Upload a local file or directory tree without mounting. Files are
+transferred in parallel and files already up to date on the server are
+skipped. An interrupted upload resumes when the same command is run again.
+
+The remote path defaults to the local name in the root folder. A file
+uploaded onto an existing folder is placed inside it.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := transferOptions(cmd, "put", filepath.Base(args[0]))
+		if err != nil {
+			return err
+		}
+		verify, _ := cmd.Flags().GetBool("verify-uploads")
+
+		dst := "/" + filepath.Base(args[0])
+		if len(args) > 1 {
+			dst = remotePath(args[1:])
+		}
+
+		storage, client, cfg, err := newStorage()
+		if err != nil {
+			return err
+		}
+		if err := requireWrite(client); err != nil {
+			return err
+		}
+		if verify {
+			cfg.Upload.Verify = true
+		}
+
+		opts.Hooks = hooks.New(cfg.Hooks, cfg.API.DirectoryID, "put")
+		defer opts.Hooks.Close(hooksTimeout)
+
+		t := transfer.New(cfg.API.DirectoryID, storage, upload.New(storage, &cfg.Upload), opts)
+		summary, err := t.Put(context.Background(), args[0], dst)
+		if summary != nil {
+			printTransferSummary(summary, "uploaded")
+		}
+		if err != nil {
+			notifier := notify.New(&cfg.Notifications)
+			notifier.Notify("put", "Upload failed", err.Error())
+			notifier.Wait()
+		}
+		return err
+	},
+}
+
+// transferOptions reads the flags shared by put and get.
+func transferOptions(cmd *cobra.Command, verb, name string) (transfer.Options, error) {
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	checksum, _ := cmd.Flags().GetBool("checksum")
+	state, _ := cmd.Flags().GetString("state")
+
+	if concurrency < 1 {
+		return transfer.Options{}, fmt.Errorf("--concurrency must be at least 1")
+	}
+
+	return transfer.Options{
+		Concurrency: concurrency,
+		Checksum:    checksum,
+		StateFile:   state,
+		Progress:    newProgressBar(verb, name),
+	}, nil
+}
+
+func printTransferSummary(summary *transfer.Summary, verb string) {
+	fmt.Printf("%d files %s (%s), %d up to date", summary.Files, verb, formatSize(summary.Bytes), summary.Skipped)
+	if summary.Failed > 0 {
+		fmt.Printf(", %d failed", summary.Failed)
+	}
+	fmt.Println()
+}
+
+func addTransferFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("concurrency", 4, "Number of files transferred in parallel")
+	cmd.Flags().Bool("checksum", false, "Compare content hashes instead of size and modification time")
+	cmd.Flags().String("state", "", "State file for resuming (default: in the user cache directory)")
+}
+
+func init() {
+	rootCmd.AddCommand(putCmd)
+
+	addTransferFlags(putCmd)
+	putCmd.Flags().Bool("verify-uploads", false, "Check the stored content of every upload against the local file")
+}
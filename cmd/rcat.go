@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+var rcatCmd = &cobra.Command{
+	Use:   "rcat <remote-path>",
+	Short: "Upload standard input to a remote file",
+	Long: `Read standard input until it ends and store it as a remote file,
+replacing its content if it exists. Output of other tools can be piped
+straight into Koneksi:
+
+  tar -cz ~/projects | koneksi-drive rcat /backups/projects.tar.gz
+  pg_dump app | koneksi-drive rcat /dumps/app.sql
+
+Input of up to --buffer bytes is held in memory and uploaded like any
+file. Longer input is streamed to the server as it is read, without being
+stored on local disk, unless uploads are scanned for malware: scanners need
+the whole content, so it is staged in a temporary file first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		buffer, _ := cmd.Flags().GetInt64("buffer")
+		if buffer < 0 {
+			return fmt.Errorf("--buffer must not be negative")
+		}
+		if isTerminal(os.Stdin) {
+			return fmt.Errorf("rcat uploads standard input; pipe the content into it")
+		}
+		dst := remotePath(args)
+
+		storage, client, cfg, err := newStorage()
+		if err != nil {
+			return err
+		}
+		if err := requireWrite(client); err != nil {
+			return err
+		}
+		if info, err := storage.Stat(context.Background(), dst); err == nil {
+			if info.IsDir {
+				return fmt.Errorf("%s is a folder", dst)
+			}
+			if err := api.CheckRetention(info); err != nil {
+				return err
+			}
+		}
+
+		h := hooks.New(cfg.Hooks, cfg.API.DirectoryID, "rcat")
+		defer h.Close(hooksTimeout)
+
+		size, err := upload.New(storage, &cfg.Upload).UploadStream(context.Background(), dst, os.Stdin, buffer)
+		if err != nil {
+			return err
+		}
+		h.Fire(hooks.Event{Event: hooks.Upload, Path: dst, Size: size})
+		fmt.Fprintf(os.Stderr, "%s uploaded to %s\n", formatSize(size), dst)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rcatCmd)
+
+	rcatCmd.Flags().Int64("buffer", 16<<20, "Bytes of input held in memory; longer input is streamed")
+}
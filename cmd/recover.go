@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/instance"
+	"github.com/koneksi/koneksi-drive/internal/recovery"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover [path...]",
+	Short: "Inspect, upload or discard changes a mount could not upload",
+	Long: `List the changes to files written through a mount that were not
+uploaded because the mount crashed, was stopped, or could not reach the
+server. They are kept in the staging or cache directory and uploaded the
+next time the directory is mounted.
+
+--upload uploads them now and --discard drops them, for the given remote
+paths or all. Changes to a file that changed on the server since are
+uploaded next to it, as a copy named like "notes.recovered-20260102-150405.txt".`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		doUpload, _ := cmd.Flags().GetBool("upload")
+		discard, _ := cmd.Flags().GetBool("discard")
+		if doUpload && discard {
+			return errors.New("--upload and --discard cannot be combined")
+		}
+
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		journal, err := recovery.OpenConfigured(cfg)
+		if err != nil {
+			return err
+		}
+		if journal == nil {
+			return errors.New("no recovery journal: mount.recovery is disabled or neither mount.staging_dir nor cache.directory is set")
+		}
+
+		entries, err := journal.Entries()
+		if err != nil {
+			return err
+		}
+		entries = selectEntries(entries, args)
+
+		if !doUpload && !discard {
+			if len(entries) == 0 {
+				fmt.Println("No pending changes.")
+				return nil
+			}
+			for _, e := range entries {
+				fmt.Println(describeEntry(journal, e))
+			}
+			return nil
+		}
+
+		// A running mount owns the journal.
+		lock, err := instance.Acquire(cfg.API.DirectoryID, "")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		if discard {
+			for _, e := range entries {
+				if err := journal.Remove(e); err != nil {
+					return err
+				}
+				fmt.Printf("discarded %s\n", e.Path)
+			}
+			return nil
+		}
+
+		storage, client, _, err := newStorage()
+		if err != nil {
+			return err
+		}
+		if err := requireWrite(client); err != nil {
+			return err
+		}
+		up := upload.New(storage, &cfg.Upload)
+
+		failed := 0
+		for _, e := range entries {
+			target, err := journal.Resume(context.Background(), storage, up, e)
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "failed to upload %s: %v\n", e.Path, err)
+				failed++
+			case target != e.Path:
+				fmt.Printf("uploaded %s as %s (the file changed since)\n", e.Path, target)
+			default:
+				fmt.Printf("uploaded %s\n", e.Path)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d uploads failed; the changes are kept", failed, len(entries))
+		}
+		return nil
+	},
+}
+
+// selectEntries returns the entries for the remote paths given, or all
+// entries if none are.
+func selectEntries(entries []*recovery.Entry, paths []string) []*recovery.Entry {
+	if len(paths) == 0 {
+		return entries
+	}
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[path.Clean("/"+p)] = true
+	}
+
+	var selected []*recovery.Entry
+	for _, e := range entries {
+		if want[e.Path] {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// describeEntry summarizes pending changes, e.g.
+// "2026-01-02 15:04    1.2MiB  /notes.txt (whole file)".
+func describeEntry(journal *recovery.Journal, e *recovery.Entry) string {
+	size := "?"
+	if info, err := os.Stat(journal.ContentFile(e)); err == nil {
+		size = formatSize(info.Size())
+	}
+
+	kind := "whole file"
+	if e.Append {
+		kind = "appended after " + formatSize(e.BaseSize)
+	}
+	return fmt.Sprintf("%s  %8s  %s (%s)", e.Created.Local().Format("2006-01-02 15:04"), size, e.Path, kind)
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+
+	recoverCmd.Flags().Bool("upload", false, "Upload the pending changes now")
+	recoverCmd.Flags().Bool("discard", false, "Drop the pending changes")
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/fs"
+	"github.com/koneksi/koneksi-drive/internal/instance"
+	"github.com/spf13/cobra"
+)
+
+var remountCmd = &cobra.Command{
+	Use:   "remount <mountpoint>",
+	Short: "Change options of a running mount without unmounting it",
+	Long: `Change options of a mount running on this host while applications keep
+using it, instead of unmounting and mounting again:
+
+  --readonly / --readwrite      deny or allow writes
+  --consistency MODE            how closely to follow changes made by others
+  --read-limit / --write-limit  bytes per second through the limit layer
+
+Changes not given are left as they are; without any, the options in
+effect are shown. A mount started read-only cannot be made writable, as
+the kernel holds it read-only, and bandwidth limits can only be changed
+when api.layers has the limit layer. Files open for writing still upload
+what was written before a switch to read-only.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var change fs.RemountOptions
+		flags := cmd.Flags()
+		if flags.Changed("readonly") && flags.Changed("readwrite") {
+			return fmt.Errorf("--readonly and --readwrite cannot be combined")
+		}
+		if flags.Changed("readonly") {
+			readOnly, _ := flags.GetBool("readonly")
+			change.ReadOnly = &readOnly
+		}
+		if flags.Changed("readwrite") {
+			readWrite, _ := flags.GetBool("readwrite")
+			readOnly := !readWrite
+			change.ReadOnly = &readOnly
+		}
+		if flags.Changed("consistency") {
+			consistency, _ := flags.GetString("consistency")
+			change.Consistency = &consistency
+		}
+		if flags.Changed("read-limit") {
+			limit, _ := flags.GetInt64("read-limit")
+			change.ReadLimit = &limit
+		}
+		if flags.Changed("write-limit") {
+			limit, _ := flags.GetInt64("write-limit")
+			change.WriteLimit = &limit
+		}
+
+		socket, err := controlSocket(args[0])
+		if err != nil {
+			return err
+		}
+		client := &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+
+		var resp *http.Response
+		if change == (fs.RemountOptions{}) {
+			resp, err = client.Get("http://koneksi-drive/options")
+		} else {
+			body, _ := json.Marshal(change)
+			resp, err = client.Post("http://koneksi-drive/remount", "application/json", bytes.NewReader(body))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reach the mount: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var failure struct{ Error string }
+			json.NewDecoder(resp.Body).Decode(&failure)
+			return errors.New(failure.Error)
+		}
+		var opts fs.RemountOptions
+		if err := json.NewDecoder(resp.Body).Decode(&opts); err != nil {
+			return fmt.Errorf("failed to read the mount's answer: %w", err)
+		}
+
+		readOnly := "no"
+		if opts.ReadOnly != nil && *opts.ReadOnly {
+			readOnly = "yes"
+		}
+		fmt.Printf("read-only:    %s\n", readOnly)
+		if opts.Consistency != nil {
+			fmt.Printf("consistency:  %s\n", *opts.Consistency)
+		}
+		fmt.Printf("read limit:   %s\n", describeLimit(opts.ReadLimit))
+		fmt.Printf("write limit:  %s\n", describeLimit(opts.WriteLimit))
+		return nil
+	},
+}
+
+// controlSocket returns the control socket of the mount running at
+// mountpoint.
+func controlSocket(mountpoint string) (string, error) {
+	abs, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	running, err := instance.Running()
+	if err != nil {
+		return "", err
+	}
+	for _, inst := range running {
+		if inst.Mountpoint != abs {
+			continue
+		}
+		if inst.Control == "" {
+			return "", fmt.Errorf("the mount at %s (pid %d) has no control socket", abs, inst.PID)
+		}
+		return inst.Control, nil
+	}
+	return "", fmt.Errorf("no mount of yours is running at %s", abs)
+}
+
+// describeLimit formats a bandwidth limit of RemountOptions.
+func describeLimit(limit *int64) string {
+	switch {
+	case limit == nil:
+		return "- (no limit layer)"
+	case *limit == 0:
+		return "none"
+	}
+	return formatSize(*limit) + "/s"
+}
+
+func init() {
+	rootCmd.AddCommand(remountCmd)
+
+	remountCmd.Flags().Bool("readonly", false, "Deny writes from now on")
+	remountCmd.Flags().Bool("readwrite", false, "Allow writes again after --readonly")
+	remountCmd.Flags().String("consistency", "", "How closely to follow changes made by others: strict, default or relaxed")
+	remountCmd.Flags().Int64("read-limit", 0, "Bytes per second read through the limit layer, 0 for no limit")
+	remountCmd.Flags().Int64("write-limit", 0, "Bytes per second written through the limit layer, 0 for no limit")
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var retainCmd = &cobra.Command{
+	Use:   "retain <remote-path>...",
+	Short: "Show or extend the retention of remote files",
+	Long: `Show how long remote files are under retention, or with --until keep
+them from being changed or deleted before a point in time: a date
+(2032-01-01), an RFC 3339 time or a period from now (90d, 7y). Folders
+apply to every file below them.
+
+Retention can only be extended. Until it expires, the mount refuses to
+change, rename or delete the file with EPERM, sync leaves it alone and
+put, copy and rcat fail to replace it; the server enforces it too.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		untilFlag, _ := cmd.Flags().GetString("until")
+		var until time.Time
+		if untilFlag != "" {
+			var err error
+			if until, err = config.ParseUntil(untilFlag, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		client, _, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, arg := range args {
+			root := remotePath([]string{arg})
+			if root != "/" {
+				info, err := client.Stat(context.Background(), root)
+				if err != nil {
+					return err
+				}
+				if !info.IsDir {
+					if err := retainFile(client, root, info, until); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			err := api.Walk(context.Background(), client, root, func(p string, info api.FileInfo) error {
+				if info.IsDir {
+					return nil
+				}
+				if err := retainFile(client, p, &info, until); err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", p, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("retention of %d files could not be set", failed)
+		}
+		return nil
+	},
+}
+
+// retainFile prints the retention of the file p, described by info,
+// after extending it to until unless that is zero.
+func retainFile(client *api.Client, p string, info *api.FileInfo, until time.Time) error {
+	if !until.IsZero() {
+		var err error
+		if info, err = client.SetRetention(context.Background(), p, until); err != nil {
+			return err
+		}
+	}
+	printRetention(p, info)
+	return nil
+}
+
+func printRetention(p string, info *api.FileInfo) {
+	if !info.Retained(time.Now()) {
+		fmt.Printf("%s: not retained\n", p)
+		return
+	}
+	fmt.Printf("%s: retained until %s\n", p, info.RetainUntil.Local().Format("2006-01-02 15:04"))
+}
+
+func init() {
+	rootCmd.AddCommand(retainCmd)
+
+	retainCmd.Flags().String("until", "", "Retain the files until this date, RFC 3339 time or period from now, e.g. 2032-01-01 or 7y")
+}
@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 
+	"github.com/koneksi/koneksi-drive/internal/api"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -18,7 +22,28 @@ using FUSE. This enables you to access your cloud storage as if it were a local
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if hint := errorHint(err); hint != "" {
+		return fmt.Errorf("%w\n%s", err, hint)
+	}
+	return err
+}
+
+// errorHint returns what to do about err, for kinds of errors whose
+// message alone does not tell.
+func errorHint(err error) string {
+	var statusErr *api.StatusError
+	switch {
+	case errors.Is(err, api.ErrUnauthorized):
+		return "Check api.client_id and api.client_secret, and that they may access api.directory_id."
+	case errors.Is(err, api.ErrQuotaExceeded):
+		return "The storage quota is used up; delete files or raise the quota."
+	case errors.As(err, &statusErr) && errors.Is(err, api.ErrRateLimited) && statusErr.RetryAfter > 0:
+		return fmt.Sprintf("The server limits requests; try again in %s.", statusErr.RetryAfter)
+	case errors.Is(err, api.ErrRateLimited):
+		return "The server limits requests; try again later."
+	}
+	return ""
 }
 
 func init() {
@@ -26,6 +51,8 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.koneksi-drive.yaml)")
 	rootCmd.PersistentFlags().Bool("debug", false, "Enable debug logging")
+	rootCmd.PersistentFlags().String("local", "", "keep files in this local folder instead of on the server, for development and demos")
+	rootCmd.PersistentFlags().StringToString("tag", nil, "accounting tag name=value sent with every request, added to api.tags (repeatable)")
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
 }
 
@@ -43,7 +70,40 @@ func initConfig() {
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	readErr := viper.ReadInConfig()
+
+	// --local needs no config file or credentials.
+	if dir, _ := rootCmd.PersistentFlags().GetString("local"); dir != "" {
+		viper.Set("api.backend", "local")
+		viper.Set("api.local_root", dir)
+	}
+	if flagTags, _ := rootCmd.PersistentFlags().GetStringToString("tag"); len(flagTags) > 0 {
+		tags := viper.GetStringMapString("api.tags")
+		for name, value := range flagTags {
+			tags[strings.ToLower(name)] = value
+		}
+		viper.Set("api.tags", tags)
+	}
+
+	// Mounts for CI pipelines log JSON lines only.
+	ci, _ := mountCmd.Flags().GetBool("ci")
+
+	level := slog.LevelInfo
+	if viper.GetBool("debug") {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if ci {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	}
+
+	if readErr == nil {
+		if ci {
+			slog.Info("using config file", "path", viper.ConfigFileUsed())
+		} else {
+			fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+		}
 	}
 }
\ No newline at end of file
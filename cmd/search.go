@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search [name]",
+	Short: "Search remote files by name, type, date or owner",
+	Long: `Search files using the server's search endpoint instead of scanning a
+mount. Dates accept YYYY-MM-DD, RFC 3339 timestamps or an age such as 7d
+(meaning 7 days ago).`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileType, _ := cmd.Flags().GetString("type")
+		after, _ := cmd.Flags().GetString("modified-after")
+		before, _ := cmd.Flags().GetString("modified-before")
+		owner, _ := cmd.Flags().GetString("owner")
+		limit, _ := cmd.Flags().GetInt("limit")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		downloadDir, _ := cmd.Flags().GetString("download")
+
+		query := api.SearchQuery{
+			Type:  fileType,
+			Owner: owner,
+			Limit: limit,
+		}
+		if len(args) > 0 {
+			query.Name = args[0]
+		}
+
+		var err error
+		if query.ModifiedAfter, err = parseTimeArg(after); err != nil {
+			return fmt.Errorf("invalid --modified-after: %w", err)
+		}
+		if query.ModifiedBefore, err = parseTimeArg(before); err != nil {
+			return fmt.Errorf("invalid --modified-before: %w", err)
+		}
+
+		client, _, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		results, err := client.Search(context.Background(), query)
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(results); err != nil {
+				return err
+			}
+		} else {
+			for _, file := range results {
+				kind := "-"
+				if file.IsDir {
+					kind = "d"
+				}
+				fmt.Printf("%s %10s  %s  %s\n", kind, formatSize(file.Size),
+					file.Modified.Local().Format("2006-01-02 15:04"), file.Path)
+			}
+		}
+
+		if downloadDir != "" {
+			return downloadResults(client, results, downloadDir)
+		}
+		return nil
+	},
+}
+
+// downloadResults saves every file in results below dir, keeping the
+// remote folder structure so files with the same name do not collide.
+func downloadResults(client *api.Client, results []api.FileInfo, dir string) error {
+	var count int
+	for _, file := range results {
+		if file.IsDir || file.Path == "" {
+			continue
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(file.Path, "/")))
+		fmt.Fprintf(os.Stderr, "Downloading %s\n", file.Path)
+		if err := downloadFile(client, file.Path, dest); err != nil {
+			return fmt.Errorf("failed to download %s: %w", file.Path, err)
+		}
+		count++
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloaded %d files to %s\n", count, dir)
+	return nil
+}
+
+// downloadFile copies a remote file to dest, writing to a temporary file
+// first so an interrupted download never leaves a truncated file behind.
+func downloadFile(client *api.Client, remote, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	reader, err := client.Read(context.Background(), remote)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".koneksi-download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
+
+// parseTimeArg parses a date, a timestamp or an age relative to now.
+func parseTimeArg(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := config.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().String("type", "", `Filter by type: "file", "folder" or a MIME prefix such as "image/"`)
+	searchCmd.Flags().String("modified-after", "", "Only files modified after this date or age")
+	searchCmd.Flags().String("modified-before", "", "Only files modified before this date or age")
+	searchCmd.Flags().String("owner", "", "Only files owned by this user")
+	searchCmd.Flags().Int("limit", 0, "Maximum number of results (default: server limit)")
+	searchCmd.Flags().Bool("json", false, "Output as JSON")
+	searchCmd.Flags().String("download", "", "Download all matching files into this directory")
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share <path>",
+	Short: "Create a public link to a remote file or folder",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		expires, _ := cmd.Flags().GetString("expires")
+		password, _ := cmd.Flags().GetString("password")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		opts := api.ShareLinkOptions{Password: password}
+		if expires != "" {
+			d, err := config.ParseDuration(expires)
+			if err != nil {
+				return err
+			}
+			opts.Expires = d
+		}
+
+		client, _, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		link, err := client.CreateShareLink(context.Background(), remotePath(args), opts)
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(link)
+		}
+
+		fmt.Println(link.URL)
+		if link.ExpiresAt != nil {
+			fmt.Fprintf(os.Stderr, "Expires: %s\n", link.ExpiresAt.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+
+	shareCmd.Flags().String("expires", "", "Link lifetime, e.g. 12h, 7d or 2w (default: no expiry)")
+	shareCmd.Flags().String("password", "", "Password required to open the link")
+	shareCmd.Flags().Bool("json", false, "Output as JSON")
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/fs"
+	"github.com/koneksi/koneksi-drive/internal/instance"
+	"github.com/spf13/cobra"
+)
+
+// statusInterval is how often a running mount publishes its status.
+const statusInterval = 10 * time.Second
+
+// mountStatus is what a running mount publishes for the status command.
+type mountStatus struct {
+	DirectoryID string           `json:"directory_id"`
+	Mountpoint  string           `json:"mountpoint"`
+	PID         int              `json:"pid"`
+	ReadOnly    bool             `json:"read_only"`
+	Caps        api.Capabilities `json:"capabilities"`
+	APIVersion  string           `json:"api_version"`
+	Unreachable time.Time        `json:"unreachable_since"` // zero while the server answers
+	Updated     time.Time        `json:"updated"`
+	Session     fs.SessionStats  `json:"session"`
+	Memory      fs.MemoryUsage   `json:"memory"`
+	StatusAddr  string           `json:"status_addr,omitempty"` // where the status API is served
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show running mounts and what they have transferred",
+	Long: `List the mounts running on this host with what their access token
+allows and their traffic so far: bytes uploaded and downloaded, API calls
+by type, the cache hit ratio and approximate memory use. Mounts publish these figures every 10
+seconds.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		running, err := instance.Running()
+		if err != nil {
+			return err
+		}
+
+		statuses := make([]mountStatus, 0, len(running))
+		for _, inst := range running {
+			status := mountStatus{PID: inst.PID, Mountpoint: inst.Mountpoint}
+			if inst.Status != nil {
+				json.Unmarshal(inst.Status, &status)
+			}
+			statuses = append(statuses, status)
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Mountpoint < statuses[j].Mountpoint })
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(statuses)
+		}
+
+		if len(statuses) == 0 {
+			fmt.Println("No mounts running.")
+			return nil
+		}
+		for i, status := range statuses {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (directory %s, pid %d)\n", status.Mountpoint, status.DirectoryID, status.PID)
+			if status.Updated.IsZero() {
+				fmt.Println("  no status published yet")
+				continue
+			}
+			mode := "read-write"
+			if status.ReadOnly {
+				mode = "read-only"
+			}
+			fmt.Printf("  %-12s %s\n", "mode:", mode)
+			fmt.Printf("  %-12s %s\n", "token:", describeCapabilities(status.Caps))
+			if role := status.Caps.Role; role != "" {
+				if api.RoleCanWrite(role) {
+					fmt.Printf("  %-12s %s\n", "role:", role)
+				} else {
+					fmt.Printf("  %-12s %s, cannot write to the server\n", "role:", role)
+				}
+			}
+			if status.Unreachable.IsZero() {
+				fmt.Printf("  %-12s %s\n", "api:", status.APIVersion)
+			} else {
+				fmt.Printf("  %-12s %s, server unreachable for %s\n", "api:", status.APIVersion,
+					status.Updated.Sub(status.Unreachable).Round(time.Second))
+			}
+			printSession(os.Stdout, status.Session)
+			printMemory(os.Stdout, status.Memory)
+			if status.StatusAddr != "" {
+				fmt.Printf("  %-12s http://%s/api/v1/status\n", "status api:", status.StatusAddr)
+			}
+			fmt.Printf("  %-12s %s ago\n", "updated:", time.Since(status.Updated).Round(time.Second))
+		}
+		return nil
+	},
+}
+
+// describeCapabilities summarizes what a token allows, e.g.
+// "read, write (scopes: files:read files:write)".
+func describeCapabilities(caps api.Capabilities) string {
+	allowed := []string{"read"}
+	if caps.Write {
+		allowed = append(allowed, "write")
+	}
+	if caps.Share {
+		allowed = append(allowed, "share links")
+	}
+	desc := strings.Join(allowed, ", ")
+	if len(caps.Scopes) == 0 {
+		return desc + " (no scopes reported)"
+	}
+	return desc + " (scopes: " + strings.Join(caps.Scopes, " ") + ")"
+}
+
+// printSession writes the traffic summary of a mount session.
+func printSession(w io.Writer, s fs.SessionStats) {
+	if !s.Started.IsZero() {
+		fmt.Fprintf(w, "  %-12s %s (since %s)\n", "mounted:",
+			time.Since(s.Started).Round(time.Second), s.Started.Local().Format("2006-01-02 15:04"))
+	}
+	if len(s.Tags) > 0 {
+		tags := make([]string, 0, len(s.Tags))
+		for name, value := range s.Tags {
+			tags = append(tags, name+"="+value)
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(w, "  %-12s %s\n", "tags:", strings.Join(tags, " "))
+	}
+	fmt.Fprintf(w, "  %-12s %s\n", "uploaded:", formatSize(s.Uploaded))
+	fmt.Fprintf(w, "  %-12s %s\n", "downloaded:", formatSize(s.Downloaded))
+
+	var total int64
+	ops := make([]string, 0, len(s.Calls))
+	for op, n := range s.Calls {
+		total += n
+		ops = append(ops, op)
+	}
+	// Most frequent first.
+	sort.Slice(ops, func(i, j int) bool {
+		if s.Calls[ops[i]] != s.Calls[ops[j]] {
+			return s.Calls[ops[i]] > s.Calls[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = fmt.Sprintf("%s %d", op, s.Calls[op])
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(w, "  %-12s %d (%s)\n", "api calls:", total, strings.Join(parts, ", "))
+	} else {
+		fmt.Fprintf(w, "  %-12s 0\n", "api calls:")
+	}
+	if s.SharedCalls > 0 {
+		fmt.Fprintf(w, "  %-12s %d calls joined an identical request in flight\n", "shared:", s.SharedCalls)
+	}
+	if s.HedgedCalls > 0 {
+		fmt.Fprintf(w, "  %-12s %d slow reads sent again, %d answered first by the second request\n", "hedged:", s.HedgedCalls, s.HedgeWins)
+	}
+
+	if m := s.Mirror; m != nil {
+		fmt.Fprintf(w, "  %-12s %s\n", "mirror:", describeMirror(m))
+	}
+
+	if opens := s.CacheHits + s.CacheMisses; opens > 0 {
+		fmt.Fprintf(w, "  %-12s %.0f%% hits (%d of %d opens)\n", "cache:",
+			float64(s.CacheHits)/float64(opens)*100, s.CacheHits, opens)
+	}
+
+	if len(s.Layers) > 0 {
+		fmt.Fprintf(w, "  %-12s (each including those below it)\n", "layers:")
+	}
+	for _, l := range s.Layers {
+		var calls, failed int64
+		for _, n := range l.Calls {
+			calls += n
+		}
+		for _, n := range l.Errors {
+			failed += n
+		}
+		line := fmt.Sprintf("%d calls, %s read, %s written", calls, formatSize(l.Read), formatSize(l.Written))
+		if reads := l.CacheHits + l.CacheMisses; reads > 0 {
+			line += fmt.Sprintf(", %.0f%% hits", float64(l.CacheHits)/float64(reads)*100)
+		}
+		if median := medianLatency(l.Latency["read"]); median != "" {
+			line += ", reads " + median
+		}
+		if median := medianLatency(l.Latency["write"]); median != "" {
+			line += ", writes " + median
+		}
+		if failed > 0 {
+			line += fmt.Sprintf(", %d failed", failed)
+		}
+		fmt.Fprintf(w, "    %-10s %s\n", l.Name, line)
+	}
+}
+
+// medianLatency names the bucket of api.LatencyBuckets the median of the
+// calls counted in buckets falls in, e.g. "<250ms", or "" for no calls.
+func medianLatency(buckets []int64) string {
+	var total int64
+	for _, n := range buckets {
+		total += n
+	}
+	if total == 0 {
+		return ""
+	}
+	var seen int64
+	for i, n := range buckets {
+		seen += n
+		if 2*seen >= total && i < len(api.LatencyBuckets) {
+			return "<" + api.LatencyBuckets[i].String()
+		}
+	}
+	return ">" + api.LatencyBuckets[len(api.LatencyBuckets)-1].String()
+}
+
+// printMemory writes the approximate memory use of a mount.
+func printMemory(w io.Writer, m fs.MemoryUsage) {
+	line := fmt.Sprintf("%s (metadata %s for %d nodes, write buffers %s, readahead %s, blocks %s)",
+		formatSize(m.Total), formatSize(m.Metadata), m.Nodes, formatSize(m.Buffers), formatSize(m.Readahead), formatSize(m.Blocks))
+	if m.Limit > 0 {
+		line += " of " + formatSize(m.Limit)
+	}
+	if m.Pressure {
+		line += ", shedding caches"
+	}
+	fmt.Fprintf(w, "  %-12s %s\n", "memory:", line)
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().Bool("json", false, "Output as JSON")
+}
+
+// describeMirror summarizes the mirror of a mirror mount, e.g. "1204
+// files (3.1GiB), updated 4m ago, verified 2h ago, 2 repaired".
+func describeMirror(m *fs.MirrorStats) string {
+	ago := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	}
+	desc := fmt.Sprintf("%d files (%s), updated %s, verified %s", m.Files, formatSize(m.Bytes), ago(m.Synced), ago(m.Verified))
+	if m.Repaired > 0 {
+		desc += fmt.Sprintf(", %d repaired", m.Repaired)
+	}
+	if m.Failed > 0 {
+		desc += fmt.Sprintf(", %d failed to download", m.Failed)
+	}
+	if m.LastError != "" {
+		desc += "; last update failed: " + m.LastError
+	}
+	return desc
+}
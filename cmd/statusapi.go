@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/fs"
+)
+
+const (
+	// recentErrorsKept is how many warnings and errors the status API
+	// returns.
+	recentErrorsKept = 100
+	// quotaCacheTTL is how long the status API reuses the quota it got
+	// from the server.
+	quotaCacheTTL = time.Minute
+)
+
+// serveStatusAPI serves the read-only status API of the mount on addr
+// until the returned server is closed. It is a public interface for tray
+// apps and dashboards: within /api/v1, fields are only ever added.
+//
+//	/api            the versions served
+//	/api/v1/status  what status --json shows for the mount, live
+//	/api/v1/transfers  uploads and downloads in progress, and files
+//	                   with changes not uploaded yet
+//	/api/v1/errors  the latest warnings and errors logged
+//	/api/v1/quota   the storage quota
+func serveStatusAPI(addr string, kfs *fs.KoneksiFS, status func() mountStatus, recent *recentLog) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(ln.Addr().String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsLoopback() {
+			slog.Warn("status API is reachable from other hosts", "addr", ln.Addr())
+		}
+	}
+
+	var quotaMu sync.Mutex
+	var quota *api.Quota
+	var quotaErr error
+	var quotaAt time.Time
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"versions": []string{"v1"}})
+	})
+	mux.HandleFunc("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, status())
+	})
+	mux.HandleFunc("/api/v1/transfers", func(w http.ResponseWriter, r *http.Request) {
+		pending := kfs.PendingNow()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"active":  kfs.Transfers(),
+			"pending": pending,
+		})
+	})
+	mux.HandleFunc("/api/v1/errors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"errors": recent.entries()})
+	})
+	mux.HandleFunc("/api/v1/quota", func(w http.ResponseWriter, r *http.Request) {
+		quotaMu.Lock()
+		if time.Since(quotaAt) > quotaCacheTTL {
+			quota, quotaErr = kfs.Quota()
+			quotaAt = time.Now()
+		}
+		q, err := quota, quotaErr
+		quotaMu.Unlock()
+
+		switch {
+		case errors.Is(err, api.ErrQuotaUnsupported):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"used": q.Used, "limit": q.Limit, "percent": q.Percent()})
+		}
+	})
+
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: localOnly(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("status API failed", "error", err)
+		}
+	}()
+	slog.Info("serving status API", "addr", ln.Addr())
+	return srv, nil
+}
+
+// localOnly allows GET requests addressed to a loopback name only. Web
+// pages cannot read the API through DNS rebinding, as their requests
+// carry their own host name.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "the status API is read-only"})
+			return
+		}
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "host not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// logEntry is a warning or error logged by the mount.
+type logEntry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// recentLog is a slog handler keeping the latest warnings and errors
+// before passing every record on.
+type recentLog struct {
+	slog.Handler
+	attrs []slog.Attr // added with WithAttrs
+	ring  *logRing
+}
+
+type logRing struct {
+	mu      sync.Mutex
+	entries []logEntry // oldest first
+}
+
+func newRecentLog(next slog.Handler) *recentLog {
+	return &recentLog{Handler: next, ring: &logRing{}}
+}
+
+func (h *recentLog) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		e := logEntry{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+		add := func(a slog.Attr) bool {
+			if e.Attrs == nil {
+				e.Attrs = make(map[string]any)
+			}
+			e.Attrs[a.Key] = a.Value.Resolve().Any()
+			if err, ok := e.Attrs[a.Key].(error); ok {
+				e.Attrs[a.Key] = err.Error()
+			}
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		r.Attrs(add)
+
+		h.ring.mu.Lock()
+		if len(h.ring.entries) == recentErrorsKept {
+			h.ring.entries = h.ring.entries[1:]
+		}
+		h.ring.entries = append(h.ring.entries, e)
+		h.ring.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *recentLog) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recentLog{
+		Handler: h.Handler.WithAttrs(attrs),
+		attrs:   append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+		ring:    h.ring,
+	}
+}
+
+func (h *recentLog) WithGroup(name string) slog.Handler {
+	return &recentLog{Handler: h.Handler.WithGroup(name), attrs: h.attrs, ring: h.ring}
+}
+
+// entries returns the kept warnings and errors, newest first.
+func (h *recentLog) entries() []logEntry {
+	h.ring.mu.Lock()
+	defer h.ring.mu.Unlock()
+	entries := make([]logEntry, len(h.ring.entries))
+	for i, e := range h.ring.entries {
+		entries[len(entries)-1-i] = e
+	}
+	return entries
+}
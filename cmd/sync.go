@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/notify"
+	"github.com/koneksi/koneksi-drive/internal/syncer"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <local-dir> [remote-path]",
+	Short: "Sync a local directory to Koneksi storage",
+	Long: `Upload new and changed files from a local directory to a remote path
+without mounting. With --delete, remote entries missing locally are removed,
+and files that were only moved or renamed locally are moved on the server
+instead of being uploaded again.
+
+Files changed both locally and on the server since the last sync are
+conflicts, resolved by --conflict:
+
+  newer-wins   keep the version modified last
+  larger-wins  keep the larger version, or the newer one if equal in size
+  keep-both    keep both, storing the local version as NAME.conflict-TIME.EXT
+  interactive  ask for each conflict
+
+A version that loses is replaced on its side by the winning one. Every
+conflict is recorded in the conflict report.
+
+With --pull, changes made on the server since the last sync are applied
+locally too: new and changed remote files are downloaded and deletions are
+mirrored in both directions.
+
+With --watch, sync keeps running and mirrors local changes as they happen.
+Combined with --pull, the server is checked for changes every
+--poll-interval.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		deleteExtra, _ := cmd.Flags().GetBool("delete")
+		detectMoves, _ := cmd.Flags().GetBool("detect-moves")
+		verify, _ := cmd.Flags().GetBool("verify-uploads")
+		policy, _ := cmd.Flags().GetString("conflict")
+		report, _ := cmd.Flags().GetString("conflict-report")
+		pull, _ := cmd.Flags().GetBool("pull")
+		watch, _ := cmd.Flags().GetBool("watch")
+		watchDelay, _ := cmd.Flags().GetDuration("watch-delay")
+		pollInterval, _ := cmd.Flags().GetDuration("poll-interval")
+
+		if watch && dryRun {
+			return fmt.Errorf("--watch cannot be combined with --dry-run")
+		}
+
+		localDir := args[0]
+		if info, err := os.Stat(localDir); err != nil {
+			return fmt.Errorf("failed to access %s: %w", localDir, err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", localDir)
+		}
+
+		storage, client, cfg, err := newStorage()
+		if err != nil {
+			return err
+		}
+		if !dryRun {
+			if err := requireWrite(client); err != nil {
+				return err
+			}
+		}
+
+		if verify {
+			cfg.Upload.Verify = true
+		}
+		if policy != "" {
+			if err := config.ValidateConflictPolicy(policy); err != nil {
+				return err
+			}
+			cfg.Sync.Conflict = policy
+		}
+		if report != "" {
+			cfg.Sync.ConflictReport = report
+		}
+		if cmd.Flags().Changed("rescan-after") {
+			cfg.Sync.RescanAfter, _ = cmd.Flags().GetDuration("rescan-after")
+		}
+
+		opts := syncer.Options{
+			Delete:      deleteExtra,
+			DetectMoves: detectMoves,
+			Pull:        pull,
+			Conflict:    syncer.ConflictPolicy(cfg.Sync.Conflict),
+			ReportFile:  cfg.Sync.ConflictReport,
+			Rescan:      cfg.Sync.RescanAfter,
+			Hooks:       hooks.New(cfg.Hooks, cfg.API.DirectoryID, "sync"),
+		}
+		defer opts.Hooks.Close(hooksTimeout)
+		// Only a configured cache directory outlives the sync, to be
+		// shared with mounts and later syncs.
+		if cfg.Cache.Enabled && cfg.Cache.Directory != "" {
+			opts.Cache, err = cache.New(&cfg.Cache, cfg.API.DirectoryID)
+			if err != nil {
+				return err
+			}
+			defer opts.Cache.Close()
+		}
+		if opts.Conflict == syncer.PolicyInteractive && !dryRun {
+			if !isTerminal(os.Stdin) {
+				return fmt.Errorf("the interactive conflict policy needs a terminal")
+			}
+			opts.Resolve = promptConflict(bufio.NewReader(os.Stdin))
+		}
+
+		uploader := upload.New(storage, &cfg.Upload)
+		engine := syncer.NewEngine(storage, uploader, localDir, remotePath(args[1:]), opts)
+
+		notifier := notify.New(&cfg.Notifications)
+		defer notifier.Wait()
+		notifyRun := func(plan *syncer.Plan, err error) {
+			notifySync(notifier, plan, err)
+			if err == nil && plan.Bytes() > 0 && client != nil {
+				notifier.CheckQuota(context.Background(), client, cfg.Notifications.QuotaWarning)
+			}
+		}
+
+		if watch {
+			if !pull {
+				pollInterval = 0
+			}
+			return watchSync(engine, watchDelay, pollInterval, notifyRun)
+		}
+
+		plan, err := engine.Plan(context.Background())
+		if err != nil {
+			notifyRun(nil, err)
+			return err
+		}
+
+		if dryRun {
+			if len(plan.Actions) == 0 && len(plan.Conflicts) == 0 {
+				fmt.Println("Already in sync.")
+				return nil
+			}
+			for _, action := range plan.Actions {
+				fmt.Println(action)
+			}
+			printConflicts(plan)
+			fmt.Printf("\n%d actions, %s to upload (dry run)\n", len(plan.Actions), formatSize(plan.Bytes()))
+			return nil
+		}
+
+		// Apply also runs without actions, to record the files in sync.
+		err = engine.Apply(context.Background(), plan, func(action syncer.Action) {
+			fmt.Println(action)
+		})
+		notifyRun(plan, err)
+		if err != nil {
+			return err
+		}
+
+		if len(plan.Actions) == 0 && len(plan.Conflicts) == 0 {
+			fmt.Println("Already in sync.")
+			return nil
+		}
+		printConflicts(plan)
+		fmt.Printf("\n%d actions, %s uploaded\n", len(plan.Actions), formatSize(plan.Bytes()))
+		if len(plan.Conflicts) > 0 {
+			reportFile := cfg.Sync.ConflictReport
+			if reportFile == "" {
+				reportFile, _ = syncer.DefaultReportPath()
+			}
+			fmt.Printf("%d conflicts recorded in %s\n", len(plan.Conflicts), reportFile)
+		}
+		return nil
+	},
+}
+
+// watchSync runs engine.Watch until interrupted. done is called after
+// every run.
+func watchSync(engine *syncer.Engine, delay, pollInterval time.Duration, done func(*syncer.Plan, error)) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Watching for changes, press Ctrl+C to stop.")
+	return engine.Watch(ctx, syncer.WatchOptions{
+		Delay:         delay,
+		PollInterval:  pollInterval,
+		RetryInterval: 30 * time.Second,
+	}, func(action syncer.Action) {
+		fmt.Println(action)
+	}, func(plan *syncer.Plan, err error) {
+		done(plan, err)
+		if err != nil || len(plan.Actions) == 0 {
+			return
+		}
+		printConflicts(plan)
+		fmt.Printf("%s  %d actions, %s uploaded\n", time.Now().Format(time.TimeOnly), len(plan.Actions), formatSize(plan.Bytes()))
+	})
+}
+
+// notifySync tells the desktop about a failed sync run, or about the
+// conflicts a run found. plan is nil if planning failed.
+func notifySync(notifier *notify.Notifier, plan *syncer.Plan, err error) {
+	if err != nil {
+		notifier.Notify("sync-failed", "Sync failed", err.Error())
+		return
+	}
+	if len(plan.Conflicts) == 0 {
+		return
+	}
+	skipped := 0
+	for _, c := range plan.Conflicts {
+		if c.Resolution == syncer.ResolveSkip {
+			skipped++
+		}
+	}
+	body := fmt.Sprintf("%d files changed both locally and on the server", len(plan.Conflicts))
+	if skipped > 0 {
+		body += fmt.Sprintf("; %d were skipped and need resolving", skipped)
+	}
+	notifier.Notify("sync-conflicts", "Sync conflicts", body+".")
+}
+
+// printConflicts lists the conflicts of plan that were left unresolved.
+func printConflicts(plan *syncer.Plan) {
+	for _, c := range plan.Conflicts {
+		if c.Resolution == syncer.ResolveSkip {
+			fmt.Printf("%-6s %s (changed on both sides, skipped)\n", "skip", c.Path)
+		}
+	}
+}
+
+// promptConflict returns a resolver asking on the terminal which version
+// of a conflicting file to keep.
+func promptConflict(in *bufio.Reader) func(syncer.Conflict) syncer.Resolution {
+	return func(c syncer.Conflict) syncer.Resolution {
+		fmt.Printf("\nConflict: %s changed locally and on the server\n", c.Path)
+		fmt.Printf("  local:  %s, modified %s\n", formatSize(c.LocalSize), c.LocalModified.Local().Format(time.DateTime))
+		fmt.Printf("  remote: %s, modified %s\n", formatSize(c.RemoteSize), c.RemoteModified.Local().Format(time.DateTime))
+
+		for {
+			fmt.Print("Keep [l]ocal, [r]emote, [b]oth, or [s]kip? ")
+			line, err := in.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "l", "local":
+				return syncer.ResolveLocal
+			case "r", "remote":
+				return syncer.ResolveRemote
+			case "b", "both":
+				return syncer.ResolveBoth
+			case "s", "skip":
+				return syncer.ResolveSkip
+			}
+			if err != nil {
+				// Input closed; leave the conflict for the next sync.
+				fmt.Println()
+				return syncer.ResolveSkip
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().BoolP("dry-run", "n", false, "Show what would be done without changing anything")
+	syncCmd.Flags().Bool("delete", false, "Delete remote files that do not exist locally")
+	syncCmd.Flags().Bool("detect-moves", true, "Use server-side moves for files renamed or moved locally (requires --delete)")
+	syncCmd.Flags().Bool("verify-uploads", false, "Check the stored content of every upload against the local file")
+	syncCmd.Flags().String("conflict", "", "Conflict policy: newer-wins, larger-wins, keep-both or interactive (default from config, keep-both)")
+	syncCmd.Flags().String("conflict-report", "", "File to record conflicts in (default: in the user cache directory)")
+	syncCmd.Flags().Bool("pull", false, "Also apply changes made on the server to the local directory")
+	syncCmd.Flags().Bool("watch", false, "Keep running and sync local changes as they happen")
+	syncCmd.Flags().Duration("watch-delay", 2*time.Second, "Quiet time after a local change before syncing")
+	syncCmd.Flags().Duration("poll-interval", time.Minute, "How often to check the server for changes with --watch --pull")
+	syncCmd.Flags().Duration("rescan-after", 0, "List unchanged directories on the server again after this long, 0 for every run (default from config, 24h)")
+}
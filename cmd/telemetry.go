@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/fs"
+	"github.com/koneksi/koneksi-drive/internal/telemetry"
+	"github.com/spf13/cobra"
+)
+
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Show or change whether anonymous usage statistics are sent",
+	Long: `Telemetry is off unless enabled here. Once enabled, mounts count the API
+calls they make by kind, how those fail and how long they take, and which
+optional features they use, and a report summing these up with the
+platform and program version is sent once a day to telemetry.endpoint,
+/api/telemetry on api.base_url by default. Reports carry no file or folder
+names, directory IDs, hostnames or credentials; "telemetry preview" prints
+the next one exactly as it would be sent.
+
+Setting the DO_NOT_TRACK environment variable turns telemetry off whatever
+is chosen here.`,
+}
+
+var telemetryStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether usage statistics are sent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := telemetry.Load()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case telemetry.OptedOut():
+			fmt.Println("Telemetry is off: DO_NOT_TRACK is set.")
+		case s.Enabled:
+			fmt.Printf("Telemetry is on since %s.\n", s.Changed.Local().Format("2006-01-02"))
+		case s.Changed.IsZero():
+			fmt.Println("Telemetry is off (the default). Enable it with \"koneksi-drive telemetry enable\".")
+		default:
+			fmt.Printf("Telemetry is off since %s.\n", s.Changed.Local().Format("2006-01-02"))
+		}
+		if !s.Active() {
+			return nil
+		}
+
+		if cfg, err := config.Load(); err == nil {
+			fmt.Printf("  %-10s %s\n", "endpoint:", telemetry.Endpoint(cfg))
+		}
+		if s.Pending != nil {
+			fmt.Printf("  %-10s %d mounts since %s\n", "collected:", s.Pending.Mounts, s.PendingSince.Local().Format("2006-01-02 15:04"))
+		}
+		if !s.LastSent.IsZero() {
+			fmt.Printf("  %-10s %s\n", "last sent:", s.LastSent.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+var telemetryEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Send anonymous usage statistics",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := telemetry.SetEnabled(true); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry enabled, thank you. Mounts now collect anonymous usage statistics, sent once a day;")
+		fmt.Println("\"koneksi-drive telemetry preview\" shows what is sent, \"koneksi-drive telemetry disable\" stops it.")
+		if telemetry.OptedOut() {
+			fmt.Println("DO_NOT_TRACK is set, so nothing is collected while it is.")
+		}
+		return nil
+	},
+}
+
+var telemetryDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop sending usage statistics and drop those not sent yet",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := telemetry.SetEnabled(false); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry disabled. Nothing is collected or sent anymore.")
+		return nil
+	},
+}
+
+var telemetryPreviewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Print the next report as it would be sent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r, err := telemetry.Preview()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(r)
+	},
+}
+
+// sendTelemetry sends the usage statistics collected by earlier mounts,
+// if telemetry is enabled and a report is due.
+func sendTelemetry(cfg *config.Config) {
+	if cfg.Mount.Offline || cfg.API.Backend != "koneksi" && cfg.Telemetry.Endpoint == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := telemetry.SendDue(ctx, telemetry.Endpoint(cfg)); err != nil {
+		slog.Debug("failed to send telemetry", "error", err)
+	}
+}
+
+// recordTelemetry adds the statistics of a mount session to the next
+// report, if telemetry is enabled.
+func recordTelemetry(cfg *config.Config, session fs.SessionStats) {
+	err := telemetry.Record(cfg, telemetry.Mount{
+		Duration:    time.Since(session.Started),
+		Calls:       session.Calls,
+		Errors:      session.Errors,
+		Latency:     session.Latency,
+		CacheHits:   session.CacheHits,
+		CacheMisses: session.CacheMisses,
+	})
+	if err != nil {
+		slog.Debug("failed to record telemetry", "error", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(telemetryCmd)
+	telemetryCmd.AddCommand(telemetryStatusCmd)
+	telemetryCmd.AddCommand(telemetryEnableCmd)
+	telemetryCmd.AddCommand(telemetryDisableCmd)
+	telemetryCmd.AddCommand(telemetryPreviewCmd)
+}
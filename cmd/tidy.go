@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// tempPatterns match the names of files written only to be renamed over
+// the real file, or to be deleted, once complete: atomic saves of editors
+// and desktop tools, partial downloads, and the write probe of a mount
+// that went read-only.
+var tempPatterns = []string{
+	"*.tmp",
+	"*.temp",
+	"*.part",
+	"*.partial",
+	"*.crdownload",
+	".goutputstream-*",
+	".*.sw?",
+	".koneksi-drive-probe",
+}
+
+// markerPatterns match the names of lock files editors create next to
+// open documents. Only empty ones are taken to be leftovers; others may
+// still say who holds the document open.
+var markerPatterns = []string{
+	".~lock.*#",
+	"~$*",
+	".#*",
+	"*.lock",
+}
+
+// leftover is a remote artifact tidy removes.
+type leftover struct {
+	kind   string // "temp", "marker" or "upload"
+	path   string
+	size   int64
+	upload string // ID of an unfinished upload
+}
+
+var tidyCmd = &cobra.Command{
+	Use:   "tidy [remote-path]",
+	Short: "Find and remove leftovers of interrupted writes",
+	Long: `List what interrupted writes left behind below a remote path (the whole
+directory by default), and with --delete remove it:
+
+  temp     temporary files of atomic saves and partial downloads
+           (*.tmp, *.part, .goutputstream-*, vim swap files, ...)
+  marker   empty lock files of editors (.~lock.*#, ~$*, .#*, *.lock)
+  upload   chunked uploads the server keeps open because they were never
+           completed
+
+Only leftovers not modified for --older-than (24h by default) are
+considered, so files still being written by a mount are left alone.
+--pattern adds name patterns to the temporary ones. Files under retention
+are never removed.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		del, _ := cmd.Flags().GetBool("delete")
+		olderThan, _ := cmd.Flags().GetString("older-than")
+		patterns, _ := cmd.Flags().GetStringSlice("pattern")
+
+		age, err := config.ParseDuration(olderThan)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid --pattern %q: %w", pattern, err)
+			}
+		}
+		patterns = append(patterns, tempPatterns...)
+
+		client, _, err := newClient()
+		if err != nil {
+			return err
+		}
+		if del {
+			if err := requireWrite(client); err != nil {
+				return err
+			}
+		}
+
+		root := remotePath(args)
+		found, err := findLeftovers(client, root, patterns, time.Now().Add(-age))
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			fmt.Println("Nothing to tidy.")
+			return nil
+		}
+
+		var total int64
+		failed := 0
+		for _, l := range found {
+			fmt.Printf("%-7s %10s  %s\n", l.kind, formatSize(l.size), l.path)
+			total += l.size
+			if !del {
+				continue
+			}
+			if l.upload != "" {
+				err = client.AbortUpload(context.Background(), l.upload)
+			} else {
+				err = client.Delete(context.Background(), l.path)
+			}
+			if err != nil {
+				failed++
+				fmt.Printf("  failed to remove: %v\n", err)
+			}
+		}
+
+		if !del {
+			fmt.Printf("%d leftovers, %s; run with --delete to remove them\n", len(found), formatSize(total))
+			return nil
+		}
+		fmt.Printf("Removed %d leftovers, %s\n", len(found)-failed, formatSize(total))
+		if failed > 0 {
+			return fmt.Errorf("%d leftovers could not be removed", failed)
+		}
+		return nil
+	},
+}
+
+// findLeftovers walks root for temporary files and empty lock files, and
+// asks the server for unfinished uploads below it, keeping those last
+// changed before cutoff.
+func findLeftovers(client *api.Client, root string, patterns []string, cutoff time.Time) ([]leftover, error) {
+	var found []leftover
+	err := api.Walk(context.Background(), client, root, func(p string, info api.FileInfo) error {
+		if info.IsDir || info.Modified.After(cutoff) || info.Retained(time.Now()) {
+			return nil
+		}
+		kind := ""
+		switch {
+		case info.Name == api.EncryptionMarker:
+		case matchAny(patterns, info.Name):
+			kind = "temp"
+		case info.Size == 0 && matchAny(markerPatterns, info.Name):
+			kind = "marker"
+		}
+		if kind != "" {
+			found = append(found, leftover{kind: kind, path: p, size: info.Size})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := client.UploadSessions(context.Background())
+	if errors.Is(err, api.ErrUploadsUnsupported) {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		p := path.Clean("/" + s.Path)
+		if s.StartedAt.After(cutoff) || (root != "/" && p != root && !strings.HasPrefix(p, root+"/")) {
+			continue
+		}
+		found = append(found, leftover{kind: "upload", path: p, size: s.Size, upload: s.ID})
+	}
+	return found, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(tidyCmd)
+
+	tidyCmd.Flags().Bool("delete", false, "Remove the leftovers instead of only listing them")
+	tidyCmd.Flags().String("older-than", "24h", "Only consider leftovers not modified for this long, e.g. 1h or 7d")
+	tidyCmd.Flags().StringSlice("pattern", nil, "Additional name pattern of temporary files (repeatable)")
+}
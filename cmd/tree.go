@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree [path]",
+	Short: "Print a remote directory tree with aggregated sizes",
+	Long: `Print a remote directory tree using directory listings, without mounting.
+Each directory shows the total size of everything below it and entries are
+sorted largest-first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		depth, _ := cmd.Flags().GetInt("depth")
+		dirsOnly, _ := cmd.Flags().GetBool("dirs-only")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		rawBytes, _ := cmd.Flags().GetBool("bytes")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+		storage, _, _, err := newStorage()
+		if err != nil {
+			return err
+		}
+
+		root, err := newUsageScanner(storage, concurrency).Scan(remotePath(args))
+		if err != nil {
+			return err
+		}
+
+		if depth <= 0 {
+			depth = -1
+		}
+		root = root.prune(depth, dirsOnly)
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(root)
+		}
+
+		fmt.Printf("[%s] %s\n", sizeLabel(root.Size, rawBytes), root.Path)
+		printTree(root, "", rawBytes)
+		fmt.Printf("\n%d directories, %d files\n", root.Dirs, root.Files)
+		return nil
+	},
+}
+
+func printTree(entry *usageEntry, prefix string, rawBytes bool) {
+	for i, child := range entry.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(entry.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		name := child.Name
+		if child.IsDir {
+			name += "/"
+		}
+		fmt.Printf("%s%s[%s] %s\n", prefix, branch, sizeLabel(child.Size, rawBytes), name)
+		printTree(child, prefix+indent, rawBytes)
+	}
+}
+
+func sizeLabel(size int64, rawBytes bool) string {
+	if rawBytes {
+		return fmt.Sprintf("%d", size)
+	}
+	return formatSize(size)
+}
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+
+	treeCmd.Flags().IntP("depth", "L", 0, "Descend only N levels deep (0 for unlimited)")
+	treeCmd.Flags().BoolP("dirs-only", "d", false, "List directories only")
+	treeCmd.Flags().Bool("json", false, "Output as JSON")
+	treeCmd.Flags().BoolP("bytes", "b", false, "Print sizes in bytes")
+	treeCmd.Flags().Int("concurrency", 8, "Number of directory listings to fetch in parallel")
+}
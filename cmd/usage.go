@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// usageEntry is a node of a remote directory tree with sizes aggregated
+// over everything below it.
+type usageEntry struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	IsDir    bool          `json:"is_dir"`
+	Size     int64         `json:"size"`
+	Files    int64         `json:"files"`
+	Dirs     int64         `json:"dirs"`
+	Children []*usageEntry `json:"children,omitempty"`
+}
+
+// usageScanner walks a remote tree through directory listings, fetching
+// up to concurrency listings in parallel.
+type usageScanner struct {
+	storage api.StorageBackend
+	sem     chan struct{}
+}
+
+func newUsageScanner(storage api.StorageBackend, concurrency int) *usageScanner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &usageScanner{
+		storage: storage,
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// Scan builds the usage tree rooted at dirPath. Children are sorted
+// largest-first.
+func (s *usageScanner) Scan(dirPath string) (*usageEntry, error) {
+	root := &usageEntry{
+		Name:  path.Base(dirPath),
+		Path:  dirPath,
+		IsDir: true,
+	}
+	if err := s.scanDir(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (s *usageScanner) scanDir(entry *usageEntry) error {
+	s.sem <- struct{}{}
+	files, err := s.storage.List(context.Background(), entry.Path)
+	<-s.sem
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", entry.Path, err)
+	}
+
+	entry.Children = make([]*usageEntry, 0, len(files))
+	for _, file := range files {
+		entry.Children = append(entry.Children, &usageEntry{
+			Name:  file.Name,
+			Path:  path.Join(entry.Path, file.Name),
+			IsDir: file.IsDir,
+			Size:  file.Size,
+		})
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, child := range entry.Children {
+		if !child.IsDir {
+			continue
+		}
+		wg.Add(1)
+		go func(child *usageEntry) {
+			defer wg.Done()
+			if err := s.scanDir(child); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(child)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	entry.Size = 0
+	for _, child := range entry.Children {
+		entry.Size += child.Size
+		if child.IsDir {
+			entry.Dirs += child.Dirs + 1
+			entry.Files += child.Files
+		} else {
+			entry.Files++
+		}
+	}
+
+	sort.Slice(entry.Children, func(i, j int) bool {
+		if entry.Children[i].Size != entry.Children[j].Size {
+			return entry.Children[i].Size > entry.Children[j].Size
+		}
+		return entry.Children[i].Name < entry.Children[j].Name
+	})
+
+	return nil
+}
+
+// prune returns a copy of entry limited to depth levels below it
+// (negative means unlimited), optionally dropping files.
+func (e *usageEntry) prune(depth int, dirsOnly bool) *usageEntry {
+	out := *e
+	out.Children = nil
+	if depth == 0 {
+		return &out
+	}
+	for _, child := range e.Children {
+		if dirsOnly && !child.IsDir {
+			continue
+		}
+		out.Children = append(out.Children, child.prune(depth-1, dirsOnly))
+	}
+	return &out
+}
+
+// formatSize renders a byte count using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// remotePath normalizes a user supplied remote path argument.
+func remotePath(args []string) string {
+	if len(args) == 0 || args[0] == "" {
+		return "/"
+	}
+	return path.Clean("/" + args[0])
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ErrAppendUnsupported is returned by Append when the server cannot add
+// to the content of a file.
+var ErrAppendUnsupported = errors.New("appending to files not supported by server")
+
+// ErrAppendMismatch is returned by Append when the file on the server
+// does not end where the new data starts, for example because it was
+// changed elsewhere.
+var ErrAppendMismatch = errors.New("remote file size does not match the append offset")
+
+// Append adds size bytes read from data to the end of filePath, which
+// must currently be offset bytes long.
+func (c *Client) Append(ctx context.Context, filePath string, offset int64, data io.Reader, size int64) error {
+	if c.appendsUnsupported.Load() || c.Encrypted(filePath) {
+		return ErrAppendUnsupported
+	}
+
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
+
+	req, err := c.newRequest(ctx, "PATCH", endpoint, data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+size-1))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		c.appendsUnsupported.Store(true)
+		return ErrAppendUnsupported
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return ErrAppendMismatch
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent:
+		return newStatusError("append", resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"io"
+	"io/fs"
+)
+
+// StorageBackend is the storage a mount keeps its files in. Client
+// implements it for the Koneksi API, LocalBackend for a local folder; the
+// file system, the caches and sync work on any implementation.
+//
+// Paths are slash-separated and relative to the root of the storage, such
+// as "/docs/report.pdf". Errors wrapping fs.ErrNotExist or ErrNotFound
+// mean the path does not exist, fs.ErrExist or ErrConflict that it
+// already does. ctx is that of the operation a call is made for; once it
+// is done, the call may be given up.
+type StorageBackend interface {
+	// List returns the entries of the folder dirPath.
+	List(ctx context.Context, dirPath string) ([]FileInfo, error)
+	// Stat returns the metadata of a file or folder.
+	Stat(ctx context.Context, filePath string) (*FileInfo, error)
+	// Read returns the content of filePath. Content that is an
+	// io.Seeker is read from an offset by seeking in it.
+	Read(ctx context.Context, filePath string) (io.ReadCloser, error)
+	// Write replaces the content of filePath, creating it and the folders
+	// above it if needed.
+	Write(ctx context.Context, filePath, contentType string, data io.Reader) error
+	// Delete removes a file or folder.
+	Delete(ctx context.Context, filePath string) error
+	// Mkdir creates the folder dirPath.
+	Mkdir(ctx context.Context, dirPath string) error
+	// Move renames a file or folder, replacing a file at dstPath.
+	Move(ctx context.Context, srcPath, dstPath string) error
+	// Copy copies the file srcPath to dstPath, or returns
+	// ErrCopyUnsupported for its content to be read and written again.
+	Copy(ctx context.Context, srcPath, dstPath string) error
+}
+
+// RangeReader is implemented by backends that read part of a file
+// without reading the content before it, when their content does not
+// seek. ReadRange returns ErrRangeUnsupported for the whole content to be
+// read instead.
+type RangeReader interface {
+	ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error)
+}
+
+// ReadRange reads length bytes of filePath at offset with the ReadRange
+// of b, or returns ErrRangeUnsupported if it has none.
+func ReadRange(ctx context.Context, b StorageBackend, filePath string, offset, length int64) ([]byte, error) {
+	rr, ok := b.(RangeReader)
+	if !ok {
+		return nil, ErrRangeUnsupported
+	}
+	return rr.ReadRange(ctx, filePath, offset, length)
+}
+
+// OffsetReader is implemented by backends that read the content of a
+// file from an offset on without reading what comes before.
+type OffsetReader interface {
+	ReadFrom(ctx context.Context, filePath string, offset int64) (body io.ReadCloser, partial bool, err error)
+}
+
+// ReadFrom returns the content of filePath from offset on, with the
+// ReadFrom of b if it has one and otherwise by seeking in the content
+// Read returns. partial is false if the content starts at the beginning
+// of the file instead, as it cannot be read from offset.
+func ReadFrom(ctx context.Context, b StorageBackend, filePath string, offset int64) (body io.ReadCloser, partial bool, err error) {
+	if r, ok := b.(OffsetReader); ok {
+		return r.ReadFrom(ctx, filePath, offset)
+	}
+	content, err := b.Read(ctx, filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	seeker, ok := content.(io.Seeker)
+	if offset == 0 || !ok {
+		return content, false, nil
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		content.Close()
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// Creator is implemented by backends that write a file only if nothing
+// exists at its path, in one call.
+type Creator interface {
+	Create(ctx context.Context, filePath, contentType string, data io.Reader) error
+}
+
+// Create writes filePath if nothing exists at its path, and otherwise
+// fails with an error IsExists reports. Backends that are no Creator are
+// asked with Stat first, so what is created in between is replaced.
+func Create(ctx context.Context, b StorageBackend, filePath, contentType string, data io.Reader) error {
+	if c, ok := b.(Creator); ok {
+		return c.Create(ctx, filePath, contentType, data)
+	}
+	_, err := b.Stat(ctx, filePath)
+	switch {
+	case err == nil:
+		return &fs.PathError{Op: "create", Path: filePath, Err: fs.ErrExist}
+	case !IsNotFound(err):
+		return err
+	}
+	return b.Write(ctx, filePath, contentType, data)
+}
+
+// Appender is implemented by backends that add to the end of a file
+// without writing what it holds again.
+type Appender interface {
+	Append(ctx context.Context, filePath string, offset int64, data io.Reader, size int64) error
+}
+
+// Append adds size bytes read from data to the end of filePath, which
+// must currently be offset bytes long, with the Append of b, or returns
+// ErrAppendUnsupported if it has none.
+func Append(ctx context.Context, b StorageBackend, filePath string, offset int64, data io.Reader, size int64) error {
+	a, ok := b.(Appender)
+	if !ok {
+		return ErrAppendUnsupported
+	}
+	return a.Append(ctx, filePath, offset, data, size)
+}
+
+var (
+	_ StorageBackend = (*Client)(nil)
+	_ RangeReader    = (*Client)(nil)
+	_ OffsetReader   = (*Client)(nil)
+	_ Creator        = (*Client)(nil)
+	_ Appender       = (*Client)(nil)
+	_ RangeReader    = (*LocalBackend)(nil)
+)
+
+// Copy copies the file srcPath to dstPath in the same directory, on the
+// server.
+func (c *Client) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return c.CopyTo(ctx, c, srcPath, dstPath)
+}
+
+// LayerStats is a snapshot of the operations passed to a layer of a
+// chain of backends, or to the backend at its bottom. What a layer counts
+// includes the time taken by the layers below it.
+type LayerStats struct {
+	Name    string             `json:"name"`
+	Calls   map[string]int64   `json:"calls"`   // by operation, e.g. "read"
+	Errors  map[string]int64   `json:"errors"`  // failed calls by class, e.g. "not-found"
+	Latency map[string][]int64 `json:"latency"` // calls by operation and LatencyBuckets; reads and writes until their content is transferred
+	Read    int64              `json:"read_bytes"`
+	Written int64              `json:"written_bytes"`
+	// Reads served from the cache of a cache layer, and those that were
+	// not.
+	CacheHits   int64 `json:"cache_hits,omitempty"`
+	CacheMisses int64 `json:"cache_misses,omitempty"`
+}
+
+// Layered is implemented by backends made of layers, to report the
+// statistics of each.
+type Layered interface {
+	// Layers returns the statistics of the layers, the outermost first.
+	Layers() []LayerStats
+}
+
+// Throttled is implemented by backends limiting their bandwidth, to
+// change the limits while in use.
+type Throttled interface {
+	// Limits returns the bytes per second read and written, 0 for no
+	// limit, and false if nothing limits them.
+	Limits() (read, write int64, ok bool)
+	// SetLimits changes the limits, reporting false if there are none to
+	// change.
+	SetLimits(read, write int64) bool
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned without contacting the server while it is
+// considered down after repeated failures.
+var ErrUnavailable = errors.New("server unavailable: not retrying until it answers again")
+
+// Unavailable returns since when the server has been considered down, or
+// the zero time if it is not.
+func (c *Client) Unavailable() time.Time {
+	if c.breaker == nil {
+		return time.Time{}
+	}
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.openedAt
+}
+
+// breaker wraps the HTTP transport of a client to stop sending requests
+// to a server that is down. After threshold consecutive requests failed
+// to get an answer, or got a server error, it fails requests with
+// ErrUnavailable right away for cooldown. Then a single request is let
+// through as a probe: if it succeeds, requests flow again, otherwise the
+// server stays down for another cooldown.
+type breaker struct {
+	base      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int       // consecutive, while closed
+	openedAt time.Time // zero while closed
+	retryAt  time.Time // when the next probe may go out, while open
+	probing  bool
+}
+
+func newBreaker(base http.RoundTripper, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{base: base, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe, err := b.admit()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := b.base.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		// Given up on by the caller, or past the deadline of its
+		// operation, which says nothing about the server.
+		b.release(probe)
+		return resp, err
+	}
+	b.record(probe, err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// admit returns whether the request is a probe of a server considered
+// down, or ErrUnavailable if it may not go out.
+func (b *breaker) admit() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openedAt.IsZero():
+		return false, nil
+	case b.probing || time.Now().Before(b.retryAt):
+		return false, ErrUnavailable
+	}
+	b.probing = true
+	return true, nil
+}
+
+// release lets another request probe the server if probe was one.
+func (b *breaker) release(probe bool) {
+	if probe {
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+	}
+}
+
+// record counts the outcome of a request that went out.
+func (b *breaker) record(probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if ok {
+		if !b.openedAt.IsZero() {
+			slog.Info("server is answering again", "down_for", time.Since(b.openedAt).Round(time.Second))
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	switch {
+	case probe:
+		b.retryAt = time.Now().Add(b.cooldown)
+	case b.openedAt.IsZero() && b.failures >= b.threshold:
+		slog.Warn("server is not answering, failing requests right away until it does",
+			"failures", b.failures, "retry_every", b.cooldown)
+		b.openedAt = time.Now()
+		b.retryAt = b.openedAt.Add(b.cooldown)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"strings"
+)
+
+// Scopes a token can be granted. Tokens issued without scope information
+// are assumed to allow everything.
+const (
+	ScopeRead  = "files:read"
+	ScopeWrite = "files:write"
+	ScopeShare = "files:share"
+)
+
+// ErrShareNotAllowed is returned by CreateShareLink when the token lacks
+// the share scope.
+var ErrShareNotAllowed = errors.New("the access token does not allow creating share links (missing " + ScopeShare + " scope)")
+
+// Capabilities describes what the client's token allows.
+type Capabilities struct {
+	// Scopes lists the scopes granted to the token; empty when the
+	// server did not report any.
+	Scopes []string `json:"scopes,omitempty"`
+	Write  bool     `json:"write"`
+	Share  bool     `json:"share"`
+	// Role is the caller's role in a team directory, such as
+	// RoleViewer; empty when the server did not report one.
+	Role string `json:"role,omitempty"`
+}
+
+// Capabilities returns what the access token allows, authenticating first
+// if needed.
+func (c *Client) Capabilities() (Capabilities, error) {
+	if _, err := c.ensureAuthenticated(); err != nil {
+		return Capabilities{}, err
+	}
+
+	c.mu.Lock()
+	scopes := c.scopes
+	c.mu.Unlock()
+
+	if len(scopes) == 0 {
+		return Capabilities{Write: true, Share: true}, nil
+	}
+	caps := Capabilities{Scopes: scopes}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeWrite:
+			caps.Write = true
+		case ScopeShare:
+			caps.Share = true
+		}
+	}
+	return caps, nil
+}
+
+// parseScopes splits the space-separated scope of a token response.
+func parseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
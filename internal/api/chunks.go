@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ErrChunkedUploadUnsupported is returned by the chunk methods when the
+// server has no chunk-level upload API. Callers should fall back to Write.
+var ErrChunkedUploadUnsupported = errors.New("chunked uploads not supported by server")
+
+// ChunkRef references stored chunk content in a file manifest.
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// MissingChunksError is returned by CommitManifest when the manifest
+// references chunks the server does not have.
+type MissingChunksError struct {
+	Hashes []string
+}
+
+func (e *MissingChunksError) Error() string {
+	return fmt.Sprintf("server is missing %d chunks", len(e.Hashes))
+}
+
+type missingChunksRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+type manifestRequest struct {
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type"`
+	Chunks      []ChunkRef `json:"chunks"`
+}
+
+type missingChunksResponse struct {
+	Missing []string `json:"missing"`
+}
+
+// unsupportedStatus reports whether a status code means the endpoint does
+// not exist on this server.
+func unsupportedStatus(code int) bool {
+	return code == http.StatusNotFound ||
+		code == http.StatusMethodNotAllowed ||
+		code == http.StatusNotImplemented
+}
+
+// MissingChunks returns the subset of hashes the server does not store
+// yet, letting callers skip uploading content the server already has.
+func (c *Client) MissingChunks(ctx context.Context, hashes []string) ([]string, error) {
+	if c.chunksUnsupported.Load() {
+		return nil, ErrChunkedUploadUnsupported
+	}
+
+	endpoint := c.endpoint("/chunks/missing")
+
+	data, err := json.Marshal(missingChunksRequest{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if unsupportedStatus(resp.StatusCode) {
+		c.chunksUnsupported.Store(true)
+		return nil, ErrChunkedUploadUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("missing chunks query", resp)
+	}
+
+	var missing missingChunksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&missing); err != nil {
+		return nil, err
+	}
+
+	return missing.Missing, nil
+}
+
+// UploadChunk stores a chunk of content under its hex SHA-256 hash.
+// Uploading a chunk the server already has is harmless.
+func (c *Client) UploadChunk(ctx context.Context, hash string, data io.Reader, size int64) error {
+	if c.chunksUnsupported.Load() {
+		return ErrChunkedUploadUnsupported
+	}
+
+	endpoint := c.endpoint("/chunks/%s", url.PathEscape(hash))
+
+	req, err := c.newRequest(ctx, "PUT", endpoint, data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if unsupportedStatus(resp.StatusCode) {
+		c.chunksUnsupported.Store(true)
+		return ErrChunkedUploadUnsupported
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return newStatusError("chunk upload", resp)
+	}
+
+	return nil
+}
+
+// CommitManifest replaces the content of filePath with the concatenation
+// of the given chunks, stored with contentType as for Write. If the server
+// lacks some of the chunks it returns a *MissingChunksError listing their
+// hashes.
+func (c *Client) CommitManifest(ctx context.Context, filePath, contentType string, size int64, chunks []ChunkRef) error {
+	if c.chunksUnsupported.Load() || c.Encrypted(filePath) {
+		return ErrChunkedUploadUnsupported
+	}
+
+	endpoint := c.endpoint("/files/%s/manifest", url.QueryEscape(c.remotePath(filePath)))
+
+	data, err := json.Marshal(manifestRequest{
+		Size:        size,
+		ContentType: contentTypeOrDefault(contentType),
+		Chunks:      chunks,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.doRequest(ctx, "PUT", endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		var missing missingChunksResponse
+		if err := json.NewDecoder(resp.Body).Decode(&missing); err != nil {
+			return newStatusError("manifest commit", resp)
+		}
+		return &MissingChunksError{Hashes: missing.Missing}
+	case unsupportedStatus(resp.StatusCode):
+		c.chunksUnsupported.Store(true)
+		return ErrChunkedUploadUnsupported
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated:
+		return newStatusError("manifest commit", resp)
+	}
+
+	return nil
+}
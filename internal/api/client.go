@@ -2,15 +2,22 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
 )
 
 type Client struct {
@@ -19,21 +26,73 @@ type Client struct {
 	clientSecret string
 	directoryID  string
 	httpClient   *http.Client
+	mu           sync.Mutex
 	token        string
 	tokenExpiry  time.Time
+	scopes       []string // granted to the token, if the server said
+
+	// chunksUnsupported is set once the server has shown it has no
+	// chunk-level upload API.
+	chunksUnsupported atomic.Bool
+	// leasesUnsupported is set once the server has shown it has no
+	// locking API.
+	leasesUnsupported atomic.Bool
+	// ownersUnsupported is set once the server has shown it does not
+	// store file ownership.
+	ownersUnsupported atomic.Bool
+	// appendsUnsupported is set once the server has shown it cannot
+	// append to files.
+	appendsUnsupported atomic.Bool
+	// rangesUnsupported is set once the server has shown it ignores
+	// byte ranges of file content.
+	rangesUnsupported atomic.Bool
+	// copiesUnsupported is set once the server has shown it cannot
+	// copy files between directories.
+	copiesUnsupported atomic.Bool
+	// sharingUnsupported is set once the server has shown it does not
+	// tell who files are shared with.
+	sharingUnsupported atomic.Bool
+
+	meter   *meter
+	tracer  *tracer
+	breaker *breaker     // nil when disabled
+	hedger  *hedger      // nil when disabled
+	retries int          // times failed requests are sent again
+	vault   *vault.Vault // encrypts content; nil when disabled
+	names   *vault.Names // encrypts names; nil when disabled
+	scope   *scope       // folders encrypted by vault
+
+	headersMu sync.Mutex
+	headers   map[string]storedHeader // encryption headers by path, while rotating keys
+
+	// Concurrent identical listings, stats and range reads share one
+	// request.
+	lists  flightGroup[[]FileInfo]
+	stats  flightGroup[*FileInfo]
+	ranges flightGroup[[]byte]
+
+	versionMu   sync.Mutex
+	version     apiVersion    // in use; nil until detected
+	detecting   bool          // while a detection is in flight
+	detectAfter time.Time     // earliest time of the next detection, after one failed
+	detectDelay time.Duration // between failed detections, doubling
 }
 
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"` // space-separated, as in OAuth 2.0
 }
 
 type FileInfo struct {
-	Name     string    `json:"name"`
-	Size     int64     `json:"size"`
-	IsDir    bool      `json:"is_dir"`
-	Modified time.Time `json:"modified"`
-	Path     string    `json:"path"`
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	IsDir       bool       `json:"is_dir"`
+	Modified    time.Time  `json:"modified"`
+	Path        string     `json:"path"`
+	Hash        string     `json:"hash,omitempty"`         // hex SHA-256 of the content, if provided
+	Owner       *Owner     `json:"owner,omitempty"`        // if the server stores ownership
+	RetainUntil *time.Time `json:"retain_until,omitempty"` // the file may not be changed or deleted before, if under retention
 }
 
 type ListResponse struct {
@@ -41,15 +100,39 @@ type ListResponse struct {
 }
 
 func NewClient(cfg *config.APIConfig) (*Client, error) {
-	return &Client{
+	if cfg.Backend == "local" {
+		return nil, ErrNoAPI
+	}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := newTracer(newTagger(transport, cfg.Tags))
+	m := newMeter(tr)
+	c := &Client{
 		baseURL:      cfg.BaseURL,
 		clientID:     cfg.ClientID,
 		clientSecret: cfg.ClientSecret,
 		directoryID:  cfg.DirectoryID,
 		httpClient: &http.Client{
-			Timeout: cfg.Timeout,
+			Timeout:   cfg.Timeout,
+			Transport: m,
 		},
-	}, nil
+		meter:   m,
+		tracer:  tr,
+		hedger:  newHedger(cfg.HedgePercentile, cfg.HedgeMinDelay),
+		retries: cfg.RetryCount,
+		version: apiVersions[cfg.Version],
+	}
+	if cfg.BreakerThreshold > 0 {
+		// Outermost, so requests failed right away are not counted as
+		// API calls.
+		c.breaker = newBreaker(m, cfg.BreakerThreshold, cfg.BreakerCooldown)
+		c.httpClient.Transport = c.breaker
+	}
+	return c, nil
 }
 
 func (c *Client) authenticate() error {
@@ -80,7 +163,7 @@ func (c *Client) authenticate() error {
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("authentication failed: %s", resp.Status)
+		return newStatusError("authentication", resp)
 	}
 	
 	var tokenResp TokenResponse
@@ -90,55 +173,98 @@ func (c *Client) authenticate() error {
 	
 	c.token = tokenResp.AccessToken
 	c.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	c.scopes = parseScopes(tokenResp.Scope)
 	
 	return nil
 }
 
-func (c *Client) ensureAuthenticated() error {
+func (c *Client) ensureAuthenticated() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.token == "" || time.Now().After(c.tokenExpiry) {
-		return c.authenticate()
+		if err := c.authenticate(); err != nil {
+			return "", err
+		}
 	}
-	return nil
+	return c.token, nil
 }
 
-func (c *Client) doRequest(method, endpoint string, body io.Reader) (*http.Response, error) {
-	if err := c.ensureAuthenticated(); err != nil {
+// newRequest builds an authenticated request for an API endpoint, made
+// for the operation of ctx.
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	token, err := c.ensureAuthenticated()
+	if err != nil {
 		return nil, err
 	}
-	
-	u, err := url.Parse(c.baseURL)
+
+	// endpoint is already escaped and may carry a query string, so it is
+	// appended verbatim rather than joined into url.URL.Path.
+	reqURL := strings.TrimSuffix(c.baseURL, "/") + endpoint
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
-	
-	req, err := http.NewRequest(method, u.String(), body)
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
+	resp, again, err := c.send(ctx, method, endpoint, body)
+	if retrying(ctx) {
+		return c.retry(ctx, 0, method, endpoint, again, resp, err)
+	}
+	if c.retries > 0 {
+		return c.retry(ctx, c.retries, method, endpoint, again, resp, err)
+	}
+	return resp, err
+}
+
+// send makes a request once. again returns the body for sending it
+// another time, or false if that is not possible.
+func (c *Client) send(ctx context.Context, method, endpoint string, body io.Reader) (resp *http.Response, again func() (io.Reader, bool), err error) {
+	req, err := c.newRequest(ctx, method, endpoint, body)
 	if err != nil {
-		return nil, err
+		// Not sent, so the body is still unread.
+		return nil, func() (io.Reader, bool) { return body, true }, err
 	}
-	
-	req.Header.Set("Authorization", "Bearer "+c.token)
+
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	
-	return c.httpClient.Do(req)
+
+	again = func() (io.Reader, bool) { return nil, body == nil }
+	if req.GetBody != nil {
+		again = func() (io.Reader, bool) {
+			b, err := req.GetBody()
+			return b, err == nil
+		}
+	}
+	resp, err = c.do(req)
+	return resp, again, err
+}
+
+// List returns the entries of the folder dirPath.
+func (c *Client) List(ctx context.Context, dirPath string) ([]FileInfo, error) {
+	return c.lists.do(ctx, dirPath, func(ctx context.Context) ([]FileInfo, error) { return c.list(ctx, dirPath) }, cloneFiles, &c.meter.shared)
 }
 
-func (c *Client) List(dirPath string) ([]FileInfo, error) {
-	endpoint := fmt.Sprintf("/api/v1/directories/%s/files", c.directoryID)
+func (c *Client) list(ctx context.Context, dirPath string) ([]FileInfo, error) {
+	endpoint := c.endpoint("/files")
 	if dirPath != "" && dirPath != "/" {
-		endpoint += "?path=" + url.QueryEscape(dirPath)
+		endpoint += "?path=" + url.QueryEscape(c.remotePath(dirPath))
 	}
 	
-	resp, err := c.doRequest("GET", endpoint, nil)
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list failed: %s", resp.Status)
+		return nil, newStatusError("list", resp)
 	}
 	
 	var listResp ListResponse
@@ -146,65 +272,174 @@ func (c *Client) List(dirPath string) ([]FileInfo, error) {
 		return nil, err
 	}
 	
-	return listResp.Files, nil
+	if c.scope != nil {
+		dir := path.Clean("/" + dirPath)
+		c.setMarked(dir, slices.ContainsFunc(listResp.Files, func(f FileInfo) bool {
+			return f.Name == EncryptionMarker && !f.IsDir
+		}))
+	}
+	return c.plainInfo(dirPath, c.plainNames(dirPath, listResp.Files)), nil
+}
+
+// Stat returns the metadata of a single file or folder.
+func (c *Client) Stat(ctx context.Context, filePath string) (*FileInfo, error) {
+	return c.stats.do(ctx, filePath, func(ctx context.Context) (*FileInfo, error) { return c.stat(ctx, filePath) }, cloneInfo, &c.meter.shared)
+}
+
+func (c *Client) stat(ctx context.Context, filePath string) (*FileInfo, error) {
+	info, err := c.rawStat(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if c.vault != nil && c.vault.Rotating() && !info.IsDir && c.Encrypted(filePath) {
+		if _, err := c.inspect(ctx, filePath, info); err != nil {
+			slog.Debug("failed to inspect encryption header", "path", filePath, "error", err)
+		}
+	}
+	return &c.plainInfo(path.Dir(filePath), []FileInfo{*info})[0], nil
 }
 
-func (c *Client) Read(filePath string) (io.ReadCloser, error) {
-	endpoint := fmt.Sprintf("/api/v1/directories/%s/files/%s/content", 
-		c.directoryID, url.QueryEscape(filePath))
+// rawStat returns the metadata of filePath as stored on the server.
+func (c *Client) rawStat(ctx context.Context, filePath string) (*FileInfo, error) {
+	endpoint := c.endpoint("/files/%s", url.QueryEscape(c.remotePath(filePath)))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("stat", resp)
+	}
+
+	var info FileInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if c.names != nil {
+		info.Name = path.Base(filePath)
+	}
+
+	return &info, nil
+}
+
+func (c *Client) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
 	
-	resp, err := c.doRequest("GET", endpoint, nil)
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 	
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("read failed: %s", resp.Status)
+		defer resp.Body.Close()
+		return nil, newStatusError("read", resp)
 	}
 	
-	return resp.Body, nil
+	return c.decrypt(filePath, resp.Body)
 }
 
-func (c *Client) Write(filePath string, data io.Reader) error {
-	endpoint := fmt.Sprintf("/api/v1/directories/%s/files/%s/content", 
-		c.directoryID, url.QueryEscape(filePath))
-	
-	resp, err := c.doRequest("PUT", endpoint, data)
+// ReadFrom returns the content of filePath starting at offset, for
+// resuming interrupted downloads. partial reports whether the server
+// honoured the offset; if not, the returned content starts at the
+// beginning of the file. Encrypted content is always read from the
+// beginning.
+func (c *Client) ReadFrom(ctx context.Context, filePath string, offset int64) (body io.ReadCloser, partial bool, err error) {
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
+
+	req, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if offset > 0 && !c.Encrypted(filePath) {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := c.decrypt(filePath, resp.Body)
+		return body, false, err
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	}
+	defer resp.Body.Close()
+	return nil, false, newStatusError("read", resp)
+}
+
+// Write replaces the content of filePath. contentType is stored with the
+// file and used when it is served; empty means application/octet-stream.
+func (c *Client) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	return c.write(ctx, filePath, contentType, data, false)
+}
+
+// Create writes filePath unless it already exists, in which case the
+// server answers with an error for which IsExists reports true. Servers
+// that do not check overwrite the file as Write does.
+func (c *Client) Create(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	return c.write(ctx, filePath, contentType, data, true)
+}
+
+func (c *Client) write(ctx context.Context, filePath, contentType string, data io.Reader, create bool) error {
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
+
+	req, err := c.newRequest(ctx, "PUT", endpoint, c.encrypt(filePath, data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeOrDefault(contentType))
+	if create {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("write failed: %s", resp.Status)
+		return newStatusError("write", resp)
 	}
-	
+
+	c.markerChanged(filePath, true)
 	return nil
 }
 
-func (c *Client) Delete(filePath string) error {
-	endpoint := fmt.Sprintf("/api/v1/directories/%s/files/%s", 
-		c.directoryID, url.QueryEscape(filePath))
+func contentTypeOrDefault(contentType string) string {
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+func (c *Client) Delete(ctx context.Context, filePath string) error {
+	endpoint := c.endpoint("/files/%s", url.QueryEscape(c.remotePath(filePath)))
 	
-	resp, err := c.doRequest("DELETE", endpoint, nil)
+	resp, err := c.doRequest(ctx, "DELETE", endpoint, nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("delete failed: %s", resp.Status)
+		return newStatusError("delete", resp)
 	}
 	
+	c.markerChanged(filePath, false)
 	return nil
 }
 
-func (c *Client) Mkdir(dirPath string) error {
-	endpoint := fmt.Sprintf("/api/v1/directories/%s/folders", c.directoryID)
+func (c *Client) Mkdir(ctx context.Context, dirPath string) error {
+	endpoint := c.endpoint("/folders")
 	
 	payload := map[string]string{
-		"path": dirPath,
+		"path": c.remotePath(dirPath),
 	}
 	
 	data, err := json.Marshal(payload)
@@ -212,15 +447,47 @@ func (c *Client) Mkdir(dirPath string) error {
 		return err
 	}
 	
-	resp, err := c.doRequest("POST", endpoint, bytes.NewBuffer(data))
+	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("mkdir failed: %s", resp.Status)
+		return newStatusError("mkdir", resp)
 	}
 	
 	return nil
-}
\ No newline at end of file
+}
+
+// Move renames a file or folder on the server without transferring its
+// content.
+func (c *Client) Move(ctx context.Context, srcPath, dstPath string) error {
+	if c.encryptedIn(path.Dir(srcPath)) != c.encryptedIn(path.Dir(dstPath)) {
+		return ErrCrossEncryption
+	}
+	endpoint := c.endpoint("/files/%s/move", url.QueryEscape(c.remotePath(srcPath)))
+
+	payload := map[string]string{
+		"destination": c.remotePath(dstPath),
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newStatusError("move", resp)
+	}
+
+	c.markerChanged(srcPath, false)
+	c.markerChanged(dstPath, true)
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// ErrCopyUnsupported is returned by CopyTo when the server cannot copy
+// the file itself, so its content has to be read and written again.
+var ErrCopyUnsupported = errors.New("copying files between directories not supported by server")
+
+// DirectoryID returns the ID of the directory the client works in.
+func (c *Client) DirectoryID() string {
+	return c.directoryID
+}
+
+// CopyTo copies the file srcPath to dstPath in the directory of dst on
+// the server, without transferring its content. Files in encrypted
+// folders, on either side, cannot be copied this way.
+func (c *Client) CopyTo(ctx context.Context, dst *Client, srcPath, dstPath string) error {
+	if c.copiesUnsupported.Load() || c.baseURL != dst.baseURL ||
+		c.encryptedIn(path.Dir(srcPath)) || dst.encryptedIn(path.Dir(dstPath)) {
+		return ErrCopyUnsupported
+	}
+	endpoint := c.endpoint("/files/%s/copy", url.QueryEscape(c.remotePath(srcPath)))
+
+	data, err := json.Marshal(map[string]string{
+		"directory_id": dst.directoryID,
+		"destination":  dst.remotePath(dstPath),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case unsupportedStatus(resp.StatusCode):
+		c.copiesUnsupported.Store(true)
+		return ErrCopyUnsupported
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent:
+		return newStatusError("copy", resp)
+	}
+
+	dst.markerChanged(dstPath, true)
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrDeadline is returned for requests of an operation that was given
+// up: their context was done before they got an answer.
+var ErrDeadline = errors.New("operation deadline exceeded")
+
+type retryKey struct{}
+
+// WithRetry returns ctx for an operation whose requests, when they fail
+// because the server cannot be reached, are sent again until they get an
+// answer or ctx is done, instead of api.retry_count times. Other
+// operations are not affected, even on the same paths.
+func WithRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// retrying reports whether the requests of the operation of ctx are
+// retried until the server answers.
+func retrying(ctx context.Context) bool {
+	retry, _ := ctx.Value(retryKey{}).(bool)
+	return retry
+}
+
+// do sends req, whose context is that of the operation making it. A
+// request aborted because the operation was given up fails with
+// ErrDeadline, so it is not taken for a server that cannot be reached.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil && req.Context().Err() != nil {
+		return nil, ErrDeadline
+	}
+	return resp, err
+}
+
+const (
+	retryMinDelay = time.Second
+	retryMaxDelay = 30 * time.Second
+)
+
+// retry sends a request that failed with resp or err again, while the
+// server cannot be reached, until it gets an answer or ctx is done, or
+// limit times if limit is not 0. again returns the body to send, as from
+// send; requests whose body cannot be sent again are not retried.
+func (c *Client) retry(ctx context.Context, limit int, method, endpoint string, again func() (io.Reader, bool), resp *http.Response, err error) (*http.Response, error) {
+	delay := retryMinDelay
+	for attempt := 1; ; attempt++ {
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if ctx.Err() != nil || (err != nil && !IsUnreachable(err)) {
+			return resp, err
+		}
+		// An open breaker is there to fail fast, unless retrying until
+		// the server answers.
+		if limit > 0 && (attempt > limit || errors.Is(err, ErrUnavailable)) {
+			return resp, err
+		}
+		body, ok := again()
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		switch {
+		case limit > 0:
+			slog.Debug("server not answering, retrying", "method", method, "endpoint", endpoint, "attempt", attempt)
+		case attempt == 1:
+			slog.Warn("server not answering, retrying until it does", "method", method, "endpoint", endpoint)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ErrDeadline
+		}
+		delay = min(delay*2, retryMaxDelay)
+
+		resp, again, err = c.send(ctx, method, endpoint, body)
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// SetVault makes the client encrypt file content with v before uploading
+// it and decrypt it after downloading, and report the sizes of the
+// decrypted content, in the folders v encrypts. The server only ever holds
+// encrypted content of files there, so byte ranges, appends and
+// chunk-level uploads, which work on the content as stored, are not used
+// for them, and the server's content hashes are dropped. If v encrypts
+// names as well, names there are encrypted in every request and decrypted
+// in every answer.
+func (c *Client) SetVault(v *vault.Vault) {
+	c.vault = v
+	if v == nil {
+		return
+	}
+	c.names = v.Names()
+	c.scope = newScope(v.Scope())
+	c.headers = make(map[string]storedHeader)
+}
+
+// remotePath returns the path filePath is stored at on the server.
+func (c *Client) remotePath(filePath string) string {
+	if c.names == nil {
+		return filePath
+	}
+	names := splitPath(filePath)
+	if len(names) == 0 {
+		return filePath
+	}
+	from := c.encryptedBelow(names[:len(names)-1])
+	if from < 0 {
+		return filePath
+	}
+	for i := from; i < len(names); i++ {
+		if names[i] != EncryptionMarker {
+			names[i] = c.names.Encrypt(names[i])
+		}
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+// plainPath returns the path of the file stored at stored.
+func (c *Client) plainPath(stored string) (string, error) {
+	names := splitPath(stored)
+	if c.names == nil || len(names) == 0 {
+		return stored, nil
+	}
+	// Names are stored as they are up to the first encrypted folder, so
+	// the stored path leads to it as well.
+	from := c.encryptedBelow(names[:len(names)-1])
+	if from < 0 {
+		return stored, nil
+	}
+	for i := from; i < len(names); i++ {
+		if names[i] == EncryptionMarker {
+			continue
+		}
+		plain, err := c.names.Decrypt(names[i])
+		if err != nil {
+			return "", err
+		}
+		names[i] = plain
+	}
+	return "/" + strings.Join(names, "/"), nil
+}
+
+// plainNames decrypts the names of files in dir, or their paths for files
+// with Path set, as the server reports them. Entries whose names were not
+// encrypted with the filename key, such as files uploaded by other means,
+// are left out.
+func (c *Client) plainNames(dir string, files []FileInfo) []FileInfo {
+	if c.names == nil {
+		return files
+	}
+	encrypted := c.encryptedIn(dir)
+	plain := files[:0]
+	for _, f := range files {
+		var err error
+		switch {
+		case f.Path != "":
+			if f.Path, err = c.plainPath(f.Path); err == nil {
+				f.Name = path.Base(f.Path)
+			}
+		case encrypted && f.Name != EncryptionMarker:
+			f.Name, err = c.names.Decrypt(f.Name)
+		}
+		if err != nil {
+			slog.Debug("skipping file with unencrypted name", "name", f.Name, "path", f.Path)
+			continue
+		}
+		plain = append(plain, f)
+	}
+	return plain
+}
+
+// encrypt returns the content to upload to filePath for data.
+func (c *Client) encrypt(filePath string, data io.Reader) io.Reader {
+	if data == nil || !c.Encrypted(filePath) {
+		return data
+	}
+	return c.vault.Encrypt(data)
+}
+
+// decrypt returns the content of the downloaded body of filePath.
+// Content that is not encrypted, or not to our keys, is refused rather
+// than passed on, as the server could have replaced it.
+func (c *Client) decrypt(filePath string, body io.ReadCloser) (io.ReadCloser, error) {
+	if !c.Encrypted(filePath) {
+		return body, nil
+	}
+	plain, err := c.vault.Decrypt(body)
+	if err != nil {
+		body.Close()
+		slog.Warn("failed to decrypt file", "path", filePath, "error", err)
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, body}, nil
+}
+
+// plainInfo adjusts the metadata of files in dir as the server reports
+// them to their decrypted content. Files with Path set may be anywhere.
+func (c *Client) plainInfo(dir string, files []FileInfo) []FileInfo {
+	if c.vault == nil {
+		return files
+	}
+	for i := range files {
+		f := &files[i]
+		filePath := filePathOf(dir, f)
+		if f.IsDir || !c.Encrypted(filePath) {
+			continue
+		}
+		if h, ok := c.knownHeader(filePath, f); ok {
+			f.Size = h.PlainSize(f.Size)
+		} else {
+			f.Size = c.vault.PlainSize(f.Size)
+		}
+		f.Hash = ""
+	}
+	return files
+}
+
+func filePathOf(dir string, f *FileInfo) string {
+	if f.Path != "" {
+		return path.Clean(f.Path)
+	}
+	return path.Join(dir, f.Name)
+}
+
+// storedHeader is the header of a version of a stored file.
+type storedHeader struct {
+	size     int64
+	modified time.Time
+	header   vault.Header
+}
+
+// knownHeader returns the header of the stored file at filePath, if it
+// was inspected in the version described by info.
+func (c *Client) knownHeader(filePath string, info *FileInfo) (vault.Header, bool) {
+	c.headersMu.Lock()
+	defer c.headersMu.Unlock()
+	h, ok := c.headers[filePath]
+	if !ok || h.size != info.Size || !h.modified.Equal(info.Modified) {
+		return vault.Header{}, false
+	}
+	return h.header, true
+}
+
+// Inspect reads the encryption header of the stored file filePath. While
+// keys are being rotated, sizes of files encrypted to other keys than the
+// current ones differ from what the current keys would make of them, so
+// Stat inspects every file it has not seen in its current version, and
+// listings use the sizes of files inspected before.
+func (c *Client) Inspect(ctx context.Context, filePath string) (vault.Header, error) {
+	if !c.Encrypted(filePath) {
+		return vault.Header{}, errors.New("file is not encrypted")
+	}
+	info, err := c.rawStat(ctx, filePath)
+	if err != nil {
+		return vault.Header{}, err
+	}
+	return c.inspect(ctx, filePath, info)
+}
+
+// inspect reads the header of filePath, stored as described by info.
+func (c *Client) inspect(ctx context.Context, filePath string, info *FileInfo) (vault.Header, error) {
+	if h, ok := c.knownHeader(filePath, info); ok {
+		return h, nil
+	}
+
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
+	req, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return vault.Header{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", vault.HeaderPeek-1))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return vault.Header{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return vault.Header{}, newStatusError("read", resp)
+	}
+	// A server ignoring the range sends everything; the start will do.
+	prefix, err := io.ReadAll(io.LimitReader(resp.Body, vault.HeaderPeek))
+	if err != nil {
+		return vault.Header{}, err
+	}
+
+	h, err := c.vault.Inspect(prefix)
+	if err != nil {
+		return vault.Header{}, err
+	}
+	c.headersMu.Lock()
+	c.headers[filePath] = storedHeader{size: info.Size, modified: info.Modified, header: h}
+	c.headersMu.Unlock()
+	return h, nil
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The kinds of errors the server answers requests with. A *StatusError
+// matches the one of its status with errors.Is, so callers can branch on
+// the kind without looking at status codes:
+//
+//	if errors.Is(err, api.ErrQuotaExceeded) { ... }
+var (
+	// ErrNotFound: the file or folder does not exist (404).
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized: the credentials are invalid, expired or do not
+	// allow the request (401, 403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrQuotaExceeded: the storage quota is used up (402, 507, or any
+	// status with the error code "quota_exceeded").
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrRateLimited: too many requests; see StatusError.RetryAfter (429).
+	ErrRateLimited = errors.New("rate limited")
+	// ErrConflict: something exists at the path already, or changed
+	// since it was read (409, 412).
+	ErrConflict = errors.New("conflict")
+)
+
+// StatusError is returned when the server answers a request with an
+// unexpected status.
+type StatusError struct {
+	Op         string // e.g. "write"
+	StatusCode int
+	Status     string
+	Code       string        // the API error code of the answer, if any, e.g. "quota_exceeded"
+	Message    string        // the API error message of the answer, if any
+	RequestID  string        // the X-Request-Id of the answer, for finding the request in the server's logs
+	RetryAfter time.Duration // how long to wait before retrying, if the server said
+}
+
+func (e *StatusError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s failed: %s", e.Op, e.Status)
+	switch {
+	case e.Message != "":
+		b.WriteString(": " + e.Message)
+	case e.Code != "":
+		b.WriteString(": " + e.Code)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// Is reports whether target is the kind of e, such as ErrNotFound.
+func (e *StatusError) Is(target error) bool {
+	kind := e.kind()
+	return kind != nil && target == kind
+}
+
+// kind returns the kind of error the status and code of e tell, or nil.
+func (e *StatusError) kind() error {
+	if e.Code == "quota_exceeded" {
+		return ErrQuotaExceeded
+	}
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusPaymentRequired, http.StatusInsufficientStorage:
+		return ErrQuotaExceeded
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrConflict
+	}
+	return nil
+}
+
+// maxErrorBody bounds how much of an error answer is read for its code
+// and message.
+const maxErrorBody = 4 << 10
+
+// newStatusError returns the error for the unexpected answer resp to op,
+// with the error code and message the server put in a JSON body, either
+// {"code": ..., "message": ...} or {"error": {"code": ..., "message": ...}}.
+// The body is read; callers still close it.
+func newStatusError(op string, resp *http.Response) error {
+	e := &StatusError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	if resp.Body == nil || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return e
+	}
+	var body struct {
+		apiError
+		Error json.RawMessage `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&body) != nil {
+		return e
+	}
+	e.Code, e.Message = body.Code, body.Message
+
+	// Some servers nest the error, or give just a message.
+	var nested apiError
+	var message string
+	if json.Unmarshal(body.Error, &nested) == nil {
+		e.Code, e.Message = nested.Code, nested.Message
+	} else if json.Unmarshal(body.Error, &message) == nil && e.Message == "" {
+		e.Message = message
+	}
+	return e
+}
+
+// apiError is the error code and message of an error answer.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// IsNotFound reports whether err means the requested file or folder does
+// not exist, as the server or a StorageBackend tells.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// IsExists reports whether err means a file or folder could not be
+// created because one already exists at its path.
+func IsExists(err error) bool {
+	return errors.Is(err, ErrConflict) || errors.Is(err, fs.ErrExist)
+}
+
+// IsWriteDenied reports whether err means the server refuses writes
+// until something changes on its side: the quota is exhausted or the
+// credentials lack write permission. Retrying such requests is pointless.
+func IsWriteDenied(err error) bool {
+	if errors.Is(err, ErrQuotaExceeded) || errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	switch statusErr.StatusCode {
+	case http.StatusForbidden, http.StatusPaymentRequired,
+		http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage:
+		return true
+	}
+	return false
+}
+
+// IsUnreachable reports whether err means the server could not be reached
+// or could not answer, as opposed to answering that a request is wrong.
+func IsUnreachable(err error) bool {
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= 500
+}
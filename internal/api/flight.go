@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// flightGroup collapses concurrent identical requests, such as the
+// listings of one folder asked for by parallel lookups, into one: calls
+// with the key of a call in progress wait for it and get its result.
+// The zero value is ready to use.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flight[T]
+}
+
+type flight[T any] struct {
+	done    chan struct{}
+	callers int  // waiting for the result
+	shared  bool // whether more than one caller asked for it
+	cancel  context.CancelFunc
+	val     T
+	err     error
+}
+
+// do runs fn unless a call with the same key is in progress, and returns
+// the result of the call. When the result is shared, every caller gets a
+// copy made by clone, so callers are free to modify what they get. saved
+// counts the calls that did not need a request of their own.
+//
+// fn runs for as long as any caller waits, with the values of the ctx of
+// the caller that started it: a caller whose ctx is done stops waiting
+// and gets ErrDeadline, without cutting the request short for the others.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(ctx context.Context) (T, error), clone func(T) T, saved *atomic.Int64) (T, error) {
+	g.mu.Lock()
+	f, ok := g.calls[key]
+	if ok {
+		f.callers++
+		f.shared = true
+		saved.Add(1)
+	} else {
+		if g.calls == nil {
+			g.calls = make(map[string]*flight[T])
+		}
+		fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight[T]{done: make(chan struct{}), callers: 1, cancel: cancel}
+		g.calls[key] = f
+		go func() {
+			f.val, f.err = fn(fctx)
+			g.mu.Lock()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	var zero T
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.callers--
+		if f.callers == 0 {
+			// Nobody waits for the request any more.
+			f.cancel()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return zero, ErrDeadline
+	}
+
+	if f.err != nil {
+		return zero, f.err
+	}
+	g.mu.Lock()
+	shared := f.shared
+	g.mu.Unlock()
+	if shared {
+		return clone(f.val), nil
+	}
+	return f.val, nil
+}
+
+func cloneFiles(files []FileInfo) []FileInfo { return slices.Clone(files) }
+
+func cloneInfo(info *FileInfo) *FileInfo {
+	c := *info
+	return &c
+}
+
+func cloneBytes(b []byte) []byte { return slices.Clone(b) }
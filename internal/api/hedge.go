@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeSamples is the number of recent read latencies the hedging
+	// delay is computed from.
+	hedgeSamples = 128
+	// hedgeMinSamples is the number of reads to wait for before hedging,
+	// so one slow start does not set the delay.
+	hedgeMinSamples = 16
+)
+
+// hedger decides when a read taking unusually long is sent a second time.
+// The delay is the configured percentile of recent read latencies, so
+// only the slowest reads are duplicated and the extra load stays around
+// 100 minus the percentile percent of reads.
+type hedger struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration // ring of recent latencies
+	next    int
+}
+
+func newHedger(percentile float64, minDelay time.Duration) *hedger {
+	if percentile <= 0 {
+		return nil
+	}
+	return &hedger{percentile: percentile, minDelay: minDelay, samples: make([]time.Duration, 0, hedgeSamples)}
+}
+
+// delay returns how long to wait for a read before sending it again, or
+// false while too few reads were seen to tell.
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	if len(h.samples) < hedgeMinSamples {
+		h.mu.Unlock()
+		return 0, false
+	}
+	sorted := slices.Clone(h.samples)
+	h.mu.Unlock()
+
+	slices.Sort(sorted)
+	i := int(float64(len(sorted)-1) * h.percentile / 100)
+	return max(sorted[i], h.minDelay), true
+}
+
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) < cap(h.samples) {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % len(h.samples)
+}
+
+type hedgeResult struct {
+	data  []byte
+	err   error
+	hedge bool
+	took  time.Duration
+}
+
+// hedged runs read, and again if it has not returned after the hedging
+// delay, and returns whichever answers first; the other is cancelled.
+// read must be safe to run twice at once. Both are cancelled when ctx is
+// done.
+func (c *Client) hedged(ctx context.Context, read func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	h := c.hedger
+	if h == nil {
+		return read(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	attempt := func(hedge bool) {
+		start := time.Now()
+		data, err := read(ctx)
+		results <- hedgeResult{data: data, err: err, hedge: hedge, took: time.Since(start)}
+	}
+	go attempt(false)
+
+	delay, ok := h.delay()
+	var timer <-chan time.Time
+	if ok {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	running := 1
+	var first hedgeResult
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			running++
+			c.meter.hedged.Add(1)
+			go attempt(true)
+			continue
+		case r := <-results:
+			running--
+			if r.err != nil && running > 0 {
+				// The other attempt may still succeed.
+				first = r
+				continue
+			}
+			if r.err == nil {
+				h.observe(r.took)
+				if r.hedge {
+					c.meter.hedgeWins.Add(1)
+				}
+				return r.data, nil
+			}
+			if first.err != nil {
+				return nil, first.err
+			}
+			return nil, r.err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ErrLeasesUnsupported is returned by the lease methods when the server
+// has no locking API.
+var ErrLeasesUnsupported = errors.New("file leases not supported by server")
+
+// Lease is an exclusive write lock on a file held for a limited time.
+type Lease struct {
+	ID        string    `json:"id"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LockedError is returned when another client holds a lease on the file.
+type LockedError struct {
+	Path      string
+	Holder    string
+	ExpiresAt time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by %s until %s", e.Path, e.Holder, e.ExpiresAt.Format(time.RFC3339))
+}
+
+type leaseRequest struct {
+	TTL    int64  `json:"ttl"` // seconds
+	Holder string `json:"holder"`
+}
+
+// AcquireLease takes a write lease on filePath for ttl. It returns a
+// *LockedError if someone else holds one.
+func (c *Client) AcquireLease(ctx context.Context, filePath string, ttl time.Duration) (*Lease, error) {
+	endpoint := c.endpoint("/files/%s/lease", url.QueryEscape(c.remotePath(filePath)))
+	return c.leaseRequest(ctx, "POST", endpoint, filePath, ttl)
+}
+
+// RenewLease extends a lease held by this client by ttl from now.
+func (c *Client) RenewLease(ctx context.Context, filePath string, lease *Lease, ttl time.Duration) (*Lease, error) {
+	endpoint := c.endpoint("/files/%s/lease/%s", url.QueryEscape(c.remotePath(filePath)), url.PathEscape(lease.ID))
+	return c.leaseRequest(ctx, "PUT", endpoint, filePath, ttl)
+}
+
+// ReleaseLease gives up a lease before it expires.
+func (c *Client) ReleaseLease(ctx context.Context, filePath string, lease *Lease) error {
+	endpoint := c.endpoint("/files/%s/lease/%s", url.QueryEscape(c.remotePath(filePath)), url.PathEscape(lease.ID))
+
+	resp, err := c.doRequest(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return newStatusError("lease release", resp)
+	}
+
+	return nil
+}
+
+func (c *Client) leaseRequest(ctx context.Context, method, endpoint, filePath string, ttl time.Duration) (*Lease, error) {
+	if c.leasesUnsupported.Load() {
+		return nil, ErrLeasesUnsupported
+	}
+
+	holder, _ := os.Hostname()
+	data, err := json.Marshal(leaseRequest{
+		TTL:    int64(ttl / time.Second),
+		Holder: holder,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, method, endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusLocked || resp.StatusCode == http.StatusConflict:
+		var other Lease
+		json.NewDecoder(resp.Body).Decode(&other)
+		return nil, &LockedError{Path: filePath, Holder: other.Holder, ExpiresAt: other.ExpiresAt}
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		c.leasesUnsupported.Store(true)
+		return nil, ErrLeasesUnsupported
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated:
+		return nil, newStatusError("lease", resp)
+	}
+
+	var lease Lease
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localTempPrefix starts the names of the files LocalBackend writes
+// content to before moving it in place. Listings leave them out.
+const localTempPrefix = ".koneksi-drive-"
+
+// ErrNoAPI is returned by NewClient for api.backend "local": its files
+// are kept by a LocalBackend, and there is no Koneksi API to ask for
+// anything else.
+var ErrNoAPI = errors.New(`not available with api.backend "local", which has no Koneksi API`)
+
+// LocalBackend keeps files in a folder of the local file system, for
+// trying out mounts and testing without a server.
+type LocalBackend struct {
+	root string
+
+	mu sync.Mutex
+	// hashes keeps the content hashes of files by local path, computed
+	// when a file is first listed, for as long as its size and
+	// modification time stay the same.
+	hashes map[string]localHash
+}
+
+type localHash struct {
+	size     int64
+	modified time.Time
+	hash     string
+}
+
+// NewLocalBackend returns a backend keeping files in the folder root,
+// which has to exist.
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder", root)
+	}
+	return &LocalBackend{root: root, hashes: make(map[string]localHash)}, nil
+}
+
+// path returns the local path of filePath. Cleaning it first keeps it
+// inside the root.
+func (l *LocalBackend) path(filePath string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+filePath)))
+}
+
+func (l *LocalBackend) info(filePath string, info os.FileInfo) FileInfo {
+	f := FileInfo{
+		Name:     info.Name(),
+		IsDir:    info.IsDir(),
+		Modified: info.ModTime().UTC(),
+		Path:     path.Clean("/" + filePath),
+	}
+	if !f.IsDir {
+		f.Size = info.Size()
+		f.Hash = l.hash(l.path(filePath), info)
+	}
+	return f
+}
+
+// hash returns the hex SHA-256 of the content of the local file name, or
+// "" if it cannot be read.
+func (l *LocalBackend) hash(name string, info os.FileInfo) string {
+	l.mu.Lock()
+	h, ok := l.hashes[name]
+	l.mu.Unlock()
+	if ok && h.size == info.Size() && h.modified.Equal(info.ModTime()) {
+		return h.hash
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return ""
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+	l.remember(name, info, hash)
+	return hash
+}
+
+func (l *LocalBackend) remember(name string, info os.FileInfo, hash string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hashes[name] = localHash{size: info.Size(), modified: info.ModTime(), hash: hash}
+}
+
+func (l *LocalBackend) List(ctx context.Context, dirPath string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(l.path(dirPath))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), localTempPrefix) {
+			continue
+		}
+		filePath := path.Join(dirPath, entry.Name())
+		// Stat rather than entry.Info, to follow symbolic links.
+		info, err := os.Stat(l.path(filePath))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, l.info(filePath, info))
+	}
+	return files, nil
+}
+
+func (l *LocalBackend) Stat(ctx context.Context, filePath string) (*FileInfo, error) {
+	info, err := os.Stat(l.path(filePath))
+	if err != nil {
+		return nil, err
+	}
+	f := l.info(filePath, info)
+	if f.Path == "/" {
+		f.Name = "/"
+	}
+	return &f, nil
+}
+
+func (l *LocalBackend) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	file, err := os.Open(l.path(filePath))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		if err == nil {
+			err = fmt.Errorf("%s is a folder", filePath)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// ReadRange reads a byte range of filePath without reading the content
+// before it.
+func (l *LocalBackend) ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	content, err := l.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	data := make([]byte, length)
+	n, err := content.(*os.File).ReadAt(data, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// Write replaces the content of filePath at once, by writing it to a new
+// file and moving that in place. contentType is not stored.
+func (l *LocalBackend) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	name := l.path(filePath)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), localTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, sum), data); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, statErr := tmp.Stat()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if statErr != nil {
+		return statErr
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	// Hashed while written, so listing the file does not read it again.
+	l.remember(name, info, hex.EncodeToString(sum.Sum(nil)))
+	return nil
+}
+
+// Delete removes a file, or a folder if it is empty.
+func (l *LocalBackend) Delete(ctx context.Context, filePath string) error {
+	name := l.path(filePath)
+	if name == filepath.Clean(l.root) {
+		return fmt.Errorf("refusing to delete the root folder: %w", os.ErrPermission)
+	}
+	return os.Remove(name)
+}
+
+func (l *LocalBackend) Mkdir(ctx context.Context, dirPath string) error {
+	return os.Mkdir(l.path(dirPath), 0755)
+}
+
+func (l *LocalBackend) Move(ctx context.Context, srcPath, dstPath string) error {
+	dst := l.path(dstPath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(l.path(srcPath), dst)
+}
+
+func (l *LocalBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	src, err := l.Read(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return l.Write(ctx, dstPath, "", src)
+}
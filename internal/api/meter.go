@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets request latencies,
+// up to the response headers, are counted in; the last bucket counts
+// those slower than all of them.
+var LatencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Traffic is a snapshot of the requests a client has made.
+type Traffic struct {
+	Uploaded   int64              // request body bytes sent
+	Downloaded int64              // response body bytes received
+	Calls      map[string]int64   // requests by operation, e.g. "read"
+	Shared     int64              // calls answered by an identical request already in flight
+	Hedged     int64              // reads sent a second time for being slow
+	HedgeWins  int64              // hedged reads whose second request answered first
+	Errors     map[string]int64   // failed requests by class, see errorClass
+	Latency    map[string][]int64 // requests by operation and LatencyBuckets
+}
+
+// Traffic returns the requests made and bytes moved by this client so
+// far. Headers are not counted.
+func (c *Client) Traffic() Traffic {
+	return c.meter.snapshot()
+}
+
+// meter counts the traffic of a client. It wraps the HTTP transport so
+// that every request is counted, whichever method issued it.
+type meter struct {
+	base http.RoundTripper
+
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	shared     atomic.Int64
+	hedged     atomic.Int64
+	hedgeWins  atomic.Int64
+
+	mu      sync.Mutex
+	calls   map[string]int64
+	errors  map[string]int64
+	latency map[string][]int64
+}
+
+func newMeter(base http.RoundTripper) *meter {
+	return &meter{
+		base:    base,
+		calls:   make(map[string]int64),
+		errors:  make(map[string]int64),
+		latency: make(map[string][]int64),
+	}
+}
+
+func (m *meter) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := operation(req)
+	m.mu.Lock()
+	m.calls[op]++
+	m.mu.Unlock()
+	start := time.Now()
+
+	if req.Body != nil && req.Body != http.NoBody {
+		// A RoundTripper must not modify the request it was given.
+		req = req.Clone(req.Context())
+		req.Body = &countingBody{ReadCloser: req.Body, n: &m.uploaded}
+	}
+
+	resp, err := m.base.RoundTrip(req)
+	m.finished(op, time.Since(start), resp, err)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: &m.downloaded}
+	return resp, nil
+}
+
+// finished counts how long a request of op took and how it failed, if
+// it did. Requests cancelled by the caller count neither.
+func (m *meter) finished(op string, took time.Duration, resp *http.Response, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if class := errorClass(resp, err); class != "" {
+		m.errors[class]++
+	}
+	if err != nil {
+		return
+	}
+	buckets := m.latency[op]
+	if buckets == nil {
+		buckets = make([]int64, len(LatencyBuckets)+1)
+		m.latency[op] = buckets
+	}
+	i := 0
+	for i < len(LatencyBuckets) && took > LatencyBuckets[i] {
+		i++
+	}
+	buckets[i]++
+}
+
+// errorClass returns the class of a failed request: "timeout" or
+// "network" when no answer arrived, and otherwise by the status the
+// server answered with "auth", "not-found", "conflict", "rate-limited",
+// "client" or "server". It returns "" for requests that succeeded.
+func errorClass(resp *http.Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	switch code := resp.StatusCode; {
+	case code < 400:
+		return ""
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return "auth"
+	case code == http.StatusNotFound:
+		return "not-found"
+	case code == http.StatusConflict || code == http.StatusPreconditionFailed:
+		return "conflict"
+	case code == http.StatusTooManyRequests:
+		return "rate-limited"
+	case code < 500:
+		return "client"
+	}
+	return "server"
+}
+
+func (m *meter) snapshot() Traffic {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calls := make(map[string]int64, len(m.calls))
+	for op, n := range m.calls {
+		calls[op] = n
+	}
+	errs := make(map[string]int64, len(m.errors))
+	for class, n := range m.errors {
+		errs[class] = n
+	}
+	latency := make(map[string][]int64, len(m.latency))
+	for op, buckets := range m.latency {
+		latency[op] = append([]int64(nil), buckets...)
+	}
+	return Traffic{
+		Uploaded:   m.uploaded.Load(),
+		Downloaded: m.downloaded.Load(),
+		Calls:      calls,
+		Shared:     m.shared.Load(),
+		Hedged:     m.hedged.Load(),
+		HedgeWins:  m.hedgeWins.Load(),
+		Errors:     errs,
+		Latency:    latency,
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// operation names the API operation of a request from its method and
+// endpoint, e.g. "GET /api/v1/directories/{id}/files/{path}/content" is
+// "read".
+func operation(req *http.Request) string {
+	p := req.URL.EscapedPath()
+	if strings.HasSuffix(p, "/oauth/token") {
+		return "auth"
+	}
+	if strings.HasSuffix(p, "/api/versions") {
+		return "version"
+	}
+
+	_, rest, ok := strings.Cut(p, "/directories/")
+	if !ok {
+		return "other"
+	}
+	// Drop the directory ID. File paths are escaped into one segment.
+	parts := strings.Split(rest, "/")[1:]
+	if len(parts) == 0 {
+		return "other"
+	}
+
+	switch parts[0] {
+	case "files":
+		switch {
+		case len(parts) == 1:
+			return "list"
+		case len(parts) == 2 && req.Method == http.MethodDelete:
+			return "delete"
+		case len(parts) == 2:
+			return "stat"
+		case parts[2] == "content" && req.Method == http.MethodGet:
+			return "read"
+		case parts[2] == "content":
+			return "write"
+		}
+		return parts[2] // move, manifest, lease, share, thumbnail, owner
+	case "folders":
+		return "mkdir"
+	case "chunks":
+		return "chunks"
+	}
+	return parts[0] // search, recent
+}
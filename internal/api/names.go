@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"path"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits the server enforces on file and folder names, in bytes.
+const (
+	MaxNameLength = 255
+	MaxPathLength = 4096
+)
+
+var (
+	// ErrNameTooLong is returned by ValidateName for names or paths over
+	// the server's limits.
+	ErrNameTooLong = errors.New("name too long")
+	// ErrInvalidName is returned by ValidateName for names the server
+	// rejects: invalid UTF-8 or control characters such as newlines.
+	ErrInvalidName = errors.New("invalid name")
+)
+
+// ValidateName checks that a file or folder called name can be stored at
+// filePath, so callers can reject it before the server answers with an
+// opaque 400 Bad Request.
+func ValidateName(name, filePath string) error {
+	if len(name) > MaxNameLength || len(filePath) > MaxPathLength {
+		return ErrNameTooLong
+	}
+	if !utf8.ValidString(name) {
+		return ErrInvalidName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return ErrInvalidName
+		}
+	}
+	return nil
+}
+
+// ValidateName is ValidateName for the names as the client stores them,
+// which are longer than the names themselves when names are encrypted.
+func (c *Client) ValidateName(name, filePath string) error {
+	if err := ValidateName(name, filePath); err != nil || c.names == nil {
+		return err
+	}
+	stored := c.remotePath(filePath)
+	if len(path.Base(stored)) > MaxNameLength || len(stored) > MaxPathLength {
+		return ErrNameTooLong
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// ErrOffline is returned for every request of a client created by
+// NewOfflineClient.
+var ErrOffline = errors.New("offline: the server is not contacted")
+
+// NewOfflineClient creates a client that fails every request with
+// ErrOffline right away, without touching the network. It serves mounts
+// working from the cache alone.
+func NewOfflineClient(cfg *config.APIConfig) *Client {
+	tr := newTracer(offlineTransport{})
+	return &Client{
+		baseURL:     cfg.BaseURL,
+		directoryID: cfg.DirectoryID,
+		httpClient:  &http.Client{Transport: tr},
+		meter:       newMeter(offlineTransport{}),
+		tracer:      tr,
+		version:     apiVersions[cfg.Version],
+	}
+}
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, ErrOffline
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ErrOwnersUnsupported is returned by SetOwner when the server does not
+// store file ownership.
+var ErrOwnersUnsupported = errors.New("file ownership not supported by server")
+
+// Owner is the local user and group a file belongs to, recorded by the
+// mount that created it.
+type Owner struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// SetOwner records owner as the owner of filePath.
+func (c *Client) SetOwner(ctx context.Context, filePath string, owner Owner) error {
+	if c.ownersUnsupported.Load() {
+		return ErrOwnersUnsupported
+	}
+
+	endpoint := c.endpoint("/files/%s/owner", url.QueryEscape(c.remotePath(filePath)))
+
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.doRequest(ctx, "PUT", endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The file exists, so a 404 means the endpoint does not.
+	if unsupportedStatus(resp.StatusCode) {
+		c.ownersUnsupported.Store(true)
+		return ErrOwnersUnsupported
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newStatusError("set owner", resp)
+	}
+
+	return nil
+}
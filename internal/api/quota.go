@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrQuotaUnsupported is returned by Quota when the server does not
+// report storage quotas.
+var ErrQuotaUnsupported = errors.New("server does not report quotas")
+
+// Quota is the storage used by the directory's account and its limit.
+type Quota struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"` // 0 for no limit
+}
+
+// Percent returns how much of the quota is used, or 0 without a limit.
+func (q *Quota) Percent() float64 {
+	if q.Limit <= 0 {
+		return 0
+	}
+	return float64(q.Used) * 100 / float64(q.Limit)
+}
+
+// Quota returns the storage quota of the directory.
+func (c *Client) Quota(ctx context.Context) (*Quota, error) {
+	resp, err := c.doRequest(ctx, "GET", c.endpoint("/quota"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrQuotaUnsupported
+	default:
+		return nil, newStatusError("quota", resp)
+	}
+
+	var q Quota
+	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
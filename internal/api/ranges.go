@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ErrRangeUnsupported is returned by ReadRange when the server cannot
+// return part of a file's content.
+var ErrRangeUnsupported = errors.New("byte ranges not supported by server")
+
+// ReadRange returns length bytes of the content of filePath starting at
+// offset, fewer if the file ends before. With api.hedge_percentile, a read
+// slower than that percentile of recent ones is sent again and the first
+// answer is used.
+func (c *Client) ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	key := fmt.Sprintf("%s\x00%d-%d", filePath, offset, length)
+	return c.ranges.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return c.hedged(ctx, func(ctx context.Context) ([]byte, error) { return c.readRange(ctx, filePath, offset, length) })
+	}, cloneBytes, &c.meter.shared)
+}
+
+func (c *Client) readRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	if c.rangesUnsupported.Load() || c.Encrypted(filePath) {
+		return nil, ErrRangeUnsupported
+	}
+
+	endpoint := c.endpoint("/files/%s/content", url.QueryEscape(c.remotePath(filePath)))
+
+	req, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return io.ReadAll(io.LimitReader(resp.Body, length))
+	case http.StatusOK:
+		c.rangesUnsupported.Store(true)
+		return nil, ErrRangeUnsupported
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, nil
+	}
+	return nil, newStatusError("read", resp)
+}
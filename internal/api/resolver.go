@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsDefaultTTL is how long addresses are kept when the answer did not
+	// say, as for names from /etc/hosts.
+	dnsDefaultTTL = time.Minute
+	// dialMinTimeout is the least time each address gets to connect when
+	// the dial timeout is shared between several.
+	dialMinTimeout = 2 * time.Second
+)
+
+// resolver dials the server by name, keeping the addresses the name
+// resolved to for as long as the DNS answer allowed, within the
+// configured bounds. The Go resolver does not report TTLs, so they are
+// read from the answers it receives. When no address of a name can be
+// reached, or a request fails to connect, the name is resolved again, so
+// a mount follows the server to its new address after a failover instead
+// of holding on to a dead one.
+type resolver struct {
+	dialer   net.Dialer
+	minTTL   time.Duration
+	maxTTL   time.Duration // 0 resolves on every dial
+	family   string        // "ip", "ip4" or "ip6"
+	fallback time.Duration // head start of the preferred address family, 0 to try addresses in turn
+
+	mu    sync.Mutex
+	hosts map[string]resolved
+}
+
+type resolved struct {
+	addrs   []net.IP
+	expires time.Time
+}
+
+func newResolver(dialer net.Dialer, minTTL, maxTTL time.Duration, family string, fallback time.Duration) *resolver {
+	network := "ip"
+	switch family {
+	case "ipv4":
+		network = "ip4"
+	case "ipv6":
+		network = "ip6"
+	}
+	return &resolver{
+		dialer:   dialer,
+		minTTL:   minTTL,
+		maxTTL:   maxTTL,
+		family:   network,
+		fallback: fallback,
+		hosts:    make(map[string]resolved),
+	}
+}
+
+// DialContext connects to address, a host and port, like net.Dialer.
+func (r *resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return r.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, cached, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := r.dialAddrs(ctx, network, addrs, port)
+	if err == nil || !cached || ctx.Err() != nil {
+		return conn, err
+	}
+
+	// The kept addresses may be stale: try again with fresh ones.
+	r.forget(host)
+	fresh, _, lerr := r.lookup(ctx, host)
+	if lerr != nil || slices.EqualFunc(fresh, addrs, net.IP.Equal) {
+		return nil, err
+	}
+	slog.Info("server address changed", "host", host, "addresses", fresh)
+	return r.dialAddrs(ctx, network, fresh, port)
+}
+
+// forget drops the addresses kept for host, so the next dial resolves it
+// again.
+func (r *resolver) forget(host string) {
+	r.mu.Lock()
+	delete(r.hosts, host)
+	r.mu.Unlock()
+}
+
+// lookup returns the addresses of host and whether they were kept from
+// an earlier lookup.
+func (r *resolver) lookup(ctx context.Context, host string) ([]net.IP, bool, error) {
+	r.mu.Lock()
+	res, ok := r.hosts[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(res.expires) {
+		return res.addrs, true, nil
+	}
+
+	var ttlMu sync.Mutex
+	ttl := time.Duration(-1)
+	seen := func(t time.Duration) {
+		ttlMu.Lock()
+		if ttl < 0 || t < ttl {
+			ttl = t
+		}
+		ttlMu.Unlock()
+	}
+	dns := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := r.dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			// The Go resolver tells UDP from TCP by whether the connection
+			// is a net.PacketConn, so keep it one.
+			if udp, ok := conn.(*net.UDPConn); ok {
+				return &ttlUDPConn{UDPConn: udp, seen: seen}, nil
+			}
+			return &ttlConn{Conn: conn, seen: seen}, nil
+		},
+	}
+	addrs, err := dns.LookupIP(ctx, r.family, host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ttlMu.Lock()
+	keep := ttl
+	ttlMu.Unlock()
+	if keep < 0 {
+		keep = dnsDefaultTTL
+	}
+	keep = min(max(keep, r.minTTL), r.maxTTL)
+	if keep > 0 {
+		r.mu.Lock()
+		r.hosts[host] = resolved{addrs: addrs, expires: time.Now().Add(keep)}
+		r.mu.Unlock()
+	}
+	return addrs, false, nil
+}
+
+// dialAddrs connects to the first of addrs that answers. With a fallback
+// delay, addresses of the other family than the first are tried in
+// parallel once the first family has had that head start (RFC 8305,
+// "happy eyeballs"), so a broken IPv6 route does not hold up connecting
+// over IPv4 or the other way round.
+func (r *resolver) dialAddrs(ctx context.Context, network string, addrs []net.IP, port string) (net.Conn, error) {
+	var primary, secondary []net.IP
+	for _, ip := range addrs {
+		if len(primary) == 0 || (ip.To4() == nil) == (primary[0].To4() == nil) {
+			primary = append(primary, ip)
+		} else {
+			secondary = append(secondary, ip)
+		}
+	}
+	if r.fallback <= 0 || len(secondary) == 0 {
+		return r.dialSerial(ctx, network, append(primary, secondary...), port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	primaryFailed := make(chan struct{})
+	go func() {
+		conn, err := r.dialSerial(ctx, network, primary, port)
+		if err != nil {
+			close(primaryFailed)
+		}
+		results <- result{conn, err}
+	}()
+	go func() {
+		t := time.NewTimer(r.fallback)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-primaryFailed:
+		case <-ctx.Done():
+			results <- result{err: ctx.Err()}
+			return
+		}
+		conn, err := r.dialSerial(ctx, network, secondary, port)
+		results <- result{conn, err}
+	}()
+
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err == nil {
+			cancel()
+			if i == 1 {
+				return res.conn, nil
+			}
+			go func() {
+				// Close the other connection if it was made too.
+				if other := <-results; other.conn != nil {
+					other.conn.Close()
+				}
+			}()
+			return res.conn, nil
+		}
+		if firstErr == nil {
+			firstErr = res.err
+		}
+	}
+	return nil, firstErr
+}
+
+// dialSerial tries addrs in turn, giving each a share of the time left.
+func (r *resolver) dialSerial(ctx context.Context, network string, addrs []net.IP, port string) (net.Conn, error) {
+	var firstErr error
+	for i, ip := range addrs {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := max(time.Until(deadline)/time.Duration(len(addrs)-i), dialMinTimeout)
+			dialCtx, cancel = context.WithTimeout(ctx, share)
+		}
+		conn, err := r.dialer.DialContext(dialCtx, network, net.JoinHostPort(ip.String(), port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.AddrError{Err: "no suitable address", Addr: port}
+	}
+	return nil, firstErr
+}
+
+// ttlConn reports the TTLs of the DNS answers read from a TCP connection
+// to a name server.
+type ttlConn struct {
+	net.Conn
+	seen func(time.Duration)
+}
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	answerTTL(b[:n], c.seen)
+	return n, err
+}
+
+// ttlUDPConn is ttlConn for UDP.
+type ttlUDPConn struct {
+	*net.UDPConn
+	seen func(time.Duration)
+}
+
+func (c *ttlUDPConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	answerTTL(b[:n], c.seen)
+	return n, err
+}
+
+// answerTTL calls seen with the TTL of each address and alias record in
+// msg, if it is a DNS answer. Over TCP the message is read in pieces, of
+// which only the one holding the whole answer parses.
+func answerTTL(msg []byte, seen func(time.Duration)) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		switch h.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME:
+			seen(time.Duration(h.TTL) * time.Second)
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrRetentionUnsupported is returned by SetRetention when the server
+// cannot keep files under retention.
+var ErrRetentionUnsupported = errors.New("retention not supported by server")
+
+// ErrRetentionShortened is returned by SetRetention for a date before the
+// one the file is already retained until: retention can only be extended.
+var ErrRetentionShortened = errors.New("retention can only be extended")
+
+// Retained reports whether the file may not be changed or deleted at now.
+func (f *FileInfo) Retained(now time.Time) bool {
+	return f.RetainUntil != nil && now.Before(*f.RetainUntil)
+}
+
+// RetainedError is returned for changes to a file under retention.
+type RetainedError struct {
+	Path  string
+	Until time.Time
+}
+
+func (e *RetainedError) Error() string {
+	return fmt.Sprintf("%s is under retention until %s", e.Path, e.Until.Local().Format("2006-01-02 15:04"))
+}
+
+// CheckRetention returns a *RetainedError if info is a file under
+// retention.
+func CheckRetention(info *FileInfo) error {
+	if info == nil || !info.Retained(time.Now()) {
+		return nil
+	}
+	return &RetainedError{Path: info.Path, Until: *info.RetainUntil}
+}
+
+// SetRetention keeps filePath from being changed or deleted before until,
+// and returns the file as it is now. A date before the current retention
+// fails with ErrRetentionShortened without asking the server, which
+// refuses it as well.
+func (c *Client) SetRetention(ctx context.Context, filePath string, until time.Time) (*FileInfo, error) {
+	info, err := c.Stat(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, fmt.Errorf("%s is a folder; retention applies to files", filePath)
+	}
+	if info.RetainUntil != nil && until.Before(*info.RetainUntil) {
+		return nil, ErrRetentionShortened
+	}
+
+	endpoint := c.endpoint("/files/%s/retention", url.QueryEscape(c.remotePath(filePath)))
+	data, err := json.Marshal(map[string]time.Time{"retain_until": until.UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, "PUT", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrRetentionUnsupported
+	case http.StatusConflict:
+		return nil, ErrRetentionShortened
+	default:
+		return nil, newStatusError("set retention", resp)
+	}
+
+	until = until.UTC()
+	info.RetainUntil = &until
+	return info, nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Roles a user can have in a team directory.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// ErrRoleUnsupported is returned by Role when the server does not report
+// roles, as for directories of a single user.
+var ErrRoleUnsupported = errors.New("server does not report directory roles")
+
+// RoleCanWrite reports whether role allows changing the directory. Roles
+// other than viewer are left for the server to enforce.
+func RoleCanWrite(role string) bool {
+	return role != RoleViewer
+}
+
+// Role returns the caller's role in the directory, such as RoleViewer.
+func (c *Client) Role(ctx context.Context) (string, error) {
+	resp, err := c.doRequest(ctx, "GET", c.endpoint("/members/me"), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if unsupportedStatus(resp.StatusCode) {
+		return "", ErrRoleUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newStatusError("role", resp)
+	}
+
+	var member struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		return "", err
+	}
+	if member.Role == "" {
+		return "", ErrRoleUnsupported
+	}
+	return member.Role, nil
+}
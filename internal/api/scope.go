@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EncryptionMarker is the name of the file that marks the folder holding
+// it as encrypted, with everything below it. The marker itself is stored
+// as it is.
+const EncryptionMarker = ".koneksi-encrypted"
+
+// ErrCrossEncryption is returned by Move for moves between a folder whose
+// content is encrypted and one whose content is not, which would leave
+// the content unreadable; the content has to be copied instead.
+var ErrCrossEncryption = errors.New("cannot move between encrypted and unencrypted folders")
+
+// markerTTL is how long the presence of a marker is trusted before the
+// folder is checked again, unless listing it tells sooner.
+const markerTTL = 5 * time.Minute
+
+// scope tells which folders have their content encrypted.
+type scope struct {
+	all     bool     // everything is encrypted
+	roots   []string // folders whose content is encrypted
+	markers bool     // look for marker files
+
+	mu     sync.Mutex
+	marked map[string]markerState // by folder path
+}
+
+type markerState struct {
+	marked  bool
+	expires time.Time
+}
+
+func newScope(roots []string, markers bool) *scope {
+	return &scope{
+		all:     len(roots) == 0 && !markers,
+		roots:   roots,
+		markers: markers,
+		marked:  make(map[string]markerState),
+	}
+}
+
+// splitPath returns the names in filePath, relative to the root of the
+// directory.
+func splitPath(filePath string) []string {
+	clean := strings.Trim(path.Clean("/"+filePath), "/")
+	if clean == "" {
+		return nil
+	}
+	return strings.Split(clean, "/")
+}
+
+// encryptedBelow returns how many of names, the path of a folder, lead to
+// the outermost folder on it whose content is encrypted, or -1 if the
+// content of the folder is not encrypted. Names up to that folder are
+// stored in plaintext.
+func (c *Client) encryptedBelow(names []string) int {
+	if c.vault == nil {
+		return -1
+	}
+	if c.scope.all {
+		return 0
+	}
+	for i := 0; i <= len(names); i++ {
+		dir := "/" + strings.Join(names[:i], "/")
+		if slices.Contains(c.scope.roots, dir) || (c.scope.markers && c.isMarked(dir)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// encryptedIn reports whether the content of the folder dir is encrypted.
+func (c *Client) encryptedIn(dir string) bool {
+	return c.encryptedBelow(splitPath(dir)) >= 0
+}
+
+// Encrypted reports whether the content of filePath is encrypted.
+func (c *Client) Encrypted(filePath string) bool {
+	names := splitPath(filePath)
+	if len(names) == 0 || names[len(names)-1] == EncryptionMarker {
+		return false
+	}
+	return c.encryptedBelow(names[:len(names)-1]) >= 0
+}
+
+// isMarked reports whether the folder dir, whose path is stored as it is,
+// holds a marker. If that cannot be found out, the folder is taken to be
+// marked, so content meant to be encrypted is not uploaded in plaintext.
+func (c *Client) isMarked(dir string) bool {
+	c.scope.mu.Lock()
+	state, ok := c.scope.marked[dir]
+	c.scope.mu.Unlock()
+	if ok && time.Now().Before(state.expires) {
+		return state.marked
+	}
+
+	marked, err := c.statMarker(dir)
+	if err != nil {
+		slog.Warn("failed to check folder for encryption marker", "path", dir, "error", err)
+		return !ok || state.marked
+	}
+	c.setMarked(dir, marked)
+	return marked
+}
+
+// setMarked records whether the folder dir holds a marker.
+func (c *Client) setMarked(dir string, marked bool) {
+	if c.scope == nil || !c.scope.markers {
+		return
+	}
+	c.scope.mu.Lock()
+	c.scope.marked[dir] = markerState{marked: marked, expires: time.Now().Add(markerTTL)}
+	c.scope.mu.Unlock()
+}
+
+// markerChanged updates the markers known after filePath was written or
+// deleted.
+func (c *Client) markerChanged(filePath string, exists bool) {
+	if path.Base(filePath) == EncryptionMarker {
+		c.setMarked(path.Clean("/"+path.Dir(filePath)), exists)
+	}
+}
+
+// statMarker asks the server whether dir holds a marker.
+func (c *Client) statMarker(dir string) (bool, error) {
+	endpoint := c.endpoint("/files/%s", url.QueryEscape(path.Join(dir, EncryptionMarker)))
+
+	resp, err := c.doRequest(context.Background(), "GET", endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var info FileInfo
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return false, err
+		}
+		return !info.IsDir, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, newStatusError("stat", resp)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchQuery filters files server-side. Zero fields are not applied.
+type SearchQuery struct {
+	Name           string    // substring or pattern of the file name
+	Type           string    // "file", "folder" or a MIME type prefix such as "image/"
+	ModifiedAfter  time.Time // only files modified at or after this time
+	ModifiedBefore time.Time // only files modified before this time
+	Owner          string
+	Limit          int
+}
+
+func (q SearchQuery) values() url.Values {
+	v := url.Values{}
+	if q.Name != "" {
+		v.Set("name", q.Name)
+	}
+	if q.Type != "" {
+		v.Set("type", q.Type)
+	}
+	if !q.ModifiedAfter.IsZero() {
+		v.Set("modified_after", q.ModifiedAfter.UTC().Format(time.RFC3339))
+	}
+	if !q.ModifiedBefore.IsZero() {
+		v.Set("modified_before", q.ModifiedBefore.UTC().Format(time.RFC3339))
+	}
+	if q.Owner != "" {
+		v.Set("owner", q.Owner)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// Search returns files anywhere in the directory matching q. Results have
+// Path set to their full path. With encrypted names, the server cannot
+// match names, so the name filter and limit are applied to its results.
+func (c *Client) Search(ctx context.Context, q SearchQuery) ([]FileInfo, error) {
+	pattern, limit := q.Name, q.Limit
+	if c.names != nil && pattern != "" {
+		q.Name, q.Limit = "", 0
+	}
+
+	endpoint := c.endpoint("/search")
+	if v := q.values(); len(v) > 0 {
+		endpoint += "?" + v.Encode()
+	}
+	files, err := c.listEndpoint(ctx, endpoint, "search")
+	if err != nil || q.Name == pattern {
+		return files, err
+	}
+
+	matches := files[:0]
+	for _, f := range files {
+		if matchName(pattern, f.Name) {
+			matches = append(matches, f)
+		}
+	}
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// matchName reports whether name matches pattern as the server matches
+// names: a shell pattern if pattern has wildcards, else a substring,
+// ignoring case either way.
+func matchName(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	return strings.Contains(name, pattern)
+}
+
+// Recent returns up to limit recently modified files, newest first.
+func (c *Client) Recent(ctx context.Context, limit int) ([]FileInfo, error) {
+	endpoint := c.endpoint("/recent")
+	if limit > 0 {
+		endpoint += "?limit=" + strconv.Itoa(limit)
+	}
+	return c.listEndpoint(ctx, endpoint, "recent")
+}
+
+func (c *Client) listEndpoint(ctx context.Context, endpoint, op string) ([]FileInfo, error) {
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(op, resp)
+	}
+
+	var listResp ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, err
+	}
+
+	return c.plainInfo("", c.plainNames("", listResp.Files)), nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ShareLinkOptions controls a public link created by CreateShareLink.
+type ShareLinkOptions struct {
+	// Expires is how long the link stays valid; zero means no expiry.
+	Expires time.Duration
+	// Password protects the link when set.
+	Password string
+}
+
+// ShareLink is a public link to a file or folder.
+type ShareLink struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Protected bool       `json:"password_protected"`
+}
+
+type shareLinkRequest struct {
+	ExpiresIn int64  `json:"expires_in,omitempty"` // seconds
+	Password  string `json:"password,omitempty"`
+}
+
+// CreateShareLink creates a public link to filePath. It fails with
+// ErrShareNotAllowed without a request if the token lacks the share scope.
+func (c *Client) CreateShareLink(ctx context.Context, filePath string, opts ShareLinkOptions) (*ShareLink, error) {
+	caps, err := c.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Share {
+		return nil, ErrShareNotAllowed
+	}
+
+	endpoint := c.endpoint("/files/%s/share", url.QueryEscape(c.remotePath(filePath)))
+
+	data, err := json.Marshal(shareLinkRequest{
+		ExpiresIn: int64(opts.Expires / time.Second),
+		Password:  opts.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newStatusError("share", resp)
+	}
+
+	var link ShareLink
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ErrSharingUnsupported is returned by Sharing when the server does not
+// tell who a file is shared with.
+var ErrSharingUnsupported = errors.New("sharing information not supported by server")
+
+// Grant is access to a file or folder given to a user or group.
+type Grant struct {
+	Principal string `json:"principal"` // user or group, e.g. "alice@example.com"
+	Role      string `json:"role"`      // e.g. "viewer" or "editor"
+}
+
+// Sharing is who can access a file or folder besides its owner.
+type Sharing struct {
+	Owner      string      `json:"owner,omitempty"`
+	SharedWith []Grant     `json:"shared_with"`
+	Links      []ShareLink `json:"links"` // public links, whether created here or elsewhere
+}
+
+// Shared reports whether anyone but the owner can access the file.
+func (s *Sharing) Shared() bool {
+	return len(s.SharedWith) > 0 || len(s.Links) > 0
+}
+
+// Sharing returns who filePath is shared with and the public links to it.
+func (c *Client) Sharing(ctx context.Context, filePath string) (*Sharing, error) {
+	if c.sharingUnsupported.Load() {
+		return nil, ErrSharingUnsupported
+	}
+
+	endpoint := c.endpoint("/files/%s/sharing", url.QueryEscape(c.remotePath(filePath)))
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The file exists, so a 404 means the endpoint does not.
+	if unsupportedStatus(resp.StatusCode) {
+		c.sharingUnsupported.Store(true)
+		return nil, ErrSharingUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("sharing", resp)
+	}
+
+	var sharing Sharing
+	if err := json.NewDecoder(resp.Body).Decode(&sharing); err != nil {
+		return nil, err
+	}
+	return &sharing, nil
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// TagsHeader carries the accounting tags of api.tags on every request, as
+// a query string such as "host=build-07&purpose=nightly&team=data", so
+// the server can attribute usage to them.
+const TagsHeader = "X-Koneksi-Client-Tags"
+
+// tagger sets the tags header on the requests passed through it.
+type tagger struct {
+	base  http.RoundTripper
+	value string
+}
+
+// newTagger returns base sending tags with every request, or base itself
+// without tags.
+func newTagger(base http.RoundTripper, tags map[string]string) http.RoundTripper {
+	if len(tags) == 0 {
+		return base
+	}
+	values := make(url.Values, len(tags))
+	for name, value := range tags {
+		values.Set(name, value)
+	}
+	return &tagger{base: base, value: values.Encode()}
+}
+
+func (t *tagger) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not change the request it is given.
+	req = req.Clone(req.Context())
+	req.Header.Set(TagsHeader, t.value)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ErrNoThumbnail is returned by Thumbnail when the server cannot render a
+// preview for the file, e.g. because it is not an image or video.
+var ErrNoThumbnail = errors.New("no thumbnail available")
+
+// maxThumbnailSize bounds how much of a thumbnail response is read, so a
+// misbehaving server cannot make a preview request load a whole file.
+const maxThumbnailSize = 1 << 20
+
+// Thumbnail returns a preview image of filePath no larger than size
+// pixels on its longest side, and the image's content type.
+func (c *Client) Thumbnail(ctx context.Context, filePath string, size int) ([]byte, string, error) {
+	if c.Encrypted(filePath) {
+		// The server cannot see the image.
+		return nil, "", ErrNoThumbnail
+	}
+
+	endpoint := c.endpoint("/files/%s/thumbnail?size=%d", url.QueryEscape(c.remotePath(filePath)), size)
+
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnsupportedMediaType, http.StatusNoContent:
+		return nil, "", ErrNoThumbnail
+	default:
+		return nil, "", newStatusError("thumbnail", resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, "", fmt.Errorf("thumbnail failed: response exceeds %d bytes", maxThumbnailSize)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Trace logs every request about a remote path for which match returns
+// true to log, with its outcome and duration.
+func (c *Client) Trace(match func(remotePath string) bool, log *slog.Logger) {
+	c.tracer.trace.Store(&traceFilter{match: match, log: log})
+}
+
+// tracer wraps the HTTP transport of a client to log the requests about
+// traced paths.
+type tracer struct {
+	base  http.RoundTripper
+	trace atomic.Pointer[traceFilter] // nil unless tracing
+}
+
+type traceFilter struct {
+	match func(string) bool
+	log   *slog.Logger
+}
+
+func newTracer(base http.RoundTripper) *tracer {
+	return &tracer{base: base}
+}
+
+func (t *tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := t.trace.Load()
+	if f == nil {
+		return t.base.RoundTrip(req)
+	}
+	p, ok := requestPath(req.URL)
+	if !ok || !f.match(p) {
+		return t.base.RoundTrip(req)
+	}
+
+	attrs := []any{"op", operation(req), "method", req.Method, "path", p}
+	if r := req.Header.Get("Range"); r != "" {
+		attrs = append(attrs, "range", r)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	attrs = append(attrs, "duration", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		f.log.Debug("api call failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+	attrs = append(attrs, "status", resp.StatusCode)
+	if resp.ContentLength >= 0 {
+		attrs = append(attrs, "length", resp.ContentLength)
+	}
+	f.log.Debug("api call", attrs...)
+	return resp, nil
+}
+
+// requestPath returns the remote path a request is about: the file of
+// "/files/{path}" endpoints or the directory listed.
+func requestPath(u *url.URL) (string, bool) {
+	if p := u.Query().Get("path"); p != "" {
+		return p, true
+	}
+	_, rest, ok := strings.Cut(u.EscapedPath(), "/files/")
+	if !ok {
+		return "", false
+	}
+	segment, _, _ := strings.Cut(rest, "/")
+	p, err := url.QueryUnescape(segment)
+	if err != nil {
+		return "", false
+	}
+	return p, true
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"golang.org/x/net/http2"
+)
+
+// tlsSessionCacheSize is how many TLS sessions are kept for resuming, one
+// per server name and more than a client ever talks to.
+const tlsSessionCacheSize = 32
+
+// newTransport builds the HTTP transport of a client. HTTP/2 is negotiated
+// with TLS servers unless disabled; idle HTTP/2 connections are pinged so
+// that a connection that died silently, e.g. after a network change, is
+// detected instead of stalling requests until they time out. Server
+// addresses are resolved by a resolver that follows DNS changes. TLS
+// sessions are kept, so connections made after the first resume them
+// instead of going through a full handshake.
+func newTransport(cfg *config.APIConfig) (http.RoundTripper, error) {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: newResolver(net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}, cfg.DNSMinTTL, cfg.DNSMaxTTL, cfg.IPFamily, cfg.DualStackDelay).DialContext,
+		TLSClientConfig: &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+		},
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	var rt http.RoundTripper = t
+	if cfg.HTTP2 {
+		h2, err := http2.ConfigureTransports(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		h2.ReadIdleTimeout = cfg.PingInterval
+		h2.PingTimeout = cfg.PingTimeout
+		// With a stream limit, requests queue on the existing connection
+		// instead of opening more when the server's limit is reached.
+		h2.StrictMaxConcurrentStreams = cfg.MaxConcurrentStreams > 0
+	} else {
+		// A non-nil empty map keeps the transport from negotiating HTTP/2,
+		// for proxies that mishandle it.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if cfg.MaxConcurrentStreams > 0 {
+		rt = newLimiter(rt, cfg.MaxConcurrentStreams)
+	}
+	return rt, nil
+}
+
+// limiter caps the number of requests in flight. A request counts until
+// its response body is closed or read to the end, like an HTTP/2 stream.
+type limiter struct {
+	base  http.RoundTripper
+	slots chan struct{}
+}
+
+func newLimiter(base http.RoundTripper, n int) *limiter {
+	return &limiter{base: base, slots: make(chan struct{}, n)}
+}
+
+func (l *limiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	resp, err := l.base.RoundTrip(req)
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-l.slots }}
+	return resp, nil
+}
+
+// releasingBody frees a limiter slot once, when the body is exhausted or
+// closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
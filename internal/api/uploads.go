@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrUploadsUnsupported is returned when the server does not report
+// unfinished chunked uploads.
+var ErrUploadsUnsupported = errors.New("server does not report unfinished uploads")
+
+// UploadSession is a chunked upload the server keeps open because its
+// manifest was never written, e.g. when the uploading client died.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"` // bytes received so far
+	StartedAt time.Time `json:"started_at"`
+}
+
+// UploadSessions returns the unfinished chunked uploads of the directory.
+func (c *Client) UploadSessions(ctx context.Context) ([]UploadSession, error) {
+	resp, err := c.doRequest(ctx, "GET", c.endpoint("/uploads"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if unsupportedStatus(resp.StatusCode) {
+		return nil, ErrUploadsUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("list uploads", resp)
+	}
+
+	var result struct {
+		Uploads []UploadSession `json:"uploads"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Uploads, nil
+}
+
+// AbortUpload discards an unfinished chunked upload and the chunks only
+// it refers to.
+func (c *Client) AbortUpload(ctx context.Context, id string) error {
+	resp, err := c.doRequest(ctx, "DELETE", c.endpoint("/uploads/%s", url.PathEscape(id)), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrUploadsUnsupported
+	}
+	return newStatusError("abort upload", resp)
+}
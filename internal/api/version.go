@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SupportedVersions lists the API versions this client speaks, newest
+// first.
+var SupportedVersions = []string{"v2", "v1"}
+
+// apiVersion is what differs between the versions of the API the client
+// speaks.
+type apiVersion interface {
+	// name returns the version as the server calls it.
+	name() string
+	// endpoint returns the path of resource of the directory directoryID.
+	endpoint(directoryID, resource string) string
+}
+
+// apiVersions holds the implementation of each of SupportedVersions.
+var apiVersions = map[string]apiVersion{
+	"v1": v1API{},
+	"v2": v2API{},
+}
+
+// v1API is the first version of the API.
+type v1API struct{}
+
+func (v1API) name() string { return "v1" }
+
+func (v1API) endpoint(directoryID, resource string) string {
+	return "/api/v1/directories/" + directoryID + resource
+}
+
+// v2API is the current version of the API, which has the same resources
+// as v1 under its own prefix.
+type v2API struct{}
+
+func (v2API) name() string { return "v2" }
+
+func (v2API) endpoint(directoryID, resource string) string {
+	return "/api/v2/directories/" + directoryID + resource
+}
+
+// fallbackVersion is used with deployments that cannot report their
+// versions, which predate the versions endpoint, and while the version is
+// not detected yet.
+const fallbackVersion = "v1"
+
+type versionsResponse struct {
+	Versions []string `json:"versions"`
+}
+
+// APIVersion returns the API version requests are sent to: the configured
+// one, or else the newest version supported by both sides, detected on
+// first use.
+func (c *Client) APIVersion() string {
+	return c.apiVersion().name()
+}
+
+// apiVersion returns the implementation of the API version in use,
+// detecting it if needed. Requests made while a detection is in flight,
+// or for a while after one failed, use fallbackVersion instead of waiting
+// for the server, which would hold up all requests while it is down.
+func (c *Client) apiVersion() apiVersion {
+	c.versionMu.Lock()
+	if c.version != nil {
+		defer c.versionMu.Unlock()
+		return c.version
+	}
+	if c.detecting || time.Now().Before(c.detectAfter) {
+		c.versionMu.Unlock()
+		return apiVersions[fallbackVersion]
+	}
+	c.detecting = true
+	c.versionMu.Unlock()
+
+	version, err := c.detectVersion()
+
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	c.detecting = false
+	if err != nil {
+		c.detectDelay = min(max(c.detectDelay*2, retryMinDelay), retryMaxDelay)
+		c.detectAfter = time.Now().Add(c.detectDelay)
+		slog.Debug("failed to detect API version", "error", err, "using", fallbackVersion, "retry_in", c.detectDelay)
+		return apiVersions[fallbackVersion]
+	}
+	c.version = apiVersions[version]
+	slog.Debug("using API version", "version", version)
+	return c.version
+}
+
+// detectVersion asks the server which API versions it offers.
+func (c *Client) detectVersion() (string, error) {
+	resp, err := c.httpClient.Get(strings.TrimSuffix(c.baseURL, "/") + "/api/versions")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fallbackVersion, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newStatusError("version detection", resp)
+	}
+
+	var versions versionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return "", fmt.Errorf("failed to parse API versions: %w", err)
+	}
+
+	for _, supported := range SupportedVersions {
+		for _, offered := range versions.Versions {
+			if offered == supported {
+				return supported, nil
+			}
+		}
+	}
+	slog.Warn("server offers no API version this client supports, trying anyway",
+		"offered", versions.Versions, "supported", SupportedVersions, "using", fallbackVersion)
+	return fallbackVersion, nil
+}
+
+// endpoint returns the path of a resource of the client's directory,
+// formatted like fmt.Sprintf, under the prefix of the API version in use.
+func (c *Client) endpoint(format string, args ...any) string {
+	return c.apiVersion().endpoint(c.directoryID, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"path"
+)
+
+// WalkFunc is called for every entry below the walk root. Returning
+// SkipDir from a directory skips its contents.
+type WalkFunc func(entryPath string, info FileInfo) error
+
+// SkipDir can be returned by a WalkFunc to skip a directory.
+var SkipDir = errors.New("skip this directory")
+
+// Walk lists root in b recursively, calling fn for each file and
+// directory in the order b lists them. Directories are visited before
+// their contents.
+func Walk(ctx context.Context, b StorageBackend, root string, fn WalkFunc) error {
+	files, err := b.List(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		entryPath := path.Join(root, file.Name)
+		if err := fn(entryPath, file); err != nil {
+			if errors.Is(err, SkipDir) {
+				continue
+			}
+			return err
+		}
+		if file.IsDir {
+			if err := Walk(ctx, b, entryPath, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
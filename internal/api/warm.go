@@ -0,0 +1,21 @@
+package api
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Warm readies the client for its first request, so that it goes out
+// right away: it authenticates and detects the API version unless
+// configured, leaving a connection to the server open and its TLS session
+// kept for resuming further ones. Errors are not returned but left for
+// the first request to run into again.
+func (c *Client) Warm() {
+	start := time.Now()
+	if _, err := c.ensureAuthenticated(); err != nil {
+		slog.Debug("failed to warm up API client", "error", err)
+		return
+	}
+	c.APIVersion()
+	slog.Debug("warmed up API client", "duration", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,91 @@
+// Package backend builds the storage mounts and sync work on from the backend
+// configured in api.backend and the layers of api.layers wrapped around
+// it, such as a content cache, encryption and bandwidth limits, each of
+// which is itself an api.StorageBackend.
+package backend
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// Open returns the storage of the directory directoryID with the
+// settings of cfg: the backend of api.backend wrapped in the layers of
+// api.layers. client is the Koneksi client the storage talks to the
+// server with, for what only the Koneksi API does, such as share links,
+// search, file locking and the scopes and role of the token; it is nil
+// with the local backend. The layers sit above the client and leave paths
+// as they are, so it addresses the files the storage holds.
+//
+// Content is encrypted with v by the crypt layer if there is one, and
+// otherwise by the Koneksi client, in the folders encryption.paths and
+// encryption.markers give. The local backend, having no client, gets a
+// crypt layer outermost instead.
+func Open(cfg *config.Config, directoryID string, v *vault.Vault) (storage api.StorageBackend, client *api.Client, err error) {
+	apiCfg := cfg.API
+	apiCfg.DirectoryID = directoryID
+
+	layers := apiCfg.Layers
+	if apiCfg.Backend == "local" {
+		if v != nil && !slices.Contains(layers, "crypt") {
+			layers = append([]string{"crypt"}, layers...)
+		}
+		if storage, err = api.NewLocalBackend(apiCfg.LocalRoot); err != nil {
+			return nil, nil, fmt.Errorf("api.local_root: %w", err)
+		}
+	} else {
+		if client, err = api.NewClient(&apiCfg); err != nil {
+			return nil, nil, err
+		}
+		if !slices.Contains(layers, "crypt") {
+			client.SetVault(v)
+		}
+		storage = client
+	}
+	if len(layers) == 0 {
+		return storage, client, nil
+	}
+
+	storage, err = chain(cfg, &apiCfg, layers, storage, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storage, client, nil
+}
+
+// chain returns b wrapped in layers, the first outermost. The operations
+// passed to each layer are counted, for the api.Layered it returns to
+// report.
+func chain(cfg *config.Config, apiCfg *config.APIConfig, layers []string, b api.StorageBackend, v *vault.Vault) (api.StorageBackend, error) {
+	top := newMetered(apiCfg.Backend, b, nil)
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+		switch layer {
+		case "cache":
+			// Apart from the cache of mounts, as what it holds depends
+			// on the layers below.
+			c, err := cache.New(&cfg.Cache, "layer:"+apiCfg.DirectoryID)
+			if err != nil {
+				return nil, err
+			}
+			b = Cache(top, c)
+		case "crypt":
+			if v == nil {
+				return nil, fmt.Errorf("the crypt layer needs encryption.enabled")
+			}
+			b = Crypt(top, v)
+		case "limit":
+			b = Limit(top, apiCfg.ReadLimit, apiCfg.WriteLimit)
+		default:
+			return nil, fmt.Errorf("unknown layer %q", layer)
+		}
+		top = newMetered(layer, b, top)
+	}
+	return top, nil
+}
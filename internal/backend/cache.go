@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/cache"
+)
+
+// cached keeps the content read from a backend in a content cache, and
+// reads it from there again for as long as the backend reports the same
+// size, modification time and hash.
+type cached struct {
+	b api.StorageBackend
+	c *cache.Cache
+}
+
+// Cache returns b with the content read from it kept in c. Content is
+// read whole into the cache before it is returned, so byte ranges are
+// then read from the cached copy.
+func Cache(b api.StorageBackend, c *cache.Cache) api.StorageBackend {
+	return &cached{b: b, c: c}
+}
+
+// Hits returns how many reads were served from the cache and how many
+// were not.
+func (l *cached) Hits() (hits, misses int64) {
+	return l.c.Hits()
+}
+
+func (l *cached) List(ctx context.Context, dirPath string) ([]api.FileInfo, error) {
+	return l.b.List(ctx, dirPath)
+}
+
+func (l *cached) Stat(ctx context.Context, filePath string) (*api.FileInfo, error) {
+	return l.b.Stat(ctx, filePath)
+}
+
+func (l *cached) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	f, err := l.open(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadRange reads a byte range from the cached copy.
+func (l *cached) ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	f, err := l.open(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	n, err := f.ReadAt(data, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// open returns the cached copy of filePath, reading it into the cache
+// first unless it is there in the version the backend reports.
+func (l *cached) open(ctx context.Context, filePath string) (*os.File, error) {
+	info, err := l.b.Stat(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := l.c.Open(filePath, info.Size, info.Modified, info.Hash); ok {
+		return f, nil
+	}
+
+	content, err := l.b.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return l.c.Fill(filePath, info.Size, info.Modified, info.Hash, content)
+}
+
+func (l *cached) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	defer l.c.Remove(filePath)
+	return l.b.Write(ctx, filePath, contentType, data)
+}
+
+func (l *cached) Delete(ctx context.Context, filePath string) error {
+	defer l.c.Remove(filePath)
+	return l.b.Delete(ctx, filePath)
+}
+
+func (l *cached) Mkdir(ctx context.Context, dirPath string) error {
+	return l.b.Mkdir(ctx, dirPath)
+}
+
+func (l *cached) Move(ctx context.Context, srcPath, dstPath string) error {
+	defer l.c.Remove(srcPath)
+	defer l.c.Remove(dstPath)
+	return l.b.Move(ctx, srcPath, dstPath)
+}
+
+func (l *cached) Copy(ctx context.Context, srcPath, dstPath string) error {
+	defer l.c.Remove(dstPath)
+	return l.b.Copy(ctx, srcPath, dstPath)
+}
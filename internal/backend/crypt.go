@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// crypted encrypts the content, and with encryption.filenames the names,
+// of every file it stores in a backend.
+type crypted struct {
+	b     api.StorageBackend
+	v     *vault.Vault
+	names *vault.Names // nil if names are stored as they are
+}
+
+// Crypt returns b with the files stored in it encrypted with v. Unlike
+// the encryption of a client, it encrypts every file, whatever
+// encryption.paths and encryption.markers say.
+func Crypt(b api.StorageBackend, v *vault.Vault) api.StorageBackend {
+	return &crypted{b: b, v: v, names: v.Names()}
+}
+
+// stored returns the path filePath is stored at.
+func (l *crypted) stored(filePath string) string {
+	if l.names == nil {
+		return filePath
+	}
+	return l.names.EncryptPath(filePath)
+}
+
+// plain returns the metadata of the stored file f in the folder dirPath
+// as it is before encryption.
+func (l *crypted) plain(dirPath string, f api.FileInfo) (api.FileInfo, error) {
+	if l.names != nil {
+		name, err := l.names.Decrypt(f.Name)
+		if err != nil {
+			return f, err
+		}
+		f.Name = name
+		f.Path = path.Join(dirPath, name)
+	}
+	l.plainSize(&f)
+	return f, nil
+}
+
+// plainSize sets the size of the stored file f to that of its content
+// before encryption. The hash of the stored content says nothing about it.
+func (l *crypted) plainSize(f *api.FileInfo) {
+	if !f.IsDir {
+		f.Size = l.v.PlainSize(f.Size)
+	}
+	f.Hash = ""
+}
+
+func (l *crypted) List(ctx context.Context, dirPath string) ([]api.FileInfo, error) {
+	files, err := l.b.List(ctx, l.stored(dirPath))
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]api.FileInfo, 0, len(files))
+	for _, f := range files {
+		p, err := l.plain(dirPath, f)
+		if err != nil {
+			slog.Debug("skipping file with a name that cannot be decrypted", "dir", dirPath, "name", f.Name, "error", err)
+			continue
+		}
+		plain = append(plain, p)
+	}
+	return plain, nil
+}
+
+func (l *crypted) Stat(ctx context.Context, filePath string) (*api.FileInfo, error) {
+	info, err := l.b.Stat(ctx, l.stored(filePath))
+	if err != nil {
+		return nil, err
+	}
+	l.plainSize(info)
+	if l.names != nil {
+		info.Name = path.Base(filePath)
+		info.Path = filePath
+	}
+	return info, nil
+}
+
+func (l *crypted) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	content, err := l.b.Read(ctx, l.stored(filePath))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := l.v.Decrypt(content)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, content}, nil
+}
+
+func (l *crypted) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	encrypted := l.v.Encrypt(data)
+	defer encrypted.Close()
+	// The content type would tell what the file holds.
+	return l.b.Write(ctx, l.stored(filePath), "", encrypted)
+}
+
+func (l *crypted) Delete(ctx context.Context, filePath string) error {
+	return l.b.Delete(ctx, l.stored(filePath))
+}
+
+func (l *crypted) Mkdir(ctx context.Context, dirPath string) error {
+	return l.b.Mkdir(ctx, l.stored(dirPath))
+}
+
+func (l *crypted) Move(ctx context.Context, srcPath, dstPath string) error {
+	return l.b.Move(ctx, l.stored(srcPath), l.stored(dstPath))
+}
+
+func (l *crypted) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return l.b.Copy(ctx, l.stored(srcPath), l.stored(dstPath))
+}
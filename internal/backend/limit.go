@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// limitChunk is the most read or written at once through a limit, so
+// transfers are paced evenly rather than in bursts.
+const limitChunk = 32 << 10
+
+// limiter paces transfers sharing it to a number of bytes per second.
+// The rate can be changed while transfers go on; 0 is no limit.
+type limiter struct {
+	rate atomic.Int64
+
+	mu   sync.Mutex
+	next time.Time // when the bytes transferred so far are due
+}
+
+func newLimiter(rate int64) *limiter {
+	l := &limiter{}
+	l.setRate(rate)
+	return l
+}
+
+// setRate changes the rate, starting afresh so what was due at the old
+// rate does not hold up transfers at the new one.
+func (l *limiter) setRate(rate int64) {
+	l.mu.Lock()
+	l.rate.Store(max(rate, 0))
+	l.next = time.Time{}
+	l.mu.Unlock()
+}
+
+// wait blocks until n more bytes may have been transferred.
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	rate := l.rate.Load()
+	if rate == 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+// limited limits the bandwidth of the content read from and written to a
+// backend, over all transfers at once.
+type limited struct {
+	b           api.StorageBackend
+	read, write *limiter
+}
+
+// Limit returns b with reads limited to read and writes to write bytes
+// per second, either 0 for no limit.
+func Limit(b api.StorageBackend, read, write int64) api.StorageBackend {
+	return &limited{b: b, read: newLimiter(read), write: newLimiter(write)}
+}
+
+func (l *limited) List(ctx context.Context, dirPath string) ([]api.FileInfo, error) {
+	return l.b.List(ctx, dirPath)
+}
+
+func (l *limited) Stat(ctx context.Context, filePath string) (*api.FileInfo, error) {
+	return l.b.Stat(ctx, filePath)
+}
+
+func (l *limited) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	content, err := l.b.Read(ctx, filePath)
+	if err != nil {
+		return content, err
+	}
+	r := &limitedReader{r: content, l: l.read}
+	// Kept seekable, for byte ranges to be read by seeking.
+	if seeker, ok := content.(io.Seeker); ok {
+		return struct {
+			io.Reader
+			io.Seeker
+			io.Closer
+		}{r, seeker, content}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, content}, nil
+}
+
+// ReadRange reads a byte range with the backend's ReadRange, if it has
+// one.
+func (l *limited) ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	rr, ok := l.b.(api.RangeReader)
+	if !ok {
+		return nil, api.ErrRangeUnsupported
+	}
+	data, err := rr.ReadRange(ctx, filePath, offset, length)
+	l.read.wait(len(data))
+	return data, err
+}
+
+func (l *limited) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	return l.b.Write(ctx, filePath, contentType, &limitedReader{r: data, l: l.write})
+}
+
+func (l *limited) Delete(ctx context.Context, filePath string) error {
+	return l.b.Delete(ctx, filePath)
+}
+
+func (l *limited) Mkdir(ctx context.Context, dirPath string) error {
+	return l.b.Mkdir(ctx, dirPath)
+}
+
+func (l *limited) Move(ctx context.Context, srcPath, dstPath string) error {
+	return l.b.Move(ctx, srcPath, dstPath)
+}
+
+func (l *limited) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return l.b.Copy(ctx, srcPath, dstPath)
+}
+
+// Limits returns the bytes per second read and written, 0 for no limit.
+func (l *limited) Limits() (read, write int64) {
+	return l.read.rate.Load(), l.write.rate.Load()
+}
+
+// SetLimits changes the limits of the transfers in progress and those
+// to come.
+func (l *limited) SetLimits(read, write int64) {
+	l.read.setRate(read)
+	l.write.setRate(write)
+}
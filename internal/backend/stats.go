@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// metered counts the operations passed to a layer, or to the backend
+// at the bottom of a chain, for api.LayerStats.
+type metered struct {
+	name  string
+	b     api.StorageBackend
+	below *metered // the next layer down; nil at the bottom
+
+	read    atomic.Int64
+	written atomic.Int64
+
+	mu      sync.Mutex
+	calls   map[string]int64
+	errors  map[string]int64
+	latency map[string][]int64
+}
+
+func newMetered(name string, b api.StorageBackend, below *metered) *metered {
+	return &metered{
+		name:    name,
+		b:       b,
+		below:   below,
+		calls:   make(map[string]int64),
+		errors:  make(map[string]int64),
+		latency: make(map[string][]int64),
+	}
+}
+
+// Layers returns the statistics of this layer and those below it.
+func (m *metered) Layers() []api.LayerStats {
+	var layers []api.LayerStats
+	for ; m != nil; m = m.below {
+		layers = append(layers, m.snapshot())
+	}
+	return layers
+}
+
+// Limits returns the limits of the limit layer at or below this one.
+func (m *metered) Limits() (read, write int64, ok bool) {
+	if l := m.limited(); l != nil {
+		read, write = l.Limits()
+		return read, write, true
+	}
+	return 0, 0, false
+}
+
+// SetLimits changes the limits of the limit layer at or below this one.
+func (m *metered) SetLimits(read, write int64) bool {
+	l := m.limited()
+	if l != nil {
+		l.SetLimits(read, write)
+	}
+	return l != nil
+}
+
+func (m *metered) limited() *limited {
+	for ; m != nil; m = m.below {
+		if l, ok := m.b.(*limited); ok {
+			return l
+		}
+	}
+	return nil
+}
+
+func (m *metered) snapshot() api.LayerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := api.LayerStats{
+		Name:    m.name,
+		Calls:   make(map[string]int64, len(m.calls)),
+		Errors:  make(map[string]int64, len(m.errors)),
+		Latency: make(map[string][]int64, len(m.latency)),
+		Read:    m.read.Load(),
+		Written: m.written.Load(),
+	}
+	for op, n := range m.calls {
+		s.Calls[op] = n
+	}
+	for class, n := range m.errors {
+		s.Errors[class] = n
+	}
+	for op, buckets := range m.latency {
+		s.Latency[op] = append([]int64(nil), buckets...)
+	}
+	if h, ok := m.b.(interface{ Hits() (int64, int64) }); ok {
+		s.CacheHits, s.CacheMisses = h.Hits()
+	}
+	return s
+}
+
+// start counts a call of op and returns the function to call with its
+// outcome once it is done.
+func (m *metered) start(op string) func(error) {
+	m.mu.Lock()
+	m.calls[op]++
+	m.mu.Unlock()
+	begin := time.Now()
+
+	return func(err error) {
+		took := time.Since(begin)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if err != nil {
+			m.errors[errorClass(err)]++
+			return
+		}
+		buckets := m.latency[op]
+		if buckets == nil {
+			buckets = make([]int64, len(api.LatencyBuckets)+1)
+			m.latency[op] = buckets
+		}
+		i := 0
+		for i < len(api.LatencyBuckets) && took > api.LatencyBuckets[i] {
+			i++
+		}
+		buckets[i]++
+	}
+}
+
+// errorClass returns the class of a failed call, named as the classes of
+// failed requests in api.Traffic.
+func errorClass(err error) string {
+	var netErr net.Error
+	var statusErr *api.StatusError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, api.ErrUnauthorized), errors.Is(err, fs.ErrPermission):
+		return "auth"
+	case errors.Is(err, api.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return "not-found"
+	case errors.Is(err, api.ErrConflict), errors.Is(err, fs.ErrExist):
+		return "conflict"
+	case errors.Is(err, api.ErrRateLimited):
+		return "rate-limited"
+	case errors.As(err, &statusErr) && statusErr.StatusCode < 500:
+		return "client"
+	case errors.As(err, &statusErr):
+		return "server"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+func (m *metered) List(ctx context.Context, dirPath string) ([]api.FileInfo, error) {
+	done := m.start("list")
+	files, err := m.b.List(ctx, dirPath)
+	done(err)
+	return files, err
+}
+
+func (m *metered) Stat(ctx context.Context, filePath string) (*api.FileInfo, error) {
+	done := m.start("stat")
+	info, err := m.b.Stat(ctx, filePath)
+	done(notFoundIsFine(err))
+	return info, err
+}
+
+// notFoundIsFine drops the error of a stat of a path that does not exist,
+// which is how callers check that it does not.
+func notFoundIsFine(err error) error {
+	if errors.Is(err, api.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (m *metered) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	done := m.start("read")
+	content, err := m.b.Read(ctx, filePath)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	body := &meteredBody{ReadCloser: content, n: &m.read, done: done}
+	// Kept seekable, for byte ranges to be read by seeking.
+	if seeker, ok := content.(io.Seeker); ok {
+		return struct {
+			*meteredBody
+			io.Seeker
+		}{body, seeker}, nil
+	}
+	return body, nil
+}
+
+// ReadRange reads a byte range with the ReadRange of the layer, if it
+// has one.
+func (m *metered) ReadRange(ctx context.Context, filePath string, offset, length int64) ([]byte, error) {
+	rr, ok := m.b.(api.RangeReader)
+	if !ok {
+		return nil, api.ErrRangeUnsupported
+	}
+	done := m.start("read-range")
+	data, err := rr.ReadRange(ctx, filePath, offset, length)
+	if errors.Is(err, api.ErrRangeUnsupported) {
+		err = nil
+	}
+	done(err)
+	m.read.Add(int64(len(data)))
+	return data, err
+}
+
+func (m *metered) Write(ctx context.Context, filePath, contentType string, data io.Reader) error {
+	done := m.start("write")
+	err := m.b.Write(ctx, filePath, contentType, &countingReader{r: data, n: &m.written})
+	done(err)
+	return err
+}
+
+func (m *metered) Delete(ctx context.Context, filePath string) error {
+	done := m.start("delete")
+	err := m.b.Delete(ctx, filePath)
+	done(err)
+	return err
+}
+
+func (m *metered) Mkdir(ctx context.Context, dirPath string) error {
+	done := m.start("mkdir")
+	err := m.b.Mkdir(ctx, dirPath)
+	done(err)
+	return err
+}
+
+func (m *metered) Move(ctx context.Context, srcPath, dstPath string) error {
+	done := m.start("move")
+	err := m.b.Move(ctx, srcPath, dstPath)
+	done(err)
+	return err
+}
+
+func (m *metered) Copy(ctx context.Context, srcPath, dstPath string) error {
+	done := m.start("copy")
+	err := m.b.Copy(ctx, srcPath, dstPath)
+	if errors.Is(err, api.ErrCopyUnsupported) {
+		// Not a failure: the content is copied by reading it instead.
+		done(nil)
+	} else {
+		done(err)
+	}
+	return err
+}
+
+// meteredBody counts the content read through it, and finishes the read
+// it belongs to when closed.
+type meteredBody struct {
+	io.ReadCloser
+	n    *atomic.Int64
+	done func(error)
+	err  error // the first error reading, other than io.EOF
+	once sync.Once
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	b.once.Do(func() { b.done(b.err) })
+	return b.ReadCloser.Close()
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archives carry the cached files of a namespace from one cache directory
+// to another, e.g. to seed new machines with a warm cache. An archive is a
+// tar file holding, for each cached file, its record, "<key>.json",
+// followed by its content, "<key>", named as in the cache directory.
+// Content already stored under another name, because the server reports
+// the same hash for it, is a hard link to that entry. The listings kept of
+// the folders holding the files follow as "listings/<key>".
+
+// maxRecordSize bounds the records read from an archive.
+const maxRecordSize = 1 << 20
+
+// ArchiveStats counts what Export wrote or Import added.
+type ArchiveStats struct {
+	Files    int   // cached files
+	Bytes    int64 // their size, counting shared content once
+	Listings int   // kept folder listings
+	Skipped  int   // files Import left alone, as the cache holds them or a newer version
+}
+
+// Export writes the cached files of the namespace, and the listings kept
+// of the folders they are in, to w as a tar archive. Files replaced or
+// evicted by a mount while it runs are left out.
+func (c *Cache) Export(w io.Writer) (ArchiveStats, error) {
+	var stats ArchiveStats
+	tw := tar.NewWriter(w)
+
+	paths := c.Paths()
+
+	written := make(map[string]string) // archive name of content, by hash
+	dirs := make(map[string]bool)
+	for _, p := range paths {
+		ok, err := c.exportFile(tw, p, written, &stats)
+		if err != nil {
+			return stats, err
+		}
+		if !ok {
+			continue
+		}
+		for d := path.Dir(p); !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+			if d == "/" || d == "." {
+				break
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	for _, d := range sorted {
+		data, ok := c.Listing(d)
+		if !ok {
+			continue
+		}
+		hdr := &tar.Header{Name: listingsDir + "/" + c.key(d), Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return stats, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return stats, err
+		}
+		stats.Listings++
+	}
+
+	return stats, tw.Close()
+}
+
+// exportFile writes the record and content of the cached copy of
+// remotePath, unless it changes while being read. written maps the hashes
+// of content already written to its name in the archive.
+func (c *Cache) exportFile(tw *tar.Writer, remotePath string, written map[string]string, stats *ArchiveStats) (bool, error) {
+	name := c.key(remotePath)
+	file := filepath.Join(c.dir, name)
+	f, err := os.Open(file)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	// The record belongs to the opened content if, after reading it, the
+	// file is still the one opened: replacing a copy removes its record
+	// first and writes the new one last.
+	info, err := f.Stat()
+	if err != nil {
+		return false, nil
+	}
+	rec, err := readRecord(file)
+	if err != nil || rec.Namespace != c.namespace || rec.Path != remotePath || rec.Size != info.Size() {
+		return false, nil
+	}
+	if current, err := os.Stat(file); err != nil || !os.SameFile(info, current) {
+		return false, nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	hdr := &tar.Header{Name: name + recordSuffix, Mode: 0600, Size: int64(len(data)), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return false, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return false, err
+	}
+
+	stats.Files++
+	if first, ok := written[rec.Hash]; ok && c.blobFile(rec.Hash) != "" {
+		return true, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: first, Mode: 0600, ModTime: info.ModTime()})
+	}
+	hdr = &tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return false, err
+	}
+	if _, err := io.CopyN(tw, f, info.Size()); err != nil {
+		return false, err
+	}
+	if c.blobFile(rec.Hash) != "" {
+		written[rec.Hash] = name
+	}
+	stats.Bytes += info.Size()
+	return true, nil
+}
+
+// Import adds the cached files and listings of an archive written by
+// Export for the same namespace. Files the cache holds in the same or a
+// newer version, and listings it keeps, are left alone. Imported copies
+// are checked against the server on first use like any other.
+func (c *Cache) Import(r io.Reader) (ArchiveStats, error) {
+	var stats ArchiveStats
+	tr := tar.NewReader(r)
+
+	var pending *record
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		name := hdr.Name
+		switch {
+		case strings.HasPrefix(name, listingsDir+"/"):
+			key := strings.TrimPrefix(name, listingsDir+"/")
+			if !validKey(key) {
+				return stats, fmt.Errorf("invalid cache archive: unexpected entry %s", name)
+			}
+			added, err := c.importListing(key, tr)
+			if err != nil {
+				return stats, err
+			}
+			if added {
+				stats.Listings++
+			}
+
+		case strings.HasSuffix(name, recordSuffix):
+			var rec record
+			data, err := io.ReadAll(io.LimitReader(tr, maxRecordSize))
+			if err == nil {
+				err = json.Unmarshal(data, &rec)
+			}
+			if err != nil {
+				return stats, fmt.Errorf("invalid cache archive: %s: %w", name, err)
+			}
+			if rec.Namespace != c.namespace {
+				return stats, fmt.Errorf("the archive holds the cache of directory %s, not %s", rec.Namespace, c.namespace)
+			}
+			if name != c.key(rec.Path)+recordSuffix {
+				return stats, fmt.Errorf("invalid cache archive: record %s does not match %s", name, rec.Path)
+			}
+			pending = &rec
+
+		default:
+			if pending == nil || name != c.key(pending.Path) {
+				return stats, fmt.Errorf("invalid cache archive: unexpected entry %s", name)
+			}
+			rec := *pending
+			pending = nil
+			if c.holds(rec) {
+				stats.Skipped++
+				continue
+			}
+
+			if hdr.Typeflag == tar.TypeLink {
+				if c.importLinked(rec) || c.importCopy(rec, hdr.Linkname) {
+					stats.Files++
+				} else {
+					stats.Skipped++
+				}
+				continue
+			}
+
+			n, err := c.importFile(rec, tr)
+			if err != nil {
+				return stats, err
+			}
+			stats.Files++
+			stats.Bytes += n
+		}
+	}
+	return stats, nil
+}
+
+// holds reports whether the cache holds the version of rec or a newer one.
+func (c *Cache) holds(rec record) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e *entry
+	var ok bool
+	if c.ownDir {
+		e, ok = c.entries[rec.Path]
+	} else {
+		c.withDirLock(func() error {
+			e, ok = c.reloadLocked(rec.Path)
+			return nil
+		})
+	}
+	return ok && !rec.Modified.After(e.modified)
+}
+
+// importFile stores the content read from r as the copy of the version
+// of rec.
+func (c *Cache) importFile(rec record, r io.Reader) (int64, error) {
+	tmp, err := c.TempFile()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r, rec.Size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != rec.Size {
+		err = fmt.Errorf("invalid cache archive: %s has %d bytes, not %d", rec.Path, n, rec.Size)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	f, err := c.commit(rec.Path, tmp.Name(), n, rec.Modified, rec.Hash, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	return n, nil
+}
+
+// importLinked makes the copy of the version of rec a link to content
+// imported before, if the cache holds it.
+func (c *Cache) importLinked(rec record) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ok bool
+	c.withDirLock(func() error {
+		_, ok = c.linkBlobLocked(rec.Path, rec.Size, rec.Modified, rec.Hash)
+		return nil
+	})
+	return ok
+}
+
+// importCopy stores a copy of the content of the cached file name, the
+// entry imported before that rec links to, if that is still the content
+// rec has. It is used where hard links are not supported.
+func (c *Cache) importCopy(rec record, name string) bool {
+	if !validKey(name) {
+		return false
+	}
+	file := filepath.Join(c.dir, name)
+	if linked, err := readRecord(file); err != nil || linked.Hash != rec.Hash || linked.Size != rec.Size {
+		return false
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = c.importFile(rec, f)
+	return err == nil
+}
+
+// importListing stores the listing read from r under key, unless one is
+// kept already.
+func (c *Cache) importListing(key string, r io.Reader) (bool, error) {
+	file := filepath.Join(c.dir, listingsDir, key)
+	if _, err := os.Stat(file); err == nil {
+		return false, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return false, err
+	}
+	return true, writeAtomic(c.dir, file, data)
+}
+
+// validKey reports whether name is a key as returned by Cache.key.
+func validKey(name string) bool {
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == 64
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Content the server reports a hash for is also stored under that hash, as
+// a blob the cached copies of every path with that content are hard links
+// to. A path whose content is cached under another name, because the file
+// was renamed or copied by another client or is identical to one in
+// another folder or directory, is linked to the blob instead of being
+// downloaded again, and identical files take their space once.
+
+// blobPrefix starts the names of blobs, followed by the hash.
+const blobPrefix = "blob-"
+
+// blobFile returns the blob of content with the given hex SHA-256, or ""
+// if hash is not one.
+func (c *Cache) blobFile(hash string) string {
+	if len(hash) != 64 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return ""
+	}
+	return filepath.Join(c.dir, blobPrefix+strings.ToLower(hash))
+}
+
+// hasBlob reports whether content of size bytes with hash is cached.
+func (c *Cache) hasBlob(hash string, size int64) bool {
+	blob := c.blobFile(hash)
+	if blob == "" {
+		return false
+	}
+	info, err := os.Stat(blob)
+	return err == nil && info.Size() == size
+}
+
+// placeLocked moves the complete temporary file tmp of n bytes to file,
+// linked to the blob of hash, and returns how many bytes that adds to the
+// directory: none when the content was cached already. Callers hold c.mu
+// and the directory lock.
+func (c *Cache) placeLocked(file, tmp string, n int64, hash string) (int64, error) {
+	blob := c.blobFile(hash)
+	if blob != "" && c.hasBlob(hash, n) {
+		if err := os.Link(blob, file); err == nil {
+			os.Remove(tmp)
+			return 0, nil
+		}
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return 0, err
+	}
+	if blob != "" {
+		// Without hard link support the copy is simply not shared.
+		os.Link(file, blob)
+	}
+	return n, nil
+}
+
+// linkBlobLocked makes the cached copy of remotePath, as the remote version
+// of size bytes modified at modified, a link to the blob of hash, if that
+// content is cached. Callers hold c.mu and, in a shared directory, the
+// directory lock.
+func (c *Cache) linkBlobLocked(remotePath string, size int64, modified time.Time, hash string) (*entry, bool) {
+	if !c.hasBlob(hash, size) {
+		return nil, false
+	}
+
+	file := filepath.Join(c.dir, c.key(remotePath))
+	var freed int64
+	if prev, err := readRecord(file); err == nil {
+		freed = c.dropFileLocked(file, prev.Hash, prev.Size)
+	}
+	delete(c.entries, remotePath)
+	defer func() { c.adjustSizeLocked(-freed) }()
+
+	if err := os.Link(c.blobFile(hash), file); err != nil {
+		return nil, false
+	}
+	// The version is what the caller expects, not what the server
+	// confirmed, so the copy is not marked validated.
+	rec := record{
+		Namespace: c.namespace,
+		Path:      remotePath,
+		Size:      size,
+		Modified:  modified,
+		Hash:      hash,
+	}
+	if c.priority != nil {
+		rec.Priority = c.priority(remotePath)
+	}
+	if err := writeRecord(file, rec); err != nil {
+		os.Remove(file)
+		return nil, false
+	}
+
+	e := &entry{
+		file:     file,
+		size:     size,
+		modified: modified,
+		lastUsed: time.Now(),
+		priority: rec.Priority,
+		hash:     hash,
+	}
+	c.entries[remotePath] = e
+	return e, true
+}
+
+// dropFileLocked removes the cached file and its record, along with the
+// blob of hash once no other copy links to it, and returns how many bytes
+// that frees. Callers hold c.mu and, in a shared directory, the directory
+// lock.
+func (c *Cache) dropFileLocked(file, hash string, size int64) int64 {
+	blob := c.blobFile(hash)
+	var linked, last bool
+	if blob != "" {
+		info, err := os.Stat(file)
+		blobInfo, blobErr := os.Stat(blob)
+		if err == nil && blobErr == nil && os.SameFile(info, blobInfo) {
+			linked = true
+			last = links(blobInfo) <= 2
+		}
+	}
+
+	os.Remove(file)
+	os.Remove(file + recordSuffix)
+	if !linked {
+		return size
+	}
+	if last {
+		os.Remove(blob)
+		return size
+	}
+	return 0
+}
+
+// sameFile reports whether info describes the file name.
+func sameFile(info os.FileInfo, name string) bool {
+	other, err := os.Stat(name)
+	return err == nil && os.SameFile(info, other)
+}
+
+// links returns the number of hard links to a file.
+func links(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
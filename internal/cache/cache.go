@@ -0,0 +1,653 @@
+// Package cache keeps local copies of remote file content so reads are
+// served from disk and uploads can be diffed against the last known
+// remote version.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/chunker"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// Cache is an on-disk content cache keyed by remote path. Each entry
+// records the remote size and modification time its content corresponds
+// to, so stale copies are detected by comparing with fresh metadata.
+// Content the server reports a hash for is shared between the paths that
+// have it (see blobs.go).
+//
+// Next to each cached file an index record describes it, so a configured
+// cache directory stays warm across restarts and can be shared by several
+// mounts at once. Changes to the directory are serialized between
+// processes with a lock file.
+type Cache struct {
+	dir       string
+	ownDir    bool
+	namespace string
+	ttl       time.Duration
+	maxSize   int64
+	keepStale bool // keep outdated copies until replaced, to fall back on
+	lockFile  *os.File
+	priority  func(remotePath string) int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	size    int64 // in a shared directory, the usage of all namespaces as last seen
+	hits    int64 // Open calls served from the cache
+	misses  int64 // Open calls that found no usable copy
+}
+
+type entry struct {
+	file      string
+	size      int64
+	modified  time.Time
+	validated time.Time
+	lastUsed  time.Time
+	priority  int
+	hash      string // of the remote content, if the server reported it
+	chunks    []chunker.Chunk
+}
+
+// New creates a cache in cfg.Directory, or in a fresh temporary directory
+// that is removed by Close when none is configured. namespace separates
+// the entries of different remote directories sharing a cache directory.
+func New(cfg *config.CacheConfig, namespace string) (*Cache, error) {
+	dir := cfg.Directory
+	ownDir := false
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "koneksi-cache-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		dir = tmp
+		ownDir = true
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	lockFile, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache lock: %w", err)
+	}
+
+	c := &Cache{
+		dir:       dir,
+		ownDir:    ownDir,
+		namespace: namespace,
+		ttl:       cfg.TTL,
+		maxSize:   cfg.MaxSize,
+		keepStale: cfg.ServeStaleOnError,
+		lockFile:  lockFile,
+		entries:   make(map[string]*entry),
+	}
+
+	if !ownDir {
+		// Pick up what earlier runs and other mounts have cached.
+		c.mu.Lock()
+		err := c.withDirLock(func() error {
+			c.loadIndexLocked()
+			return nil
+		})
+		c.mu.Unlock()
+		if err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Open returns the cached content of remotePath if it matches the given
+// remote size and modification time. Otherwise, content with the given
+// hash cached for another path is used, if hash is not empty. The
+// returned file stays readable even if the entry is evicted while it is
+// open.
+func (c *Cache) Open(remotePath string, size int64, modified time.Time, hash string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e *entry
+	var f *os.File
+	open := func() error {
+		var ok bool
+		if c.ownDir {
+			e, ok = c.entries[remotePath]
+		} else {
+			// Another process sharing the directory may have cached,
+			// replaced or evicted the file since we last looked.
+			e, ok = c.reloadLocked(remotePath)
+		}
+		if !ok || e.size != size || !e.modified.Equal(modified) {
+			linked, ok := c.linkBlobLocked(remotePath, size, modified, hash)
+			if !ok {
+				return os.ErrNotExist
+			}
+			e = linked
+		}
+
+		var err error
+		f, err = os.Open(e.file)
+		return err
+	}
+
+	var err error
+	if c.ownDir {
+		err = open()
+	} else {
+		err = c.withDirLock(open)
+	}
+	if err != nil {
+		// A stale private copy is useless, unless kept to fall back on
+		// while the server cannot be reached. A shared one may be newer
+		// than what the caller knows, so it is left for a later Fill.
+		if c.ownDir && e != nil && !c.keepStale {
+			c.removeLocked(remotePath)
+		}
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	e.lastUsed = time.Now()
+	// The modification time of the data file tells other processes
+	// sharing the directory how recently the entry was used.
+	os.Chtimes(e.file, e.lastUsed, e.lastUsed)
+	return f, true
+}
+
+// OpenStale returns whatever content of remotePath is cached, with the
+// modification time of the remote version it is a copy of, however
+// outdated. It is a fallback for when the current content cannot be
+// fetched.
+func (c *Cache) OpenStale(remotePath string) (*os.File, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e *entry
+	var f *os.File
+	open := func() error {
+		var ok bool
+		if c.ownDir {
+			e, ok = c.entries[remotePath]
+		} else {
+			e, ok = c.reloadLocked(remotePath)
+		}
+		if !ok {
+			return os.ErrNotExist
+		}
+		var err error
+		f, err = os.Open(e.file)
+		return err
+	}
+
+	var err error
+	if c.ownDir {
+		err = open()
+	} else {
+		err = c.withDirLock(open)
+	}
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	e.lastUsed = time.Now()
+	return f, e.modified, true
+}
+
+// Cached reports whether Open would find a copy of remotePath matching
+// the given size and modification time, or content with hash, without
+// counting a hit or miss.
+func (c *Cache) Cached(remotePath string, size int64, modified time.Time, hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e *entry
+	var ok bool
+	if c.ownDir {
+		e, ok = c.entries[remotePath]
+	} else {
+		c.withDirLock(func() error {
+			e, ok = c.reloadLocked(remotePath)
+			return nil
+		})
+	}
+	if ok && e.size == size && e.modified.Equal(modified) {
+		return true
+	}
+	return c.hasBlob(hash, size)
+}
+
+// Fresh reports whether remotePath is cached and was validated against
+// the server less than the TTL ago.
+func (c *Cache) Fresh(remotePath string) bool {
+	return c.FreshWithin(remotePath, c.ttl)
+}
+
+// FreshWithin reports whether remotePath is cached and was validated
+// against the server less than maxAge ago.
+func (c *Cache) FreshWithin(remotePath string, maxAge time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[remotePath]
+	return ok && time.Since(e.validated) < maxAge
+}
+
+// Validated marks remotePath as confirmed up to date with the server.
+func (c *Cache) Validated(remotePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[remotePath]; ok {
+		e.validated = time.Now()
+	}
+}
+
+// Fill stores the content read from r as the cached copy of remotePath,
+// whose remote content has hash if not empty, and returns it opened for
+// reading.
+func (c *Cache) Fill(remotePath string, size int64, modified time.Time, hash string, r io.Reader) (*os.File, error) {
+	tmp, err := os.CreateTemp(c.dir, tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return c.commit(remotePath, tmp.Name(), n, modified, hash, time.Now())
+}
+
+// SetPriority sets fn to give the eviction priority of the files cached
+// from then on. Entries of lower priority are evicted first, the least
+// recently used of each priority first. Without it all entries have
+// priority 0.
+func (c *Cache) SetPriority(fn func(remotePath string) int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.priority = fn
+}
+
+// Dir returns the cache directory.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// TempFile creates a file in the cache directory for content that may
+// later become a cached copy through Adopt. It is removed on the next
+// start if left behind.
+func (c *Cache) TempFile() (*os.File, error) {
+	return os.CreateTemp(c.dir, tempPrefix+"*")
+}
+
+// Adopt makes the file name, created by TempFile and no longer written
+// to, the cached copy of remotePath, whose remote content has hash if not
+// empty, without copying it, and returns it opened for reading. On
+// failure the file is removed.
+func (c *Cache) Adopt(remotePath string, modified time.Time, hash, name string) (*os.File, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	return c.commit(remotePath, name, info.Size(), modified, hash, time.Now())
+}
+
+// commit moves the complete temporary file tmp of n bytes into place as
+// the cached copy of remotePath, last confirmed current at validated.
+func (c *Cache) commit(remotePath, tmp string, n int64, modified time.Time, hash string, validated time.Time) (*os.File, error) {
+	now := time.Now()
+	file := filepath.Join(c.dir, c.key(remotePath))
+	rec := record{
+		Namespace: c.namespace,
+		Path:      remotePath,
+		Size:      n,
+		Modified:  modified,
+		Validated: validated,
+		Hash:      hash,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.priority != nil {
+		rec.Priority = c.priority(remotePath)
+	}
+
+	err := c.withDirLock(func() error {
+		var replaced int64
+		if prev, err := readRecord(file); err == nil {
+			replaced = c.dropFileLocked(file, prev.Hash, prev.Size)
+		}
+		delete(c.entries, remotePath)
+		added, err := c.placeLocked(file, tmp, n, hash)
+		if err == nil {
+			if err = writeRecord(file, rec); err != nil {
+				replaced += c.dropFileLocked(file, hash, n)
+			}
+		}
+		c.adjustSizeLocked(added - replaced)
+		return err
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c.entries[remotePath] = &entry{
+		file:      file,
+		size:      n,
+		modified:  modified,
+		validated: validated,
+		lastUsed:  now,
+		priority:  rec.Priority,
+		hash:      hash,
+	}
+	c.evictLocked(remotePath)
+
+	return f, nil
+}
+
+// Chunks returns the chunk signature of the cached copy of remotePath,
+// computing it with ck on first use. It returns nil if nothing is cached.
+func (c *Cache) Chunks(remotePath string, ck chunker.Chunker) ([]chunker.Chunk, error) {
+	c.mu.Lock()
+	e, ok := c.entries[remotePath]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	if e.chunks != nil {
+		chunks := e.chunks
+		c.mu.Unlock()
+		return chunks, nil
+	}
+	file := e.file
+	c.mu.Unlock()
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	chunks, err := ck.Split(f)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetChunks(remotePath, chunks)
+	return chunks, nil
+}
+
+// SetChunks records a precomputed chunk signature for the cached copy of
+// remotePath.
+func (c *Cache) SetChunks(remotePath string, chunks []chunker.Chunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[remotePath]; ok {
+		e.chunks = chunks
+	}
+}
+
+// Paths returns the remote paths of the files cached for the namespace,
+// sorted.
+func (c *Cache) Paths() []string {
+	c.mu.Lock()
+	if !c.ownDir {
+		c.withDirLock(func() error {
+			c.loadIndexLocked()
+			return nil
+		})
+	}
+	paths := make([]string, 0, len(c.entries))
+	for p := range c.entries {
+		paths = append(paths, p)
+	}
+	c.mu.Unlock()
+	sort.Strings(paths)
+	return paths
+}
+
+// Remove drops the cached copy of remotePath.
+func (c *Cache) Remove(remotePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(remotePath)
+}
+
+// Discard drops the cached copy of remotePath, as Remove does, along with
+// the blob its content is shared through, so that content found corrupt
+// is not linked to again. Other paths linked to the blob keep their copy
+// until it is replaced.
+func (c *Cache) Discard(remotePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file := filepath.Join(c.dir, c.key(remotePath))
+	c.withDirLock(func() error {
+		rec, err := readRecord(file)
+		if err != nil {
+			return err
+		}
+		if blob := c.blobFile(rec.Hash); blob != "" {
+			os.Remove(blob)
+		}
+		return nil
+	})
+	c.removeLocked(remotePath)
+}
+
+// Rename moves the cached copies of from and, when it is a folder, of
+// everything below it to the same names below to, following a rename on
+// the server. Copies cached under the new names are replaced.
+func (c *Cache) Rename(from, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := strings.TrimSuffix(from, "/") + "/"
+	var moved []string
+	for p := range c.entries {
+		if p == from || strings.HasPrefix(p, prefix) {
+			moved = append(moved, p)
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	c.withDirLock(func() error {
+		for _, p := range moved {
+			c.renameLocked(p, to+strings.TrimPrefix(p, from))
+		}
+		return nil
+	})
+}
+
+// renameLocked moves the cached copy of from to to, dropping it if that
+// fails. Callers hold c.mu and the directory lock.
+func (c *Cache) renameLocked(from, to string) {
+	e := c.entries[from]
+	delete(c.entries, from)
+	file := filepath.Join(c.dir, c.key(to))
+
+	if prev, err := readRecord(file); err == nil {
+		c.adjustSizeLocked(-c.dropFileLocked(file, prev.Hash, prev.Size))
+	}
+	delete(c.entries, to)
+
+	rec, err := readRecord(e.file)
+	if err == nil {
+		rec.Path = to
+		if c.priority != nil {
+			rec.Priority = c.priority(to)
+		}
+		err = os.Rename(e.file, file)
+	}
+	if err == nil {
+		os.Remove(e.file + recordSuffix)
+		e.file = file
+		err = writeRecord(file, rec)
+	}
+	if err != nil {
+		c.adjustSizeLocked(-c.dropFileLocked(e.file, e.hash, e.size))
+		return
+	}
+
+	e.priority = rec.Priority
+	c.entries[to] = e
+}
+
+// adjustSizeLocked accounts for delta bytes added to the directory.
+// Callers hold c.mu and, in a shared directory, the directory lock.
+func (c *Cache) adjustSizeLocked(delta int64) {
+	if c.ownDir {
+		c.size += delta
+	} else {
+		c.size = c.addUsageLocked(delta)
+	}
+}
+
+// Stats returns the number of cached files of this cache and the size of
+// the cache directory.
+func (c *Cache) Stats() (entries int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.size
+}
+
+// Hits returns how many Open calls were served from the cache and how many
+// found no usable copy.
+func (c *Cache) Hits() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits, c.misses
+}
+
+// Close releases the cache, deleting its directory if it was temporary.
+func (c *Cache) Close() error {
+	c.lockFile.Close()
+	if c.ownDir {
+		return os.RemoveAll(c.dir)
+	}
+	return nil
+}
+
+func (c *Cache) removeLocked(remotePath string) {
+	e, ok := c.entries[remotePath]
+	if ok {
+		delete(c.entries, remotePath)
+	}
+
+	if c.ownDir {
+		if ok {
+			c.size -= c.dropFileLocked(e.file, e.hash, e.size)
+		}
+		return
+	}
+
+	// In a shared directory the file may have been cached by another
+	// process without this one knowing.
+	file := filepath.Join(c.dir, c.key(remotePath))
+	c.withDirLock(func() error {
+		rec, err := readRecord(file)
+		if err != nil {
+			return err
+		}
+		c.size = c.addUsageLocked(-c.dropFileLocked(file, rec.Hash, rec.Size))
+		return nil
+	})
+}
+
+// evictLocked drops entries of the lowest priority, least recently used
+// first, until the cache fits in maxSize, never evicting keep. In a shared directory the entries of all
+// processes count towards maxSize.
+func (c *Cache) evictLocked(keep string) {
+	if c.maxSize <= 0 || c.size <= c.maxSize {
+		return
+	}
+
+	if c.ownDir {
+		paths := make([]string, 0, len(c.entries))
+		for p := range c.entries {
+			if p != keep {
+				paths = append(paths, p)
+			}
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			a, b := c.entries[paths[i]], c.entries[paths[j]]
+			if a.priority != b.priority {
+				return a.priority < b.priority
+			}
+			return a.lastUsed.Before(b.lastUsed)
+		})
+
+		for _, p := range paths {
+			if c.size <= c.maxSize {
+				return
+			}
+			c.removeLocked(p)
+		}
+		return
+	}
+
+	c.withDirLock(func() error {
+		all, total := c.loadIndexLocked()
+		keepFile := filepath.Join(c.dir, c.key(keep))
+
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].rec.Priority != all[j].rec.Priority {
+				return all[i].rec.Priority < all[j].rec.Priority
+			}
+			return all[i].lastUsed.Before(all[j].lastUsed)
+		})
+		for _, d := range all {
+			if total <= c.maxSize {
+				break
+			}
+			if d.file == keepFile {
+				continue
+			}
+			total -= c.dropFileLocked(d.file, d.rec.Hash, d.rec.Size)
+			if d.rec.Namespace == c.namespace {
+				if e, ok := c.entries[d.rec.Path]; ok && e.file == d.file {
+					delete(c.entries, d.rec.Path)
+				}
+			}
+		}
+		c.size = total
+		c.setUsageLocked(total)
+		return nil
+	})
+}
+
+// key returns the file name of the cached copy of remotePath.
+func (c *Cache) key(remotePath string) string {
+	sum := sha256.Sum256([]byte(c.namespace + "\x00" + remotePath))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// lockName is the lock file serializing changes to the directory.
+	lockName = ".lock"
+	// tempPrefix marks files still being written.
+	tempPrefix = ".fill-"
+	// recordSuffix is appended to a cached file's name for its record.
+	recordSuffix = ".json"
+	// staleTempAge is the age after which a temporary file is assumed to
+	// be left over from an interrupted fill.
+	staleTempAge = time.Hour
+)
+
+// record is the index entry stored next to each cached file.
+type record struct {
+	Namespace string    `json:"namespace"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Validated time.Time `json:"validated"`
+	Priority  int       `json:"priority,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // of the remote content, if the server reported it
+}
+
+// diskEntry is a cached file found in the directory, possibly belonging
+// to another namespace.
+type diskEntry struct {
+	file     string
+	rec      record
+	lastUsed time.Time
+}
+
+// withDirLock runs fn while holding the directory lock, which keeps other
+// processes from changing the directory at the same time. Callers hold
+// c.mu, which does the same for goroutines of this process.
+func (c *Cache) withDirLock(fn func() error) error {
+	fd := int(c.lockFile.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	return fn()
+}
+
+// loadIndexLocked reads the records of every cached file in the directory,
+// rebuilds the entries of this namespace from them and removes files left
+// without a record, and blobs no copy links to any more. The cache size is
+// set to the total of all namespaces, since they share maxSize, counting
+// shared content once. It returns all entries and their total size.
+// Callers hold c.mu and the directory lock.
+func (c *Cache) loadIndexLocked() ([]diskEntry, int64) {
+	names, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, 0
+	}
+
+	var all []diskEntry
+	var total int64
+	entries := make(map[string]*entry)
+	shared := make(map[string]bool) // blobs counted
+
+	for _, de := range names {
+		name := de.Name()
+		if de.IsDir() || name == lockName {
+			continue
+		}
+		if strings.HasPrefix(name, tempPrefix) {
+			// Fills in progress are recent; old ones were interrupted.
+			if info, err := de.Info(); err == nil && time.Since(info.ModTime()) > staleTempAge {
+				os.Remove(filepath.Join(c.dir, name))
+			}
+			continue
+		}
+
+		file := filepath.Join(c.dir, name)
+		if strings.HasPrefix(name, blobPrefix) {
+			if info, err := de.Info(); err == nil && links(info) <= 1 {
+				os.Remove(file)
+			}
+			continue
+		}
+		if strings.HasSuffix(name, recordSuffix) {
+			if _, err := os.Stat(strings.TrimSuffix(file, recordSuffix)); os.IsNotExist(err) {
+				os.Remove(file)
+			}
+			continue
+		}
+
+		rec, err := readRecord(file)
+		if err != nil {
+			// Content without a record cannot be matched to a remote
+			// file, e.g. left behind by a crash or an older version.
+			os.Remove(file)
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		all = append(all, diskEntry{file: file, rec: rec, lastUsed: info.ModTime()})
+		if blob := c.blobFile(rec.Hash); blob == "" || !sameFile(info, blob) {
+			total += rec.Size
+		} else if !shared[blob] {
+			shared[blob] = true
+			total += rec.Size
+		}
+
+		if rec.Namespace != c.namespace {
+			continue
+		}
+		e := &entry{
+			file:      file,
+			size:      rec.Size,
+			modified:  rec.Modified,
+			validated: rec.Validated,
+			lastUsed:  info.ModTime(),
+			priority:  rec.Priority,
+			hash:      rec.Hash,
+		}
+		if old, ok := c.entries[rec.Path]; ok && old.file == file && old.modified.Equal(rec.Modified) {
+			e.validated = old.validated
+			e.chunks = old.chunks
+		}
+		entries[rec.Path] = e
+	}
+
+	c.entries = entries
+	c.size = total
+	c.setUsageLocked(total)
+	return all, total
+}
+
+// reloadLocked updates the entry of remotePath from its record, picking
+// up changes made by other processes sharing the directory. Callers hold
+// c.mu and the directory lock.
+func (c *Cache) reloadLocked(remotePath string) (*entry, bool) {
+	file := filepath.Join(c.dir, c.key(remotePath))
+	old, cached := c.entries[remotePath]
+
+	rec, err := readRecord(file)
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(file)
+	}
+	if err != nil || rec.Namespace != c.namespace || rec.Path != remotePath {
+		if cached {
+			delete(c.entries, remotePath)
+		}
+		return nil, false
+	}
+
+	if cached && old.size == rec.Size && old.modified.Equal(rec.Modified) {
+		return old, true
+	}
+
+	e := &entry{
+		file:      file,
+		size:      rec.Size,
+		modified:  rec.Modified,
+		validated: rec.Validated,
+		lastUsed:  info.ModTime(),
+		priority:  rec.Priority,
+		hash:      rec.Hash,
+	}
+	c.entries[remotePath] = e
+	return e, true
+}
+
+// The lock file holds the total size of the shared directory, so every
+// process sharing it can tell when maxSize is exceeded without scanning
+// the directory on each fill.
+
+// addUsageLocked adjusts the recorded directory size by delta and returns
+// the new size. Callers hold the directory lock.
+func (c *Cache) addUsageLocked(delta int64) int64 {
+	var buf [8]byte
+	var usage int64
+	if n, _ := c.lockFile.ReadAt(buf[:], 0); n == len(buf) {
+		usage = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+
+	usage += delta
+	if usage < 0 {
+		usage = 0
+	}
+	c.setUsageLocked(usage)
+	return usage
+}
+
+// setUsageLocked records the directory size. Callers hold the directory
+// lock.
+func (c *Cache) setUsageLocked(usage int64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(usage))
+	c.lockFile.WriteAt(buf[:], 0)
+}
+
+func readRecord(file string) (record, error) {
+	var rec record
+	data, err := os.ReadFile(file + recordSuffix)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// writeRecord stores the record of file, replacing any previous one
+// atomically so readers never see a partial record.
+func writeRecord(file string, rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Dir(file), file+recordSuffix, data)
+}
+
+// writeAtomic replaces file with data through a temporary file in dir,
+// where leftovers of interrupted writes are cleaned up.
+func writeAtomic(dir, file string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
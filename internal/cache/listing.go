@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// listingsDir is the subdirectory of the cache directory holding the
+// last known listings of remote directories.
+const listingsDir = "listings"
+
+// StoreListing records data, an encoded listing of the remote directory
+// dir, so it can be served when the server cannot be reached. Listings
+// are only kept in a configured cache directory, since a temporary one
+// does not outlive the mount.
+func (c *Cache) StoreListing(dir string, data []byte) error {
+	if c.ownDir {
+		return nil
+	}
+
+	file := c.listingFile(dir)
+	if old, err := os.ReadFile(file); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return writeAtomic(c.dir, file, data)
+}
+
+// Listing returns the listing last stored for dir by StoreListing.
+func (c *Cache) Listing(dir string) ([]byte, bool) {
+	data, err := os.ReadFile(c.listingFile(dir))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *Cache) listingFile(dir string) string {
+	return filepath.Join(c.dir, listingsDir, c.key(dir))
+}
@@ -0,0 +1,77 @@
+// Package chunker splits file content into hashed chunks for chunked and
+// delta uploads.
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// Chunk is a contiguous range of a file identified by its content hash.
+type Chunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Hash   string `json:"hash"` // hex SHA-256 of the chunk content
+}
+
+// Chunker splits a stream into chunks.
+type Chunker interface {
+	Split(r io.Reader) ([]Chunk, error)
+}
+
+// Fixed splits content into blocks of a fixed size. Only the last block
+// may be shorter.
+type Fixed struct {
+	Size int
+}
+
+func (f Fixed) Split(r io.Reader) ([]Chunk, error) {
+	buf := make([]byte, f.Size)
+	var (
+		chunks []Chunk
+		offset int64
+	)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunks = append(chunks, newChunk(offset, buf[:n]))
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newChunk(offset int64, data []byte) Chunk {
+	sum := sha256.Sum256(data)
+	return Chunk{
+		Offset: offset,
+		Size:   int64(len(data)),
+		Hash:   hex.EncodeToString(sum[:]),
+	}
+}
+
+// Changed returns the chunks of next whose content does not appear
+// anywhere in base, in order and without duplicates.
+func Changed(base, next []Chunk) []Chunk {
+	known := make(map[string]bool, len(base))
+	for _, c := range base {
+		known[c.Hash] = true
+	}
+
+	var changed []Chunk
+	for _, c := range next {
+		if known[c.Hash] {
+			continue
+		}
+		known[c.Hash] = true
+		changed = append(changed, c)
+	}
+	return changed
+}
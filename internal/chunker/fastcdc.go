@@ -0,0 +1,127 @@
+package chunker
+
+import (
+	"io"
+	"math/bits"
+)
+
+// FastCDC splits content at content-defined boundaries using the FastCDC
+// algorithm with normalized chunking. Boundaries depend only on nearby
+// bytes, so inserting or removing data shifts at most a couple of chunks
+// instead of every chunk after the edit.
+type FastCDC struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// NewFastCDC returns a FastCDC chunker targeting chunks of avg bytes,
+// bounded to [avg/4, avg*4].
+func NewFastCDC(avg int) FastCDC {
+	return FastCDC{
+		MinSize: avg / 4,
+		AvgSize: avg,
+		MaxSize: avg * 4,
+	}
+}
+
+func (f FastCDC) Split(r io.Reader) ([]Chunk, error) {
+	maskS, maskL := f.masks()
+
+	buf := make([]byte, 2*f.MaxSize)
+	var (
+		chunks []Chunk
+		offset int64
+		start  int
+		end    int
+		eof    bool
+	)
+
+	for {
+		// Keep at least MaxSize bytes buffered unless the input is done.
+		if !eof && end-start < f.MaxSize {
+			copy(buf, buf[start:end])
+			end -= start
+			start = 0
+
+			n, err := io.ReadFull(r, buf[end:])
+			end += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		if start == end {
+			return chunks, nil
+		}
+
+		n := f.cut(buf[start:end], maskS, maskL)
+		chunks = append(chunks, newChunk(offset, buf[start:start+n]))
+		offset += int64(n)
+		start += n
+	}
+}
+
+// cut returns the length of the next chunk at the start of data.
+func (f FastCDC) cut(data []byte, maskS, maskL uint64) int {
+	n := len(data)
+	if n <= f.MinSize {
+		return n
+	}
+	if n > f.MaxSize {
+		n = f.MaxSize
+	}
+	normal := f.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := f.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// masks returns the stricter mask used before the average size and the
+// looser one used after it. Masks use the high bits of the fingerprint,
+// which depend on the last 64 bytes rather than the last few.
+func (f FastCDC) masks() (uint64, uint64) {
+	b := bits.Len(uint(f.AvgSize)) - 1
+	return highBits(b + 1), highBits(b - 1)
+}
+
+func highBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// gear maps each byte to a pseudo-random value. It is generated from a
+// fixed seed so chunk boundaries are identical across runs and versions.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6b6f6e656b7369) // "koneksi"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
@@ -1,70 +1,733 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	API   APIConfig   `mapstructure:"api"`
-	Mount MountConfig `mapstructure:"mount"`
-	Cache CacheConfig `mapstructure:"cache"`
+	API    APIConfig    `mapstructure:"api"`
+	Mount  MountConfig  `mapstructure:"mount"`
+	Cache  CacheConfig  `mapstructure:"cache"`
+	Upload UploadConfig `mapstructure:"upload"`
+	Policy PolicyConfig `mapstructure:"policy"`
+	Sync   SyncConfig   `mapstructure:"sync"`
+
+	Encryption EncryptionConfig  `mapstructure:"encryption"`
+	Hooks      []HookConfig      `mapstructure:"hooks"`
+	Remotes    map[string]string `mapstructure:"remotes"` // other directories by name, for copy
+
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	Telemetry     TelemetryConfig     `mapstructure:"telemetry"`
 }
 
 type APIConfig struct {
-	BaseURL      string        `mapstructure:"base_url"`
-	ClientID     string        `mapstructure:"client_id"`
-	ClientSecret string        `mapstructure:"client_secret"`
-	DirectoryID  string        `mapstructure:"directory_id"`
-	Timeout      time.Duration `mapstructure:"timeout"`
-	RetryCount   int           `mapstructure:"retry_count"`
+	Backend      string            `mapstructure:"backend"`     // "koneksi", or "local" to keep files in local_root instead
+	LocalRoot    string            `mapstructure:"local_root"`  // folder files are kept in with backend "local"
+	Layers       []string          `mapstructure:"layers"`      // "cache", "crypt" and "limit" wrapped around the backend, outermost first
+	ReadLimit    int64             `mapstructure:"read_limit"`  // bytes per second read through the "limit" layer, 0 for no limit
+	WriteLimit   int64             `mapstructure:"write_limit"` // bytes per second written through it, 0 for no limit
+	Tags         map[string]string `mapstructure:"tags"`        // accounting tags sent with every request, e.g. team and purpose
+	BaseURL      string            `mapstructure:"base_url"`
+	ClientID     string            `mapstructure:"client_id"`
+	ClientSecret string            `mapstructure:"client_secret"`
+	DirectoryID  string            `mapstructure:"directory_id"`
+	Timeout      time.Duration     `mapstructure:"timeout"`
+	RetryCount   int               `mapstructure:"retry_count"` // times failed requests are sent again
+	Version      string            `mapstructure:"version"`     // "v1" or "v2"; empty to detect
+
+	HTTP2                bool          `mapstructure:"http2"`                   // negotiate HTTP/2; false forces HTTP/1.1
+	MaxConcurrentStreams int           `mapstructure:"max_concurrent_streams"`  // requests in flight at once, 0 for no limit
+	MaxIdleConnsPerHost  int           `mapstructure:"max_idle_conns_per_host"` // HTTP/1.1 connections kept for reuse
+	PingInterval         time.Duration `mapstructure:"ping_interval"`           // ping HTTP/2 connections idle this long, 0 to disable
+	PingTimeout          time.Duration `mapstructure:"ping_timeout"`            // close the connection if a ping gets no answer
+	BreakerThreshold     int           `mapstructure:"breaker_threshold"`       // failed requests in a row before failing requests right away, 0 to never
+	BreakerCooldown      time.Duration `mapstructure:"breaker_cooldown"`        // how long requests fail right away before one is tried again
+	HedgePercentile      float64       `mapstructure:"hedge_percentile"`        // send block reads slower than this percentile of recent ones again, 0 to never
+	HedgeMinDelay        time.Duration `mapstructure:"hedge_min_delay"`         // never send a read again sooner than this
+	DNSMinTTL            time.Duration `mapstructure:"dns_min_ttl"`             // keep resolved server addresses at least this long
+	DNSMaxTTL            time.Duration `mapstructure:"dns_max_ttl"`             // and at most this long, whatever the DNS answer says; 0 to resolve on every connection
+	IPFamily             string        `mapstructure:"ip_family"`               // "any", "ipv4" or "ipv6"
+	DualStackDelay       time.Duration `mapstructure:"dual_stack_delay"`        // head start of the first address family before the other is tried too, 0 to try addresses in turn
 }
 
 type MountConfig struct {
-	ReadOnly   bool   `mapstructure:"readonly"`
-	AllowOther bool   `mapstructure:"allow_other"`
-	UID        uint32 `mapstructure:"uid"`
-	GID        uint32 `mapstructure:"gid"`
-	Umask      uint32 `mapstructure:"umask"`
+	ReadOnly        bool          `mapstructure:"readonly"`
+	AllowOther      bool          `mapstructure:"allow_other"`
+	UID             uint32        `mapstructure:"uid"`
+	GID             uint32        `mapstructure:"gid"`
+	Umask           uint32        `mapstructure:"umask"`
+	RootMode        uint32        `mapstructure:"root_mode"`  // permissions of the mountpoint's root folder, e.g. 0750; 0 for 0755 like other folders
+	RootOwner       string        `mapstructure:"root_owner"` // owner of the root folder as user[:group], names or IDs; empty for uid and gid
+	RootUID         uint32        `mapstructure:"-"`          // parsed from RootOwner
+	RootGID         uint32        `mapstructure:"-"`
+	NonEmpty        bool          `mapstructure:"nonempty"`     // mount over a mountpoint that has files, hiding them while mounted
+	AutoUnmount     bool          `mapstructure:"auto_unmount"` // unmount from a watchdog process if the mount process dies
+	Leases          bool          `mapstructure:"leases"`
+	LeaseTTL        time.Duration `mapstructure:"lease_ttl"`
+	LeaseMode       string        `mapstructure:"lease_mode"` // mandatory: fail conflicting opens; advisory: warn and open anyway
+	LeaseWait       time.Duration `mapstructure:"lease_wait"` // how long a conflicting open waits for the lease to be released
+	VirtualDir      string        `mapstructure:"virtual_dir"`
+	RemotePath      string        `mapstructure:"remote_path"`      // remote folder shown as the root of the mount, e.g. /projects/2024/q3
+	RawMap          []string      `mapstructure:"map"`              // name=/remote/path entries, each shown as a folder at the root instead
+	Binds           []Bind        `mapstructure:"-"`                // parsed from RawMap
+	DegradeAfter    int           `mapstructure:"degrade_after"`    // denied writes before switching to read-only, 0 to never
+	ProbeInterval   time.Duration `mapstructure:"probe_interval"`   // how often a read-only mount checks whether writes work again
+	WriteBuffer     int64         `mapstructure:"write_buffer"`     // bytes of a file being written kept in memory before staging it on disk
+	StagingDir      string        `mapstructure:"staging_dir"`      // where larger files are staged; empty for the cache or temp directory
+	StagingMinFree  int64         `mapstructure:"staging_min_free"` // free space to leave on the staging filesystem
+	OverlayDir      string        `mapstructure:"overlay_dir"`      // local upper layer receiving all changes; the remote directory is not written
+	Offline         bool          `mapstructure:"offline"`          // serve only what is cached, read-only, without contacting the server
+	Mirror          bool          `mapstructure:"mirror"`           // keep a full copy in the cache in the background and serve only from it, read-only
+	MirrorInterval  time.Duration `mapstructure:"mirror_interval"`  // how often the mirror is brought up to date with the server
+	MirrorVerify    time.Duration `mapstructure:"mirror_verify"`    // how often every mirrored file is checked against its checksum, 0 to never
+	AppendOnly      bool          `mapstructure:"append_only"`      // files and folders can be added, but existing ones not changed, replaced or deleted
+	PreloadDepth    int           `mapstructure:"preload_depth"`    // directory levels listed in the background after mounting, 0 for none
+	StreamMinSize   int64         `mapstructure:"stream_min_size"`  // media files at least this large are streamed instead of cached, 0 to never stream
+	StreamReadahead int64         `mapstructure:"stream_readahead"` // bytes a stream fetches ahead of the reader
+	BlockSize       int64         `mapstructure:"block_size"`       // bytes fetched per read of files read in blocks, 0 to read all files whole
+	BlockCacheSize  int64         `mapstructure:"block_cache_size"` // memory for blocks read recently
+	BlockExtensions []string      `mapstructure:"block_extensions"` // files read in blocks, e.g. ["db", "parquet"]; "*" for all
+	RawIORules      IORuleList    `mapstructure:"io_rules"`         // pattern -> options, first match wins
+	IORules         []IORule      `mapstructure:"-"`                // parsed from RawIORules
+	ProcessRules    ProcessRules  `mapstructure:"process_rules"`    // policies by requesting process, first match wins
+	NodeGCInterval  time.Duration `mapstructure:"node_gc_interval"` // how often metadata of files no longer in use is forgotten, 0 to keep it
+	MemoryLimit     int64         `mapstructure:"memory_limit"`     // bytes of metadata, buffers and caches before shedding them, 0 for no limit
+	DebugAddr       string        `mapstructure:"debug_addr"`       // address serving pprof and expvar, e.g. "localhost:6060"; empty for none
+	StatusAddr      string        `mapstructure:"status_addr"`      // address serving the read-only status API, e.g. "localhost:7070"; empty for none
+	TracePath       string        `mapstructure:"trace_path"`       // glob of paths whose operations and API calls are always logged
+	OpTimeout       time.Duration `mapstructure:"op_timeout"`       // how long an operation such as a lookup or listing may wait for the server, 0 for no limit
+	Hard            bool          `mapstructure:"hard"`             // retry operations until the server answers or the caller is interrupted, instead of failing them after op_timeout
+	FlushTimeout    time.Duration `mapstructure:"flush_timeout"`    // how long unmounting waits for pending changes to upload
+	Recovery        bool          `mapstructure:"recovery"`         // keep changes in a journal on disk until uploaded, to resume after a crash
+	Consistency     string        `mapstructure:"consistency"`      // strict, default or relaxed
+	RelaxedTTL      time.Duration `mapstructure:"relaxed_ttl"`      // how long cached content is trusted in relaxed consistency
+	CloseToOpen     bool          `mapstructure:"close_to_open"`    // check files on the server on every open in default consistency
+	ListingTTL      time.Duration `mapstructure:"listing_ttl"`      // how long a folder listing is used before listing again, 0 to list on every read
+	WorkingSet      bool          `mapstructure:"working_set"`      // record which files are read, for the advise command
 }
 
 type CacheConfig struct {
-	Enabled   bool          `mapstructure:"enabled"`
-	Directory string        `mapstructure:"directory"`
-	TTL       time.Duration `mapstructure:"ttl"`
-	MaxSize   int64         `mapstructure:"max_size"`
+	Enabled           bool          `mapstructure:"enabled"`
+	Directory         string        `mapstructure:"directory"`
+	TTL               time.Duration `mapstructure:"ttl"`
+	MaxSize           int64         `mapstructure:"max_size"`
+	ServeStaleOnError bool          `mapstructure:"serve_stale_on_error"` // read an outdated cached copy when the current content cannot be fetched
+}
+
+type UploadConfig struct {
+	Delta        bool              `mapstructure:"delta"`
+	Chunker      string            `mapstructure:"chunker"`
+	ChunkSize    int64             `mapstructure:"chunk_size"`
+	DeltaMinSize int64             `mapstructure:"delta_min_size"`
+	ContentTypes map[string]string `mapstructure:"content_types"` // extension (without dot) -> MIME type
+	Verify       bool              `mapstructure:"verify"`        // check the stored content after each upload
+	Scan         ScanConfig        `mapstructure:"scan"`
+}
+
+// ScanConfig has content checked for malware before it is uploaded.
+type ScanConfig struct {
+	Scanner       string        `mapstructure:"scanner"`        // "off", "exec", "clamd" or "icap"
+	Command       []string      `mapstructure:"command"`        // exec: program and arguments, "{}" standing for a file with the content
+	Address       string        `mapstructure:"address"`        // clamd: host:port or socket path; icap: icap://host[:port]/service
+	Timeout       time.Duration `mapstructure:"timeout"`        // per scan
+	Action        string        `mapstructure:"action"`         // "reject" or "quarantine"
+	QuarantineDir string        `mapstructure:"quarantine_dir"` // empty for the user cache directory
+	FailOpen      bool          `mapstructure:"fail_open"`      // upload when the scanner fails instead of refusing
+}
+
+// PolicyConfig restricts what can be written through the mount.
+type PolicyConfig struct {
+	MaxFileSize    int64    `mapstructure:"max_file_size"`   // bytes, 0 for no limit
+	DenyExtensions []string `mapstructure:"deny_extensions"` // e.g. ["exe", "bat"]
+}
+
+// HookConfig runs a command or calls a webhook on events, given the event
+// as JSON.
+type HookConfig struct {
+	Events  []string      `mapstructure:"events"`  // upload, download, delete, conflict or sync-finished; empty for all
+	Command []string      `mapstructure:"command"` // program and arguments, run with the event on standard input
+	URL     string        `mapstructure:"url"`     // the event is POSTed to it
+	Timeout time.Duration `mapstructure:"timeout"` // 0 for 10s
+}
+
+// TelemetryConfig says where usage statistics go once the user opts in
+// with "telemetry enable".
+type TelemetryConfig struct {
+	Endpoint string `mapstructure:"endpoint"` // URL reports are POSTed to; empty for /api/telemetry on api.base_url
+}
+
+// NotificationsConfig controls desktop notifications about conflicts,
+// failed uploads and the storage quota.
+type NotificationsConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	QuotaWarning  float64       `mapstructure:"quota_warning"`  // percent of the quota used to warn at, 0 not to check
+	QuotaInterval time.Duration `mapstructure:"quota_interval"` // how often a mount checks the quota
+}
+
+// SyncConfig controls the sync command.
+type SyncConfig struct {
+	Conflict       string        `mapstructure:"conflict"`        // newer-wins, larger-wins, keep-both or interactive
+	ConflictReport string        `mapstructure:"conflict_report"` // file conflicts are recorded in, empty for the default
+	RescanAfter    time.Duration `mapstructure:"rescan_after"`    // how long unchanged directories are not listed remotely, 0 to always list
+}
+
+// EncryptionConfig controls client-side encryption of file content.
+type EncryptionConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	IdentityFile string   `mapstructure:"identity_file"` // age secret keys, as written by age-keygen
+	Recipients   []string `mapstructure:"recipients"`    // more age public keys uploads are encrypted to
+
+	// Keys content encrypted before a rotation is still decrypted with;
+	// nothing is encrypted to them.
+	RetiredIdentityFiles []string `mapstructure:"retired_identity_files"`
+
+	Filenames       string `mapstructure:"filenames"`         // "off", "obfuscate", "keep-extension" or "ordered"
+	FilenameKeyFile string `mapstructure:"filename_key_file"` // secret names are encrypted with
+
+	// Folders whose content is encrypted, if not the whole directory:
+	// those listed and, with Markers, those holding a marker file.
+	Paths   []string `mapstructure:"paths"`
+	Markers bool     `mapstructure:"markers"`
+
+	KMS KMSConfig `mapstructure:"kms"`
+}
+
+// KMSConfig configures the key management service the key content is
+// encrypted with is wrapped by, instead of or besides an identity file.
+type KMSConfig struct {
+	Provider       string `mapstructure:"provider"`         // "vault", "aws" or "gcp"; empty for none
+	Key            string `mapstructure:"key"`              // transit key name, AWS key ID or ARN, or GCP key resource name
+	WrappedKeyFile string `mapstructure:"wrapped_key_file"` // the content key as wrapped by the service
+	Address        string `mapstructure:"address"`          // Vault address, or endpoint replacing the provider's
+	Mount          string `mapstructure:"mount"`            // Vault transit engine mount, "transit" by default
+	Region         string `mapstructure:"region"`           // AWS region
 }
 
 func Load() (*Config, error) {
 	var cfg Config
 
 	// Set defaults
+	viper.SetDefault("api.backend", "koneksi")
 	viper.SetDefault("api.timeout", "30s")
 	viper.SetDefault("api.retry_count", 3)
+	viper.SetDefault("api.http2", true)
+	viper.SetDefault("api.max_idle_conns_per_host", 16)
+	viper.SetDefault("api.ping_interval", "30s")
+	viper.SetDefault("api.ping_timeout", "15s")
+	viper.SetDefault("api.breaker_threshold", 5)
+	viper.SetDefault("api.breaker_cooldown", "30s")
+	viper.SetDefault("api.hedge_min_delay", "20ms")
+	viper.SetDefault("api.dns_min_ttl", "5s")
+	viper.SetDefault("api.dns_max_ttl", "5m")
+	viper.SetDefault("api.ip_family", "any")
+	viper.SetDefault("api.dual_stack_delay", "300ms")
 	viper.SetDefault("mount.umask", 0022)
+	viper.SetDefault("mount.leases", true)
+	viper.SetDefault("mount.lease_ttl", "5m")
+	viper.SetDefault("mount.lease_mode", "mandatory")
+	viper.SetDefault("mount.remote_path", "/")
+	viper.SetDefault("mount.virtual_dir", ".koneksi")
+	viper.SetDefault("mount.degrade_after", 3)
+	viper.SetDefault("mount.probe_interval", "1m")
+	viper.SetDefault("mount.write_buffer", 8<<20)       // 8MB
+	viper.SetDefault("mount.staging_min_free", 100<<20) // 100MB
+	viper.SetDefault("mount.stream_min_size", 32<<20)   // 32MB
+	viper.SetDefault("mount.stream_readahead", 16<<20)  // 16MB
+	viper.SetDefault("mount.block_size", 64<<10)        // 64KB
+	viper.SetDefault("mount.block_cache_size", 256<<20) // 256MB
+	viper.SetDefault("mount.block_extensions", []string{"db", "sqlite", "sqlite3", "db3", "duckdb", "parquet", "arrow", "feather", "orc"})
+	viper.SetDefault("mount.node_gc_interval", "5m")
+	viper.SetDefault("mount.op_timeout", "1m")
+	viper.SetDefault("mount.flush_timeout", "1m")
+	viper.SetDefault("mount.recovery", true)
+	viper.SetDefault("mount.consistency", "default")
+	viper.SetDefault("mount.relaxed_ttl", "1h")
+	viper.SetDefault("mount.close_to_open", true)
+	viper.SetDefault("mount.working_set", true)
+	viper.SetDefault("mount.mirror_interval", "15m")
+	viper.SetDefault("mount.mirror_verify", "24h")
 	viper.SetDefault("cache.enabled", true)
 	viper.SetDefault("cache.ttl", "5m")
 	viper.SetDefault("cache.max_size", 1<<30) // 1GB
+	viper.SetDefault("upload.delta", true)
+	viper.SetDefault("upload.chunker", "fixed")
+	viper.SetDefault("upload.chunk_size", 4<<20)      // 4MB
+	viper.SetDefault("upload.delta_min_size", 16<<20) // 16MB
+	viper.SetDefault("upload.verify", false)
+	viper.SetDefault("upload.scan.scanner", "off")
+	viper.SetDefault("upload.scan.timeout", "2m")
+	viper.SetDefault("upload.scan.action", "reject")
+	viper.SetDefault("sync.conflict", "keep-both")
+	viper.SetDefault("sync.rescan_after", "24h")
+	viper.SetDefault("encryption.filenames", "off")
+	viper.SetDefault("notifications.quota_warning", 90)
+	viper.SetDefault("notifications.quota_interval", "15m")
+
+	// --cache-dir and --cache-ttl were once bound to these keys, which
+	// nothing read. Configs that set them get what they meant, unless
+	// they set the cache keys too.
+	for old, key := range map[string]string{"mount.cache_dir": "cache.directory", "mount.cache_ttl": "cache.ttl"} {
+		if viper.InConfig(old) {
+			slog.Warn("config key is deprecated, use the new key instead", "key", old, "new_key", key)
+			viper.SetDefault(key, viper.Get(old))
+		}
+	}
 
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
 	// Validate required fields
-	if cfg.API.BaseURL == "" {
-		return nil, fmt.Errorf("api.base_url is required")
+	switch cfg.API.Backend {
+	case "koneksi":
+		if cfg.API.BaseURL == "" {
+			return nil, fmt.Errorf("api.base_url is required")
+		}
+		if cfg.API.ClientID == "" {
+			return nil, fmt.Errorf("api.client_id is required")
+		}
+		if cfg.API.ClientSecret == "" {
+			return nil, fmt.Errorf("api.client_secret is required")
+		}
+		if cfg.API.DirectoryID == "" {
+			return nil, fmt.Errorf("api.directory_id is required")
+		}
+	case "local":
+		if cfg.API.LocalRoot == "" {
+			return nil, fmt.Errorf("api.local_root is required with api.backend \"local\"")
+		}
+		root, err := filepath.Abs(cfg.API.LocalRoot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api.local_root: %w", err)
+		}
+		cfg.API.LocalRoot = root
+		if cfg.API.DirectoryID == "" {
+			// Names the caches and state kept for the folder.
+			sum := sha256.Sum256([]byte(root))
+			cfg.API.DirectoryID = "local-" + hex.EncodeToString(sum[:4])
+		}
+		if cfg.Encryption.Enabled && (len(cfg.Encryption.Paths) > 0 || cfg.Encryption.Markers) {
+			return nil, fmt.Errorf("api.backend \"local\" encrypts every file, as the crypt layer does; remove encryption.paths and encryption.markers")
+		}
+	default:
+		return nil, fmt.Errorf("api.backend must be \"koneksi\" or \"local\"")
+	}
+	for i, layer := range cfg.API.Layers {
+		switch layer {
+		case "cache":
+			// A temporary cache directory would never be removed.
+			if cfg.Cache.Directory == "" {
+				return nil, fmt.Errorf("the cache layer of api.layers needs cache.directory")
+			}
+		case "crypt":
+			if !cfg.Encryption.Enabled {
+				return nil, fmt.Errorf("the crypt layer of api.layers needs encryption.enabled")
+			}
+			if len(cfg.Encryption.Paths) > 0 || cfg.Encryption.Markers {
+				return nil, fmt.Errorf("the crypt layer of api.layers encrypts every file; remove encryption.paths and encryption.markers")
+			}
+			// Share links, leases and retention would ask the server
+			// for the plain names.
+			if cfg.API.Backend != "local" && cfg.Encryption.Filenames != "" && cfg.Encryption.Filenames != "off" {
+				return nil, fmt.Errorf("the crypt layer of api.layers cannot encrypt names on the Koneksi server; remove crypt from api.layers to have the client encrypt them")
+			}
+		case "limit":
+			if cfg.API.ReadLimit <= 0 && cfg.API.WriteLimit <= 0 {
+				return nil, fmt.Errorf("the limit layer of api.layers needs api.read_limit or api.write_limit")
+			}
+		default:
+			return nil, fmt.Errorf("unknown layer %q in api.layers; use \"cache\", \"crypt\" or \"limit\"", layer)
+		}
+		if slices.Contains(cfg.API.Layers[:i], layer) {
+			return nil, fmt.Errorf("layer %q appears twice in api.layers", layer)
+		}
+	}
+	if cfg.API.ReadLimit < 0 || cfg.API.WriteLimit < 0 {
+		return nil, fmt.Errorf("api.read_limit and api.write_limit must not be negative")
+	}
+	if err := validateTags(cfg.API.Tags); err != nil {
+		return nil, err
+	}
+	for name, id := range cfg.Remotes {
+		if id == "" {
+			return nil, fmt.Errorf("remotes.%s needs a directory ID", name)
+		}
+		if strings.ContainsAny(name, ":/") {
+			return nil, fmt.Errorf("remote name %q must not contain ':' or '/'", name)
+		}
+	}
+	if cfg.API.Version != "" && cfg.API.Version != "v1" && cfg.API.Version != "v2" {
+		return nil, fmt.Errorf("api.version must be \"v1\", \"v2\" or empty to detect it")
+	}
+	if cfg.API.RetryCount < 0 {
+		return nil, fmt.Errorf("api.retry_count must not be negative")
+	}
+	if cfg.API.MaxConcurrentStreams < 0 {
+		return nil, fmt.Errorf("api.max_concurrent_streams must not be negative")
+	}
+	if cfg.API.BreakerThreshold < 0 {
+		return nil, fmt.Errorf("api.breaker_threshold must not be negative")
+	}
+	if cfg.API.BreakerThreshold > 0 && cfg.API.BreakerCooldown <= 0 {
+		return nil, fmt.Errorf("api.breaker_cooldown must be positive")
+	}
+	if cfg.API.HedgePercentile < 0 || cfg.API.HedgePercentile >= 100 {
+		return nil, fmt.Errorf("api.hedge_percentile must be at least 0 and below 100")
+	}
+	if cfg.API.HedgeMinDelay < 0 {
+		return nil, fmt.Errorf("api.hedge_min_delay must not be negative")
+	}
+	if cfg.API.DNSMinTTL < 0 || cfg.API.DNSMaxTTL < 0 {
+		return nil, fmt.Errorf("api.dns_min_ttl and api.dns_max_ttl must not be negative")
+	}
+	if cfg.API.DNSMaxTTL > 0 && cfg.API.DNSMinTTL > cfg.API.DNSMaxTTL {
+		return nil, fmt.Errorf("api.dns_min_ttl must not exceed api.dns_max_ttl")
+	}
+	switch cfg.API.IPFamily {
+	case "any", "ipv4", "ipv6":
+	default:
+		return nil, fmt.Errorf("api.ip_family must be \"any\", \"ipv4\" or \"ipv6\"")
+	}
+	if cfg.API.DualStackDelay < 0 {
+		return nil, fmt.Errorf("api.dual_stack_delay must not be negative")
+	}
+	if cfg.Mount.WriteBuffer < 0 {
+		return nil, fmt.Errorf("mount.write_buffer must not be negative")
+	}
+	if cfg.Mount.StagingMinFree < 0 {
+		return nil, fmt.Errorf("mount.staging_min_free must not be negative")
+	}
+	if !strings.HasPrefix(cfg.Mount.RemotePath, "/") {
+		return nil, fmt.Errorf("mount.remote_path must start with /")
+	}
+	cfg.Mount.RemotePath = path.Clean(cfg.Mount.RemotePath)
+	binds, err := parseMap(cfg.Mount.RawMap, cfg.Mount.VirtualDir)
+	if err != nil {
+		return nil, fmt.Errorf("mount.map: %w", err)
+	}
+	cfg.Mount.Binds = binds
+	if len(binds) > 0 && cfg.Mount.RemotePath != "/" {
+		return nil, fmt.Errorf("mount.map cannot be combined with mount.remote_path")
+	}
+	if cfg.Mount.OverlayDir != "" && cfg.Mount.ReadOnly {
+		return nil, fmt.Errorf("mount.overlay_dir cannot be combined with mount.readonly")
+	}
+	if cfg.Mount.PreloadDepth < 0 {
+		return nil, fmt.Errorf("mount.preload_depth must not be negative")
+	}
+	if cfg.Mount.StreamMinSize < 0 {
+		return nil, fmt.Errorf("mount.stream_min_size must not be negative")
+	}
+	if cfg.Mount.StreamReadahead <= 0 {
+		return nil, fmt.Errorf("mount.stream_readahead must be positive")
+	}
+	if cfg.Mount.NodeGCInterval < 0 {
+		return nil, fmt.Errorf("mount.node_gc_interval must not be negative")
+	}
+	if cfg.Mount.FlushTimeout <= 0 {
+		return nil, fmt.Errorf("mount.flush_timeout must be positive")
+	}
+	if cfg.Mount.LeaseMode != "mandatory" && cfg.Mount.LeaseMode != "advisory" {
+		return nil, fmt.Errorf("mount.lease_mode must be \"mandatory\" or \"advisory\"")
+	}
+	if cfg.Mount.LeaseWait < 0 {
+		return nil, fmt.Errorf("mount.lease_wait must not be negative")
+	}
+	if cfg.Mount.OpTimeout < 0 {
+		return nil, fmt.Errorf("mount.op_timeout must not be negative")
+	}
+	if !cfg.Mount.Hard && cfg.Mount.OpTimeout > 0 && cfg.Mount.LeaseWait >= cfg.Mount.OpTimeout {
+		return nil, fmt.Errorf("mount.lease_wait must be shorter than mount.op_timeout, which bounds opening files")
+	}
+	switch cfg.Mount.Consistency {
+	case "strict", "default", "relaxed":
+	default:
+		return nil, fmt.Errorf("mount.consistency must be strict, default or relaxed, got %q", cfg.Mount.Consistency)
 	}
-	if cfg.API.ClientID == "" {
-		return nil, fmt.Errorf("api.client_id is required")
+	if cfg.Mount.RelaxedTTL <= 0 {
+		return nil, fmt.Errorf("mount.relaxed_ttl must be positive")
 	}
-	if cfg.API.ClientSecret == "" {
-		return nil, fmt.Errorf("api.client_secret is required")
+	if cfg.Mount.ListingTTL < 0 {
+		return nil, fmt.Errorf("mount.listing_ttl must not be negative")
 	}
-	if cfg.API.DirectoryID == "" {
-		return nil, fmt.Errorf("api.directory_id is required")
+	if cfg.Mount.MemoryLimit < 0 {
+		return nil, fmt.Errorf("mount.memory_limit must not be negative")
+	}
+	if cfg.Mount.BlockSize < 0 {
+		return nil, fmt.Errorf("mount.block_size must not be negative")
+	}
+	if cfg.Mount.BlockCacheSize < 0 {
+		return nil, fmt.Errorf("mount.block_cache_size must not be negative")
+	}
+	rules, err := parseIORules(cfg.Mount.RawIORules)
+	if err != nil {
+		return nil, fmt.Errorf("mount.io_rules: %w", err)
+	}
+	for _, r := range rules {
+		if r.Read == "blocks" && cfg.Mount.BlockSize == 0 {
+			return nil, fmt.Errorf("mount.io_rules: %q is read in blocks but mount.block_size is 0", r.Pattern)
+		}
+	}
+	cfg.Mount.IORules = rules
+	if _, err := path.Match(cfg.Mount.TracePath, ""); err != nil {
+		return nil, fmt.Errorf("mount.trace_path: bad pattern %q: %w", cfg.Mount.TracePath, err)
+	}
+	if cfg.Mount.RootMode&^07777 != 0 {
+		return nil, fmt.Errorf("mount.root_mode must be a permission mode such as 0750")
+	}
+	if cfg.Mount.RootOwner != "" {
+		uid, gid, err := parseOwner(cfg.Mount.RootOwner, cfg.Mount.GID)
+		if err != nil {
+			return nil, fmt.Errorf("mount.root_owner: %w", err)
+		}
+		cfg.Mount.RootUID, cfg.Mount.RootGID = uid, gid
+	}
+	if err := cfg.Mount.ProcessRules.parse(); err != nil {
+		return nil, fmt.Errorf("mount.process_rules: %w", err)
+	}
+	if cfg.Mount.Offline && cfg.Mount.OverlayDir != "" {
+		return nil, fmt.Errorf("mount.offline cannot be combined with mount.overlay_dir")
+	}
+	if cfg.Mount.Offline && (!cfg.Cache.Enabled || cfg.Cache.Directory == "") {
+		return nil, fmt.Errorf("mount.offline requires cache.enabled and a cache.directory")
+	}
+	if cfg.Mount.Mirror {
+		switch {
+		case cfg.Mount.Offline || cfg.Mount.OverlayDir != "":
+			return nil, fmt.Errorf("mount.mirror cannot be combined with mount.offline or mount.overlay_dir")
+		case len(cfg.Mount.Binds) > 0:
+			return nil, fmt.Errorf("mount.mirror cannot be combined with mount.map")
+		case !cfg.Cache.Enabled || cfg.Cache.Directory == "":
+			return nil, fmt.Errorf("mount.mirror requires cache.enabled and a cache.directory")
+		case cfg.Mount.MirrorInterval <= 0:
+			return nil, fmt.Errorf("mount.mirror_interval must be positive")
+		case cfg.Mount.MirrorVerify < 0:
+			return nil, fmt.Errorf("mount.mirror_verify must not be negative")
+		}
+	}
+	if cfg.Upload.ChunkSize <= 0 {
+		return nil, fmt.Errorf("upload.chunk_size must be positive")
+	}
+	if cfg.Upload.Chunker != "fixed" && cfg.Upload.Chunker != "cdc" {
+		return nil, fmt.Errorf("upload.chunker must be \"fixed\" or \"cdc\"")
+	}
+	if err := validateScan(&cfg.Upload.Scan); err != nil {
+		return nil, err
+	}
+	if cfg.Notifications.QuotaWarning < 0 || cfg.Notifications.QuotaWarning > 100 {
+		return nil, fmt.Errorf("notifications.quota_warning must be between 0 and 100")
+	}
+	if cfg.Notifications.QuotaInterval <= 0 {
+		return nil, fmt.Errorf("notifications.quota_interval must be positive")
+	}
+	if e := cfg.Telemetry.Endpoint; e != "" && !strings.HasPrefix(e, "http://") && !strings.HasPrefix(e, "https://") {
+		return nil, fmt.Errorf("telemetry.endpoint must be an http:// or https:// URL")
+	}
+	for i, hook := range cfg.Hooks {
+		if err := validateHook(&hook); err != nil {
+			return nil, fmt.Errorf("hooks[%d]: %w", i, err)
+		}
+	}
+	if cfg.Policy.MaxFileSize < 0 {
+		return nil, fmt.Errorf("policy.max_file_size must not be negative")
+	}
+	if cfg.Sync.RescanAfter < 0 {
+		return nil, fmt.Errorf("sync.rescan_after must not be negative")
+	}
+	if err := ValidateConflictPolicy(cfg.Sync.Conflict); err != nil {
+		return nil, fmt.Errorf("sync.conflict: %w", err)
+	}
+	if cfg.Encryption.Enabled && cfg.Encryption.IdentityFile == "" && cfg.Encryption.KMS.Provider == "" {
+		return nil, fmt.Errorf("encryption.enabled requires encryption.identity_file or encryption.kms")
+	}
+	switch cfg.Encryption.KMS.Provider {
+	case "":
+	case "vault", "aws", "gcp":
+		if cfg.Encryption.KMS.Key == "" || cfg.Encryption.KMS.WrappedKeyFile == "" {
+			return nil, fmt.Errorf("encryption.kms requires key and wrapped_key_file")
+		}
+	default:
+		return nil, fmt.Errorf("encryption.kms.provider must be \"vault\", \"aws\" or \"gcp\"")
+	}
+	for i, p := range cfg.Encryption.Paths {
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("encryption.paths: %q is not an absolute path", p)
+		}
+		cfg.Encryption.Paths[i] = path.Clean(p)
+	}
+	switch cfg.Encryption.Filenames {
+	case "off":
+	case "obfuscate", "keep-extension", "ordered":
+		if cfg.Encryption.Enabled && cfg.Encryption.FilenameKeyFile == "" {
+			return nil, fmt.Errorf("encryption.filenames requires encryption.filename_key_file")
+		}
+	default:
+		return nil, fmt.Errorf("encryption.filenames must be \"off\", \"obfuscate\", \"keep-extension\" or \"ordered\"")
 	}
 
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// CacheOnly reports whether file operations are served from the cache
+// alone, never asking the server, as on offline and mirror mounts.
+func (m *MountConfig) CacheOnly() bool {
+	return m.Offline || m.Mirror
+}
+
+// Limits of the CI preset.
+const (
+	ciOpTimeout  = 30 * time.Second
+	ciRetryCount = 2
+)
+
+// ApplyCI adjusts the configuration of a mount for running unattended in
+// a CI pipeline, where a job failing quickly beats one hanging until the
+// pipeline times out: operations are never retried until the server
+// answers and wait ciOpTimeout at most, requests are retried
+// ciRetryCount times at most, a crashed mount is unmounted rather than
+// left for later steps to fail on, and nothing is shown on a desktop.
+func (c *Config) ApplyCI() {
+	c.Mount.Hard = false
+	c.Mount.AutoUnmount = true
+	if c.Mount.OpTimeout == 0 || c.Mount.OpTimeout > ciOpTimeout {
+		c.Mount.OpTimeout = ciOpTimeout
+	}
+	// Opens waiting for a lease must still fit in an operation.
+	c.Mount.LeaseWait = min(c.Mount.LeaseWait, c.Mount.OpTimeout/2)
+	c.API.RetryCount = min(c.API.RetryCount, ciRetryCount)
+	c.Notifications.Enabled = false
+}
+
+// ValidateConflictPolicy checks the name of a sync conflict policy.
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case "newer-wins", "larger-wins", "keep-both", "interactive":
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q (want newer-wins, larger-wins, keep-both or interactive)", policy)
+}
+
+// maxTagLength is the longest value of an accounting tag.
+const maxTagLength = 128
+
+// validateTags checks the accounting tags of api.tags, naming the host
+// unless a host tag is given. Tags with an empty value are left out, so
+// `host: ""` sends no host.
+func validateTags(tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if _, ok := tags["host"]; !ok {
+		if host, err := os.Hostname(); err == nil {
+			tags["host"] = host
+		}
+	}
+	for name, value := range tags {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.')
+		}) >= 0 {
+			return fmt.Errorf("api.tags: tag name %q may only have lowercase letters, digits, '_', '-' and '.'", name)
+		}
+		if len(value) > maxTagLength || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("api.tags.%s must be at most %d characters, without control characters", name, maxTagLength)
+		}
+		if value == "" {
+			delete(tags, name)
+		}
+	}
+	return nil
+}
+
+// Bind is an entry of mount.map: the remote folder Path shown as the
+// folder Name at the mount root.
+type Bind struct {
+	Name string
+	Path string
+}
+
+// parseMap parses the name=/remote/path entries of mount.map. Names
+// become folders at the mount root, so they may not contain a slash or
+// clash with each other or with the virtual folder.
+func parseMap(raw []string, virtualDir string) ([]Bind, error) {
+	var binds []Bind
+	for _, entry := range raw {
+		name, remote, ok := strings.Cut(entry, "=")
+		switch {
+		case !ok || !strings.HasPrefix(remote, "/"):
+			return nil, fmt.Errorf("entry %q must be name=/remote/path", entry)
+		case name == "" || name == "." || name == ".." || strings.Contains(name, "/"):
+			return nil, fmt.Errorf("entry %q: %q cannot be a folder name", entry, name)
+		case name == virtualDir:
+			return nil, fmt.Errorf("entry %q: %q is mount.virtual_dir", entry, name)
+		}
+		for _, b := range binds {
+			if b.Name == name {
+				return nil, fmt.Errorf("%q is mapped twice", name)
+			}
+		}
+		binds = append(binds, Bind{Name: name, Path: path.Clean(remote)})
+	}
+	return binds, nil
+}
+
+// validateScan checks the malware scanner settings.
+func validateScan(cfg *ScanConfig) error {
+	switch cfg.Scanner {
+	case "off":
+		return nil
+	case "exec":
+		if len(cfg.Command) == 0 {
+			return fmt.Errorf("upload.scan.command is required with the exec scanner")
+		}
+	case "clamd":
+		if cfg.Address == "" {
+			return fmt.Errorf("upload.scan.address is required with the clamd scanner")
+		}
+	case "icap":
+		if !strings.HasPrefix(cfg.Address, "icap://") {
+			return fmt.Errorf("upload.scan.address must be an icap:// URL with the icap scanner")
+		}
+	default:
+		return fmt.Errorf("upload.scan.scanner must be \"off\", \"exec\", \"clamd\" or \"icap\"")
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("upload.scan.timeout must be positive")
+	}
+	if cfg.Action != "reject" && cfg.Action != "quarantine" {
+		return fmt.Errorf("upload.scan.action must be \"reject\" or \"quarantine\"")
+	}
+	return nil
+}
+
+// validateHook checks a hook entry.
+func validateHook(hook *HookConfig) error {
+	if (len(hook.Command) == 0) == (hook.URL == "") {
+		return fmt.Errorf("exactly one of command and url is required")
+	}
+	if hook.URL != "" && !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+		return fmt.Errorf("url must be an http:// or https:// URL")
+	}
+	for _, event := range hook.Events {
+		switch event {
+		case "upload", "download", "delete", "conflict", "sync-finished":
+		default:
+			return fmt.Errorf("unknown event %q (want upload, download, delete, conflict or sync-finished)", event)
+		}
+	}
+	if hook.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration is time.ParseDuration with additional "d" (day), "w"
+// (week) and "y" (365 days) units, which are the natural way to express
+// link and retention periods. Mixed forms such as "1d12h" are not
+// supported.
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
+
+// ParseUntil parses a point in time given as an RFC 3339 time, a date
+// ("2006-01-02", midnight UTC) or a duration from now as ParseDuration
+// reads it, e.g. "7y".
+func ParseUntil(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q: use a date, an RFC 3339 time or a duration such as 30d", s)
+	}
+	return now.Add(d), nil
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IORuleList is mount.io_rules as configured: entries mapping a pattern
+// to a comma-separated list of options, such as "*.mp4": "stream,
+// no-cache". The first matching entry applies.
+type IORuleList []map[string]string
+
+// IORule sets how the files matching Pattern are read, cached and
+// written. The zero value of each field keeps the default behavior.
+type IORule struct {
+	// Pattern is a glob matched against the file name, or against the
+	// whole path below the mount root if it contains a slash.
+	Pattern string
+	// Read is "stream", "blocks" or "whole", or empty to decide by the
+	// file's type and size.
+	Read string
+	// NoCache keeps the content out of the content cache.
+	NoCache bool
+	// CachePriority orders cached copies for eviction: lower priorities
+	// are evicted first.
+	CachePriority int
+	// WriteBack uploads changes once the file is released rather than
+	// each time it is closed.
+	WriteBack bool
+	// Consistency is "strict", "default" or "relaxed", or empty for
+	// mount.consistency.
+	Consistency string
+}
+
+// Match reports whether the rule applies to the remote file p.
+func (r IORule) Match(p string) bool {
+	return MatchPath(r.Pattern, p)
+}
+
+// MatchPath reports whether the remote path p matches pattern, a glob
+// matched against the file name, or against the whole path below the
+// mount root if it contains a slash.
+func MatchPath(pattern, p string) bool {
+	name := path.Base(p)
+	if strings.Contains(pattern, "/") {
+		name = strings.TrimPrefix(p, "/")
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name)
+	return ok
+}
+
+// MatchIORule returns the first of rules matching the remote file p, or
+// the zero rule.
+func MatchIORule(rules []IORule, p string) IORule {
+	for _, r := range rules {
+		if r.Match(p) {
+			return r
+		}
+	}
+	return IORule{}
+}
+
+// parseIORules parses the entries of mount.io_rules.
+func parseIORules(raw IORuleList) ([]IORule, error) {
+	var rules []IORule
+	for _, entry := range raw {
+		// An entry normally holds one pattern; order several by name.
+		patterns := make([]string, 0, len(entry))
+		for pattern := range entry {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			options := entry[pattern]
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+
+			rule := IORule{Pattern: pattern}
+			for _, opt := range strings.Split(options, ",") {
+				switch opt = strings.TrimSpace(opt); opt {
+				case "stream", "blocks", "whole":
+					rule.Read = opt
+				case "cache":
+					rule.NoCache = false
+				case "no-cache":
+					rule.NoCache = true
+				case "cache-priority-high":
+					rule.CachePriority = 1
+				case "cache-priority-low":
+					rule.CachePriority = -1
+				case "write-back":
+					rule.WriteBack = true
+				case "write-through":
+					rule.WriteBack = false
+				case "consistency-strict", "consistency-default", "consistency-relaxed":
+					rule.Consistency = strings.TrimPrefix(opt, "consistency-")
+				case "":
+				default:
+					return nil, fmt.Errorf("unknown option %q for %q (want stream, blocks, whole, cache, no-cache, cache-priority-high, cache-priority-low, write-back, write-through or consistency-strict, -default or -relaxed)", opt, pattern)
+				}
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// parseOwner reads an owner given as user[:group], each a name or a
+// numeric ID. Numeric IDs need not exist on this host. Without a group,
+// the user's primary group is used if the user is known, and gid
+// otherwise.
+func parseOwner(s string, gid uint32) (uint32, uint32, error) {
+	name, group, hasGroup := strings.Cut(s, ":")
+
+	id := name
+	u, err := user.LookupId(name)
+	if _, numeric := parseID(name); !numeric {
+		if u, err = user.Lookup(name); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q", name)
+		}
+		id = u.Uid
+	}
+	uid, ok := parseID(id)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid user ID %q", id)
+	}
+
+	if !hasGroup {
+		if err == nil {
+			if g, ok := parseID(u.Gid); ok {
+				gid = g
+			}
+		}
+		return uid, gid, nil
+	}
+
+	if g, ok := parseID(group); ok {
+		return uid, g, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown group %q", group)
+	}
+	if gid, ok = parseID(g.Gid); !ok {
+		return 0, 0, fmt.Errorf("invalid group ID %q", g.Gid)
+	}
+	return uid, gid, nil
+}
+
+func parseID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err == nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ProcessRules is mount.process_rules: policies for the requests of
+// matching processes. The first matching rule applies.
+type ProcessRules []ProcessRule
+
+// ProcessRule applies its options to the requests of processes matching
+// both Comm and UID; an empty one matches any process.
+type ProcessRule struct {
+	Comm    string `mapstructure:"comm"`    // glob matched against the process name
+	UID     string `mapstructure:"uid"`     // user ID, or "other" for users other than the one running the mount
+	Options string `mapstructure:"options"` // comma-separated
+
+	DenyWrites  bool `mapstructure:"-"` // refuse changes with EACCES
+	NoReadahead bool `mapstructure:"-"` // fetch only what is read
+	NoCache     bool `mapstructure:"-"` // keep what is read out of the content cache
+	Log         bool `mapstructure:"-"` // log every operation
+
+	uid   uint32
+	other bool
+}
+
+// Match reports whether the rule applies to a process named comm (empty
+// if unknown) running as uid, when the mount runs as self.
+func (r ProcessRule) Match(comm string, uid, self uint32) bool {
+	switch {
+	case r.other && uid == self:
+		return false
+	case r.UID != "" && !r.other && uid != r.uid:
+		return false
+	}
+	if r.Comm == "" {
+		return true
+	}
+	ok, _ := path.Match(r.Comm, comm)
+	return ok
+}
+
+// Match returns the first rule applying to a process, or the zero rule.
+func (rules ProcessRules) Match(comm string, uid, self uint32) ProcessRule {
+	for _, r := range rules {
+		if r.Match(comm, uid, self) {
+			return r
+		}
+	}
+	return ProcessRule{}
+}
+
+func (rules ProcessRules) parse() error {
+	for i := range rules {
+		r := &rules[i]
+		if _, err := path.Match(r.Comm, ""); err != nil {
+			return fmt.Errorf("bad comm pattern %q: %w", r.Comm, err)
+		}
+
+		switch r.UID {
+		case "":
+		case "other":
+			r.other = true
+		default:
+			uid, err := strconv.ParseUint(r.UID, 10, 32)
+			if err != nil {
+				return fmt.Errorf("uid must be a number or \"other\", got %q", r.UID)
+			}
+			r.uid = uint32(uid)
+		}
+
+		for _, opt := range strings.Split(r.Options, ",") {
+			switch opt = strings.TrimSpace(opt); opt {
+			case "deny-writes":
+				r.DenyWrites = true
+			case "no-readahead":
+				r.NoReadahead = true
+			case "no-cache":
+				r.NoCache = true
+			case "log":
+				r.Log = true
+			case "":
+			default:
+				return fmt.Errorf("unknown option %q (want deny-writes, no-readahead, no-cache or log)", opt)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package fs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// composite reports whether n is the root of a mount composed of the
+// remote folders of mount.map, which holds those folders and nothing
+// else.
+func (n *koneksiNode) composite() bool {
+	return len(n.cfg.Mount.Binds) > 0 && n.place.Load() == nil
+}
+
+// boundPath returns the remote folder mount.map shows as name at the
+// root.
+func (n *koneksiNode) boundPath(name string) string {
+	for _, b := range n.cfg.Mount.Binds {
+		if b.Name == name {
+			return b.Path
+		}
+	}
+	return ""
+}
+
+// bindListing lists the root of a composite mount: a folder for each
+// entry of mount.map, with the time of the root.
+func (n *koneksiNode) bindListing() []api.FileInfo {
+	modified := n.stat().Modified
+	files := make([]api.FileInfo, len(n.cfg.Mount.Binds))
+	for i, b := range n.cfg.Mount.Binds {
+		files[i] = api.FileInfo{Name: b.Name, Path: b.Path, IsDir: true, Modified: modified}
+	}
+	return files
+}
+
+// mountedPath returns where the remote path p is in the mount, relative
+// to its root, or false if p cannot be reached through the mount. Where
+// mount.map binds folders inside each other, the innermost is used.
+func mountedPath(cfg *config.Config, p string) (string, bool) {
+	if len(cfg.Mount.Binds) == 0 {
+		if !pathWithin(p, cfg.Mount.RemotePath) {
+			return "", false
+		}
+		return strings.TrimPrefix(strings.TrimPrefix(p, cfg.Mount.RemotePath), "/"), true
+	}
+
+	var bind *config.Bind
+	for i, b := range cfg.Mount.Binds {
+		if pathWithin(p, b.Path) && (bind == nil || len(b.Path) > len(bind.Path)) {
+			bind = &cfg.Mount.Binds[i]
+		}
+	}
+	if bind == nil {
+		return "", false
+	}
+	return path.Join(bind.Name, strings.TrimPrefix(p, bind.Path)), true
+}
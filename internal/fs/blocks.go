@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"container/list"
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// blockFanout is how many blocks a single read fetches at once.
+const blockFanout = 8
+
+// blockReads reports whether n is read in blocks, as configured for
+// databases and columnar files, which read small pieces all over the
+// file, or by mount.io_rules. Files already cached whole are read from
+// the cache instead.
+func (n *koneksiNode) blockReads() bool {
+	if n.blocks == nil {
+		return false
+	}
+	info := n.stat()
+	if n.cache != nil && n.cache.Cached(n.path(), info.Size, info.Modified, info.Hash) {
+		return false
+	}
+
+	if rule := n.ioRule(); rule.Read != "" {
+		return rule.Read == "blocks"
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(n.path()), "."))
+	for _, e := range n.cfg.Mount.BlockExtensions {
+		if e == "*" || strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockCache keeps recently read blocks of remote files in memory, up to
+// a size of its own, so the pages of a database stay around however many
+// other files go through the content cache. Blocks are keyed by the
+// version of the file they were read from; those of older versions are
+// never served and age out.
+type blockCache struct {
+	blockSize int64
+	maxSize   int64
+
+	mu       sync.Mutex
+	lru      *list.List // of *cachedBlock, most recently used first
+	blocks   map[blockKey]*list.Element
+	used     int64
+	fetching map[blockKey]*blockFetch
+}
+
+type blockKey struct {
+	path     string
+	size     int64
+	modified int64 // UnixNano
+	index    int64
+}
+
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+// blockFetch is a block being read from the server, which readers of the
+// same block wait for instead of requesting it again.
+type blockFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newBlockCache(blockSize, maxSize int64) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		maxSize:   maxSize,
+		lru:       list.New(),
+		blocks:    make(map[blockKey]*list.Element),
+		fetching:  make(map[blockKey]*blockFetch),
+	}
+}
+
+// load returns the block key, calling fetch to read it unless it is
+// cached or already being read.
+func (c *blockCache) load(key blockKey, fetch func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if el, ok := c.blocks[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedBlock).data, nil
+	}
+	if f, ok := c.fetching[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &blockFetch{done: make(chan struct{})}
+	c.fetching[key] = f
+	c.mu.Unlock()
+
+	f.data, f.err = fetch()
+
+	c.mu.Lock()
+	delete(c.fetching, key)
+	if f.err == nil {
+		c.addLocked(key, f.data)
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return f.data, f.err
+}
+
+func (c *blockCache) addLocked(key blockKey, data []byte) {
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, data: data})
+	c.used += int64(len(data))
+	c.evictLocked(c.maxSize, 1)
+}
+
+// evictLocked drops the least recently used blocks until those left take
+// at most size bytes or only keep of them are left.
+func (c *blockCache) evictLocked(size int64, keep int) {
+	for c.used > size && c.lru.Len() > keep {
+		el := c.lru.Back()
+		b := el.Value.(*cachedBlock)
+		c.lru.Remove(el)
+		delete(c.blocks, b.key)
+		c.used -= int64(len(b.data))
+	}
+}
+
+// size returns the bytes of the blocks cached.
+func (c *blockCache) size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// shrink evicts blocks until those left take at most size bytes.
+func (c *blockCache) shrink(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked(size, 0)
+}
+
+// blockReader reads a version of a file block by block through the
+// block cache, fetching the blocks a read needs in parallel.
+type blockReader struct {
+	node *koneksiNode
+	info *api.FileInfo
+}
+
+func (r *blockReader) ReadAt(p []byte, off int64) (int, error) {
+	c := r.node.blocks
+	end := off + int64(len(p))
+	if end > r.info.Size {
+		end = r.info.Size
+	}
+	if off >= end {
+		return 0, nil
+	}
+
+	first, last := off/c.blockSize, (end-1)/c.blockSize
+	blocks := make([][]byte, last-first+1)
+	errs := make([]error, len(blocks))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, blockFanout)
+	for i := range blocks {
+		key := blockKey{
+			path:     r.node.path(),
+			size:     r.info.Size,
+			modified: r.info.Modified.UnixNano(),
+			index:    first + int64(i),
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			blocks[i], errs[i] = c.load(key, func() ([]byte, error) {
+				return api.ReadRange(context.Background(), r.node.storage, r.node.path(), key.index*c.blockSize, c.blockSize)
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	n := 0
+	for i, data := range blocks {
+		if errs[i] != nil {
+			return n, errs[i]
+		}
+		start := (first + int64(i)) * c.blockSize
+		from := off + int64(n) - start
+		if from >= int64(len(data)) {
+			break
+		}
+		n += copy(p[n:end-off], data[from:])
+	}
+	return n, nil
+}
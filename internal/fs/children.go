@@ -0,0 +1,180 @@
+package fs
+
+import (
+	"sync"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// childShards is the number of independently locked parts of a
+// directory's children, so lookups of different names in a large
+// directory rarely wait for each other or for a listing being stored.
+const childShards = 16
+
+// childMap holds the known children of a directory node by name. Its
+// zero value is empty and ready to use; the shards are allocated on
+// first use so file nodes do not pay for them.
+type childMap struct {
+	init   sync.Once
+	shards *[childShards]childShard
+}
+
+type childShard struct {
+	mu sync.RWMutex
+	m  map[string]*koneksiNode
+}
+
+func (c *childMap) all() *[childShards]childShard {
+	c.init.Do(func() {
+		c.shards = new([childShards]childShard)
+	})
+	return c.shards
+}
+
+func (c *childMap) shard(name string) *childShard {
+	return &c.all()[shardIndex(name)]
+}
+
+// shardIndex hashes name with FNV-1a.
+func shardIndex(name string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return h % childShards
+}
+
+func (c *childMap) get(name string) (*koneksiNode, bool) {
+	s := c.shard(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	child, ok := s.m[name]
+	return child, ok
+}
+
+func (c *childMap) set(name string, child *koneksiNode) {
+	s := c.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[string]*koneksiNode)
+	}
+	s.m[name] = child
+}
+
+func (c *childMap) remove(name string) {
+	s := c.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.m, name)
+}
+
+// list returns the children.
+func (c *childMap) list() []*koneksiNode {
+	var children []*koneksiNode
+	shards := c.all()
+	for i := range shards {
+		s := &shards[i]
+		s.mu.RLock()
+		for _, child := range s.m {
+			children = append(children, child)
+		}
+		s.mu.RUnlock()
+	}
+	return children
+}
+
+// sync brings the children in line with listing, one shard at a time,
+// and returns the children listed. merge returns the node for each
+// listed entry given the current child of that name, or nil if there is
+// none. Current children that are not listed are dropped unless keep
+// returns true for them.
+func (c *childMap) sync(listing map[string]api.FileInfo, merge func(old *koneksiNode, file api.FileInfo) *koneksiNode, keep func(child *koneksiNode) bool) map[string]*koneksiNode {
+	var parts [childShards][]string
+	for name := range listing {
+		i := shardIndex(name)
+		parts[i] = append(parts[i], name)
+	}
+
+	listed := make(map[string]*koneksiNode, len(listing))
+	shards := c.all()
+	for i := range shards {
+		s := &shards[i]
+		s.mu.Lock()
+		m := make(map[string]*koneksiNode, len(parts[i]))
+		for _, name := range parts[i] {
+			child := merge(s.m[name], listing[name])
+			m[name] = child
+			listed[name] = child
+		}
+		for name, child := range s.m {
+			if _, ok := m[name]; !ok && keep(child) {
+				m[name] = child
+			}
+		}
+		s.m = m
+		s.mu.Unlock()
+	}
+	return listed
+}
+
+// add sets child as the child name unless there already is one, and
+// returns the child that is set.
+func (c *childMap) add(name string, child *koneksiNode) *koneksiNode {
+	s := c.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.m[name]; ok {
+		return old
+	}
+	if s.m == nil {
+		s.m = make(map[string]*koneksiNode)
+	}
+	s.m[name] = child
+	return child
+}
+
+// sweep forgets the children for which keep returns false and returns
+// the others, along with how many were forgotten.
+func (c *childMap) sweep(keep func(child *koneksiNode) bool) (kept []*koneksiNode, dropped int) {
+	shards := c.all()
+	for i := range shards {
+		s := &shards[i]
+		s.mu.Lock()
+		for name, child := range s.m {
+			if keep(child) {
+				kept = append(kept, child)
+			} else {
+				delete(s.m, name)
+				dropped++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return kept, dropped
+}
+
+// reset forgets the children for which keep returns false and returns
+// all children.
+func (c *childMap) reset(keep func(child *koneksiNode) bool) []*koneksiNode {
+	shards := c.all()
+
+	var children []*koneksiNode
+	for i := range shards {
+		s := &shards[i]
+		s.mu.Lock()
+		for name, child := range s.m {
+			children = append(children, child)
+			if !keep(child) {
+				delete(s.m, name)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return children
+}
@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// relaxedAttrTimeout is how long the kernel may keep the attributes and
+// directory entries of files under relaxed consistency without asking
+// again. Otherwise it asks every time, although the answer is served
+// from the node metadata.
+const relaxedAttrTimeout = 30 * time.Second
+
+// consistency returns how closely the node follows changes made on the
+// server by others: "strict", "default" or "relaxed", from its
+// mount.io_rules entry or else mount.consistency, as changed by remount.
+func (n *koneksiNode) consistency() string {
+	if c := n.ioRule().Consistency; c != "" {
+		return c
+	}
+	return *n.live.consistency.Load()
+}
+
+// cacheFresh reports whether the cached content of the node can be used
+// without checking the server first.
+func (n *koneksiNode) cacheFresh() bool {
+	if n.consistency() == "relaxed" {
+		return n.cache.FreshWithin(n.path(), n.cfg.Mount.RelaxedTTL)
+	}
+	return n.cache.Fresh(n.path())
+}
+
+// kernelTimeout returns how long the kernel may cache the attributes and
+// entry of the node.
+func (n *koneksiNode) kernelTimeout() time.Duration {
+	if n.consistency() == "relaxed" {
+		return relaxedAttrTimeout
+	}
+	return 0
+}
+
+// revalidatesOnOpen reports whether opening the node checks it on the
+// server first: always under strict consistency, and under default
+// consistency with mount.close_to_open, so a file written and closed
+// elsewhere is read as it was closed whatever the cache TTL.
+func (n *koneksiNode) revalidatesOnOpen() bool {
+	switch n.consistency() {
+	case "strict":
+		return true
+	case "default":
+		return n.cfg.Mount.CloseToOpen
+	}
+	return false
+}
+
+// revalidate refreshes the node metadata from the server, so the file is
+// read as it is on the server now rather than as it was last listed. An
+// unchanged cached copy counts as validated and is used without another
+// request. Files with changes not yet uploaded, in the overlay or not, are
+// left alone, as is everything when the server cannot be reached.
+func (n *koneksiNode) revalidate(ctx context.Context) syscall.Errno {
+	if n.cfg.Mount.CacheOnly() || n.handles.dirty(n) {
+		return 0
+	}
+	if n.overlay != nil {
+		if _, ok := n.overlay.stat(n.path()); ok {
+			return 0
+		}
+	}
+
+	fresh, err := n.storage.Stat(ctx, n.path())
+	if api.IsNotFound(err) {
+		return syscall.ENOENT
+	}
+	if err != nil {
+		slog.Debug("failed to revalidate file", "path", n.path(), "error", err)
+		return 0
+	}
+
+	info := n.stat()
+	if fresh.Size == info.Size && fresh.Modified.Equal(info.Modified) {
+		if n.cache != nil {
+			n.cache.Validated(n.path())
+		}
+		return 0
+	}
+	n.trace("revalidate", n.path(), "size", fresh.Size, "modified", fresh.Modified)
+	n.updateInfo(fresh)
+	return 0
+}
+
+// setEntryTimeout lets the kernel cache the entry and attributes of the
+// node for its kernelTimeout.
+func (n *koneksiNode) setEntryTimeout(out *fuse.EntryOut) {
+	if t := n.kernelTimeout(); t > 0 {
+		out.SetEntryTimeout(t)
+		out.SetAttrTimeout(t)
+	}
+}
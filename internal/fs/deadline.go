@@ -0,0 +1,57 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"syscall"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// deadline bounds the API requests an operation makes, so that a server
+// that stopped answering does not hang it, and everything waiting on it,
+// for good. On soft mounts the operation fails with ETIMEDOUT after
+// mount.op_timeout; on hard mounts its requests are retried until the
+// server answers. Either way, interrupting the caller gives up with
+// EINTR. The operation makes its requests with the returned context, so
+// the bound is its own and not that of other operations on the same
+// paths. The returned function ends the bound and sets errno
+// accordingly; operations defer it with their result:
+//
+//	ctx, end := n.deadline(ctx, "lookup", &errno, p)
+//	defer end()
+//
+// Reading and writing file content is not bounded, as moving a large
+// file may rightly take longer. The operation counts as interactive while
+// it runs, holding back background work (see ioScheduler).
+func (n *koneksiNode) deadline(ctx context.Context, op string, errno *syscall.Errno, p string) (context.Context, func()) {
+	end := n.io.begin(classInteractive)
+	hard := n.cfg.Mount.Hard
+	timeout := n.cfg.Mount.OpTimeout
+	if n.cfg.Mount.CacheOnly() || (!hard && timeout <= 0) {
+		return ctx, end
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if hard {
+		ctx = api.WithRetry(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, func() {
+		defer end()
+		err := ctx.Err()
+		cancel()
+		if err == nil || *errno == 0 {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			slog.Warn("operation timed out", "op", op, "path", p, "timeout", timeout, "error", *errno)
+			*errno = syscall.ETIMEDOUT
+		} else {
+			*errno = syscall.EINTR
+		}
+	}
+}
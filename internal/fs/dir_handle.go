@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// koneksiDirHandle reads a directory from a snapshot of its listing taken
+// when reading starts. Each entry's offset is its position in the
+// snapshot, so telldir/seekdir and reads split over many getdents calls
+// stay consistent even if the directory changes on the server meanwhile.
+// Rewinding to the start takes a fresh snapshot.
+type koneksiDirHandle struct {
+	node *koneksiNode
+
+	mu      sync.Mutex
+	entries []fuse.DirEntry // nil until the first read
+	next    int             // index of the next entry to return
+}
+
+var _ = (fs.NodeOpendirHandler)((*koneksiNode)(nil))
+
+func (n *koneksiNode) OpendirHandle(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if !n.stat().IsDir {
+		return nil, 0, syscall.ENOTDIR
+	}
+	n.processRule(ctx, "opendir", n.path())
+	return &koneksiDirHandle{node: n}, 0, 0
+}
+
+var _ = (fs.FileReaddirenter)((*koneksiDirHandle)(nil))
+
+func (dh *koneksiDirHandle) Readdirent(ctx context.Context) (*fuse.DirEntry, syscall.Errno) {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	if dh.entries == nil {
+		entries, errno := dh.node.readdirEntries(ctx)
+		if errno != 0 {
+			return nil, errno
+		}
+		dh.entries = entries
+	}
+
+	if dh.next >= len(dh.entries) {
+		return nil, 0
+	}
+	e := dh.entries[dh.next]
+	dh.next++
+	return &e, 0
+}
+
+var _ = (fs.FileSeekdirer)((*koneksiDirHandle)(nil))
+
+// Seekdir moves to the entry after the one with offset off, as returned
+// by an earlier Readdirent on this handle.
+func (dh *koneksiDirHandle) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	if off == 0 {
+		dh.entries = nil
+		dh.next = 0
+		return 0
+	}
+	if off > uint64(len(dh.entries)) {
+		return syscall.EINVAL
+	}
+	dh.next = int(off)
+	return 0
+}
+
+var _ = (fs.FileReleasedirer)((*koneksiDirHandle)(nil))
+
+func (dh *koneksiDirHandle) Releasedir(ctx context.Context, releaseFlags uint32) {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	dh.entries = nil
+}
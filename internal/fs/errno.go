@@ -0,0 +1,28 @@
+package fs
+
+import (
+	"errors"
+	iofs "io/fs"
+	"syscall"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// apiErrno maps a failed API call to the error returned to the caller, so
+// programs report a missing file, a refused login or a full quota as
+// such rather than as an I/O error.
+func apiErrno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, api.ErrOffline):
+		return syscall.ENETUNREACH
+	case api.IsNotFound(err):
+		return syscall.ENOENT
+	case errors.Is(err, api.ErrUnauthorized), errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, api.ErrQuotaExceeded), errors.Is(err, syscall.ENOSPC):
+		return syscall.EDQUOT
+	}
+	return syscall.EIO
+}
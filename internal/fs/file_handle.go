@@ -0,0 +1,497 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+)
+
+// koneksiFileHandle serves reads from the content cache (or the API when
+// caching is disabled) and collects writes in a staging buffer that is
+// uploaded on flush. Large media files are streamed instead of cached,
+// and databases and columnar files are read in blocks.
+//
+// Writes that only append to the file, as logs do, are collected on their
+// own and appended to the remote file, without staging or uploading the
+// existing content. Any other change copies the existing content up into
+// the staging buffer first.
+type koneksiFileHandle struct {
+	node    *koneksiNode
+	flags   uint32
+	proc    config.ProcessRule // for the process that opened the file
+	created bool               // opened by creating the file
+
+	mu      sync.Mutex
+	cached  *os.File       // cached remote content, opened on first read
+	stream  *streamReader  // remote content, when streamed
+	blocks  *blockReader   // remote content, when read in blocks
+	staging *stagingBuffer // modified content, created on first write
+	// dirty is set while there are changes not yet uploaded. It is
+	// changed with mu held but read without it, so reads of the file,
+	// which hold mu throughout, do not make it look changed.
+	dirty atomic.Bool
+
+	tail *stagingBuffer // appended content, while only appending
+	base *api.FileInfo  // the version tail appends to
+
+	readAny bool  // remote content was read
+	readEnd int64 // where the last read of remote content ended
+
+	leaseMu   sync.Mutex
+	lease     *api.Lease // write lease held while open for writing
+	leaseStop chan struct{}
+}
+
+var _ = (fs.FileReader)((*koneksiFileHandle)(nil))
+
+func (fh *koneksiFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fh.node.processRule(ctx, "read", fh.node.path())
+
+	// Changes written through another descriptor are read from there
+	// until they are uploaded.
+	dest, res, errno, ok := fh.readPending(dest, off)
+	if ok {
+		return res, errno
+	}
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if fh.tail != nil {
+		if err := fh.ensureStaging(); err != nil {
+			return nil, fh.stagingErrno(err)
+		}
+	}
+	if fh.staging != nil {
+		return readAt(fh.staging, dest, off)
+	}
+	defer fh.node.io.begin(classInteractive)()
+
+	size := fh.node.stat().Size
+	fh.node.workset.read(fh.node.path(), size, off, len(dest), !fh.readAny, fh.readAny && off == fh.readEnd)
+	fh.readAny, fh.readEnd = true, min(off+int64(len(dest)), size)
+
+	if fh.blocks == nil && fh.stream == nil && fh.cached == nil && fh.node.blockReads() {
+		fh.blocks = &blockReader{node: fh.node, info: fh.node.stat()}
+	}
+	if fh.blocks != nil {
+		n, err := fh.blocks.ReadAt(dest, off)
+		if !errors.Is(err, api.ErrRangeUnsupported) {
+			if err != nil {
+				return nil, syscall.EIO
+			}
+			return fuse.ReadResultData(dest[:n]), 0
+		}
+		// Read the file as a whole instead.
+		fh.blocks = nil
+	}
+
+	if fh.stream == nil && fh.cached == nil && fh.node.streams(fh.flags, off, fh.proc) {
+		info := fh.node.stat()
+		readahead := fh.node.cfg.Mount.StreamReadahead
+		if fh.proc.NoReadahead {
+			readahead = streamChunk
+		}
+		fh.stream = newStreamReader(fh.node.storage, fh.node.path, info.Size, readahead, fh.node.memory)
+	}
+	if fh.stream != nil {
+		return readAt(fh.stream, dest, off)
+	}
+
+	if fh.node.cache != nil {
+		if fh.cached == nil {
+			f, err := fh.node.openCached(fh.node.stat())
+			if err != nil {
+				return nil, syscall.EIO
+			}
+			fh.cached = f
+		}
+		return readAt(fh.cached, dest, off)
+	}
+
+	reader, err := fh.node.storage.Read(context.Background(), fh.node.path())
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	defer reader.Close()
+
+	// Skip to offset
+	if off > 0 {
+		if _, err := io.CopyN(io.Discard, reader, off); err != nil {
+			if err == io.EOF {
+				return fuse.ReadResultData(nil), 0
+			}
+			return nil, syscall.EIO
+		}
+	}
+
+	n, err := io.ReadFull(reader, dest)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, syscall.EIO
+	}
+
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// readPending reads from the changes not yet uploaded of another handle
+// on the same file, waiting for an upload in progress to finish, and
+// reports whether it did. Reads before the content appended through
+// another handle are served as usual, but no further than where that
+// content starts, so the returned dest is to be read instead. A handle
+// with changes of its own reads those.
+func (fh *koneksiFileHandle) readPending(dest []byte, off int64) ([]byte, fuse.ReadResult, syscall.Errno, bool) {
+	fh.mu.Lock()
+	own := fh.staging != nil || fh.tail != nil
+	fh.mu.Unlock()
+	if own {
+		return dest, nil, 0, false
+	}
+
+	for _, other := range fh.node.handles.snapshot() {
+		if other == fh || other.node != fh.node {
+			continue
+		}
+
+		other.mu.Lock()
+		switch {
+		case !other.dirty.Load():
+		case other.staging != nil:
+			res, errno := readAt(other.staging, dest, off)
+			other.mu.Unlock()
+			return dest, res, errno, true
+		case other.tail != nil:
+			base := other.base.Size
+			if off >= base {
+				res, errno := readAt(other.tail, dest, off-base)
+				other.mu.Unlock()
+				return dest, res, errno, true
+			}
+			if end := off + int64(len(dest)); end > base {
+				dest = dest[:base-off]
+			}
+		}
+		other.mu.Unlock()
+	}
+	return dest, nil, 0, false
+}
+
+var _ = (fs.FileWriter)((*koneksiFileHandle)(nil))
+
+func (fh *koneksiFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	if errno := fh.node.checkProcess(ctx, "write", fh.node.path(), true); errno != 0 {
+		return 0, errno
+	}
+	if !fh.node.health.writable() {
+		return 0, syscall.EROFS
+	}
+
+	if errno := fh.node.checkSizePolicy(off + int64(len(data))); errno != 0 {
+		return 0, errno
+	}
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	var n int
+	var err error
+	if fh.appends(off) {
+		if fh.tail == nil {
+			fh.base = fh.node.stat()
+			fh.tail = fh.node.newStaging(fh.base, true)
+		}
+		n, err = fh.tail.WriteAt(data, off-fh.base.Size)
+	} else {
+		if err := fh.ensureStaging(); err != nil {
+			return 0, fh.stagingErrno(err)
+		}
+		n, err = fh.staging.WriteAt(data, off)
+	}
+	if err != nil {
+		return 0, fh.stagingErrno(err)
+	}
+	fh.dirty.Store(true)
+
+	// Update file info
+	fh.node.modifyInfo(func(info *api.FileInfo) {
+		if end := off + int64(n); end > info.Size {
+			info.Size = end
+		}
+		info.Modified = time.Now()
+	})
+
+	return uint32(n), 0
+}
+
+var _ = (fs.FileFlusher)((*koneksiFileHandle)(nil))
+
+// Flush uploads pending changes when the file is closed. Files written
+// back under mount.io_rules are uploaded on release instead, which the
+// kernel sends after close has returned.
+func (fh *koneksiFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.node.trace("flush", fh.node.path())
+	if fh.node.ioRule().WriteBack {
+		return 0
+	}
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	return fh.flushLocked()
+}
+
+var _ = (fs.FileFsyncer)((*koneksiFileHandle)(nil))
+
+func (fh *koneksiFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	fh.node.trace("fsync", fh.node.path())
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	return fh.flushLocked()
+}
+
+var _ = (fs.FileReleaser)((*koneksiFileHandle)(nil))
+
+func (fh *koneksiFileHandle) Release(ctx context.Context) syscall.Errno {
+	fh.node.trace("release", fh.node.path())
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	errno := fh.flushLocked()
+	if errno != 0 && fh.node.ioRule().WriteBack {
+		// Nobody is left to see the error.
+		slog.Error("failed to upload written back file", "path", fh.node.path(), "error", errno)
+	}
+
+	if fh.cached != nil {
+		fh.cached.Close()
+		fh.cached = nil
+	}
+	if fh.stream != nil {
+		fh.stream.Close()
+		fh.stream = nil
+	}
+	// Changes that could not be uploaded stay in the recovery journal,
+	// if there is one, to be uploaded on the next start, unless the
+	// malware scan rejected them.
+	kept := false
+	if fh.staging != nil {
+		if errno != 0 && errno != syscall.EPERM {
+			kept = fh.staging.keep()
+		} else {
+			fh.staging.Close()
+		}
+		fh.staging = nil
+	}
+	if fh.tail != nil && errno != 0 {
+		kept = fh.tail.keep() || kept
+		fh.tail = nil
+		fh.base = nil
+	}
+	fh.dropTail()
+	if kept {
+		slog.Warn("kept changes not uploaded for recovery", "path", fh.node.path())
+	}
+
+	fh.releaseLease()
+	fh.node.handles.remove(fh)
+
+	return errno
+}
+
+func (fh *koneksiFileHandle) flushLocked() syscall.Errno {
+	if !fh.dirty.Load() {
+		return 0
+	}
+
+	if fh.tail != nil {
+		err := fh.node.uploadAppend(fh.base.Size, fh.tail)
+		if err == nil {
+			fh.dropTail()
+			fh.dirty.Store(false)
+			if fh.cached != nil {
+				fh.cached.Close()
+				fh.cached = nil
+			}
+			return 0
+		}
+		if !errors.Is(err, api.ErrAppendUnsupported) && !errors.Is(err, api.ErrAppendMismatch) {
+			fh.node.notifyUploadFailed(err)
+			return apiErrno(err)
+		}
+		// Upload the whole file instead.
+		if err := fh.ensureStaging(); err != nil {
+			return fh.stagingErrno(err)
+		}
+	}
+
+	cached, err := fh.node.upload(fh.staging)
+	if upload.IsRejected(err) {
+		// Scanning the same content again would not change the
+		// verdict. The remote file is as it was.
+		fh.dirty.Store(false)
+		if info, err := fh.node.storage.Stat(context.Background(), fh.node.path()); err == nil {
+			fh.node.updateInfo(info)
+		}
+		fh.node.notifyUploadFailed(err)
+		return syscall.EPERM
+	}
+	if err != nil {
+		fh.node.notifyUploadFailed(err)
+		return apiErrno(err)
+	}
+	fh.dirty.Store(false)
+
+	if fh.node.cache != nil {
+		// The staged content is now the cached copy; further writes
+		// stage it again.
+		fh.staging = nil
+		if fh.cached != nil {
+			fh.cached.Close()
+		}
+		fh.cached = cached
+	}
+	return 0
+}
+
+// truncate resizes the staged content, staging the current content first
+// unless the file is being emptied.
+func (fh *koneksiFileHandle) truncate(size int64) syscall.Errno {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if fh.staging == nil && size == 0 {
+		fh.dropTail()
+		fh.staging = fh.node.newStaging(fh.node.stat(), false)
+	} else if err := fh.ensureStaging(); err != nil {
+		return fh.stagingErrno(err)
+	}
+
+	if err := fh.staging.Truncate(size); err != nil {
+		return fh.stagingErrno(err)
+	}
+	fh.dirty.Store(true)
+
+	fh.node.modifyInfo(func(info *api.FileInfo) {
+		info.Size = size
+		info.Modified = time.Now()
+	})
+
+	return 0
+}
+
+// ensureStaging creates the staging buffer, seeded with the current
+// remote content so partial writes keep the rest of the file intact.
+// Content collected for appending is copied in after it.
+func (fh *koneksiFileHandle) ensureStaging() error {
+	if fh.staging != nil {
+		return nil
+	}
+
+	base := fh.node.stat()
+	if fh.tail != nil {
+		base = fh.base
+	}
+
+	staging := fh.node.newStaging(base, false)
+
+	if base.Size > 0 {
+		if err := staging.Grow(fh.node.stat().Size); err != nil {
+			return err
+		}
+		if err := fh.seed(staging, base); err != nil {
+			staging.Close()
+			return err
+		}
+	}
+
+	if fh.tail != nil {
+		tail := io.NewSectionReader(fh.tail, 0, fh.tail.Size())
+		if _, err := io.Copy(io.NewOffsetWriter(staging, base.Size), tail); err != nil {
+			staging.Close()
+			return err
+		}
+		fh.dropTail()
+	}
+
+	fh.staging = staging
+	return nil
+}
+
+// appends reports whether a write at off only adds to the end of the
+// remote content, so it can be appended without staging the rest. With
+// upload verification, which hashes the whole file, or malware scanning,
+// which checks it, it never does.
+func (fh *koneksiFileHandle) appends(off int64) bool {
+	if fh.staging != nil || fh.node.cfg.Upload.Verify || fh.node.uploader.Scanning() {
+		return false
+	}
+	if fh.tail != nil {
+		return off >= fh.base.Size
+	}
+	size := fh.node.stat().Size
+	return size > 0 && off >= size
+}
+
+func (fh *koneksiFileHandle) dropTail() {
+	if fh.tail != nil {
+		fh.tail.Close()
+		fh.tail = nil
+		fh.base = nil
+	}
+}
+
+func (fh *koneksiFileHandle) seed(dst *stagingBuffer, base *api.FileInfo) error {
+	var src io.Reader
+	if fh.node.cache != nil {
+		// Going through the cache also keeps the original as the base
+		// for a delta upload.
+		if fh.cached == nil {
+			f, err := fh.node.openCached(base)
+			if err != nil {
+				return err
+			}
+			fh.cached = f
+		}
+		src = io.NewSectionReader(fh.cached, 0, 1<<62)
+	} else {
+		reader, err := fh.node.storage.Read(context.Background(), fh.node.path())
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		src = reader
+	}
+
+	_, err := io.Copy(io.NewOffsetWriter(dst, 0), src)
+	return err
+}
+
+// stagingErrno maps a failure to stage a write to the error returned to
+// the caller.
+func (fh *koneksiFileHandle) stagingErrno(err error) syscall.Errno {
+	if errors.Is(err, syscall.ENOSPC) {
+		slog.Error("no space to stage write", "path", fh.node.path(), "error", err)
+		return syscall.ENOSPC
+	}
+	return syscall.EIO
+}
+
+func readAt(f io.ReaderAt, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := f.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Nodes are kept in their parent's children after a lookup or listing, so
+// later lookups are answered from memory. On a long-running mount that
+// touches many files they would pile up forever; collectNodes forgets
+// those the kernel no longer holds, that are not open and that were not
+// used since the previous collection. Forgetting a directory forgets all
+// below it.
+
+var _ = (fs.NodeOnForgetter)((*koneksiNode)(nil))
+
+// OnForget is called when the kernel no longer knows the node, which
+// makes it eligible for collection.
+func (n *koneksiNode) OnForget() {
+	n.referenced.Store(false)
+}
+
+// hold marks the node as about to be handed to the kernel.
+func (n *koneksiNode) hold() {
+	n.referenced.Store(true)
+	n.used.Store(true)
+}
+
+// collectNodes forgets unused nodes every interval until ctx is
+// cancelled.
+func (kfs *KoneksiFS) collectNodes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		kept, dropped := kfs.root.collect(kfs.root.handles.nodes(), false)
+		if dropped > 0 {
+			slog.Debug("forgot unused nodes", "forgotten", dropped, "kept", kept, "duration", time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+// collect forgets the unused children of n and below, given the nodes
+// with open handles, and returns how many nodes were kept and forgotten.
+// Under memory pressure, all forgets nodes that were used recently too.
+func (n *koneksiNode) collect(open map[*koneksiNode]bool, all bool) (kept, dropped int) {
+	children, dropped := n.children.sweep(func(child *koneksiNode) bool {
+		if _, ok := open[child]; ok || child.referenced.Load() {
+			return true
+		}
+		// Used since the last collection: give it another round.
+		return child.used.Swap(false) && !all
+	})
+	if dropped > 0 {
+		n.listed.Store(0)
+	}
+
+	kept = len(children)
+	for _, child := range children {
+		if child.stat().IsDir {
+			k, d := child.collect(open, all)
+			kept += k
+			dropped += d
+		}
+	}
+	return kept, dropped
+}
@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"sort"
+	"sync"
+)
+
+// handleSet tracks the open file handles of a mount so that pending
+// writes can be flushed on demand, not only when files are closed.
+type handleSet struct {
+	mu   sync.Mutex
+	open map[*koneksiFileHandle]struct{}
+
+	renaming sync.Mutex // held while a rename has handles locked
+}
+
+func newHandleSet() *handleSet {
+	return &handleSet{open: make(map[*koneksiFileHandle]struct{})}
+}
+
+func (s *handleSet) add(fh *koneksiFileHandle) {
+	s.mu.Lock()
+	s.open[fh] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *handleSet) remove(fh *koneksiFileHandle) {
+	s.mu.Lock()
+	delete(s.open, fh)
+	s.mu.Unlock()
+}
+
+func (s *handleSet) snapshot() []*koneksiFileHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	handles := make([]*koneksiFileHandle, 0, len(s.open))
+	for fh := range s.open {
+		handles = append(handles, fh)
+	}
+	return handles
+}
+
+// nodes returns the nodes with open handles, mapped to whether any of
+// their handles has changes not yet uploaded.
+func (s *handleSet) nodes() map[*koneksiNode]bool {
+	nodes := make(map[*koneksiNode]bool)
+	for _, fh := range s.snapshot() {
+		nodes[fh.node] = nodes[fh.node] || fh.dirty.Load()
+	}
+	return nodes
+}
+
+// dirty reports whether n has an open handle with changes not yet
+// uploaded.
+func (s *handleSet) dirty(n *koneksiNode) bool {
+	for _, fh := range s.snapshot() {
+		if fh.node == n && fh.dirty.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// counts returns the number of open handles and of those with changes
+// not yet uploaded.
+func (s *handleSet) counts() (open, dirty int) {
+	for _, fh := range s.snapshot() {
+		open++
+		if fh.dirty.Load() {
+			dirty++
+		}
+	}
+	return open, dirty
+}
+
+// dirtyPaths returns the remote paths of the open files with changes not
+// yet uploaded, sorted. With wait, operations in flight on the files, such
+// as uploads, are waited for first.
+func (s *handleSet) dirtyPaths(wait bool) []string {
+	dirty := make(map[string]bool)
+	for _, fh := range s.snapshot() {
+		if wait {
+			fh.mu.Lock()
+			fh.mu.Unlock()
+		}
+		if fh.dirty.Load() {
+			dirty[fh.node.path()] = true
+		}
+	}
+
+	paths := make([]string, 0, len(dirty))
+	for p := range dirty {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// flush uploads the pending changes of every open handle and returns how
+// many were flushed and how many failed.
+func (s *handleSet) flush() (flushed, failed int) {
+	for _, fh := range s.snapshot() {
+		fh.mu.Lock()
+		if fh.dirty.Load() {
+			if errno := fh.flushLocked(); errno != 0 {
+				failed++
+			} else {
+				flushed++
+			}
+		}
+		fh.mu.Unlock()
+	}
+	return flushed, failed
+}
@@ -0,0 +1,127 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/notify"
+)
+
+// probePath is written and deleted to check whether the server accepts
+// writes again after the mount switched to read-only.
+const probePath = "/.koneksi-drive-probe"
+
+// writeHealth switches the mount to read-only once the server keeps
+// refusing writes, e.g. because the quota is exhausted or the token lost
+// its write scope, so applications get EROFS instead of retrying on EIO.
+// While read-only, a probe write runs periodically and restores write
+// access once it succeeds.
+type writeHealth struct {
+	storage  api.StorageBackend
+	cfg      *config.MountConfig
+	notifier *notify.Notifier
+
+	readOnly atomic.Bool
+	forced   atomic.Bool // made read-only by remount
+	draining atomic.Bool // the mount is shutting down
+
+	mu       sync.Mutex
+	denied   int // consecutive denied writes
+	since    time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWriteHealth(storage api.StorageBackend, cfg *config.MountConfig, notifier *notify.Notifier) *writeHealth {
+	return &writeHealth{
+		storage:  storage,
+		cfg:      cfg,
+		notifier: notifier,
+		stop:     make(chan struct{}),
+	}
+}
+
+// writable reports whether write operations should be attempted.
+func (h *writeHealth) writable() bool {
+	return !h.cfg.ReadOnly && !h.forced.Load() && !h.readOnly.Load() && !h.draining.Load()
+}
+
+// record notes the outcome of a write request. Errors other than denied
+// writes, such as network failures, neither count nor reset the streak.
+func (h *writeHealth) record(err error) {
+	if h.cfg.DegradeAfter <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.denied = 0
+		return
+	}
+	if !api.IsWriteDenied(err) {
+		return
+	}
+
+	h.denied++
+	if h.denied < h.cfg.DegradeAfter || h.readOnly.Load() {
+		return
+	}
+
+	h.since = time.Now()
+	h.readOnly.Store(true)
+	slog.Error("server keeps refusing writes, mount is now read-only",
+		"error", err, "failures", h.denied, "probe_interval", h.cfg.ProbeInterval)
+	h.notifier.Notify("read-only", "Koneksi mount is read-only",
+		"The server keeps refusing writes, e.g. because the quota is exhausted: "+err.Error())
+
+	go h.probe()
+}
+
+func (h *writeHealth) probe() {
+	interval := h.cfg.ProbeInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := h.storage.Write(context.Background(), probePath, "text/plain", strings.NewReader("probe"))
+		if err != nil {
+			slog.Debug("write probe failed, mount stays read-only", "error", err)
+			continue
+		}
+		if err := h.storage.Delete(context.Background(), probePath); err != nil {
+			slog.Warn("failed to remove write probe", "path", probePath, "error", err)
+		}
+
+		h.mu.Lock()
+		h.denied = 0
+		h.readOnly.Store(false)
+		since := h.since
+		h.mu.Unlock()
+
+		slog.Info("server accepts writes again, mount is writable",
+			"read_only_for", time.Since(since).Round(time.Second))
+		return
+	}
+}
+
+func (h *writeHealth) close() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
@@ -2,44 +2,219 @@ package fs
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/backend"
+	"github.com/koneksi/koneksi-drive/internal/cache"
 	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/notify"
+	"github.com/koneksi/koneksi-drive/internal/recovery"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+	"github.com/koneksi/koneksi-drive/internal/vault"
 )
 
 type KoneksiFS struct {
-	root   *koneksiNode
-	client *api.Client
-	cfg    *config.Config
-	server *fuse.Server
-	mu     sync.RWMutex
+	root    *koneksiNode
+	storage api.StorageBackend
+	client  *api.Client // nil without the Koneksi API
+	cfg     *config.Config
+	cache   *cache.Cache
+	server  *fuse.Server
+
+	started     time.Time // when the mount was established
+	caps        api.Capabilities
+	stopPreload context.CancelFunc // cancels preloading listings, if started
+	stopGC      context.CancelFunc // stops collecting unused nodes
+	memory      *memoryBudget
+	stopMemory  context.CancelFunc // stops watching memory use, if limited
+	stopRefresh context.CancelFunc // stops refreshing hot listings, if listings are kept
+	stopQuota   context.CancelFunc // stops checking the quota, if notifying
+	stopWorkset context.CancelFunc // stops saving the working set, if recorded
+	mirror      *mirror            // nil unless a mirror mount
+	stopMirror  context.CancelFunc // stops updating the mirror
 }
 
 type koneksiNode struct {
 	fs.Inode
 	
-	path     string
-	info     *api.FileInfo
-	client   *api.Client
-	cfg      *config.Config
-	mu       sync.RWMutex
-	children map[string]*koneksiNode
+	place     atomic.Pointer[place]        // parent and name, nil for the root; replaced on rename
+	info      atomic.Pointer[api.FileInfo] // replaced on change, never modified
+	storage   api.StorageBackend
+	client    *api.Client // nil without the Koneksi API
+	cfg       *config.Config
+	cache     *cache.Cache // nil when content caching is disabled
+	uploader  *upload.Uploader
+	health    *writeHealth
+	handles   *handleSet
+	children  childMap
+	overlay   *overlay    // nil unless mounted over a local upper layer
+	blocks    *blockCache // nil unless files are read in blocks
+	memory    *memoryBudget
+	journal   *recovery.Journal // nil unless changes are journaled for recovery
+	listings  *listingRefresher // nil unless listings are kept for mount.listing_ttl
+	hooks     *hooks.Hooks      // nil without configured hooks
+	notifier  *notify.Notifier  // nil without desktop notifications
+	transfers *transferSet
+	io        *ioScheduler  // ranks API requests by who waits on them
+	workset   *workingSet   // nil unless the working set is recorded
+	live      *liveSettings // options changed by remount
+	// role is the caller's role in the directory when it does not allow
+	// writing, or "" if it does.
+	role string
+	// listed is when the children were last set from a complete listing,
+	// in UnixNano, or 0 if some were forgotten since.
+	listed atomic.Int64
+	// preloaded is the listing fetched at startup, kept until the first
+	// read of the directory.
+	preloaded atomic.Pointer[preloadedListing]
+	// referenced is set while the kernel knows the node, and used when
+	// it was looked up since the last collection of unused nodes.
+	referenced atomic.Bool
+	used       atomic.Bool
+
+	mu sync.RWMutex // guards shareLink, thumb and sharing
+	// shareLink is the last link created through the share xattrs.
+	shareLink *api.ShareLink
+	// thumb is the cached thumbnail, valid while the file's modification
+	// time equals thumbModified.
+	thumb         []byte
+	thumbModified time.Time
+	// sharing is who the node is shared with, as fetched at
+	// sharingFetched for the sharing xattrs.
+	sharing        *api.Sharing
+	sharingFetched time.Time
 }
 
 func NewKoneksiFS(cfg *config.Config) (*KoneksiFS, error) {
-	client, err := api.NewClient(&cfg.API)
+	if cfg.Mount.Offline {
+		return newOfflineFS(cfg)
+	}
+	if cfg.Mount.Mirror {
+		return newMirrorFS(cfg)
+	}
+
+	v, err := vault.Open(&cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	storage, client, err := backend.Open(cfg, cfg.API.DirectoryID, v)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create API client: %w", err)
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	if err := checkRemotePath(storage, cfg.Mount.RemotePath); err != nil {
+		return nil, fmt.Errorf("mount.remote_path %s: %w", cfg.Mount.RemotePath, err)
+	}
+	for _, b := range cfg.Mount.Binds {
+		if err := checkRemotePath(storage, b.Path); err != nil {
+			return nil, fmt.Errorf("mount.map %s=%s: %w", b.Name, b.Path, err)
+		}
+	}
+
+	// Without the Koneksi API there is no token to adapt to.
+	caps := api.Capabilities{Write: true}
+	if client != nil {
+		caps = tokenCapabilities(cfg, client)
+		// So the first operation after mounting does not wait for what the
+		// client does on first use.
+		client.Warm()
+	} else {
+		// Searches and recent files need the server.
+		cfg.Mount.VirtualDir = ""
+	}
+
+	var contentCache *cache.Cache
+	if cfg.Cache.Enabled && cfg.Cache.TTL > 0 {
+		contentCache, err = cache.New(&cfg.Cache, cfg.API.DirectoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newKoneksiFS(cfg, storage, client, caps, contentCache)
+}
+
+// tokenCapabilities returns what the token of client allows, and the
+// role in the directory if it does not allow writing. Without the write
+// scope, the mount is made read-only.
+func tokenCapabilities(cfg *config.Config, client *api.Client) api.Capabilities {
+	// Adapt to what the token allows instead of failing at runtime.
+	caps, err := client.Capabilities()
+	if err != nil {
+		slog.Warn("failed to detect token capabilities, assuming full access", "error", err)
+		caps = api.Capabilities{Write: true, Share: true}
+	}
+	if !caps.Write && !cfg.Mount.ReadOnly && cfg.Mount.OverlayDir == "" {
+		slog.Warn("access token has no write scope, mounting read-only", "scopes", caps.Scopes)
+		cfg.Mount.ReadOnly = true
+	}
+
+	// In a team directory, viewers may read but not write whatever the
+	// token allows. Their writes are denied here rather than after upload.
+	role, err := client.Role(context.Background())
+	switch {
+	case errors.Is(err, api.ErrRoleUnsupported):
+	case err != nil:
+		slog.Warn("failed to get role in directory, not restricting writes", "error", err)
+	default:
+		caps.Role = role
+		if !api.RoleCanWrite(role) && !cfg.Mount.ReadOnly && cfg.Mount.OverlayDir == "" {
+			slog.Warn("role in directory does not allow writing, denying writes", "role", role)
+		}
+	}
+	return caps
+}
+
+// checkRemotePath checks that the remote path p, to be mounted as the
+// root or a folder at the root, is a folder on the server, so a mistyped
+// path fails the mount instead of every operation. Only p itself is
+// looked up; the folders above it are never listed.
+func checkRemotePath(storage api.StorageBackend, p string) error {
+	if p == "/" {
+		return nil
+	}
+	info, err := storage.Stat(context.Background(), p)
+	switch {
+	case api.IsNotFound(err):
+		return fmt.Errorf("no such folder")
+	case err != nil:
+		return fmt.Errorf("failed to look it up: %w", err)
+	case !info.IsDir:
+		return fmt.Errorf("it is a file, not a folder")
+	}
+	return nil
+}
+
+// newKoneksiFS creates the filesystem once the storage and cache are set
+// up. client is nil without the Koneksi API.
+func newKoneksiFS(cfg *config.Config, storage api.StorageBackend, client *api.Client, caps api.Capabilities, contentCache *cache.Cache) (*KoneksiFS, error) {
+	var err error
+	if cfg.Mount.StagingDir != "" {
+		if err := os.MkdirAll(cfg.Mount.StagingDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+	}
+
+	var upper *overlay
+	if cfg.Mount.OverlayDir != "" {
+		upper, err = newOverlay(cfg.Mount.OverlayDir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	rootInfo := &api.FileInfo{
@@ -48,18 +223,60 @@ func NewKoneksiFS(cfg *config.Config) (*KoneksiFS, error) {
 		Modified: time.Now(),
 	}
 
+	var journal *recovery.Journal
+	if !cfg.Mount.CacheOnly() {
+		if journal, err = recovery.OpenConfigured(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	notifier := notify.New(&cfg.Notifications)
 	root := &koneksiNode{
-		path:     "/",
-		info:     rootInfo,
-		client:   client,
-		cfg:      cfg,
-		children: make(map[string]*koneksiNode),
+		storage:   storage,
+		client:    client,
+		cfg:       cfg,
+		cache:     contentCache,
+		uploader:  upload.New(storage, &cfg.Upload),
+		health:    newWriteHealth(storage, &cfg.Mount, notifier),
+		handles:   newHandleSet(),
+		overlay:   upper,
+		memory:    &memoryBudget{limit: cfg.Mount.MemoryLimit},
+		journal:   journal,
+		listings:  newListingRefresher(cfg.Mount.ListingTTL),
+		hooks:     hooks.New(cfg.Hooks, cfg.API.DirectoryID, "mount"),
+		notifier:  notifier,
+		transfers: newTransferSet(),
+		io:        newIOScheduler(),
+		workset:   newWorkingSet(cfg),
+		live:      newLiveSettings(cfg),
+	}
+	// With an overlay, writes stay local and are not the server's concern.
+	if !api.RoleCanWrite(caps.Role) && cfg.Mount.OverlayDir == "" {
+		root.role = caps.Role
+	}
+	if cfg.Mount.BlockSize > 0 && !cfg.Mount.CacheOnly() {
+		root.blocks = newBlockCache(cfg.Mount.BlockSize, cfg.Mount.BlockCacheSize)
+	}
+	root.info.Store(rootInfo)
+
+	if pattern := cfg.Mount.TracePath; pattern != "" && client != nil {
+		client.Trace(func(p string) bool { return config.MatchPath(pattern, p) }, traceLog)
+	}
+
+	if contentCache != nil && len(cfg.Mount.IORules) > 0 {
+		contentCache.SetPriority(func(remotePath string) int {
+			return config.MatchIORule(cfg.Mount.IORules, remotePath).CachePriority
+		})
 	}
 
 	return &KoneksiFS{
-		root:   root,
-		client: client,
-		cfg:    cfg,
+		root:    root,
+		storage: storage,
+		client:  client,
+		cfg:     cfg,
+		cache:   contentCache,
+		caps:    caps,
+		memory:  root.memory,
 	}, nil
 }
 
@@ -74,6 +291,28 @@ func (kfs *KoneksiFS) Mount(mountpoint string) error {
 	if kfs.cfg.Mount.ReadOnly {
 		opts.Options = append(opts.Options, "ro")
 	}
+	// fusermount3 always mounts over files in the mountpoint and
+	// rejects the option; the older fusermount needs it.
+	if kfs.cfg.Mount.NonEmpty && runtime.GOOS == "linux" {
+		if _, err := exec.LookPath("fusermount3"); err != nil {
+			opts.Options = append(opts.Options, "nonempty")
+		}
+	}
+
+	if kfs.root.journal != nil {
+		kfs.root.resumePending()
+	}
+
+	// There is nothing to serve before the first mirror is complete.
+	if kfs.mirror != nil && !kfs.mirror.complete() {
+		slog.Info("mirroring the directory before mounting")
+		if err := checkRemotePath(kfs.mirror.storage, kfs.root.path()); err != nil {
+			return fmt.Errorf("mount.remote_path %s: %w", kfs.root.path(), err)
+		}
+		if err := kfs.mirror.sync(context.Background()); err != nil {
+			return fmt.Errorf("failed to mirror directory: %w", err)
+		}
+	}
 
 	server, err := fs.Mount(mountpoint, kfs.root, &fs.Options{
 		MountOptions: *opts,
@@ -82,15 +321,88 @@ func (kfs *KoneksiFS) Mount(mountpoint string) error {
 		return fmt.Errorf("mount failed: %w", err)
 	}
 
+	// fs.Mount has already started serving requests.
 	kfs.server = server
-	go server.Serve()
-	
+	kfs.started = time.Now()
+
+	if interval := kfs.cfg.Mount.NodeGCInterval; interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopGC = cancel
+		go kfs.collectNodes(ctx, interval)
+	}
+
+	if kfs.memory.limit > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopMemory = cancel
+		go kfs.watchMemory(ctx)
+	}
+
+	if kfs.root.listings != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopRefresh = cancel
+		go kfs.refreshListings(ctx)
+	}
+
+	if kfs.root.notifier != nil && kfs.cfg.Notifications.QuotaWarning > 0 && kfs.client != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopQuota = cancel
+		go kfs.watchQuota(ctx, kfs.cfg.Notifications.QuotaInterval)
+	}
+
+	if kfs.root.workset != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopWorkset = cancel
+		go kfs.saveWorkingSet(ctx)
+	}
+
+	if kfs.mirror != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopMirror = cancel
+		go kfs.mirror.run(ctx)
+	}
+
+	if depth := kfs.cfg.Mount.PreloadDepth; depth > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		kfs.stopPreload = cancel
+		go kfs.root.preload(ctx, depth)
+	}
+
 	return nil
 }
 
 func (kfs *KoneksiFS) Unmount() error {
+	if kfs.stopPreload != nil {
+		kfs.stopPreload()
+	}
+	if kfs.stopGC != nil {
+		kfs.stopGC()
+	}
+	if kfs.stopMemory != nil {
+		kfs.stopMemory()
+	}
+	if kfs.stopRefresh != nil {
+		kfs.stopRefresh()
+	}
+	if kfs.stopQuota != nil {
+		kfs.stopQuota()
+	}
+	if kfs.stopMirror != nil {
+		kfs.stopMirror()
+	}
+	if kfs.stopWorkset != nil {
+		kfs.stopWorkset()
+	}
 	if kfs.server != nil {
-		return kfs.server.Unmount()
+		if err := kfs.server.Unmount(); err != nil {
+			return err
+		}
+	}
+	kfs.root.workset.save()
+	kfs.root.health.close()
+	kfs.root.hooks.Close(kfs.cfg.Mount.FlushTimeout)
+	kfs.root.notifier.Wait()
+	if kfs.cache != nil {
+		return kfs.cache.Close()
 	}
 	return nil
 }
@@ -101,63 +413,77 @@ var _ = (fs.InodeEmbedder)((*koneksiNode)(nil))
 // Implement fs.NodeLookuper
 var _ = (fs.NodeLookuper)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	n.mu.RLock()
-	child, ok := n.children[name]
-	n.mu.RUnlock()
+func (n *koneksiNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (_ *fs.Inode, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "lookup", &errno, n.path())
+	defer end()
+	n.processRule(ctx, "lookup", filepath.Join(n.path(), name))
 
-	if ok {
-		n.setAttr(&out.Attr, child.info)
-		return n.NewInode(ctx, child, n.stableAttr(child.info)), 0
+	if n.IsRoot() && name != "" && name == n.cfg.Mount.VirtualDir {
+		node := &virtualDirNode{client: n.client, cfg: n.cfg}
+		setVirtualDirAttr(&out.Attr, n.cfg)
+		return n.NewInode(ctx, node, fs.StableAttr{Mode: syscall.S_IFDIR}), 0
 	}
 
-	// Try to fetch from API
-	childPath := filepath.Join(n.path, name)
-	files, err := n.client.List(n.path)
-	if err != nil {
+	if child, ok := n.children.get(name); ok {
+		child.hold()
+		// Unused nodes may have been collected meanwhile.
+		child = n.children.add(name, child)
+		info := child.stat()
+		n.setAttr(&out.Attr, info)
+		child.setEntryTimeout(out)
+		return n.NewInode(ctx, child, n.stableAttr(info)), 0
+	}
+
+	// A fresh listing lacking the name needs no asking again.
+	n.touchListing()
+	if n.listingFresh() {
 		return nil, syscall.ENOENT
 	}
 
+	// Try to fetch from API. Failing to list is not the name missing.
+	files, err := n.list(ctx)
+	if err != nil {
+		return nil, apiErrno(err)
+	}
+
 	for _, file := range files {
 		if file.Name == name {
-			child = &koneksiNode{
-				path:     childPath,
-				info:     &file,
-				client:   n.client,
-				cfg:      n.cfg,
-				children: make(map[string]*koneksiNode),
-			}
-
-			n.mu.Lock()
-			n.children[name] = child
-			n.mu.Unlock()
-
-			n.setAttr(&out.Attr, &file)
-			return n.NewInode(ctx, child, n.stableAttr(&file)), 0
+			// Another lookup may have found it meanwhile.
+			child := n.children.add(name, n.newChild(name, file))
+			child.hold()
+			info := child.stat()
+			n.setAttr(&out.Attr, info)
+			child.setEntryTimeout(out)
+			return n.NewInode(ctx, child, n.stableAttr(info)), 0
 		}
 	}
 
 	return nil, syscall.ENOENT
 }
 
-// Implement fs.NodeReaddirer
-var _ = (fs.NodeReaddirer)((*koneksiNode)(nil))
-
-func (n *koneksiNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	if !n.info.IsDir {
-		return nil, syscall.ENOTDIR
+// readdirEntries lists the directory for a directory handle, replacing
+// the known children with the listing. Each entry's offset is its
+// position in the result. A preloaded listing, or the known children
+// within mount.listing_ttl of the last listing, are used instead of
+// listing again.
+func (n *koneksiNode) readdirEntries(ctx context.Context) (_ []fuse.DirEntry, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "readdir", &errno, n.path())
+	defer end()
+	n.touchListing()
+	files, ok := n.takePreloaded()
+	if !ok {
+		files, ok = n.knownListing()
 	}
-
-	files, err := n.client.List(n.path)
-	if err != nil {
-		return nil, syscall.EIO
+	if !ok {
+		var err error
+		files, err = n.list(ctx)
+		if err != nil {
+			return nil, apiErrno(err)
+		}
+		n.setChildren(files)
 	}
 
 	entries := make([]fuse.DirEntry, 0, len(files))
-	
-	n.mu.Lock()
-	n.children = make(map[string]*koneksiNode)
-	
 	for _, file := range files {
 		mode := uint32(syscall.S_IFREG)
 		if file.IsDir {
@@ -167,141 +493,400 @@ func (n *koneksiNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno)
 		entries = append(entries, fuse.DirEntry{
 			Name: file.Name,
 			Mode: mode,
+			Off:  uint64(len(entries) + 1),
 		})
+	}
+
+	return entries, 0
+}
+
+// setChildren updates the known children from the listing files and
+// returns the nodes of the listed entries. Nodes of entries still listed
+// are kept, so the inodes the kernel knows and the handles open on them
+// stay attached, and take the listed metadata unless they have changes
+// not yet uploaded. Entries no longer listed are forgotten unless open.
+func (n *koneksiNode) setChildren(files []api.FileInfo) map[string]*koneksiNode {
+	listing := make(map[string]api.FileInfo, len(files))
+	for _, file := range files {
+		listing[file.Name] = file
+	}
+	open := n.handles.nodes()
 
-		child := &koneksiNode{
-			path:     filepath.Join(n.path, file.Name),
-			info:     &file,
-			client:   n.client,
-			cfg:      n.cfg,
-			children: make(map[string]*koneksiNode),
+	merge := func(old *koneksiNode, file api.FileInfo) *koneksiNode {
+		if old == nil || old.stat().IsDir != file.IsDir {
+			return n.newChild(file.Name, file)
 		}
-		n.children[file.Name] = child
+		if dirty := open[old]; !dirty {
+			old.updateInfo(&file)
+		}
+		old.used.Store(true)
+		return old
+	}
+	keep := func(child *koneksiNode) bool {
+		_, ok := open[child]
+		return ok
 	}
-	n.mu.Unlock()
+	defer n.listed.Store(time.Now().UnixNano())
+	return n.children.sync(listing, merge, keep)
+}
 
-	return fs.NewListDirStream(entries), 0
+// list returns the directory's entries, merged with the upper layer on
+// overlay mounts.
+func (n *koneksiNode) list(ctx context.Context) ([]api.FileInfo, error) {
+	if n.composite() {
+		return n.bindListing(), nil
+	}
+	if n.overlay != nil {
+		return n.overlay.list(n.path(), func(dir string) ([]api.FileInfo, error) { return n.listRemote(ctx, dir) })
+	}
+	return n.listRemote(ctx, n.path())
 }
 
 // Implement fs.NodeGetattrer
 var _ = (fs.NodeGetattrer)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
-	n.setAttr(&out.Attr, n.info)
+func (n *koneksiNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "getattr", &errno, n.path())
+	defer end()
+	n.processRule(ctx, "getattr", n.path())
+	if n.consistency() == "strict" && !n.stat().IsDir {
+		if errno := n.revalidate(ctx); errno != 0 {
+			return errno
+		}
+	}
+	n.setAttr(&out.Attr, n.stat())
+	if t := n.kernelTimeout(); t > 0 {
+		out.SetTimeout(t)
+	}
 	return 0
 }
 
+// Implement fs.NodeSetattrer
+var _ = (fs.NodeSetattrer)((*koneksiNode)(nil))
+
+func (n *koneksiNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) (errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "setattr", &errno, n.path())
+	defer end()
+	_, resize := in.GetSize()
+	if errno := n.checkProcess(ctx, "setattr", n.path(), resize); errno != 0 {
+		return errno
+	}
+	if fh, ok := f.(*koneksiFileHandle); resize && !(ok && fh.created) {
+		// Files being created may still be truncated by their creator.
+		if errno := n.checkAppendOnly("truncate", n.path()); errno != 0 {
+			return errno
+		}
+	}
+	if resize {
+		if errno := n.checkRetention("truncate", n.path(), n.stat()); errno != 0 {
+			return errno
+		}
+	}
+
+	if size, ok := in.GetSize(); ok && n.overlay != nil {
+		if n.stat().IsDir {
+			return syscall.EISDIR
+		}
+		if errno := n.overlayTruncate(int64(size)); errno != 0 {
+			return errno
+		}
+	} else if ok {
+		if !n.health.writable() {
+			return syscall.EROFS
+		}
+		if n.stat().IsDir {
+			return syscall.EISDIR
+		}
+		if errno := n.checkSizePolicy(int64(size)); errno != 0 {
+			return errno
+		}
+
+		fh, ok := f.(*koneksiFileHandle)
+		if !ok {
+			// truncate(2) on a path: stage, truncate and upload right away.
+			fh = &koneksiFileHandle{node: n}
+			if errno := fh.acquireLease(ctx); errno != 0 {
+				return errno
+			}
+			defer fh.Release(ctx)
+		}
+		if errno := fh.truncate(int64(size)); errno != 0 {
+			return errno
+		}
+		if !ok {
+			if errno := fh.Fsync(ctx, 0); errno != 0 {
+				return errno
+			}
+		}
+	}
+
+	// Timestamps and permissions are not stored remotely; report the
+	// current attributes so utimes/chmod callers do not fail.
+	return n.Getattr(ctx, f, out)
+}
+
 // Implement fs.NodeOpener
 var _ = (fs.NodeOpener)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
-	if n.info.IsDir {
+func (n *koneksiNode) Open(ctx context.Context, flags uint32) (_ fs.FileHandle, _ uint32, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "open", &errno, n.path())
+	defer end()
+	if n.stat().IsDir {
 		return nil, 0, syscall.EISDIR
 	}
+	proc := n.processRule(ctx, "open", n.path())
+	if (proc.DenyWrites || n.role != "") && flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EACCES
+	}
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		if errno := n.checkAppendOnly("open for writing", n.path()); errno != 0 {
+			return nil, 0, errno
+		}
+		if errno := n.checkRetention("open for writing", n.path(), n.stat()); errno != 0 {
+			return nil, 0, errno
+		}
+	}
+
+	if n.overlay != nil {
+		if _, ok := n.overlay.stat(n.path()); ok || flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+			return n.overlayOpen(flags)
+		}
+	}
+
+	if n.cfg.Mount.CacheOnly() {
+		info := n.stat()
+		if !n.cache.Cached(n.path(), info.Size, info.Modified, info.Hash) {
+			return nil, 0, syscall.ENETUNREACH
+		}
+	}
 
-	if n.cfg.Mount.ReadOnly && (flags&(syscall.O_WRONLY|syscall.O_RDWR)) != 0 {
+	if !n.health.writable() && (flags&(syscall.O_WRONLY|syscall.O_RDWR)) != 0 {
 		return nil, 0, syscall.EROFS
 	}
 
-	return &koneksiFileHandle{node: n, flags: flags}, fuse.FOPEN_DIRECT_IO, 0
+	if n.revalidatesOnOpen() {
+		if errno := n.revalidate(ctx); errno != 0 {
+			return nil, 0, errno
+		}
+	}
+
+	fh := &koneksiFileHandle{node: n, flags: flags, proc: proc}
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		if errno := n.checkTypePolicy(n.path()); errno != 0 {
+			return nil, 0, errno
+		}
+		if errno := fh.acquireLease(ctx); errno != 0 {
+			return nil, 0, errno
+		}
+	}
+	n.handles.add(fh)
+
+	return fh, fuse.FOPEN_DIRECT_IO, 0
 }
 
 // Implement fs.NodeCreater
 var _ = (fs.NodeCreater)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
-	if n.cfg.Mount.ReadOnly {
+func (n *koneksiNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (_ *fs.Inode, _ fs.FileHandle, _ uint32, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "create", &errno, n.path())
+	defer end()
+	if errno := n.checkProcess(ctx, "create", filepath.Join(n.path(), name), true); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	if n.overlay != nil {
+		return n.overlayCreate(ctx, name, flags, out)
+	}
+	if !n.health.writable() {
 		return nil, nil, 0, syscall.EROFS
 	}
 
-	childPath := filepath.Join(n.path, name)
+	childPath := filepath.Join(n.path(), name)
+	if errno := n.checkName(name, childPath); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	if errno := n.checkTypePolicy(childPath); errno != 0 {
+		return nil, nil, 0, errno
+	}
 	
 	// Create empty file
-	if err := n.client.Write(childPath, strings.NewReader("")); err != nil {
-		return nil, nil, 0, syscall.EIO
+	contentType := n.uploader.ContentType(childPath, nil, 0)
+	err := api.Create(ctx, n.storage, childPath, contentType, strings.NewReader(""))
+	n.health.record(err)
+	if api.IsExists(err) {
+		return n.createExisting(ctx, name, flags, out)
+	}
+	if err != nil {
+		return nil, nil, 0, apiErrno(err)
 	}
 
-	info := &api.FileInfo{
+	info := api.FileInfo{
 		Name:     name,
 		Size:     0,
 		IsDir:    false,
 		Modified: time.Now(),
 		Path:     childPath,
+		Owner:    n.callerOwner(ctx),
 	}
+	n.recordOwner(ctx, childPath, info.Owner)
 
-	child := &koneksiNode{
-		path:     childPath,
-		info:     info,
-		client:   n.client,
-		cfg:      n.cfg,
-		children: make(map[string]*koneksiNode),
+	child := n.newChild(name, info)
+	n.children.set(name, child)
+
+	n.setAttr(&out.Attr, &info)
+	child.hold()
+	inode := n.NewInode(ctx, child, n.stableAttr(&info))
+	fh := &koneksiFileHandle{node: child, flags: flags, created: true}
+	if errno := fh.acquireLease(ctx); errno != 0 {
+		return nil, nil, 0, errno
 	}
+	n.handles.add(fh)
 
-	n.mu.Lock()
-	n.children[name] = child
-	n.mu.Unlock()
+	return inode, fh, fuse.FOPEN_DIRECT_IO, 0
+}
 
+// createExisting completes the creation of the file name, which the
+// server reports to exist although the folder listing lacked it: with
+// O_EXCL it fails with EEXIST, otherwise the existing file is opened, and
+// emptied for O_TRUNC, as it would have been had it been listed.
+func (n *koneksiNode) createExisting(ctx context.Context, name string, flags uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	child := n.refreshChild(ctx, name)
+	if child == nil || flags&syscall.O_EXCL != 0 {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	if errno := n.checkAppendOnly("open for writing", child.path()); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	if errno := n.checkRetention("open for writing", child.path(), child.stat()); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	info := child.stat()
+	if info.IsDir {
+		return nil, nil, 0, syscall.EISDIR
+	}
+
+	child.hold()
 	n.setAttr(&out.Attr, info)
 	inode := n.NewInode(ctx, child, n.stableAttr(info))
 	fh := &koneksiFileHandle{node: child, flags: flags}
+	if errno := fh.acquireLease(ctx); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	n.handles.add(fh)
+	if flags&syscall.O_TRUNC != 0 && info.Size > 0 {
+		if errno := fh.truncate(0); errno != 0 {
+			fh.Release(ctx)
+			return nil, nil, 0, errno
+		}
+		n.setAttr(&out.Attr, child.stat())
+	}
 
 	return inode, fh, fuse.FOPEN_DIRECT_IO, 0
 }
 
+// refreshChild lists the folder again after the server reported that
+// name exists although it was not known, and returns its node, or nil if
+// it still is not listed.
+func (n *koneksiNode) refreshChild(ctx context.Context, name string) *koneksiNode {
+	files, err := n.list(ctx)
+	if err != nil {
+		slog.Warn("failed to list folder after conflict", "path", n.path(), "error", err)
+		return nil
+	}
+	return n.setChildren(files)[name]
+}
+
 // Implement fs.NodeMkdirer
 var _ = (fs.NodeMkdirer)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	if n.cfg.Mount.ReadOnly {
+func (n *koneksiNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (_ *fs.Inode, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "mkdir", &errno, n.path())
+	defer end()
+	if errno := n.checkProcess(ctx, "mkdir", filepath.Join(n.path(), name), true); errno != 0 {
+		return nil, errno
+	}
+	if n.overlay != nil {
+		return n.overlayMkdir(ctx, name, out)
+	}
+	if !n.health.writable() {
 		return nil, syscall.EROFS
 	}
 
-	childPath := filepath.Join(n.path, name)
+	childPath := filepath.Join(n.path(), name)
+	if errno := n.checkName(name, childPath); errno != 0 {
+		return nil, errno
+	}
 	
-	if err := n.client.Mkdir(childPath); err != nil {
-		return nil, syscall.EIO
+	err := n.storage.Mkdir(ctx, childPath)
+	n.health.record(err)
+	if api.IsExists(err) {
+		// Created elsewhere since the folder was listed; list it again so
+		// the entry is found from now on.
+		n.refreshChild(ctx, name)
+		return nil, syscall.EEXIST
+	}
+	if err != nil {
+		return nil, apiErrno(err)
 	}
 
-	info := &api.FileInfo{
+	info := api.FileInfo{
 		Name:     name,
 		IsDir:    true,
 		Modified: time.Now(),
 		Path:     childPath,
+		Owner:    n.callerOwner(ctx),
 	}
+	n.recordOwner(ctx, childPath, info.Owner)
 
-	child := &koneksiNode{
-		path:     childPath,
-		info:     info,
-		client:   n.client,
-		cfg:      n.cfg,
-		children: make(map[string]*koneksiNode),
-	}
-
-	n.mu.Lock()
-	n.children[name] = child
-	n.mu.Unlock()
+	child := n.newChild(name, info)
+	n.children.set(name, child)
 
-	n.setAttr(&out.Attr, info)
-	return n.NewInode(ctx, child, n.stableAttr(info)), 0
+	n.setAttr(&out.Attr, &info)
+	child.hold()
+	return n.NewInode(ctx, child, n.stableAttr(&info)), 0
 }
 
 // Implement fs.NodeUnlinker
 var _ = (fs.NodeUnlinker)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Unlink(ctx context.Context, name string) syscall.Errno {
-	if n.cfg.Mount.ReadOnly {
+func (n *koneksiNode) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "unlink", &errno, filepath.Join(n.path(), name))
+	defer end()
+	if errno := n.checkProcess(ctx, "unlink", filepath.Join(n.path(), name), true); errno != 0 {
+		return errno
+	}
+	if errno := n.checkAppendOnly("unlink", filepath.Join(n.path(), name)); errno != 0 {
+		return errno
+	}
+	if child, ok := n.children.get(name); ok {
+		if errno := n.checkRetention("unlink", child.path(), child.stat()); errno != 0 {
+			return errno
+		}
+	}
+	if n.overlay != nil {
+		return n.overlayRemove(ctx, name, false)
+	}
+	return n.remove(ctx, name)
+}
+
+// remove deletes the entry name, a file or an empty directory.
+func (n *koneksiNode) remove(ctx context.Context, name string) syscall.Errno {
+	if !n.health.writable() {
 		return syscall.EROFS
 	}
 
-	childPath := filepath.Join(n.path, name)
+	childPath := filepath.Join(n.path(), name)
 	
-	if err := n.client.Delete(childPath); err != nil {
-		return syscall.EIO
+	err := n.storage.Delete(ctx, childPath)
+	n.health.record(err)
+	if err != nil {
+		return apiErrno(err)
+	}
+	if n.cache != nil {
+		n.cache.Remove(childPath)
 	}
 
-	n.mu.Lock()
-	delete(n.children, name)
-	n.mu.Unlock()
+	n.children.remove(name)
+	n.hooks.Fire(hooks.Event{Event: hooks.Delete, Path: childPath})
 
 	return 0
 }
@@ -309,8 +894,19 @@ func (n *koneksiNode) Unlink(ctx context.Context, name string) syscall.Errno {
 // Implement fs.NodeRmdirer
 var _ = (fs.NodeRmdirer)((*koneksiNode)(nil))
 
-func (n *koneksiNode) Rmdir(ctx context.Context, name string) syscall.Errno {
-	return n.Unlink(ctx, name)
+func (n *koneksiNode) Rmdir(ctx context.Context, name string) (errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "rmdir", &errno, filepath.Join(n.path(), name))
+	defer end()
+	if errno := n.checkProcess(ctx, "rmdir", filepath.Join(n.path(), name), true); errno != 0 {
+		return errno
+	}
+	if errno := n.checkAppendOnly("rmdir", filepath.Join(n.path(), name)); errno != 0 {
+		return errno
+	}
+	if n.overlay != nil {
+		return n.overlayRemove(ctx, name, true)
+	}
+	return n.remove(ctx, name)
 }
 
 func (n *koneksiNode) setAttr(attr *fuse.Attr, info *api.FileInfo) {
@@ -325,8 +921,28 @@ func (n *koneksiNode) setAttr(attr *fuse.Attr, info *api.FileInfo) {
 		attr.Mode = syscall.S_IFREG | 0644
 	}
 	
+	if n.role != "" {
+		attr.Mode &^= 0222
+	}
+
 	attr.Uid = n.cfg.Mount.UID
 	attr.Gid = n.cfg.Mount.GID
+	if info.Owner != nil {
+		attr.Uid = info.Owner.UID
+		attr.Gid = info.Owner.GID
+	}
+
+	// The root folder is the mountpoint, which may need to be presented
+	// differently from the folders in it.
+	if n.IsRoot() {
+		if mode := n.cfg.Mount.RootMode; mode != 0 {
+			attr.Mode = syscall.S_IFDIR | mode
+		}
+		if n.cfg.Mount.RootOwner != "" {
+			attr.Uid = n.cfg.Mount.RootUID
+			attr.Gid = n.cfg.Mount.RootGID
+		}
+	}
 }
 
 func (n *koneksiNode) stableAttr(info *api.FileInfo) fs.StableAttr {
@@ -339,89 +955,92 @@ func (n *koneksiNode) stableAttr(info *api.FileInfo) fs.StableAttr {
 	}
 }
 
-// File handle implementation
-type koneksiFileHandle struct {
-	node  *koneksiNode
-	flags uint32
+// newChild creates a node for the entry name below n sharing its client,
+// configuration and cache. info is copied so nodes never alias the
+// caller's listing.
+func (n *koneksiNode) newChild(name string, info api.FileInfo) *koneksiNode {
+	child := &koneksiNode{
+		storage:   n.storage,
+		client:    n.client,
+		cfg:       n.cfg,
+		cache:     n.cache,
+		uploader:  n.uploader,
+		health:    n.health,
+		handles:   n.handles,
+		overlay:   n.overlay,
+		blocks:    n.blocks,
+		memory:    n.memory,
+		journal:   n.journal,
+		listings:  n.listings,
+		hooks:     n.hooks,
+		notifier:  n.notifier,
+		transfers: n.transfers,
+		io:        n.io,
+		workset:   n.workset,
+		live:      n.live,
+		role:      n.role,
+	}
+	child.place.Store(&place{parent: n, name: name})
+	child.info.Store(&info)
+	child.used.Store(true)
+	return child
 }
 
-var _ = (fs.FileReader)((*koneksiFileHandle)(nil))
-
-func (fh *koneksiFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	reader, err := fh.node.client.Read(fh.node.path)
-	if err != nil {
-		return nil, syscall.EIO
-	}
-	defer reader.Close()
+// place is where a node is in the tree. Nodes know their parent rather
+// than their path, so renaming a folder moves everything below it at once.
+type place struct {
+	parent *koneksiNode
+	name   string
+}
 
-	// Skip to offset
-	if off > 0 {
-		if _, err := io.CopyN(io.Discard, reader, off); err != nil {
-			if err == io.EOF {
-				return fuse.ReadResultData(nil), 0
-			}
-			return nil, syscall.EIO
-		}
+// path returns the path of the node on the server, following a rename of
+// the node or of a folder above it. The root is mount.remote_path, and
+// the folders at the root of a composite mount are where mount.map binds
+// them.
+func (n *koneksiNode) path() string {
+	pl := n.place.Load()
+	if pl == nil {
+		return n.cfg.Mount.RemotePath
 	}
-
-	n, err := io.ReadFull(reader, dest)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return nil, syscall.EIO
+	if pl.parent.composite() {
+		return n.boundPath(pl.name)
 	}
-
-	return fuse.ReadResultData(dest[:n]), 0
+	return filepath.Join(pl.parent.path(), pl.name)
 }
 
-var _ = (fs.FileWriter)((*koneksiFileHandle)(nil))
-
-func (fh *koneksiFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
-	if fh.node.cfg.Mount.ReadOnly {
-		return 0, syscall.EROFS
-	}
-
-	// For simplicity, we'll implement write as a full file replacement
-	// A production implementation would handle partial writes properly
-	tempFile, err := os.CreateTemp("", "koneksi-write-*")
-	if err != nil {
-		return 0, syscall.EIO
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
+// pathWithin reports whether the remote path p is dir or below it.
+func pathWithin(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
 
-	// If offset is not 0, we need to read existing content first
-	if off > 0 {
-		reader, err := fh.node.client.Read(fh.node.path)
-		if err != nil {
-			return 0, syscall.EIO
-		}
-		defer reader.Close()
+// stat returns the node metadata. Updates replace the FileInfo instead of
+// modifying it, so it can be read without locking.
+func (n *koneksiNode) stat() *api.FileInfo {
+	return n.info.Load()
+}
 
-		if _, err := io.CopyN(tempFile, reader, off); err != nil && err != io.EOF {
-			return 0, syscall.EIO
+// modifyInfo applies fn to a copy of the node metadata and stores the
+// result, retrying if another update got there first.
+func (n *koneksiNode) modifyInfo(fn func(info *api.FileInfo)) {
+	for {
+		old := n.info.Load()
+		info := *old
+		fn(&info)
+		if n.info.CompareAndSwap(old, &info) {
+			return
 		}
 	}
+}
 
-	// Write new data
-	n, err := tempFile.Write(data)
-	if err != nil {
-		return 0, syscall.EIO
-	}
-
-	// Seek to beginning for upload
-	if _, err := tempFile.Seek(0, 0); err != nil {
-		return 0, syscall.EIO
-	}
-
-	// Upload file
-	if err := fh.node.client.Write(fh.node.path, tempFile); err != nil {
-		return 0, syscall.EIO
-	}
-
-	// Update file info
-	fh.node.mu.Lock()
-	fh.node.info.Size = off + int64(n)
-	fh.node.info.Modified = time.Now()
-	fh.node.mu.Unlock()
-
-	return uint32(n), 0
-}
\ No newline at end of file
+// updateInfo replaces the node metadata with a fresh copy from the server.
+func (n *koneksiNode) updateInfo(fresh *api.FileInfo) {
+	n.modifyInfo(func(info *api.FileInfo) {
+		info.Size = fresh.Size
+		info.Modified = fresh.Modified
+		info.Hash = fresh.Hash
+		info.RetainUntil = fresh.RetainUntil
+		if fresh.Owner != nil {
+			info.Owner = fresh.Owner
+		}
+	})
+}
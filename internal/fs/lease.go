@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// leaseRetry is how often a conflicting lease is asked for again while
+// waiting for it under mount.lease_wait.
+const leaseRetry = time.Second
+
+// acquireLease takes a write lease for a handle opened for writing and
+// keeps it renewed until the handle is released, so other clients cannot
+// modify the file while it is open here. If another client holds one,
+// it waits up to mount.lease_wait for it to be released, unless the file
+// was opened non-blocking, then fails with EBUSY, or under advisory
+// mount.lease_mode opens the file anyway without a lease. Without the
+// Koneksi API there are no leases to take.
+func (fh *koneksiFileHandle) acquireLease(ctx context.Context) syscall.Errno {
+	cfg := fh.node.cfg.Mount
+	if !cfg.Leases || fh.node.client == nil {
+		return 0
+	}
+
+	deadline := time.Now().Add(cfg.LeaseWait)
+	if fh.flags&syscall.O_NONBLOCK != 0 {
+		deadline = time.Time{}
+	}
+
+	var lease *api.Lease
+	for {
+		var err error
+		lease, err = fh.node.client.AcquireLease(ctx, fh.node.path(), cfg.LeaseTTL)
+
+		var locked *api.LockedError
+		switch {
+		case err == nil:
+		case errors.Is(err, api.ErrLeasesUnsupported):
+			return 0
+		case errors.As(err, &locked):
+			if wait := time.Until(deadline); wait > 0 {
+				fh.node.trace("lease wait", fh.node.path(), "holder", locked.Holder)
+				select {
+				case <-time.After(min(wait, leaseRetry)):
+					continue
+				case <-ctx.Done():
+					return syscall.EINTR
+				}
+			}
+			if cfg.LeaseMode == "advisory" {
+				slog.Warn("file is locked by another client, opening it anyway",
+					"path", fh.node.path(), "holder", locked.Holder, "expires", locked.ExpiresAt)
+				return 0
+			}
+			slog.Warn("file is locked by another client",
+				"path", fh.node.path(), "holder", locked.Holder, "expires", locked.ExpiresAt)
+			return syscall.EBUSY
+		default:
+			slog.Warn("failed to acquire lease", "path", fh.node.path(), "error", err)
+			return syscall.EIO
+		}
+		break
+	}
+
+	fh.leaseMu.Lock()
+	fh.lease = lease
+	fh.leaseStop = make(chan struct{})
+	go fh.renewLease(fh.leaseStop)
+	fh.leaseMu.Unlock()
+
+	return 0
+}
+
+func (fh *koneksiFileHandle) renewLease(stop chan struct{}) {
+	ttl := fh.node.cfg.Mount.LeaseTTL
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		fh.leaseMu.Lock()
+		lease := fh.lease
+		fh.leaseMu.Unlock()
+		if lease == nil {
+			return
+		}
+
+		renewed, err := fh.node.client.RenewLease(context.Background(), fh.node.path(), lease, ttl)
+		if err != nil {
+			slog.Warn("failed to renew lease", "path", fh.node.path(), "error", err)
+			continue
+		}
+
+		fh.leaseMu.Lock()
+		if fh.lease != nil {
+			fh.lease = renewed
+		}
+		fh.leaseMu.Unlock()
+	}
+}
+
+// releaseLease stops renewing and gives up the lease, if one is held.
+func (fh *koneksiFileHandle) releaseLease() {
+	fh.leaseMu.Lock()
+	lease := fh.lease
+	fh.lease = nil
+	if fh.leaseStop != nil {
+		close(fh.leaseStop)
+		fh.leaseStop = nil
+	}
+	fh.leaseMu.Unlock()
+
+	if lease == nil {
+		return
+	}
+	if err := fh.node.client.ReleaseLease(context.Background(), fh.node.path(), lease); err != nil {
+		slog.Warn("failed to release lease", "path", fh.node.path(), "error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryCheckInterval is how often memory use is compared with the
+	// configured limit.
+	memoryCheckInterval = 5 * time.Second
+	// memoryRelief is the share of the limit memory use must drop below
+	// before pressure is considered over, so it does not flap.
+	memoryRelief = 0.9
+	// nodeOverhead approximates the memory of a node besides its path
+	// and name.
+	nodeOverhead = 512
+)
+
+// MemoryUsage is the approximate memory held by a mount's metadata,
+// buffers and caches, in bytes.
+type MemoryUsage struct {
+	Nodes     int   `json:"nodes"`
+	Metadata  int64 `json:"metadata_bytes"`
+	Buffers   int64 `json:"write_buffer_bytes"`
+	Readahead int64 `json:"readahead_bytes"`
+	Blocks    int64 `json:"block_cache_bytes"`
+	Total     int64 `json:"total_bytes"`
+	Limit     int64 `json:"limit_bytes,omitempty"` // 0 for no limit
+	Pressure  bool  `json:"under_pressure,omitempty"`
+}
+
+// memoryBudget tells the parts of a mount holding memory whether it is
+// over its limit. Under pressure, streams stop reading ahead and new
+// writes are staged on disk right away.
+type memoryBudget struct {
+	limit    int64
+	pressure atomic.Bool
+}
+
+// underPressure reports whether memory use is over the limit. It is safe
+// to call on a nil budget.
+func (m *memoryBudget) underPressure() bool {
+	return m != nil && m.pressure.Load()
+}
+
+// MemoryUsage returns the approximate memory the mount holds.
+func (kfs *KoneksiFS) MemoryUsage() MemoryUsage {
+	var u MemoryUsage
+	kfs.root.countNodes(&u)
+
+	for _, fh := range kfs.root.handles.snapshot() {
+		// A handle may be busy reading from the server; an estimate
+		// without it is better than waiting.
+		if !fh.mu.TryLock() {
+			continue
+		}
+		if fh.staging != nil {
+			u.Buffers += int64(cap(fh.staging.mem))
+		}
+		if fh.tail != nil {
+			u.Buffers += int64(cap(fh.tail.mem))
+		}
+		if fh.stream != nil {
+			u.Readahead += fh.stream.buffered()
+		}
+		fh.mu.Unlock()
+	}
+	if kfs.root.blocks != nil {
+		u.Blocks = kfs.root.blocks.size()
+	}
+
+	u.Total = u.Metadata + u.Buffers + u.Readahead + u.Blocks
+	u.Limit = kfs.memory.limit
+	u.Pressure = kfs.memory.underPressure()
+	return u
+}
+
+func (n *koneksiNode) countNodes(u *MemoryUsage) {
+	u.Nodes++
+	u.Metadata += nodeOverhead + int64(len(n.path())+len(n.stat().Name))
+	for _, child := range n.children.list() {
+		child.countNodes(u)
+	}
+}
+
+// watchMemory checks memory use against the limit until ctx is
+// cancelled. Going over it sheds readahead and write buffers and evicts
+// cached blocks and nodes not in use until use is back under the limit.
+func (kfs *KoneksiFS) watchMemory(ctx context.Context) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		limit := kfs.memory.limit
+		u := kfs.MemoryUsage()
+		switch {
+		case u.Total > limit:
+			if !kfs.memory.pressure.Swap(true) {
+				slog.Warn("memory use over limit, shedding caches", "used", u.Total, "limit", limit,
+					"metadata", u.Metadata, "buffers", u.Buffers, "readahead", u.Readahead, "blocks", u.Blocks)
+			}
+			kfs.relieveMemory(u.Total - int64(float64(limit)*memoryRelief))
+		case float64(u.Total) < float64(limit)*memoryRelief:
+			if kfs.memory.pressure.Swap(false) {
+				slog.Info("memory use back under limit", "used", u.Total, "limit", limit)
+			}
+		}
+	}
+}
+
+// relieveMemory evicts cached blocks, then nodes not in use, to free
+// about excess bytes.
+func (kfs *KoneksiFS) relieveMemory(excess int64) {
+	if b := kfs.root.blocks; b != nil && excess > 0 {
+		used := b.size()
+		b.shrink(max(used-excess, 0))
+		excess -= used - b.size()
+	}
+	if excess > 0 {
+		if _, dropped := kfs.root.collect(kfs.root.handles.nodes(), true); dropped > 0 {
+			slog.Debug("forgot nodes under memory pressure", "forgotten", dropped)
+		}
+	}
+}
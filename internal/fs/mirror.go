@@ -0,0 +1,484 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/backend"
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/vault"
+)
+
+// A mirror mount keeps a full copy of the remote directory in the cache
+// directory and serves it like an offline mount, so no file operation
+// makes an API call or waits on the server. In the background the whole
+// tree is listed every mount.mirror_interval and new and changed files
+// are downloaded. A folder switches to its new listing only once all its
+// files are downloaded, so readers see either the old or the new version
+// of a file, never one half mirrored. Every mount.mirror_verify, each
+// mirrored file is read back and checked against its checksum, and files
+// that do not match are downloaded again.
+
+// mirrorWorkers is how many files are downloaded at once while mirroring.
+const mirrorWorkers = 4
+
+// MirrorStats describes the mirror of a mirror mount.
+type MirrorStats struct {
+	Files  int64 `json:"files"` // files mirrored
+	Bytes  int64 `json:"bytes"` // their size
+	Passes int64 `json:"passes"`
+	// When the last complete pass ended and when every file was last
+	// verified; zero before the first.
+	Synced   time.Time `json:"synced"`
+	Verified time.Time `json:"verified"`
+	// Files downloaded since mounting, and of those the files that were
+	// found corrupt by verification.
+	Downloaded int64 `json:"downloaded"`
+	Repaired   int64 `json:"repaired"`
+	// Files that failed to download in the last pass, left at their
+	// previous version, and why the last pass failed, if it did.
+	Failed    int64  `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// mirror keeps the cache of a mirror mount a copy of the remote directory.
+type mirror struct {
+	storage  api.StorageBackend
+	cache    *cache.Cache
+	root     *koneksiNode
+	interval time.Duration
+	verify   time.Duration
+
+	mu    sync.Mutex
+	files map[string]api.FileInfo // the mirrored files by path, as of the last pass
+	stats MirrorStats
+}
+
+// newMirrorFS creates a filesystem served from a mirror kept in the
+// configured cache directory. The nodes get an offline client, so only
+// the mirror talks to the server.
+func newMirrorFS(cfg *config.Config) (*KoneksiFS, error) {
+	v, err := vault.Open(&cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	storage, client, err := backend.Open(cfg, cfg.API.DirectoryID, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	// Every file is kept, whatever the size limit of the cache.
+	cfg.Cache.MaxSize = 0
+	contentCache, err := cache.New(&cfg.Cache, cfg.API.DirectoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Mount.ReadOnly = true
+	// Searches and recent files need the server.
+	cfg.Mount.VirtualDir = ""
+
+	offline := api.NewOfflineClient(&cfg.API)
+	kfs, err := newKoneksiFS(cfg, offline, offline, api.Capabilities{}, contentCache)
+	if err != nil {
+		return nil, err
+	}
+	// The session traffic is that of mirroring.
+	kfs.storage, kfs.client = storage, client
+	kfs.mirror = &mirror{
+		storage:  storage,
+		cache:    contentCache,
+		root:     kfs.root,
+		interval: cfg.Mount.MirrorInterval,
+		verify:   cfg.Mount.MirrorVerify,
+	}
+	return kfs, nil
+}
+
+// Mirror returns the state of the mirror of a mirror mount, or nil for
+// other mounts.
+func (kfs *KoneksiFS) Mirror() *MirrorStats {
+	if kfs.mirror == nil {
+		return nil
+	}
+	kfs.mirror.mu.Lock()
+	defer kfs.mirror.mu.Unlock()
+	stats := kfs.mirror.stats
+	return &stats
+}
+
+// complete reports whether a pass has completed before, in this mount or
+// an earlier one, so there is a mirror to serve.
+func (m *mirror) complete() bool {
+	_, ok := m.cache.Listing(m.root.path())
+	return ok
+}
+
+// run brings the mirror up to date, unless a pass completed in this mount
+// already, and then every interval, and verifies it every verify, until
+// ctx is cancelled.
+func (m *mirror) run(ctx context.Context) {
+	syncTicker := time.NewTicker(m.interval)
+	defer syncTicker.Stop()
+	var verifyC <-chan time.Time
+	if m.verify > 0 {
+		verifyTicker := time.NewTicker(m.verify)
+		defer verifyTicker.Stop()
+		verifyC = verifyTicker.C
+	}
+
+	m.mu.Lock()
+	synced := m.stats.Passes > 0
+	m.mu.Unlock()
+	if !synced {
+		m.update(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncTicker.C:
+			m.update(ctx)
+		case <-verifyC:
+			m.verifyAll(ctx)
+		}
+	}
+}
+
+// update makes a pass, logging why it failed if it did.
+func (m *mirror) update(ctx context.Context) {
+	if err := m.sync(ctx); err != nil && ctx.Err() == nil {
+		slog.Warn("failed to update mirror, serving the previous version", "error", err)
+	}
+}
+
+// sync makes a pass bringing the mirror up to date: it lists the whole
+// tree, downloads the files that are new or changed, switches each folder
+// to its new listing and drops the files no longer on the server. A
+// listing that fails aborts the pass, leaving the mirror as it was.
+func (m *mirror) sync(ctx context.Context) (err error) {
+	defer func() {
+		m.mu.Lock()
+		m.stats.LastError = ""
+		if err != nil {
+			m.stats.LastError = err.Error()
+		}
+		m.mu.Unlock()
+	}()
+
+	start := time.Now()
+	tree, err := m.walk(ctx)
+	if err != nil {
+		return err
+	}
+
+	var todo []string
+	listed := make(map[string]api.FileInfo)
+	for dir, listing := range tree {
+		for _, f := range listing {
+			p := path.Join(dir, f.Name)
+			if !f.IsDir && !m.cache.Cached(p, f.Size, f.Modified, f.Hash) {
+				todo = append(todo, p)
+				listed[p] = f
+			}
+		}
+	}
+	sort.Strings(todo)
+	fetched, failed := m.fetchAll(ctx, todo, listed)
+	if err := ctx.Err(); err != nil {
+		for _, tmp := range fetched {
+			os.Remove(tmp)
+		}
+		return err
+	}
+
+	// Parents first, so a folder appears once its parent is switched.
+	dirs := make([]string, 0, len(tree))
+	for dir := range tree {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	files := make(map[string]api.FileInfo)
+	var total int64
+	for _, dir := range dirs {
+		listing := m.commit(dir, tree[dir], fetched, failed)
+		for _, f := range listing {
+			if !f.IsDir {
+				files[path.Join(dir, f.Name)] = f
+				total += f.Size
+			}
+		}
+	}
+
+	// Files cached by mounts of other folders of the directory are kept.
+	root := m.root.path()
+	for _, p := range m.cache.Paths() {
+		if _, ok := files[p]; !ok && pathWithin(p, root) {
+			m.cache.Remove(p)
+		}
+	}
+
+	m.mu.Lock()
+	m.files = files
+	m.stats.Files = int64(len(files))
+	m.stats.Bytes = total
+	m.stats.Passes++
+	m.stats.Synced = time.Now()
+	m.stats.Downloaded += int64(len(fetched))
+	m.stats.Failed = int64(len(failed))
+	m.mu.Unlock()
+	slog.Info("mirror updated", "files", len(files), "downloaded", len(fetched), "failed", len(failed),
+		"took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// walk lists every folder of the mounted remote folder, by path.
+func (m *mirror) walk(ctx context.Context) (map[string][]api.FileInfo, error) {
+	tree := make(map[string][]api.FileInfo)
+	queue := []string{m.root.path()}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		listing, err := m.storage.List(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		tree[dir] = listing
+		for _, f := range listing {
+			if f.IsDir {
+				queue = append(queue, path.Join(dir, f.Name))
+			}
+		}
+	}
+	return tree, nil
+}
+
+// fetchAll downloads the files of paths, as described by files, to
+// temporary files in the cache directory, mirrorWorkers at a time. It
+// returns the temporary file of each file downloaded and the files that
+// could not be.
+func (m *mirror) fetchAll(ctx context.Context, paths []string, files map[string]api.FileInfo) (fetched map[string]string, failed map[string]bool) {
+	fetched = make(map[string]string)
+	failed = make(map[string]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, mirrorWorkers)
+	for _, p := range paths {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return fetched, failed
+		}
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			tmp, err := m.fetch(ctx, p, files[p])
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.Warn("failed to mirror file, keeping the previous version", "path", p, "error", err)
+				failed[p] = true
+				return
+			}
+			fetched[p] = tmp
+		}(p)
+	}
+	wg.Wait()
+	return fetched, failed
+}
+
+// fetch downloads the file at p, described by f, to a temporary file in
+// the cache directory, checking it against the size and checksum the
+// server reports.
+func (m *mirror) fetch(ctx context.Context, p string, f api.FileInfo) (string, error) {
+	content, err := m.storage.Read(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	tmp, err := m.cache.TempFile()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkContent(f, n, hex.EncodeToString(h.Sum(nil)))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// checkContent returns an error if n bytes with the hex SHA-256 sum are
+// not the content of f.
+func checkContent(f api.FileInfo, n int64, sum string) error {
+	if n != f.Size {
+		return fmt.Errorf("got %d bytes, expected %d", n, f.Size)
+	}
+	if f.Hash != "" && !strings.EqualFold(sum, f.Hash) {
+		return fmt.Errorf("checksum %s does not match %s", sum, f.Hash)
+	}
+	return nil
+}
+
+// commit switches the folder dir to listing: the files downloaded to
+// fetched become its cached content, the listing is stored for the
+// mount to serve and the folder's node, if loaded, takes it. Files that
+// failed keep their previous version, or are left out if new. It returns
+// the listing stored.
+func (m *mirror) commit(dir string, listing []api.FileInfo, fetched map[string]string, failed map[string]bool) []api.FileInfo {
+	previous := make(map[string]api.FileInfo)
+	if data, ok := m.cache.Listing(dir); ok {
+		var files []api.FileInfo
+		if json.Unmarshal(data, &files) == nil {
+			for _, f := range files {
+				previous[f.Name] = f
+			}
+		}
+	}
+
+	stored := make([]api.FileInfo, 0, len(listing))
+	for _, f := range listing {
+		p := path.Join(dir, f.Name)
+		if tmp, ok := fetched[p]; ok {
+			content, err := m.cache.Adopt(p, f.Modified, f.Hash, tmp)
+			if err != nil {
+				slog.Warn("failed to store mirrored file", "path", p, "error", err)
+				failed[p] = true
+			} else {
+				content.Close()
+			}
+		}
+		if failed[p] {
+			old, ok := previous[f.Name]
+			if !ok || old.IsDir {
+				continue
+			}
+			f = old
+		}
+		stored = append(stored, f)
+	}
+
+	data, err := json.Marshal(stored)
+	if err == nil {
+		err = m.cache.StoreListing(dir, data)
+	}
+	if err != nil {
+		slog.Warn("failed to store mirrored listing", "path", dir, "error", err)
+	}
+	if node := m.node(dir); node != nil {
+		node.setChildren(stored)
+	}
+	return stored
+}
+
+// node returns the loaded node of the folder dir, or nil.
+func (m *mirror) node(dir string) *koneksiNode {
+	n := m.root
+	rel := strings.TrimPrefix(dir, n.path())
+	for _, name := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child, ok := n.children.get(name)
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+	return n
+}
+
+// verifyAll reads back every mirrored file and checks it against the
+// size and checksum the server reported for it, downloading the files
+// that do not match again.
+func (m *mirror) verifyAll(ctx context.Context) {
+	m.mu.Lock()
+	files := m.files
+	m.mu.Unlock()
+
+	start := time.Now()
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var repaired int64
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			return
+		}
+		f := files[p]
+		err := m.check(p, f)
+		if err == nil {
+			continue
+		}
+		slog.Warn("mirrored file is damaged, downloading it again", "path", p, "error", err)
+		m.cache.Discard(p)
+		tmp, err := m.fetch(ctx, p, f)
+		if err == nil {
+			var content *os.File
+			if content, err = m.cache.Adopt(p, f.Modified, f.Hash, tmp); err == nil {
+				content.Close()
+				repaired++
+			}
+		}
+		if err != nil {
+			slog.Error("failed to repair mirrored file", "path", p, "error", err)
+		}
+	}
+
+	m.mu.Lock()
+	m.stats.Verified = time.Now()
+	m.stats.Downloaded += repaired
+	m.stats.Repaired += repaired
+	m.mu.Unlock()
+	slog.Info("mirror verified", "files", len(paths), "repaired", repaired, "took", time.Since(start).Round(time.Millisecond))
+}
+
+// check reads the mirrored copy of the file at p, described by f, and
+// returns an error if it is missing or does not match.
+func (m *mirror) check(p string, f api.FileInfo) error {
+	content, modified, ok := m.cache.OpenStale(p)
+	if !ok {
+		return errors.New("not in the mirror")
+	}
+	defer content.Close()
+	if !modified.Equal(f.Modified) {
+		return fmt.Errorf("mirrored version of %s, expected %s", modified, f.Modified)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, content)
+	if err != nil {
+		return err
+	}
+	return checkContent(f, n, hex.EncodeToString(h.Sum(nil)))
+}
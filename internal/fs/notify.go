@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// notifyUploadFailed tells the desktop that changes to the node could
+// not be uploaded.
+func (n *koneksiNode) notifyUploadFailed(err error) {
+	n.notifier.Notify("upload:"+n.path(), "Upload failed", n.path()+": "+err.Error())
+}
+
+// watchQuota checks the storage quota every interval until ctx is
+// cancelled or the server turns out not to report one.
+func (kfs *KoneksiFS) watchQuota(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if kfs.root.io.background(ctx) != nil {
+			return
+		}
+		err := kfs.root.notifier.CheckQuota(ctx, kfs.client, kfs.cfg.Notifications.QuotaWarning)
+		if errors.Is(err, api.ErrQuotaUnsupported) {
+			slog.Debug("server reports no quota, not checking it")
+			return
+		}
+		if err != nil {
+			slog.Debug("failed to check quota", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// newOfflineFS creates a filesystem serving the cache alone. Listings and
+// content come from the configured cache directory, the mount is
+// read-only and the server is never contacted, so warmed data stays
+// available without a network and nothing waits for timeouts.
+func newOfflineFS(cfg *config.Config) (*KoneksiFS, error) {
+	contentCache, err := cache.New(&cfg.Cache, cfg.API.DirectoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := contentCache.Listing(cfg.Mount.RemotePath); !ok && cfg.Mount.RemotePath != "/" {
+		return nil, fmt.Errorf("mount.remote_path %s was never listed online, so it cannot be mounted offline", cfg.Mount.RemotePath)
+	}
+	for _, b := range cfg.Mount.Binds {
+		if _, ok := contentCache.Listing(b.Path); !ok {
+			return nil, fmt.Errorf("mount.map %s=%s was never listed online, so it cannot be mounted offline", b.Name, b.Path)
+		}
+	}
+
+	cfg.Mount.ReadOnly = true
+	// Searches and recent files need the server.
+	cfg.Mount.VirtualDir = ""
+
+	offline := api.NewOfflineClient(&cfg.API)
+	return newKoneksiFS(cfg, offline, offline, api.Capabilities{}, contentCache)
+}
+
+// listRemote lists the remote directory dir. With a cache the listing is
+// kept, and offline and mirror mounts list from the cache only. While the server
+// cannot be reached, the kept listing is served instead.
+func (n *koneksiNode) listRemote(ctx context.Context, dir string) ([]api.FileInfo, error) {
+	if n.cfg.Mount.CacheOnly() {
+		files, ok, err := n.cachedListing(dir)
+		if err == nil && !ok {
+			err = api.ErrOffline
+		}
+		return files, err
+	}
+
+	files, err := n.storage.List(ctx, dir)
+	if api.IsUnreachable(err) {
+		if cached, ok, cerr := n.cachedListing(dir); ok && cerr == nil {
+			slog.Debug("serving cached listing", "path", dir, "error", err)
+			return cached, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n.cache != nil {
+		data, err := json.Marshal(files)
+		if err == nil {
+			err = n.cache.StoreListing(dir, data)
+		}
+		if err != nil {
+			slog.Debug("failed to cache listing", "path", dir, "error", err)
+		}
+	}
+	return files, nil
+}
+
+// cachedListing returns the listing of dir kept in the cache, if any.
+func (n *koneksiNode) cachedListing(dir string) ([]api.FileInfo, bool, error) {
+	if n.cache == nil {
+		return nil, false, nil
+	}
+	data, ok := n.cache.Listing(dir)
+	if !ok {
+		return nil, false, nil
+	}
+	var files []api.FileInfo
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, false, err
+	}
+	return files, true, nil
+}
@@ -0,0 +1,397 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+const (
+	// whiteoutPrefix marks an entry of the upper layer that hides the
+	// remote entry of the same name without the prefix. Names with the
+	// prefix are never shown.
+	whiteoutPrefix = ".wh."
+	// opaqueMarker in an upper directory hides all remote entries of the
+	// directory, for a directory deleted and created again.
+	opaqueMarker = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// overlay is the local upper layer of an overlay mount. The remote
+// directory is the read-only lower layer: files are copied up into the
+// upper layer when they are first changed, new entries are created there,
+// and deleting a remote entry leaves a whiteout. Nothing is written to the
+// server.
+type overlay struct {
+	dir string
+}
+
+func newOverlay(dir string) (*overlay, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create overlay directory: %w", err)
+	}
+	return &overlay{dir: dir}, nil
+}
+
+// path returns the location of the remote path p in the upper layer.
+func (o *overlay) path(p string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(p))
+}
+
+// stat returns the upper layer entry for p, if any.
+func (o *overlay) stat(p string) (*api.FileInfo, bool) {
+	fi, err := os.Lstat(o.path(p))
+	if err != nil {
+		return nil, false
+	}
+	return upperInfo(p, fi), true
+}
+
+func upperInfo(p string, fi os.FileInfo) *api.FileInfo {
+	info := &api.FileInfo{
+		Name:     filepath.Base(p),
+		IsDir:    fi.IsDir(),
+		Modified: fi.ModTime(),
+		Path:     p,
+	}
+	if !info.IsDir {
+		info.Size = fi.Size()
+	}
+	return info
+}
+
+func (o *overlay) whiteout(p string) string {
+	return filepath.Join(o.path(filepath.Dir(p)), whiteoutPrefix+filepath.Base(p))
+}
+
+// hidden reports whether the remote entry at p is hidden, because it was
+// deleted or its directory was replaced.
+func (o *overlay) hidden(p string) bool {
+	if _, err := os.Lstat(o.whiteout(p)); err == nil {
+		return true
+	}
+	return o.opaque(filepath.Dir(p))
+}
+
+func (o *overlay) opaque(dir string) bool {
+	_, err := os.Lstat(filepath.Join(o.path(dir), opaqueMarker))
+	return err == nil
+}
+
+// hide records that the remote entry at p was deleted.
+func (o *overlay) hide(p string) error {
+	if err := os.MkdirAll(o.path(filepath.Dir(p)), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(o.whiteout(p))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// unhide removes the whiteout of p and reports whether there was one.
+func (o *overlay) unhide(p string) bool {
+	return os.Remove(o.whiteout(p)) == nil
+}
+
+// list returns the entries of dir: the remote ones listed by lower that
+// are not hidden, replaced by or combined with those of the upper layer.
+func (o *overlay) list(dir string, lower func(dir string) ([]api.FileInfo, error)) ([]api.FileInfo, error) {
+	upper := make(map[string]api.FileInfo)
+	if des, err := os.ReadDir(o.path(dir)); err == nil {
+		for _, de := range des {
+			if strings.HasPrefix(de.Name(), whiteoutPrefix) {
+				continue
+			}
+			if fi, err := de.Info(); err == nil {
+				upper[de.Name()] = *upperInfo(filepath.Join(dir, de.Name()), fi)
+			}
+		}
+	}
+
+	var files []api.FileInfo
+	if !o.opaque(dir) {
+		remote, err := lower(dir)
+		if err != nil && !(api.IsNotFound(err) && o.isDir(dir)) {
+			return nil, err
+		}
+		for _, f := range remote {
+			if u, ok := upper[f.Name]; ok {
+				files = append(files, u)
+				delete(upper, f.Name)
+			} else if !o.hidden(filepath.Join(dir, f.Name)) {
+				files = append(files, f)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(upper))
+	for name := range upper {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files = append(files, upper[name])
+	}
+	return files, nil
+}
+
+func (o *overlay) isDir(p string) bool {
+	fi, err := os.Stat(o.path(p))
+	return err == nil && fi.IsDir()
+}
+
+// copyUp stores the remote content of n in the upper layer.
+func (n *koneksiNode) copyUp() error {
+	target := n.overlay.path(n.path())
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	var src io.ReadCloser
+	var err error
+	if n.cache != nil {
+		src, err = n.openCached(n.stat())
+	} else {
+		src, err = n.storage.Read(context.Background(), n.path())
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), whiteoutPrefix+"copy-*")
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = io.Copy(tmp, src)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		modified := n.stat().Modified
+		os.Chtimes(tmp.Name(), modified, modified)
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// overlayOpen opens the upper layer copy of n, copying it up first when
+// it is opened for writing.
+func (n *koneksiNode) overlayOpen(flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if _, ok := n.overlay.stat(n.path()); !ok {
+		if err := n.copyUp(); err != nil {
+			return nil, 0, syscall.EIO
+		}
+	}
+
+	f, err := os.OpenFile(n.overlay.path(n.path()), int(flags)&syscall.O_ACCMODE, 0)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return &overlayFileHandle{node: n, file: f}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (n *koneksiNode) overlayCreate(ctx context.Context, name string, flags uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	childPath := filepath.Join(n.path(), name)
+	if errno := n.overlayCheckName(name, childPath); errno != 0 {
+		return nil, nil, 0, errno
+	}
+
+	target := n.overlay.path(childPath)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return nil, nil, 0, fs.ToErrno(err)
+	}
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, 0, fs.ToErrno(err)
+	}
+	n.overlay.unhide(childPath)
+
+	child := n.overlayChild(childPath)
+	n.setAttr(&out.Attr, child.stat())
+	child.hold()
+	inode := n.NewInode(ctx, child, n.stableAttr(child.stat()))
+	return inode, &overlayFileHandle{node: child, file: f}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (n *koneksiNode) overlayMkdir(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	childPath := filepath.Join(n.path(), name)
+	if errno := n.overlayCheckName(name, childPath); errno != 0 {
+		return nil, errno
+	}
+
+	target := n.overlay.path(childPath)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := os.Mkdir(target, 0755); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if n.overlay.unhide(childPath) {
+		// The deleted remote directory must not show through.
+		f, err := os.Create(filepath.Join(target, opaqueMarker))
+		if err != nil {
+			return nil, fs.ToErrno(err)
+		}
+		f.Close()
+	}
+
+	child := n.overlayChild(childPath)
+	n.setAttr(&out.Attr, child.stat())
+	child.hold()
+	return n.NewInode(ctx, child, n.stableAttr(child.stat())), 0
+}
+
+// overlayChild creates the node for the new upper layer entry childPath.
+func (n *koneksiNode) overlayChild(childPath string) *koneksiNode {
+	info, ok := n.overlay.stat(childPath)
+	if !ok {
+		info = &api.FileInfo{Name: filepath.Base(childPath), Modified: time.Now(), Path: childPath}
+	}
+
+	name := filepath.Base(childPath)
+	child := n.newChild(name, *info)
+	n.children.set(name, child)
+	return child
+}
+
+// overlayRemove deletes the entry name from the upper layer and hides a
+// remote entry of that name.
+func (n *koneksiNode) overlayRemove(ctx context.Context, name string, dir bool) syscall.Errno {
+	childPath := filepath.Join(n.path(), name)
+
+	if dir {
+		files, err := n.overlay.list(childPath, func(dir string) ([]api.FileInfo, error) { return n.listRemote(ctx, dir) })
+		if err != nil {
+			return syscall.EIO
+		}
+		if len(files) > 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+
+	_, inUpper := n.overlay.stat(childPath)
+	inRemote := false
+	if !n.overlay.hidden(childPath) {
+		_, err := n.storage.Stat(ctx, childPath)
+		if err != nil && !api.IsNotFound(err) {
+			return syscall.EIO
+		}
+		inRemote = err == nil
+	}
+	if !inUpper && !inRemote {
+		return syscall.ENOENT
+	}
+
+	if inUpper {
+		// An empty directory may still hold whiteouts.
+		if err := os.RemoveAll(n.overlay.path(childPath)); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	if inRemote {
+		if err := n.overlay.hide(childPath); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+
+	n.children.remove(name)
+	return 0
+}
+
+// overlayTruncate resizes the upper layer copy of n.
+func (n *koneksiNode) overlayTruncate(size int64) syscall.Errno {
+	target := n.overlay.path(n.path())
+	if _, ok := n.overlay.stat(n.path()); !ok {
+		if size == 0 {
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return fs.ToErrno(err)
+			}
+		} else if err := n.copyUp(); err != nil {
+			return syscall.EIO
+		}
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fs.ToErrno(err)
+	}
+
+	n.modifyInfo(func(info *api.FileInfo) {
+		info.Size = size
+		info.Modified = time.Now()
+	})
+	return 0
+}
+
+func (n *koneksiNode) overlayCheckName(name, filePath string) syscall.Errno {
+	if strings.HasPrefix(name, whiteoutPrefix) {
+		return syscall.EPERM
+	}
+	return n.checkName(name, filePath)
+}
+
+// overlayFileHandle reads and writes the upper layer copy of a file.
+type overlayFileHandle struct {
+	node *koneksiNode
+	file *os.File
+}
+
+var _ = (fs.FileReader)((*overlayFileHandle)(nil))
+
+func (fh *overlayFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	return readAt(fh.file, dest, off)
+}
+
+var _ = (fs.FileWriter)((*overlayFileHandle)(nil))
+
+func (fh *overlayFileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if errno := fh.node.checkProcess(ctx, "write", fh.node.path(), true); errno != 0 {
+		return 0, errno
+	}
+	n, err := fh.file.WriteAt(data, off)
+	if err != nil {
+		return 0, fs.ToErrno(err)
+	}
+
+	fh.node.modifyInfo(func(info *api.FileInfo) {
+		if end := off + int64(n); end > info.Size {
+			info.Size = end
+		}
+		info.Modified = time.Now()
+	})
+	return uint32(n), 0
+}
+
+var _ = (fs.FileFsyncer)((*overlayFileHandle)(nil))
+
+func (fh *overlayFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return fs.ToErrno(fh.file.Sync())
+}
+
+var _ = (fs.FileReleaser)((*overlayFileHandle)(nil))
+
+func (fh *overlayFileHandle) Release(ctx context.Context) syscall.Errno {
+	return fs.ToErrno(fh.file.Close())
+}
@@ -0,0 +1,39 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// callerOwner returns the owner of a file created by the caller of ctx.
+// On mounts shared with other users that is the calling user; otherwise,
+// and if the caller is unknown, it is nil and the configured uid and gid
+// apply.
+func (n *koneksiNode) callerOwner(ctx context.Context) *api.Owner {
+	if !n.cfg.Mount.AllowOther {
+		return nil
+	}
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return &api.Owner{UID: caller.Uid, GID: caller.Gid}
+}
+
+// recordOwner stores owner with the new file filePath so other mounts
+// and later listings show it too. Servers that do not store ownership
+// are tolerated, as is storage without the Koneksi API; the owner then
+// lasts until the next listing.
+func (n *koneksiNode) recordOwner(ctx context.Context, filePath string, owner *api.Owner) {
+	if owner == nil || n.client == nil {
+		return
+	}
+	err := n.client.SetOwner(ctx, filePath, *owner)
+	if err != nil && !errors.Is(err, api.ErrOwnersUnsupported) {
+		slog.Warn("failed to record file owner", "path", filePath, "error", err)
+	}
+}
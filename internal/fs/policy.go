@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"errors"
+	"log/slog"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// checkName returns ENAMETOOLONG or EINVAL for names the server would
+// reject, so applications get a meaningful error instead of EIO.
+func (n *koneksiNode) checkName(name, filePath string) syscall.Errno {
+	var err error
+	if n.client != nil {
+		err = n.client.ValidateName(name, filePath)
+	} else {
+		err = api.ValidateName(name, filePath)
+	}
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, api.ErrNameTooLong):
+		return syscall.ENAMETOOLONG
+	default:
+		slog.Debug("invalid file name", "name", name, "error", err)
+		return syscall.EINVAL
+	}
+}
+
+// checkTypePolicy returns EPERM if the file at filePath may not be
+// written under the configured policy.
+func (n *koneksiNode) checkTypePolicy(filePath string) syscall.Errno {
+	ext := strings.TrimPrefix(path.Ext(filePath), ".")
+	if ext == "" {
+		return 0
+	}
+
+	for _, denied := range n.cfg.Policy.DenyExtensions {
+		if strings.EqualFold(ext, strings.TrimPrefix(denied, ".")) {
+			slog.Warn("file type denied by policy", "path", filePath)
+			return syscall.EPERM
+		}
+	}
+	return 0
+}
+
+// checkSizePolicy returns EFBIG if the node may not grow to size bytes
+// under the configured policy.
+func (n *koneksiNode) checkSizePolicy(size int64) syscall.Errno {
+	limit := n.cfg.Policy.MaxFileSize
+	if limit > 0 && size > limit {
+		slog.Warn("file size denied by policy", "path", n.path(), "size", size, "limit", limit)
+		return syscall.EFBIG
+	}
+	return 0
+}
+
+// checkAppendOnly returns EPERM on append-only mounts, where op would
+// change or remove the existing entry at filePath.
+func (n *koneksiNode) checkAppendOnly(op, filePath string) syscall.Errno {
+	if !n.cfg.Mount.AppendOnly {
+		return 0
+	}
+	slog.Warn("change denied on append-only mount", "op", op, "path", filePath)
+	return syscall.EPERM
+}
+
+// checkRetention returns EPERM if op would change or delete the file at
+// filePath, described by info, while it is under retention.
+func (n *koneksiNode) checkRetention(op, filePath string, info *api.FileInfo) syscall.Errno {
+	if api.CheckRetention(info) == nil {
+		return 0
+	}
+	slog.Warn("change denied by retention", "op", op, "path", filePath, "until", *info.RetainUntil)
+	return syscall.EPERM
+}
+
+// ioRule returns the mount.io_rules entry for the node, setting how it is
+// read, cached and written.
+func (n *koneksiNode) ioRule() config.IORule {
+	return config.MatchIORule(n.cfg.Mount.IORules, n.path())
+}
@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+const (
+	// preloadWorkers is how many directories are listed at once while
+	// preloading.
+	preloadWorkers = 4
+	// preloadMaxAge is how long a preloaded listing may serve the first
+	// read of its directory; older ones are listed again.
+	preloadMaxAge = 10 * time.Minute
+)
+
+// preloadedListing is a directory listing fetched ahead of the first read
+// of the directory.
+type preloadedListing struct {
+	files []api.FileInfo
+	at    time.Time
+}
+
+// preload lists the directories below n down to depth levels, n itself
+// being the first, so the first walk of the tree is served from memory.
+// It stops early when ctx is cancelled.
+func (n *koneksiNode) preload(ctx context.Context, depth int) {
+	start := time.Now()
+	sem := make(chan struct{}, preloadWorkers)
+	var wg sync.WaitGroup
+	var listed atomic.Int64
+
+	var visit func(dir *koneksiNode, level int)
+	visit = func(dir *koneksiNode, level int) {
+		defer wg.Done()
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		if dir.io.background(ctx) != nil {
+			<-sem
+			return
+		}
+		files, err := dir.list(ctx)
+		<-sem
+		if err != nil {
+			slog.Debug("failed to preload directory", "path", dir.path(), "error", err)
+			return
+		}
+
+		children := dir.setChildren(files)
+		dir.preloaded.Store(&preloadedListing{files: files, at: time.Now()})
+		listed.Add(1)
+
+		if level >= depth {
+			return
+		}
+		for _, child := range children {
+			if child.stat().IsDir {
+				wg.Add(1)
+				go visit(child, level+1)
+			}
+		}
+	}
+
+	wg.Add(1)
+	visit(n, 1)
+	wg.Wait()
+
+	slog.Info("preloaded directory listings", "directories", listed.Load(), "depth", depth, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// takePreloaded returns the preloaded listing of n, if a recent one is
+// left. Each is used once, so later reads see changes on the server.
+func (n *koneksiNode) takePreloaded() ([]api.FileInfo, bool) {
+	p := n.preloaded.Swap(nil)
+	if p == nil || time.Since(p.at) > preloadMaxAge {
+		return nil, false
+	}
+	return p.files, true
+}
@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ioClass ranks the work of a mount by how directly someone waits on it.
+type ioClass int
+
+const (
+	// classInteractive is a filesystem operation a process is blocked
+	// in, such as a lookup, a listing, an open or a read.
+	classInteractive ioClass = iota
+	// classFlush is the upload of a file being closed or synced.
+	classFlush
+	// classBackground is work nobody waits on: preloading and refreshing
+	// listings, and checking the quota.
+	classBackground
+)
+
+const (
+	// backgroundQuiet is how long background work keeps waiting after an
+	// interactive operation ended, as they tend to come in bursts, such
+	// as the lookups of an ls -l.
+	backgroundQuiet = 100 * time.Millisecond
+	// backgroundMaxWait bounds how long one background request waits, so
+	// a mount that is always busy still keeps its listings fresh.
+	backgroundMaxWait = 5 * time.Second
+)
+
+// ioScheduler holds back the API requests of background work while
+// interactive operations or flushes are running, so warming caches never
+// competes with what a user is waiting for. Interactive operations and
+// flushes never wait.
+type ioScheduler struct {
+	mu      sync.Mutex
+	active  [classBackground]int // running interactive operations and flushes
+	quiet   time.Time            // background work waits until then
+	changed chan struct{}        // closed and replaced when work ends
+}
+
+func newIOScheduler() *ioScheduler {
+	return &ioScheduler{changed: make(chan struct{})}
+}
+
+// begin records that work of class started, and returns the function to
+// call when it ends.
+func (s *ioScheduler) begin(class ioClass) (end func()) {
+	if class == classBackground {
+		return func() {}
+	}
+	s.mu.Lock()
+	s.active[class]++
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.active[class]--
+		if class == classInteractive {
+			s.quiet = time.Now().Add(backgroundQuiet)
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// background waits until background work may make a request: until no
+// interactive operation or flush is running and none ended just now, or
+// for backgroundMaxWait at most. It returns early with an error when ctx
+// is done.
+func (s *ioScheduler) background(ctx context.Context) error {
+	limit := time.NewTimer(backgroundMaxWait)
+	defer limit.Stop()
+
+	for {
+		s.mu.Lock()
+		busy := s.active[classInteractive] > 0 || s.active[classFlush] > 0
+		quiet := time.Until(s.quiet)
+		changed := s.changed
+		s.mu.Unlock()
+
+		var wake <-chan time.Time
+		switch {
+		case busy:
+		case quiet > 0:
+			wake = time.After(quiet)
+		default:
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-wake:
+		case <-limit.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// idle waits until no interactive operation or flush is running, or
+// returns an error when ctx is done first.
+func (s *ioScheduler) idle(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		busy := s.active[classInteractive] > 0 || s.active[classFlush] > 0
+		changed := s.changed
+		s.mu.Unlock()
+		if !busy {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
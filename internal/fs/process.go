@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// selfUID is the user running the mount, for rules about other users.
+var selfUID = uint32(os.Getuid())
+
+// processName returns the name of process pid, or "" where it cannot be
+// found, as on systems without /proc.
+func processName(pid uint32) string {
+	data, err := os.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// processRule returns the mount.process_rules entry for the process
+// making the request of ctx, logging op on the remote path p if the rule
+// says so or p is traced.
+func (n *koneksiNode) processRule(ctx context.Context, op, p string) config.ProcessRule {
+	rules := n.cfg.Mount.ProcessRules
+	traced := n.traced(p)
+	if len(rules) == 0 && !traced {
+		return config.ProcessRule{}
+	}
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		n.trace(op, p)
+		return config.ProcessRule{}
+	}
+
+	comm := processName(caller.Pid)
+	if traced {
+		traceLog.Debug("operation", "op", op, "path", p, "pid", caller.Pid, "uid", caller.Uid, "comm", comm)
+	}
+	rule := rules.Match(comm, caller.Uid, selfUID)
+	if rule.Log {
+		slog.Info("process operation", "op", op, "path", p, "pid", caller.Pid, "uid", caller.Uid, "comm", comm)
+	}
+	return rule
+}
+
+// checkProcess applies mount.process_rules to op on the remote path p,
+// returning EACCES if op changes something and the process may not write,
+// or the caller's role in the directory does not allow writing. Nothing
+// changes at the root of a composite mount, which returns EPERM.
+func (n *koneksiNode) checkProcess(ctx context.Context, op, p string, write bool) syscall.Errno {
+	rule := n.processRule(ctx, op, p)
+	switch {
+	case write && n.composite():
+		return syscall.EPERM
+	case write && n.role != "":
+		slog.Debug("write denied by role in directory", "op", op, "path", p, "role", n.role)
+		return syscall.EACCES
+	case write && rule.DenyWrites:
+		slog.Debug("write denied by process rule", "op", op, "path", p)
+		return syscall.EACCES
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+)
+
+// resumePending uploads the changes a previous run left in the recovery
+// journal because it crashed or could not reach the server. Changes that
+// still cannot be uploaded stay in the journal for the next start or the
+// recover command.
+func (n *koneksiNode) resumePending() {
+	entries, err := n.journal.Clean()
+	if err != nil {
+		slog.Warn("failed to read recovery journal", "dir", n.journal.Dir(), "error", err)
+		return
+	}
+
+	for _, e := range entries {
+		target, err := n.journal.Resume(context.Background(), n.storage, n.uploader, e)
+		switch {
+		case err != nil:
+			slog.Warn("failed to upload recovered changes, keeping them", "path", e.Path, "error", err)
+		case target != e.Path:
+			slog.Warn("uploaded recovered changes as a copy, the file changed since", "path", e.Path, "copy", target)
+		default:
+			slog.Info("uploaded recovered changes", "path", e.Path)
+		}
+	}
+}
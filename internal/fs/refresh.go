@@ -0,0 +1,153 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// With mount.listing_ttl, a folder listed less than that long ago is read
+// and looked up in from the children known since, without asking the
+// server. So that busy folders never wait for a listing, those read often
+// are listed again in the background shortly before their listing
+// expires; folders read rarely are left to expire.
+
+const (
+	// hotScore is the decayed number of reads per refresh round from
+	// which a folder is kept listed in the background.
+	hotScore = 2
+	// coldScore is the score below which a folder is no longer tracked.
+	coldScore = 0.5
+)
+
+// listingRefresher tracks how often the folders of a mount are read.
+type listingRefresher struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	dirs  map[*koneksiNode]float64 // decayed reads per round
+	count int64                    // background listings so far
+}
+
+func newListingRefresher(ttl time.Duration) *listingRefresher {
+	if ttl <= 0 {
+		return nil
+	}
+	return &listingRefresher{ttl: ttl, dirs: make(map[*koneksiNode]float64)}
+}
+
+// listingFresh reports whether the known children of n are a listing of
+// the folder younger than mount.listing_ttl, kept up to date with the
+// changes made through the mount since.
+func (n *koneksiNode) listingFresh() bool {
+	r := n.listings
+	if r == nil {
+		return false
+	}
+	listed := n.listed.Load()
+	return listed != 0 && time.Since(time.Unix(0, listed)) < r.ttl
+}
+
+// knownListing returns the known children of n as a listing, sorted by
+// name, if it is fresh.
+func (n *koneksiNode) knownListing() ([]api.FileInfo, bool) {
+	if !n.listingFresh() {
+		return nil, false
+	}
+	children := n.children.list()
+	files := make([]api.FileInfo, 0, len(children))
+	for _, child := range children {
+		files = append(files, *child.stat())
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, true
+}
+
+// touchListing counts a read of the folder n towards its hotness.
+func (n *koneksiNode) touchListing() {
+	r := n.listings
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.dirs[n]++
+	r.mu.Unlock()
+}
+
+// refreshListings lists hot folders again shortly before their listing
+// expires, until ctx is cancelled.
+func (kfs *KoneksiFS) refreshListings(ctx context.Context) {
+	r := kfs.root.listings
+	interval := max(r.ttl/4, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, dir := range r.due(interval) {
+			if kfs.root.io.background(ctx) != nil {
+				return
+			}
+			files, err := dir.list(ctx)
+			if err != nil {
+				slog.Debug("failed to refresh listing", "path", dir.path(), "error", err)
+				r.forget(dir)
+				continue
+			}
+			dir.setChildren(files)
+			r.mu.Lock()
+			r.count++
+			r.mu.Unlock()
+		}
+	}
+}
+
+// due decays the scores of the tracked folders, stops tracking cold ones
+// and returns the hot folders whose listing expires within the next
+// round of interval.
+func (r *listingRefresher) due(interval time.Duration) []*koneksiNode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []*koneksiNode
+	for dir, score := range r.dirs {
+		// Reads since the last round count as one round's worth.
+		score /= 2
+		if score < coldScore {
+			delete(r.dirs, dir)
+			continue
+		}
+		r.dirs[dir] = score
+
+		listed := dir.listed.Load()
+		if score >= hotScore && listed != 0 && time.Since(time.Unix(0, listed)) >= r.ttl-interval {
+			due = append(due, dir)
+		}
+	}
+	return due
+}
+
+func (r *listingRefresher) forget(dir *koneksiNode) {
+	r.mu.Lock()
+	delete(r.dirs, dir)
+	r.mu.Unlock()
+}
+
+// refreshed returns the number of listings refreshed in the background.
+func (r *listingRefresher) refreshed() int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
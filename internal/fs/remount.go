@@ -0,0 +1,101 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// RemountOptions are the options remount changes on a running mount,
+// sent over its control socket. Nil fields are left as they are.
+type RemountOptions struct {
+	ReadOnly    *bool   `json:"read_only,omitempty"`
+	Consistency *string `json:"consistency,omitempty"` // strict, default or relaxed
+	ReadLimit   *int64  `json:"read_limit,omitempty"`  // bytes per second, 0 for no limit
+	WriteLimit  *int64  `json:"write_limit,omitempty"`
+}
+
+// ErrRemount is wrapped by the errors of changes a running mount cannot
+// make, as opposed to failures making them.
+var ErrRemount = errors.New("cannot remount")
+
+// liveSettings holds the options of a mount that remount changes while
+// it is mounted. The rest of the configuration is fixed at mount time.
+type liveSettings struct {
+	consistency atomic.Pointer[string]
+}
+
+func newLiveSettings(cfg *config.Config) *liveSettings {
+	s := &liveSettings{}
+	consistency := cfg.Mount.Consistency
+	s.consistency.Store(&consistency)
+	return s
+}
+
+// Remount applies change to the running mount, all of it or, if part of
+// it cannot be applied, none of it, and returns the options in effect
+// afterwards. Files open for writing keep uploading what was written
+// before a switch to read-only; further writes fail with EROFS.
+func (kfs *KoneksiFS) Remount(change RemountOptions) (RemountOptions, error) {
+	if change.ReadOnly != nil && !*change.ReadOnly && kfs.cfg.Mount.ReadOnly {
+		return kfs.Options(), fmt.Errorf("%w: the mount is read-only since mounting, which only mounting again can undo", ErrRemount)
+	}
+	if c := change.Consistency; c != nil && *c != "strict" && *c != "default" && *c != "relaxed" {
+		return kfs.Options(), fmt.Errorf("consistency must be strict, default or relaxed, got %q", *c)
+	}
+	throttled, _ := kfs.storage.(api.Throttled)
+	var read, write int64
+	var ok bool
+	if throttled != nil {
+		read, write, ok = throttled.Limits()
+	}
+	if change.ReadLimit != nil || change.WriteLimit != nil {
+		if !ok {
+			return kfs.Options(), fmt.Errorf("%w: bandwidth limits need the limit layer in api.layers", ErrRemount)
+		}
+		if change.ReadLimit != nil {
+			read = *change.ReadLimit
+		}
+		if change.WriteLimit != nil {
+			write = *change.WriteLimit
+		}
+		if read < 0 || write < 0 {
+			return kfs.Options(), fmt.Errorf("bandwidth limits must not be negative")
+		}
+	}
+
+	if change.ReadOnly != nil {
+		kfs.root.health.forced.Store(*change.ReadOnly)
+	}
+	if change.Consistency != nil {
+		consistency := *change.Consistency
+		kfs.root.live.consistency.Store(&consistency)
+	}
+	if change.ReadLimit != nil || change.WriteLimit != nil {
+		throttled.SetLimits(read, write)
+	}
+
+	slog.Info("remounted", "read_only", kfs.ReadOnly(), "consistency", *kfs.root.live.consistency.Load(),
+		"read_limit", read, "write_limit", write)
+	return kfs.Options(), nil
+}
+
+// Options returns the options remount changes as they are in effect.
+// The limits are nil without a limit layer.
+func (kfs *KoneksiFS) Options() RemountOptions {
+	readOnly := kfs.ReadOnly()
+	opts := RemountOptions{
+		ReadOnly:    &readOnly,
+		Consistency: kfs.root.live.consistency.Load(),
+	}
+	if throttled, ok := kfs.storage.(api.Throttled); ok {
+		if read, write, ok := throttled.Limits(); ok {
+			opts.ReadLimit, opts.WriteLimit = &read, &write
+		}
+	}
+	return opts
+}
@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// Flags of renameat2(2), from <linux/fs.h>.
+const (
+	renameNoReplace = 1 << 0
+	renameExchange  = 1 << 1
+)
+
+var _ = (fs.NodeRenamer)((*koneksiNode)(nil))
+
+// Rename moves the entry name to newName in newParent on the server,
+// replacing what is there as rename(2) does. Files and folders keep
+// their nodes, so handles open below a renamed folder carry on under
+// the new path, and cached copies move along.
+func (n *koneksiNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	oldPath := filepath.Join(n.path(), name)
+	if errno := n.checkProcess(ctx, "rename", oldPath, true); errno != 0 {
+		return errno
+	}
+	dst, ok := newParent.(*koneksiNode)
+	if !ok || n.overlay != nil {
+		// Let mv copy and delete instead.
+		return syscall.EXDEV
+	}
+	if dst.composite() {
+		return syscall.EPERM
+	}
+	if flags&renameExchange != 0 {
+		return syscall.EINVAL
+	}
+	if !n.health.writable() {
+		return syscall.EROFS
+	}
+
+	newPath := filepath.Join(dst.path(), newName)
+	ctx, end := n.deadline(ctx, "rename", &errno, oldPath)
+	defer end()
+	if errno := n.checkName(newName, newPath); errno != 0 {
+		return errno
+	}
+	child, ok := n.children.get(name)
+	if !ok {
+		if child = n.refreshChild(ctx, name); child == nil {
+			return syscall.ENOENT
+		}
+	}
+	if !child.stat().IsDir {
+		if errno := n.checkTypePolicy(newPath); errno != 0 {
+			return errno
+		}
+	}
+	// Moving an entry away removes it from its path as deleting does.
+	if errno := n.checkAppendOnly("rename", oldPath); errno != 0 {
+		return errno
+	}
+	if errno := n.checkRetention("rename", oldPath, child.stat()); errno != 0 {
+		return errno
+	}
+	if target, ok := dst.children.get(newName); ok && target != child {
+		if errno := replaceable(ctx, child, target, flags); errno != 0 {
+			return errno
+		}
+		if errno := n.checkRetention("rename over", newPath, target.stat()); errno != 0 {
+			return errno
+		}
+	}
+
+	// Hold off uploads below the old path until the nodes have moved, so
+	// none recreates it.
+	handles := n.handles.lockBelow(oldPath)
+	defer n.handles.unlockBelow(handles)
+
+	n.trace("rename", oldPath, "to", newPath)
+	err := n.storage.Move(ctx, oldPath, newPath)
+	if api.IsExists(err) {
+		// Created elsewhere since the folder was listed.
+		target := dst.refreshChild(ctx, newName)
+		if target == nil {
+			return syscall.EEXIST
+		}
+		if errno := replaceable(ctx, child, target, flags); errno != 0 {
+			return errno
+		}
+		if errno := n.checkRetention("rename over", newPath, target.stat()); errno != 0 {
+			return errno
+		}
+		if err = n.storage.Delete(ctx, newPath); err == nil || api.IsNotFound(err) {
+			err = n.storage.Move(ctx, oldPath, newPath)
+		}
+	}
+	if errors.Is(err, api.ErrCrossEncryption) {
+		// Let mv copy the content, encrypting or decrypting it.
+		return syscall.EXDEV
+	}
+	n.health.record(err)
+	if api.IsNotFound(err) {
+		return syscall.ENOENT
+	}
+	if err != nil {
+		slog.Warn("failed to rename", "path", oldPath, "to", newPath, "error", err)
+		return apiErrno(err)
+	}
+
+	n.children.remove(name)
+	child.place.Store(&place{parent: dst, name: newName})
+	child.modifyInfo(func(info *api.FileInfo) {
+		info.Name = newName
+		info.Path = newPath
+	})
+	dst.children.set(newName, child)
+
+	if n.cache != nil {
+		n.cache.Rename(oldPath, newPath)
+	}
+	n.workset.rename(oldPath, newPath)
+	for _, fh := range handles {
+		fh.renamed()
+	}
+	return 0
+}
+
+// replaceable returns the error renaming child over target fails with,
+// if any: both must be files or both folders, and a folder must be empty.
+func replaceable(ctx context.Context, child, target *koneksiNode, flags uint32) syscall.Errno {
+	switch {
+	case flags&renameNoReplace != 0:
+		return syscall.EEXIST
+	case !target.stat().IsDir:
+		if child.stat().IsDir {
+			return syscall.ENOTDIR
+		}
+		return 0
+	case !child.stat().IsDir:
+		return syscall.EISDIR
+	}
+
+	files, err := target.list(ctx)
+	if err != nil {
+		return syscall.EIO
+	}
+	if len(files) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	return 0
+}
+
+// renamed records the changes of fh under the new path of its file in
+// the recovery journal. Callers hold fh.mu.
+func (fh *koneksiFileHandle) renamed() {
+	p := fh.node.path()
+	if fh.staging != nil {
+		fh.staging.rename(p)
+	}
+	if fh.tail != nil {
+		fh.tail.rename(p)
+	}
+}
+
+// lockBelow locks the handles of the files at or below the remote path p
+// and returns them. Renames are serialized, so two never lock the same
+// handles in different orders.
+func (s *handleSet) lockBelow(p string) []*koneksiFileHandle {
+	s.renaming.Lock()
+
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	var locked []*koneksiFileHandle
+	for _, fh := range s.snapshot() {
+		if fp := fh.node.path(); fp == p || strings.HasPrefix(fp, prefix) {
+			fh.mu.Lock()
+			locked = append(locked, fh)
+		}
+	}
+	return locked
+}
+
+// unlockBelow unlocks the handles locked by lockBelow.
+func (s *handleSet) unlockBelow(handles []*koneksiFileHandle) {
+	for _, fh := range handles {
+		fh.mu.Unlock()
+	}
+	s.renaming.Unlock()
+}
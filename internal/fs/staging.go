@@ -0,0 +1,329 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"syscall"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/recovery"
+)
+
+// stagingBuffer holds the modified content of a file until it is
+// uploaded. Content up to limit bytes stays in memory; beyond that it
+// moves to a file in dir: the configured staging directory, or else the
+// cache directory, so the uploaded content can become the cached copy
+// without another copy, or the system temporary directory.
+//
+// Under memory pressure, content moves to the file on the next write
+// whatever its size. With a recovery journal, content is always kept in
+// a file of the journal, recorded there once created, so it survives a
+// crash of the mount.
+//
+// Before a staging file grows, dir is checked for room to spare minFree
+// bytes, so a large write fails early with ENOSPC instead of filling the
+// filesystem.
+type stagingBuffer struct {
+	limit     int64
+	minFree   int64
+	dir       string
+	create    func() (*os.File, error)
+	adoptable bool // the file can be moved into the cache
+	memory    *memoryBudget
+	journal   *recovery.Journal
+	entry     *recovery.Entry // recorded in journal once the file exists
+
+	mem      []byte
+	file     *os.File // set once the content outgrew limit
+	size     int64    // of file
+	reserved int64    // size file may reach before space is checked again
+}
+
+// spaceError reports a staging directory without room for a file.
+type spaceError struct {
+	dir       string
+	needed    int64
+	available int64
+}
+
+func (e *spaceError) Error() string {
+	return fmt.Sprintf("not enough space to stage in %s: %d bytes needed, %d available", e.dir, e.needed, e.available)
+}
+
+func (e *spaceError) Unwrap() error {
+	return syscall.ENOSPC
+}
+
+// newStaging returns an empty staging buffer for changes of n to base,
+// the remote version they replace or, if appending, are appended to.
+func (n *koneksiNode) newStaging(base *api.FileInfo, appending bool) *stagingBuffer {
+	b := &stagingBuffer{
+		limit:   n.cfg.Mount.WriteBuffer,
+		minFree: n.cfg.Mount.StagingMinFree,
+		memory:  n.memory,
+	}
+	switch {
+	case n.journal != nil:
+		b.limit = 0
+		b.dir = n.journal.Dir()
+		b.create = n.journal.Create
+		b.adoptable = n.cache != nil && n.cfg.Mount.StagingDir == ""
+		b.journal = n.journal
+		b.entry = &recovery.Entry{
+			Path:         n.path(),
+			Append:       appending,
+			BaseSize:     base.Size,
+			BaseModified: base.Modified,
+		}
+	case n.cfg.Mount.StagingDir != "":
+		b.dir = n.cfg.Mount.StagingDir
+	case n.cache != nil:
+		b.dir = n.cache.Dir()
+		b.create = n.cache.TempFile
+		b.adoptable = true
+	default:
+		b.dir = os.TempDir()
+	}
+	if b.create == nil {
+		b.create = func() (*os.File, error) {
+			return os.CreateTemp(b.dir, "koneksi-write-*")
+		}
+	}
+	return b
+}
+
+func (b *stagingBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if b.file != nil {
+		return b.file.ReadAt(p, off)
+	}
+	if off >= int64(len(b.mem)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.mem[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *stagingBuffer) WriteAt(p []byte, off int64) (int, error) {
+	b.record()
+	if b.file == nil {
+		end := off + int64(len(p))
+		if end <= b.limit && !b.memory.underPressure() {
+			b.grow(end)
+			return copy(b.mem[off:], p), nil
+		}
+		if err := b.spill(end); err != nil {
+			return 0, err
+		}
+	}
+	if end := off + int64(len(p)); end > b.size {
+		if err := b.reserve(end); err != nil {
+			return 0, err
+		}
+		b.size = end
+	}
+	return b.file.WriteAt(p, off)
+}
+
+// Truncate changes the size of the content, padding it with zeros when
+// it grows.
+func (b *stagingBuffer) Truncate(size int64) error {
+	b.record()
+	if b.file == nil {
+		if size <= b.limit {
+			if size < int64(len(b.mem)) {
+				b.mem = b.mem[:size]
+			} else {
+				b.grow(size)
+			}
+			return nil
+		}
+		if err := b.spill(size); err != nil {
+			return err
+		}
+	}
+	if size > b.size {
+		if err := b.reserve(size); err != nil {
+			return err
+		}
+	}
+	if err := b.file.Truncate(size); err != nil {
+		return err
+	}
+	b.size = size
+	return nil
+}
+
+// Grow prepares the buffer for size bytes of content, moving it to a file
+// if it will not fit in memory.
+func (b *stagingBuffer) Grow(size int64) error {
+	if b.file == nil && size > b.limit {
+		return b.spill(size)
+	}
+	return nil
+}
+
+// Size returns the size of the content.
+func (b *stagingBuffer) Size() int64 {
+	if b.file == nil {
+		return int64(len(b.mem))
+	}
+	return b.size
+}
+
+// Close discards the content.
+func (b *stagingBuffer) Close() error {
+	b.mem = nil
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	err := os.Remove(b.file.Name())
+	b.file = nil
+	b.forget()
+	return err
+}
+
+// keep closes the staging file but leaves it and its journal entry in
+// place, for changes that could not be uploaded to be recovered later.
+// It reports whether they were kept; without a journal, or with content
+// held in memory, they are discarded.
+func (b *stagingBuffer) keep() bool {
+	if b.journal == nil || b.file == nil {
+		b.Close()
+		return false
+	}
+	b.file.Close()
+	b.file = nil
+	return true
+}
+
+// detach closes the staging file and hands it to the caller, who becomes
+// responsible for removing it. It returns "" for content held in memory
+// or staged outside the cache directory.
+func (b *stagingBuffer) detach() string {
+	if b.file == nil || !b.adoptable {
+		return ""
+	}
+	name := b.file.Name()
+	b.file.Close()
+	b.file = nil
+	b.mem = nil
+	b.forget()
+	return name
+}
+
+// forget drops the journal entry of the content, which was uploaded or
+// discarded.
+func (b *stagingBuffer) forget() {
+	if b.entry == nil || b.entry.Content == "" {
+		return
+	}
+	if err := b.journal.Forget(b.entry); err != nil {
+		slog.Warn("failed to remove recovery journal entry", "path", b.entry.Path, "error", err)
+	}
+	b.entry.Content = ""
+}
+
+// grow extends the content held in memory to size bytes. Bytes exposed by
+// the extension are zeroed, as they may hold data cut off by Truncate.
+func (b *stagingBuffer) grow(size int64) {
+	old := int64(len(b.mem))
+	if size <= old {
+		return
+	}
+	if size <= int64(cap(b.mem)) {
+		b.mem = b.mem[:size]
+		clear(b.mem[old:])
+		return
+	}
+	mem := make([]byte, size, min(max(size, 2*int64(cap(b.mem))), b.limit))
+	copy(mem, b.mem)
+	b.mem = mem
+}
+
+// spill moves the content held in memory to a file that is about to
+// grow to size bytes.
+func (b *stagingBuffer) spill(size int64) error {
+	if err := b.reserve(size); err != nil {
+		return err
+	}
+
+	f, err := b.create()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.mem); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	b.file = f
+	b.size = int64(len(b.mem))
+	b.mem = nil
+	b.record()
+	return nil
+}
+
+// record adds the content to the journal, if any and not recorded yet.
+func (b *stagingBuffer) record() {
+	if b.entry == nil || b.entry.Content != "" || b.file == nil {
+		return
+	}
+	if err := b.journal.Record(b.entry, b.file.Name()); err != nil {
+		slog.Warn("failed to record changes in recovery journal", "path", b.entry.Path, "error", err)
+	}
+}
+
+// rename records the content in the journal, if any, as changes of the
+// remote path p, where the file was moved.
+func (b *stagingBuffer) rename(p string) {
+	if b.entry == nil {
+		return
+	}
+	b.entry.Path = p
+	if b.entry.Content != "" {
+		b.entry.Content = ""
+		b.record()
+	}
+}
+
+// rebase drops the journal entry of content that was uploaded but is
+// kept for further changes, which are recorded anew against info.
+func (b *stagingBuffer) rebase(info *api.FileInfo) {
+	if b.entry == nil {
+		return
+	}
+	b.forget()
+	b.entry = &recovery.Entry{Path: b.entry.Path, BaseSize: info.Size, BaseModified: info.Modified}
+}
+
+// reserve checks that the staging file can grow to size bytes. The
+// filesystem is only asked again once the file outgrows the space found
+// free last time.
+func (b *stagingBuffer) reserve(size int64) error {
+	if size <= b.reserved {
+		return nil
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(b.dir, &st); err != nil {
+		return err
+	}
+	available := int64(st.Bavail) * int64(st.Bsize)
+
+	var staged int64
+	if b.file != nil {
+		staged = b.size
+	}
+	needed := size - staged + b.minFree
+	if needed > available {
+		return &spaceError{dir: b.dir, needed: needed, available: available}
+	}
+	b.reserved = staged + available - b.minFree
+	return nil
+}
@@ -0,0 +1,242 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// SessionStats summarizes the traffic of a mount session, for users who
+// want to know what the mount cost them on a metered connection.
+type SessionStats struct {
+	Started    time.Time        `json:"started"`
+	Uploaded   int64            `json:"uploaded_bytes"`
+	Downloaded int64            `json:"downloaded_bytes"`
+	Calls      map[string]int64 `json:"api_calls"`
+	// Failed calls by class, such as "timeout" or "not-found", and calls
+	// by operation counted in the buckets of api.LatencyBuckets.
+	Errors  map[string]int64   `json:"api_errors"`
+	Latency map[string][]int64 `json:"api_latency"`
+	// Calls answered by an identical request already in flight.
+	SharedCalls int64 `json:"shared_api_calls"`
+	// Block reads sent again for being slow, and how many of those the
+	// second request answered first.
+	HedgedCalls int64 `json:"hedged_api_calls"`
+	HedgeWins   int64 `json:"hedge_wins"`
+	// Cache hits and misses of file opens; both zero without a cache.
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+	// The operations passed to each layer of api.layers and to the
+	// backend below them, the outermost first; none without layers.
+	Layers []api.LayerStats `json:"layers,omitempty"`
+	// The accounting tags of api.tags sent with every request, for
+	// charging the traffic back to them.
+	Tags map[string]string `json:"tags,omitempty"`
+	// The state of the mirror of a mirror mount; nil for other mounts.
+	Mirror *MirrorStats `json:"mirror,omitempty"`
+}
+
+// Session returns the traffic of the mount so far.
+func (kfs *KoneksiFS) Session() SessionStats {
+	traffic := kfs.traffic()
+	stats := SessionStats{
+		Started:     kfs.started,
+		Uploaded:    traffic.Uploaded,
+		Downloaded:  traffic.Downloaded,
+		Calls:       traffic.Calls,
+		Errors:      traffic.Errors,
+		Latency:     traffic.Latency,
+		SharedCalls: traffic.Shared,
+		HedgedCalls: traffic.Hedged,
+		HedgeWins:   traffic.HedgeWins,
+		Tags:        kfs.cfg.API.Tags,
+		Mirror:      kfs.Mirror(),
+	}
+	if layered, ok := kfs.storage.(api.Layered); ok {
+		stats.Layers = layered.Layers()
+	}
+	if kfs.cache != nil {
+		stats.CacheHits, stats.CacheMisses = kfs.cache.Hits()
+	}
+	return stats
+}
+
+// Capabilities returns what the access token allowed when the filesystem
+// was created.
+func (kfs *KoneksiFS) Capabilities() api.Capabilities {
+	return kfs.caps
+}
+
+// traffic returns the traffic of the client, none without the Koneksi
+// API.
+func (kfs *KoneksiFS) traffic() api.Traffic {
+	if kfs.client == nil {
+		return api.Traffic{}
+	}
+	return kfs.client.Traffic()
+}
+
+// APIVersion returns the API version the mount talks to, "" without the
+// Koneksi API.
+func (kfs *KoneksiFS) APIVersion() string {
+	if kfs.client == nil {
+		return ""
+	}
+	return kfs.client.APIVersion()
+}
+
+// Unreachable returns since when requests to the server fail right away
+// after it stopped answering, or the zero time if they do not.
+func (kfs *KoneksiFS) Unreachable() time.Time {
+	if kfs.client == nil {
+		return time.Time{}
+	}
+	return kfs.client.Unavailable()
+}
+
+// Quota returns the storage quota of the mounted directory.
+func (kfs *KoneksiFS) Quota() (*api.Quota, error) {
+	if kfs.client == nil {
+		return nil, api.ErrQuotaUnsupported
+	}
+	return kfs.client.Quota(context.Background())
+}
+
+// ReadOnly reports whether the mount currently refuses writes, either as
+// configured or after falling back to read-only.
+func (kfs *KoneksiFS) ReadOnly() bool {
+	return !kfs.root.health.writable()
+}
+
+// LogStats logs a summary of the mount's internal state followed by a
+// dump of all goroutines, for diagnosing hangs in a running mount.
+func (kfs *KoneksiFS) LogStats() {
+	open, dirty := kfs.root.handles.counts()
+	traffic := kfs.traffic()
+
+	attrs := []any{
+		"open_handles", open,
+		"dirty_handles", dirty,
+		"read_only", kfs.ReadOnly(),
+		"uploaded_bytes", traffic.Uploaded,
+		"downloaded_bytes", traffic.Downloaded,
+		"goroutines", runtime.NumGoroutine(),
+	}
+	if kfs.cache != nil {
+		entries, size := kfs.cache.Stats()
+		attrs = append(attrs, "cache_entries", entries, "cache_bytes", size)
+	}
+	if r := kfs.root.listings; r != nil {
+		r.mu.Lock()
+		hot := len(r.dirs)
+		r.mu.Unlock()
+		attrs = append(attrs, "tracked_folders", hot, "listings_refreshed", r.refreshed())
+	}
+	mem := kfs.MemoryUsage()
+	attrs = append(attrs, "nodes", mem.Nodes, "memory_bytes", mem.Total, "memory_pressure", mem.Pressure)
+	slog.Info("mount stats", attrs...)
+
+	fmt.Fprintln(os.Stderr, "--- goroutine dump ---")
+	pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+	fmt.Fprintln(os.Stderr, "--- end of goroutine dump ---")
+}
+
+// Flush uploads the pending changes of all open files and drops cached
+// directory listings, so the next access sees the server's current state.
+// Cached file content is kept; it is revalidated against the fresh
+// metadata before use.
+func (kfs *KoneksiFS) Flush() {
+	flushed, failed := kfs.root.handles.flush()
+	kfs.root.forgetChildren()
+
+	if failed > 0 {
+		slog.Error("flush finished with errors", "flushed", flushed, "failed", failed)
+		return
+	}
+	slog.Info("flushed dirty files and metadata cache", "flushed", flushed)
+}
+
+// Pending returns the remote paths of open files with changes not yet
+// uploaded.
+func (kfs *KoneksiFS) Pending() []string {
+	return kfs.root.handles.dirtyPaths(true)
+}
+
+// PendingNow is Pending without waiting for operations in flight, such
+// as uploads, whose files count as pending.
+func (kfs *KoneksiFS) PendingNow() []string {
+	return kfs.root.handles.dirtyPaths(false)
+}
+
+// Drain prepares the mount for unmounting: it refuses new changes with
+// EROFS, so nothing is written that could not be uploaded anymore, then
+// uploads the pending changes of open files and waits for operations and
+// uploads in flight, such as a file being created or the upload of one
+// just closed or of changes recovered at startup, giving up after
+// timeout. It returns the paths of open files
+// whose changes are still not uploaded; uploads that time out carry on in
+// the background. Resume accepts changes again if the mount is to stay.
+func (kfs *KoneksiFS) Drain(timeout time.Duration) []string {
+	kfs.root.health.draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	flush := func() bool {
+		done := make(chan struct{})
+		go func() {
+			kfs.root.handles.flush()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// Flushing again uploads what operations in flight changed.
+	if !flush() || kfs.root.io.idle(ctx) != nil || !flush() {
+		return kfs.root.handles.dirtyPaths(false)
+	}
+	return kfs.root.handles.dirtyPaths(true)
+}
+
+// Resume accepts changes again after Drain.
+func (kfs *KoneksiFS) Resume() {
+	kfs.root.health.draining.Store(false)
+}
+
+// forgetChildren drops the cached listing of this node and of every
+// directory below it. Open files and the directories leading to them
+// stay known, so their handles are not cut off from later lookups.
+func (n *koneksiNode) forgetChildren() {
+	open := n.handles.nodes()
+	dirs := make(map[string]bool)
+	for node := range open {
+		for dir := path.Dir(node.path()); !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	n.forgetChildrenExcept(func(child *koneksiNode) bool {
+		_, ok := open[child]
+		return ok || (child.stat().IsDir && dirs[child.path()])
+	})
+}
+
+func (n *koneksiNode) forgetChildrenExcept(keep func(child *koneksiNode) bool) {
+	n.preloaded.Store(nil)
+	n.listed.Store(0)
+	for _, child := range n.children.reset(keep) {
+		child.forgetChildrenExcept(keep)
+	}
+}
@@ -0,0 +1,267 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	// streamChunk is how much a stream reads from the server at a time.
+	streamChunk = 256 << 10
+	// streamBehind is how much already read content a stream keeps, for
+	// players stepping back a little, as when reading a frame again.
+	streamBehind = 4 << 20
+)
+
+// mediaExtensions are the extensions of audio and video files, which are
+// streamed by players from the start rather than read whole.
+var mediaExtensions = map[string]bool{
+	"aac": true, "avi": true, "flac": true, "flv": true, "m2ts": true,
+	"m4a": true, "m4v": true, "mkv": true, "mov": true, "mp3": true,
+	"mp4": true, "mpeg": true, "mpg": true, "mts": true, "ogg": true,
+	"ogv": true, "opus": true, "ts": true, "wav": true, "webm": true,
+	"wmv": true,
+}
+
+// streams reports whether a handle opened with flags should stream n
+// rather than read it through the cache, once its first read is at off.
+// Large files opened read-only are streamed when they are audio or video,
+// or when reading does not start at the beginning, as players probing a
+// container do; downloading all of such a file before the first read
+// returns keeps playback from starting for minutes. mount.io_rules can
+// stream files of any size, or never, and proc, the rule for the process
+// that opened the file, streams everything it reads that is not cached
+// if it is to be kept out of the cache or fetch no more than it reads.
+func (n *koneksiNode) streams(flags uint32, off int64, proc config.ProcessRule) bool {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 || n.cfg.Mount.CacheOnly() {
+		return false
+	}
+	forced := proc.NoCache || proc.NoReadahead
+	if !forced {
+		rule := n.ioRule()
+		if rule.Read == "blocks" || rule.Read == "whole" {
+			return false
+		}
+		forced = rule.Read == "stream" || rule.NoCache
+	}
+
+	info := n.stat()
+	minSize := n.cfg.Mount.StreamMinSize
+	if !forced && (minSize <= 0 || info.Size < minSize) {
+		return false
+	}
+	if n.cache != nil && n.cache.Cached(n.path(), info.Size, info.Modified, info.Hash) {
+		return false
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(n.path()), "."))
+	return forced || mediaExtensions[ext] || off > 0
+}
+
+// streamReader reads a file over one ranged request kept open between
+// reads, fetching ahead of the reader in the background. A read outside
+// the content fetched or about to be fetched starts a new request at its
+// offset. Nothing is cached. Under memory pressure, it fetches only
+// what is about to be read and keeps nothing behind the reader.
+type streamReader struct {
+	storage   api.StorageBackend
+	path      func() string // follows renames, for requests started later
+	size      int64
+	readahead int64
+	memory    *memoryBudget
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	fetch *streamFetch
+	pos   int64 // end of the last read
+}
+
+// streamFetch is one request of a streamReader and the content it
+// received that was not yet dropped.
+type streamFetch struct {
+	body   io.ReadCloser
+	start  int64 // file offset of data[0]
+	data   []byte
+	err    error // io.EOF or why the request failed, once it ended
+	closed bool
+}
+
+func newStreamReader(storage api.StorageBackend, path func() string, size, readahead int64, memory *memoryBudget) *streamReader {
+	s := &streamReader{storage: storage, path: path, size: size, readahead: readahead, memory: memory}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *streamReader) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > s.size {
+		end = s.size
+	}
+	s.pos = off
+
+	retried := false
+	for {
+		f := s.fetch
+		if f == nil || off < f.start || off > f.start+int64(len(f.data))+s.readahead {
+			if err := s.restart(off); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		for f.start+int64(len(f.data)) < end && f.err == nil && !f.closed {
+			s.cond.Broadcast()
+			s.cond.Wait()
+		}
+		if f.closed {
+			// Another read moved the stream elsewhere.
+			continue
+		}
+
+		n := 0
+		if avail := f.start + int64(len(f.data)); avail > off {
+			n = copy(p[:end-off], f.data[off-f.start:])
+		}
+		if n < int(end-off) && f.err != io.EOF {
+			// The request failed or timed out; continue with a new one.
+			if !retried {
+				retried = true
+				slog.Debug("restarting stream", "path", s.path(), "offset", off+int64(n), "error", f.err)
+				if err := s.restart(off); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			return n, f.err
+		}
+
+		s.pos = off + int64(n)
+		s.trim(f)
+		s.cond.Broadcast()
+		return n, nil
+	}
+}
+
+// restart closes the current request and starts one at off. It is called
+// with s.mu held.
+func (s *streamReader) restart(off int64) error {
+	s.stop()
+
+	body, partial, err := api.ReadFrom(context.Background(), s.storage, s.path(), off)
+	if err != nil {
+		return err
+	}
+	f := &streamFetch{body: body, start: off}
+	if !partial {
+		f.start = 0
+	}
+	s.fetch = f
+	go s.run(f, off)
+	return nil
+}
+
+// run fetches the content of f until it ends, keeping at most readahead
+// bytes ahead of the reader. skipTo drops the content before it, for a
+// server that ignored the range.
+func (s *streamReader) run(f *streamFetch, skipTo int64) {
+	buf := make([]byte, streamChunk)
+	for {
+		s.mu.Lock()
+		for !f.closed && f.start+int64(len(f.data))-s.pos >= s.ahead() {
+			s.cond.Wait()
+		}
+		closed := f.closed
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+
+		n, err := f.body.Read(buf)
+
+		s.mu.Lock()
+		if f.closed {
+			s.mu.Unlock()
+			return
+		}
+		f.data = append(f.data, buf[:n]...)
+		if f.start < skipTo {
+			drop := min(skipTo-f.start, int64(len(f.data)))
+			f.data = f.data[drop:]
+			f.start += drop
+		}
+		if err != nil {
+			f.err = err
+			f.body.Close()
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ahead returns how far ahead of the reader to fetch.
+func (s *streamReader) ahead() int64 {
+	if s.memory.underPressure() {
+		return streamChunk
+	}
+	return s.readahead
+}
+
+// buffered returns the memory held by the content fetched.
+func (s *streamReader) buffered() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fetch == nil {
+		return 0
+	}
+	return int64(cap(s.fetch.data))
+}
+
+// trim drops the content of f further behind the reader than
+// streamBehind, so memory use stays bounded however long the file.
+func (s *streamReader) trim(f *streamFetch) {
+	behind := int64(streamBehind)
+	if s.memory.underPressure() {
+		behind = 0
+	}
+	drop := s.pos - behind - f.start
+	if drop < streamChunk {
+		return
+	}
+	f.data = f.data[drop:]
+	f.start += drop
+}
+
+// stop closes the current request. It is called with s.mu held.
+func (s *streamReader) stop() {
+	if f := s.fetch; f != nil {
+		f.closed = true
+		f.body.Close()
+		s.fetch = nil
+		s.cond.Broadcast()
+	}
+}
+
+func (s *streamReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stop()
+	return nil
+}
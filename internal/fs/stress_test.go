@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	stressDirs  = 8
+	stressFiles = 64
+)
+
+// newStressFS returns the folders of a filesystem on a local backend:
+// stressDirs folders of stressFiles files, each looked up once.
+func newStressFS(tb testing.TB) []*koneksiNode {
+	tb.Helper()
+	root := tb.TempDir()
+	for d := 0; d < stressDirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%d", d))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < stressFiles; f++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", f)), []byte("data"), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.API.DirectoryID = "stress"
+	cfg.Mount.RemotePath = "/"
+	cfg.Mount.Consistency = "default"
+	b, err := api.NewLocalBackend(root)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	kfs, err := newKoneksiFS(cfg, b, nil, api.Capabilities{Write: true}, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// Attaches the root inode, which creating children needs.
+	fs.NewNodeFS(kfs.root, &fs.Options{})
+
+	dirs := make([]*koneksiNode, stressDirs)
+	for d := range dirs {
+		var out fuse.EntryOut
+		inode, errno := kfs.root.Lookup(context.Background(), fmt.Sprintf("dir%d", d), &out)
+		if errno != 0 {
+			tb.Fatalf("lookup dir%d: %v", d, errno)
+		}
+		dirs[d] = inode.Operations().(*koneksiNode)
+	}
+	return dirs
+}
+
+// stressOp looks up a file of a folder or, every eighth time, lists the
+// folder, as a metadata-heavy workload such as a build or a file indexer
+// does.
+func stressOp(dirs []*koneksiNode, i int) error {
+	dir := dirs[i%len(dirs)]
+	if i%8 == 0 {
+		entries, errno := dir.readdirEntries(context.Background())
+		if errno != 0 {
+			return fmt.Errorf("readdir %s: %v", dir.path(), errno)
+		}
+		if len(entries) != stressFiles {
+			return fmt.Errorf("readdir %s: %d entries, want %d", dir.path(), len(entries), stressFiles)
+		}
+		return nil
+	}
+	name := fmt.Sprintf("file%d", i%stressFiles)
+	var out fuse.EntryOut
+	if _, errno := dir.Lookup(context.Background(), name, &out); errno != 0 {
+		return fmt.Errorf("lookup %s/%s: %v", dir.path(), name, errno)
+	}
+	if _, errno := dir.Lookup(context.Background(), "missing", &out); errno != syscall.ENOENT {
+		return fmt.Errorf("lookup %s/missing: got %v, want ENOENT", dir.path(), errno)
+	}
+	return nil
+}
+
+// TestParallelLookupReaddir looks up and lists the same folders from many
+// goroutines at once. Run with -race to catch unsynchronized access to
+// nodes.
+func TestParallelLookupReaddir(t *testing.T) {
+	dirs := newStressFS(t)
+
+	const workers, ops = 32, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				if err := stressOp(dirs, w*ops+i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkParallelLookupReaddir measures the throughput of lookups and
+// listings from GOMAXPROCS goroutines; compare runs with -cpu 1,4,16.
+func BenchmarkParallelLookupReaddir(b *testing.B) {
+	dirs := newStressFS(b)
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := stressOp(dirs, int(next.Add(1))); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
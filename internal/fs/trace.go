@@ -0,0 +1,27 @@
+package fs
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// traceLog logs the operations and API calls on the paths matching
+// mount.trace_path. It logs at debug level whatever the level of the
+// default logger, so a trace of one misbehaving file can be taken
+// without the debug logs of everything else.
+var traceLog = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})).With("trace", true)
+
+// traced reports whether operations on the remote path p are traced.
+func (n *koneksiNode) traced(p string) bool {
+	pattern := n.cfg.Mount.TracePath
+	return pattern != "" && config.MatchPath(pattern, p)
+}
+
+// trace logs op on the remote path p with attrs if p is traced.
+func (n *koneksiNode) trace(op, p string, attrs ...any) {
+	if n.traced(p) {
+		traceLog.Debug("operation", append([]any{"op", op, "path", p}, attrs...)...)
+	}
+}
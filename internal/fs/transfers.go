@@ -0,0 +1,56 @@
+package fs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Transfer is an upload or download in progress.
+type Transfer struct {
+	Kind    string    `json:"kind"` // "upload" or "download"
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Started time.Time `json:"started"`
+}
+
+// transferSet tracks the transfers in progress, for the status API.
+type transferSet struct {
+	mu     sync.Mutex
+	next   uint64
+	active map[uint64]Transfer
+}
+
+func newTransferSet() *transferSet {
+	return &transferSet{active: make(map[uint64]Transfer)}
+}
+
+// start records a transfer and returns the function to call when it
+// ends.
+func (s *transferSet) start(kind, path string, size int64) (done func()) {
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.active[id] = Transfer{Kind: kind, Path: path, Size: size, Started: time.Now()}
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.active, id)
+		s.mu.Unlock()
+	}
+}
+
+// Transfers returns the uploads and downloads in progress, oldest first.
+func (kfs *KoneksiFS) Transfers() []Transfer {
+	s := kfs.root.transfers
+	s.mu.Lock()
+	transfers := make([]Transfer, 0, len(s.active))
+	for _, t := range s.active {
+		transfers = append(transfers, t)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].Started.Before(transfers[j].Started) })
+	return transfers
+}
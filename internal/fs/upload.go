@@ -0,0 +1,173 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/chunker"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+)
+
+// openCached returns the node content from the cache, revalidating it
+// against the server once the cache TTL has passed, or mount.relaxed_ttl
+// under relaxed consistency, and downloading it when missing or stale.
+// While the server cannot be reached, a cached copy is used as it is.
+// Content cached for another path is used when the server reports the
+// same hash for it. info is the version the caller expects, normally the
+// node metadata.
+func (n *koneksiNode) openCached(info *api.FileInfo) (*os.File, error) {
+	size, modified, hash := info.Size, info.Modified, info.Hash
+
+	if f, ok := n.cache.Open(n.path(), size, modified, hash); ok {
+		if n.cacheFresh() || n.cfg.Mount.CacheOnly() {
+			return f, nil
+		}
+
+		info, err := n.storage.Stat(context.Background(), n.path())
+		if err == nil && info.Size == size && info.Modified.Equal(modified) {
+			n.cache.Validated(n.path())
+			return f, nil
+		}
+		if api.IsUnreachable(err) {
+			// Stale maybe, but better than nothing while the server is down.
+			slog.Debug("serving unvalidated cached copy", "path", n.path(), "error", err)
+			return f, nil
+		}
+		f.Close()
+
+		if err == nil {
+			n.updateInfo(info)
+			size, modified, hash = info.Size, info.Modified, info.Hash
+			if hash != "" {
+				if f, ok := n.cache.Open(n.path(), size, modified, hash); ok {
+					n.cache.Validated(n.path())
+					return f, nil
+				}
+			}
+		}
+	}
+
+	f, err := n.download(size, modified, hash)
+	if err != nil && n.cfg.Cache.ServeStaleOnError && api.IsUnreachable(err) {
+		if stale, staleModified, ok := n.cache.OpenStale(n.path()); ok {
+			slog.Warn("serving outdated cached copy", "path", n.path(), "cached", staleModified, "current", modified, "error", err)
+			return stale, nil
+		}
+	}
+	return f, err
+}
+
+// download fetches the content of the node into the cache.
+func (n *koneksiNode) download(size int64, modified time.Time, hash string) (*os.File, error) {
+	done := n.transfers.start("download", n.path(), size)
+	defer done()
+	reader, err := n.storage.Read(context.Background(), n.path())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	f, err := n.cache.Fill(n.path(), size, modified, hash, reader)
+	if err == nil {
+		n.hooks.Fire(hooks.Event{Event: hooks.Download, Path: n.path(), Size: size})
+	}
+	return f, err
+}
+
+// uploadAppend adds the content of b to the remote file, which must still
+// end at offset. The cached copy, lacking the new data, is dropped.
+func (n *koneksiNode) uploadAppend(offset int64, b *stagingBuffer) error {
+	size := b.Size()
+	if size == 0 {
+		return nil
+	}
+	done := n.transfers.start("upload", n.path(), size)
+	end := n.io.begin(classFlush)
+	err := api.Append(context.Background(), n.storage, n.path(), offset, io.NewSectionReader(b, 0, size), size)
+	end()
+	done()
+	n.health.record(err)
+	if err != nil {
+		return err
+	}
+
+	info, err := n.storage.Stat(context.Background(), n.path())
+	if err != nil {
+		info = &api.FileInfo{Size: offset + size, Modified: time.Now()}
+	}
+	n.updateInfo(info)
+	n.hooks.Fire(hooks.Event{Event: hooks.Upload, Path: n.path(), Size: info.Size, Details: map[string]any{"appended": size}})
+
+	if n.cache != nil {
+		n.cache.Remove(n.path())
+	}
+	return nil
+}
+
+// upload replaces the remote content of the node with the staged
+// content. Large files are diffed against the cached copy, if any, so
+// only changed chunks are sent.
+//
+// With caching enabled, unless mount.io_rules keep the file out of the
+// cache, the staged content becomes the new cached copy,
+// moved into place rather than copied when it was staged in a file, and
+// is returned opened for reading; b is empty afterwards. The returned file
+// is nil if the content could not be cached.
+func (n *koneksiNode) upload(b *stagingBuffer) (*os.File, error) {
+	size := b.Size()
+
+	var base []chunker.Chunk
+	if n.cache != nil && n.uploader.Chunked(n.path(), size) {
+		// Without a usable base every chunk is a candidate.
+		base, _ = n.cache.Chunks(n.path(), n.uploader.Chunker())
+	}
+
+	done := n.transfers.start("upload", n.path(), size)
+	end := n.io.begin(classFlush)
+	chunks, err := n.uploader.Upload(context.Background(), n.path(), b, size, base)
+	end()
+	done()
+	n.health.record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer the server's view of the new file so cached content stays
+	// valid against later listings.
+	info, err := n.storage.Stat(context.Background(), n.path())
+	if err != nil {
+		info = &api.FileInfo{Size: size, Modified: time.Now()}
+	}
+	n.updateInfo(info)
+	n.hooks.Fire(hooks.Event{Event: hooks.Upload, Path: n.path(), Size: size})
+
+	if n.cache == nil {
+		b.rebase(info)
+		return nil, nil
+	}
+	if n.ioRule().NoCache {
+		b.Close()
+		n.cache.Remove(n.path())
+		return nil, nil
+	}
+
+	var cached *os.File
+	if name := b.detach(); name != "" {
+		cached, err = n.cache.Adopt(n.path(), info.Modified, info.Hash, name)
+	} else {
+		cached, err = n.cache.Fill(n.path(), info.Size, info.Modified, info.Hash, io.NewSectionReader(b, 0, size))
+		b.Close()
+	}
+	if err != nil {
+		n.cache.Remove(n.path())
+		return nil, nil
+	}
+	if chunks != nil {
+		n.cache.SetChunks(n.path(), chunks)
+	}
+	return cached, nil
+}
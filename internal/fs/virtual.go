@@ -0,0 +1,214 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// The virtual directory (".koneksi" by default) lives at the mount root
+// and exposes server-side features as read-only folders:
+//
+//	.koneksi/recent/          recently modified files
+//	.koneksi/search/<query>/  files whose name matches <query>
+//
+// Entries are symlinks to the files' real locations in the mount, so
+// opening them behaves exactly like opening the original. The directory
+// is not listed in the root so recursive tools do not wander into it.
+
+// recentLimit is the number of files shown in the recent folder.
+const recentLimit = 100
+
+// virtualDirNode is the top of the virtual directory.
+type virtualDirNode struct {
+	fs.Inode
+
+	client *api.Client
+	cfg    *config.Config
+}
+
+var _ = (fs.NodeLookuper)((*virtualDirNode)(nil))
+var _ = (fs.NodeReaddirer)((*virtualDirNode)(nil))
+var _ = (fs.NodeGetattrer)((*virtualDirNode)(nil))
+
+func (v *virtualDirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	switch name {
+	case "recent":
+		node := &queryResultsNode{
+			cfg:   v.cfg,
+			depth: 2,
+			query: func(ctx context.Context) ([]api.FileInfo, error) { return v.client.Recent(ctx, recentLimit) },
+		}
+		setVirtualDirAttr(&out.Attr, v.cfg)
+		return v.NewInode(ctx, node, fs.StableAttr{Mode: syscall.S_IFDIR}), 0
+	case "search":
+		node := &searchDirNode{client: v.client, cfg: v.cfg}
+		setVirtualDirAttr(&out.Attr, v.cfg)
+		return v.NewInode(ctx, node, fs.StableAttr{Mode: syscall.S_IFDIR}), 0
+	}
+	return nil, syscall.ENOENT
+}
+
+func (v *virtualDirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewListDirStream([]fuse.DirEntry{
+		{Name: "recent", Mode: syscall.S_IFDIR},
+		{Name: "search", Mode: syscall.S_IFDIR},
+	}), 0
+}
+
+func (v *virtualDirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setVirtualDirAttr(&out.Attr, v.cfg)
+	return 0
+}
+
+// searchDirNode resolves any name looked up in it as a search query. It
+// lists as empty since queries cannot be enumerated.
+type searchDirNode struct {
+	fs.Inode
+
+	client *api.Client
+	cfg    *config.Config
+}
+
+var _ = (fs.NodeLookuper)((*searchDirNode)(nil))
+var _ = (fs.NodeReaddirer)((*searchDirNode)(nil))
+var _ = (fs.NodeGetattrer)((*searchDirNode)(nil))
+
+func (s *searchDirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	node := &queryResultsNode{
+		cfg:   s.cfg,
+		depth: 3,
+		query: func(ctx context.Context) ([]api.FileInfo, error) {
+			return s.client.Search(ctx, api.SearchQuery{Name: name})
+		},
+	}
+	setVirtualDirAttr(&out.Attr, s.cfg)
+	return s.NewInode(ctx, node, fs.StableAttr{Mode: syscall.S_IFDIR}), 0
+}
+
+func (s *searchDirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewListDirStream(nil), 0
+}
+
+func (s *searchDirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setVirtualDirAttr(&out.Attr, s.cfg)
+	return 0
+}
+
+// queryResultsNode lists the files returned by a server query as
+// symlinks. depth is how many levels below the mount root the node sits,
+// used to build relative link targets.
+type queryResultsNode struct {
+	fs.Inode
+
+	cfg   *config.Config
+	depth int
+	query func(ctx context.Context) ([]api.FileInfo, error)
+
+	mu      sync.Mutex
+	targets map[string]string // entry name -> symlink target
+}
+
+var _ = (fs.NodeLookuper)((*queryResultsNode)(nil))
+var _ = (fs.NodeReaddirer)((*queryResultsNode)(nil))
+var _ = (fs.NodeGetattrer)((*queryResultsNode)(nil))
+
+func (q *queryResultsNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	q.mu.Lock()
+	targets := q.targets
+	q.mu.Unlock()
+
+	if targets == nil {
+		var errno syscall.Errno
+		if targets, errno = q.refresh(ctx); errno != 0 {
+			return nil, errno
+		}
+	}
+
+	target, ok := targets[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	link := &fs.MemSymlink{Data: []byte(target)}
+	setVirtualAttr(&link.Attr, q.cfg, syscall.S_IFLNK|0777)
+	link.Attr.Size = uint64(len(target))
+	out.Attr = link.Attr
+	return q.NewInode(ctx, link, fs.StableAttr{Mode: syscall.S_IFLNK}), 0
+}
+
+func (q *queryResultsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	targets, errno := q.refresh(ctx)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(targets))
+	for name := range targets {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: syscall.S_IFLNK})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (q *queryResultsNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setVirtualDirAttr(&out.Attr, q.cfg)
+	return 0
+}
+
+// refresh runs the query and rebuilds the entry names. Files with the
+// same name in different folders get a numeric suffix. Files outside
+// what is mounted are left out, as the links could not reach them.
+func (q *queryResultsNode) refresh(ctx context.Context) (map[string]string, syscall.Errno) {
+	files, err := q.query(ctx)
+	if err != nil {
+		return nil, syscall.EIO
+	}
+
+	up := strings.Repeat("../", q.depth)
+	targets := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.Path == "" {
+			continue
+		}
+		rel, ok := mountedPath(q.cfg, path.Clean(file.Path))
+		if !ok || rel == "" {
+			continue
+		}
+
+		name := file.Name
+		for i := 2; targets[name] != ""; i++ {
+			ext := path.Ext(file.Name)
+			name = fmt.Sprintf("%s~%d%s", strings.TrimSuffix(file.Name, ext), i, ext)
+		}
+		targets[name] = up + rel
+	}
+
+	q.mu.Lock()
+	q.targets = targets
+	q.mu.Unlock()
+
+	return targets, 0
+}
+
+func setVirtualDirAttr(attr *fuse.Attr, cfg *config.Config) {
+	setVirtualAttr(attr, cfg, syscall.S_IFDIR|0555)
+}
+
+func setVirtualAttr(attr *fuse.Attr, cfg *config.Config, mode uint32) {
+	now := uint64(time.Now().Unix())
+	attr.Mode = mode
+	attr.Mtime = now
+	attr.Ctime = now
+	attr.Atime = now
+	attr.Uid = cfg.Mount.UID
+	attr.Gid = cfg.Mount.GID
+}
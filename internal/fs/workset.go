@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/workset"
+)
+
+// worksetInterval is how often the working set is written to disk, so a
+// crash loses little of it.
+const worksetInterval = 5 * time.Minute
+
+// workingSet records the files read through the mount, for the advise
+// command.
+type workingSet struct {
+	file string
+
+	mu    sync.Mutex
+	set   *workset.Set
+	dirty bool
+}
+
+// newWorkingSet loads the working set recorded for the configured
+// directory, or returns nil if it is not recorded.
+func newWorkingSet(cfg *config.Config) *workingSet {
+	if !cfg.Mount.WorkingSet {
+		return nil
+	}
+	file, err := workset.Path(cfg.API.DirectoryID)
+	if err != nil {
+		slog.Warn("not recording the working set", "error", err)
+		return nil
+	}
+	set, err := workset.Load(file)
+	if err != nil {
+		slog.Warn("not recording the working set", "error", err)
+		return nil
+	}
+	return &workingSet{file: file, set: set}
+}
+
+// read records a read of the remote file p; see workset.Set.Read.
+func (w *workingSet) read(p string, size, off int64, n int, opened, continued bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.set.Read(p, size, off, n, opened, continued, time.Now())
+	w.dirty = true
+	w.mu.Unlock()
+}
+
+// rename moves what was recorded about oldPath and below to newPath.
+func (w *workingSet) rename(oldPath, newPath string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.set.Rename(oldPath, newPath)
+	w.dirty = true
+	w.mu.Unlock()
+}
+
+// save writes the working set to disk if it changed.
+func (w *workingSet) save() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return
+	}
+	if err := w.set.Save(w.file, time.Now()); err != nil {
+		slog.Warn("failed to save the working set", "error", err)
+		return
+	}
+	w.dirty = false
+}
+
+// saveWorkingSet saves the working set every worksetInterval until ctx
+// is cancelled.
+func (kfs *KoneksiFS) saveWorkingSet(ctx context.Context) {
+	ticker := time.NewTicker(worksetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			kfs.root.workset.save()
+		}
+	}
+}
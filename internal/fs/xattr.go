@@ -0,0 +1,310 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// Extended attributes exposing Koneksi features inside the mount. They
+// are deliberately not listed by listxattr so that tools copying all
+// attributes (cp -a, rsync -X) never create links as a side effect.
+const (
+	// xattrShare creates a share link when set. The value holds
+	// comma-separated options, e.g. "expires=7d,password=secret".
+	xattrShare = "user.koneksi.share"
+	// xattrShareLink returns the URL of the link created for this node,
+	// creating one without expiry or password if there is none yet.
+	xattrShareLink = "user.koneksi.share_link"
+	// xattrThumbnail returns a preview image rendered by the server, so
+	// previews can be shown without downloading the original file.
+	xattrThumbnail = "user.koneksi.thumbnail"
+	// xattrRetainUntil holds the RFC 3339 time a file under retention may
+	// not be changed or deleted before. Setting it, to a time or a period
+	// from now such as "7y", extends the retention.
+	xattrRetainUntil = "user.koneksi.retain_until"
+
+	// Read-only attributes telling who can access a node besides its
+	// owner, for auditing what is shared: the owner, the users and groups
+	// it is shared with as "principal role" lines, and the public links
+	// to it, one URL per line. The latter two are empty if there are
+	// none; unlike xattrShareLink, reading them never creates a link.
+	xattrOwner      = "user.koneksi.owner"
+	xattrSharedWith = "user.koneksi.shared_with"
+	xattrLinks      = "user.koneksi.links"
+)
+
+// sharingTTL is how long the sharing of a node is reused for its xattrs,
+// so reading all of them, or those of many files, asks the server once
+// per file.
+const sharingTTL = 30 * time.Second
+
+// thumbnailSize is the longest side, in pixels, of requested thumbnails.
+const thumbnailSize = 256
+
+// maxXattrSize is the largest value Linux accepts from getxattr.
+const maxXattrSize = 64 << 10
+
+// Implement fs.NodeGetxattrer
+var _ = (fs.NodeGetxattrer)((*koneksiNode)(nil))
+
+func (n *koneksiNode) Getxattr(ctx context.Context, attr string, dest []byte) (_ uint32, errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "getxattr", &errno, n.path())
+	defer end()
+	n.trace("getxattr", n.path(), "attr", attr)
+	var value []byte
+
+	switch attr {
+	case xattrShareLink:
+		link, err := n.shareLinkOrCreate(ctx)
+		if err == api.ErrShareNotAllowed {
+			return 0, fs.ENOATTR
+		}
+		if err != nil {
+			return 0, syscall.EIO
+		}
+		value = []byte(link.URL)
+	case xattrThumbnail:
+		data, err := n.thumbnail(ctx)
+		if err == api.ErrNoThumbnail {
+			return 0, fs.ENOATTR
+		}
+		if err != nil {
+			return 0, syscall.EIO
+		}
+		if len(data) > maxXattrSize {
+			return 0, syscall.E2BIG
+		}
+		value = data
+	case xattrRetainUntil:
+		info := n.stat()
+		if info.RetainUntil == nil {
+			return 0, fs.ENOATTR
+		}
+		value = []byte(info.RetainUntil.UTC().Format(time.RFC3339))
+	case xattrOwner, xattrSharedWith, xattrLinks:
+		sharing, err := n.sharingInfo(ctx)
+		if errors.Is(err, api.ErrSharingUnsupported) {
+			return 0, fs.ENOATTR
+		}
+		if err != nil {
+			return 0, apiErrno(err)
+		}
+		value = sharingXattr(attr, sharing)
+		if value == nil {
+			return 0, fs.ENOATTR
+		}
+	default:
+		return 0, fs.ENOATTR
+	}
+
+	if len(dest) == 0 {
+		return uint32(len(value)), 0
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+// Implement fs.NodeSetxattrer
+var _ = (fs.NodeSetxattrer)((*koneksiNode)(nil))
+
+func (n *koneksiNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	ctx, end := n.deadline(ctx, "setxattr", &errno, n.path())
+	defer end()
+	if errno := n.checkProcess(ctx, "setxattr", n.path(), true); errno != 0 {
+		return errno
+	}
+	switch attr {
+	case xattrShare:
+		if n.client == nil {
+			return syscall.ENOTSUP
+		}
+		opts, err := parseShareOptions(string(data))
+		if err != nil {
+			return syscall.EINVAL
+		}
+		link, err := n.client.CreateShareLink(ctx, n.path(), opts)
+		if err == api.ErrShareNotAllowed {
+			return syscall.EPERM
+		}
+		if err != nil {
+			return syscall.EIO
+		}
+		n.mu.Lock()
+		n.shareLink = link
+		n.sharing = nil
+		n.mu.Unlock()
+		return 0
+	case xattrRetainUntil:
+		return n.setRetention(ctx, string(data))
+	}
+
+	return syscall.ENOTSUP
+}
+
+// setRetention keeps the file from being changed or deleted before the
+// time value gives.
+func (n *koneksiNode) setRetention(ctx context.Context, value string) syscall.Errno {
+	if n.stat().IsDir {
+		return syscall.EISDIR
+	}
+	if n.client == nil {
+		return syscall.ENOTSUP
+	}
+	until, err := config.ParseUntil(strings.TrimSpace(value), time.Now())
+	if err != nil {
+		return syscall.EINVAL
+	}
+
+	info, err := n.client.SetRetention(ctx, n.path(), until)
+	switch {
+	case errors.Is(err, api.ErrRetentionShortened):
+		slog.Warn("retention can only be extended", "path", n.path(), "until", until)
+		return syscall.EPERM
+	case errors.Is(err, api.ErrRetentionUnsupported):
+		return syscall.ENOTSUP
+	case err != nil:
+		slog.Warn("failed to set retention", "path", n.path(), "error", err)
+		return syscall.EIO
+	}
+	n.modifyInfo(func(i *api.FileInfo) { i.RetainUntil = info.RetainUntil })
+	return 0
+}
+
+func (n *koneksiNode) shareLinkOrCreate(ctx context.Context) (*api.ShareLink, error) {
+	n.mu.RLock()
+	link := n.shareLink
+	n.mu.RUnlock()
+	if link != nil {
+		return link, nil
+	}
+	if n.client == nil {
+		return nil, api.ErrShareNotAllowed
+	}
+
+	link, err := n.client.CreateShareLink(ctx, n.path(), api.ShareLinkOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	n.shareLink = link
+	n.sharing = nil
+	n.mu.Unlock()
+	return link, nil
+}
+
+// sharingInfo returns who the node is shared with, asking the server
+// again only after sharingTTL.
+func (n *koneksiNode) sharingInfo(ctx context.Context) (*api.Sharing, error) {
+	n.mu.RLock()
+	sharing := n.sharing
+	fresh := sharing != nil && time.Since(n.sharingFetched) < sharingTTL
+	n.mu.RUnlock()
+	if fresh {
+		return sharing, nil
+	}
+	if n.client == nil {
+		return nil, api.ErrSharingUnsupported
+	}
+
+	sharing, err := n.client.Sharing(ctx, n.path())
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	n.sharing = sharing
+	n.sharingFetched = time.Now()
+	n.mu.Unlock()
+	return sharing, nil
+}
+
+// sharingXattr returns the value of the sharing xattr attr, or nil if
+// there is none.
+func sharingXattr(attr string, s *api.Sharing) []byte {
+	var b strings.Builder
+	switch attr {
+	case xattrOwner:
+		if s.Owner == "" {
+			return nil
+		}
+		b.WriteString(s.Owner)
+	case xattrSharedWith:
+		for _, g := range s.SharedWith {
+			fmt.Fprintf(&b, "%s %s\n", g.Principal, g.Role)
+		}
+	case xattrLinks:
+		for _, link := range s.Links {
+			b.WriteString(link.URL + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// thumbnail returns the node's preview image, fetching it again only
+// when the file has changed since it was last fetched.
+func (n *koneksiNode) thumbnail(ctx context.Context) ([]byte, error) {
+	info := n.stat()
+	if info.IsDir || n.client == nil {
+		return nil, api.ErrNoThumbnail
+	}
+	modified := info.Modified
+
+	n.mu.RLock()
+	data := n.thumb
+	fresh := data != nil && n.thumbModified.Equal(modified)
+	n.mu.RUnlock()
+	if fresh {
+		return data, nil
+	}
+
+	data, _, err := n.client.Thumbnail(ctx, n.path(), thumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	n.thumb = data
+	n.thumbModified = modified
+	n.mu.Unlock()
+	return data, nil
+}
+
+// parseShareOptions parses "key=value" pairs separated by commas.
+func parseShareOptions(s string) (api.ShareLinkOptions, error) {
+	var opts api.ShareLinkOptions
+
+	for _, field := range strings.Split(strings.TrimSpace(s), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "expires":
+			d, err := config.ParseDuration(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Expires = d
+		case "password":
+			opts.Password = value
+		default:
+			return opts, fmt.Errorf("unknown share option %q", key)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,205 @@
+// Package hooks runs user-configured commands and posts to webhooks when
+// files are uploaded, downloaded or deleted, when sync finds a conflict
+// and when a sync finishes, so other tools can follow what happens
+// without polling.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// Events hooks can be configured for.
+const (
+	Upload       = "upload"
+	Download     = "download"
+	Delete       = "delete"
+	Conflict     = "conflict"
+	SyncFinished = "sync-finished"
+)
+
+const (
+	// defaultTimeout bounds a hook without a configured timeout.
+	defaultTimeout = 10 * time.Second
+	// queueSize is how many events wait for delivery before new ones are
+	// dropped, so a slow hook never holds up file operations.
+	queueSize = 1000
+)
+
+// Event is what happened, passed to hooks as JSON.
+type Event struct {
+	Event     string         `json:"event"`
+	Time      time.Time      `json:"time"`
+	Source    string         `json:"source"`          // "mount", "sync", "put", "get", "copy" or "rcat"
+	Directory string         `json:"directory"`       // Koneksi directory ID
+	Path      string         `json:"path,omitempty"`  // remote path
+	Local     string         `json:"local,omitempty"` // local path, for sync, put and get
+	Size      int64          `json:"size,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// Hooks delivers events to the configured hooks in the background, one
+// at a time and in order. A nil *Hooks ignores events.
+type Hooks struct {
+	hooks     []config.HookConfig
+	source    string
+	directory string
+	client    *http.Client
+
+	mu     sync.Mutex // guards closed and sending to queue
+	closed bool
+	queue  chan Event
+	done   chan struct{} // closed when delivery stopped
+}
+
+// New starts delivering the events source fires to hooks. It returns nil
+// if there are no hooks.
+func New(hooks []config.HookConfig, directory, source string) *Hooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+	h := &Hooks{
+		hooks:     hooks,
+		source:    source,
+		directory: directory,
+		client:    &http.Client{},
+		queue:     make(chan Event, queueSize),
+		done:      make(chan struct{}),
+	}
+	go h.deliver()
+	return h
+}
+
+// Fire queues e for the hooks configured for its kind. It does not wait
+// for them to run.
+func (h *Hooks) Fire(e Event) {
+	if h == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Source = h.source
+	e.Directory = h.directory
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.queue <- e:
+	default:
+		slog.Warn("hook queue full, dropping event", "event", e.Event, "path", e.Path)
+	}
+}
+
+// Close delivers the events still queued, waiting at most timeout, and
+// stops.
+func (h *Hooks) Close(timeout time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-h.done:
+	case <-time.After(timeout):
+		slog.Warn("gave up waiting for hooks", "pending", len(h.queue))
+	}
+}
+
+func (h *Hooks) deliver() {
+	defer close(h.done)
+	for e := range h.queue {
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		for i := range h.hooks {
+			hook := &h.hooks[i]
+			if len(hook.Events) > 0 && !slices.Contains(hook.Events, e.Event) {
+				continue
+			}
+			if err := h.run(hook, e, data); err != nil {
+				slog.Warn("hook failed", "event", e.Event, "path", e.Path, "hook", hookName(hook), "error", err)
+			}
+		}
+	}
+}
+
+// run passes the event to hook, given as data in JSON.
+func (h *Hooks) run(hook *config.HookConfig, e Event, data []byte) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if hook.URL != "" {
+		return h.post(ctx, hook.URL, data)
+	}
+
+	// The event is on standard input; the most used fields are in the
+	// environment too, for simple scripts.
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"KONEKSI_EVENT="+e.Event,
+		"KONEKSI_PATH="+e.Path,
+		"KONEKSI_LOCAL_PATH="+e.Local,
+		"KONEKSI_SOURCE="+e.Source,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
+
+func (h *Hooks) post(ctx context.Context, target string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// hookName identifies hook in logs, without the path and query of its
+// URL, which may hold a secret.
+func hookName(hook *config.HookConfig) string {
+	if hook.URL != "" {
+		if u, err := url.Parse(hook.URL); err == nil {
+			return u.Scheme + "://" + u.Host
+		}
+		return "webhook"
+	}
+	return hook.Command[0]
+}
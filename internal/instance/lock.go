@@ -0,0 +1,129 @@
+// Package instance coordinates mount processes on the same host: it
+// prevents the same remote directory from being mounted twice and cleans
+// up mountpoints left behind by instances that crashed.
+package instance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Lock is held by a running mount for its remote directory.
+type Lock struct {
+	file    *os.File
+	status  string // status file published by the mount, see WriteStatus
+	control string // control socket of the mount, see ControlPath
+}
+
+// AlreadyMountedError is returned by Acquire when another process on this
+// host has the directory mounted.
+type AlreadyMountedError struct {
+	DirectoryID string
+	Mountpoint  string
+	PID         int
+}
+
+func (e *AlreadyMountedError) Error() string {
+	if e.Mountpoint == "" {
+		return fmt.Sprintf("directory %s is already mounted by another process", e.DirectoryID)
+	}
+	return fmt.Sprintf("directory %s is already mounted at %s (pid %d)",
+		e.DirectoryID, e.Mountpoint, e.PID)
+}
+
+// Acquire takes the instance lock for directoryID, recording mountpoint
+// so a second attempt can report where the directory is mounted. The
+// lock is released by Release or when the process exits, so a crashed
+// mount never blocks the next one.
+func Acquire(directoryID, mountpoint string) (*Lock, error) {
+	dir, err := lockDir()
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(dir, lockName(directoryID))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if err == syscall.EWOULDBLOCK {
+			holder := &AlreadyMountedError{DirectoryID: directoryID}
+			holder.Mountpoint, holder.PID = readOwner(f)
+			return nil, holder
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", name, err)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n%s\n", os.Getpid(), mountpoint)
+	}
+
+	return &Lock{file: f, status: statusPath(name), control: controlPath(name)}, nil
+}
+
+// ControlPath returns where the mount listens for commands from other
+// processes, such as remount. Only the owner of the lock may serve it,
+// so a socket left there is stale.
+func (l *Lock) ControlPath() string {
+	return l.control
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	os.Remove(l.status)
+	os.Remove(l.control)
+
+	if err := os.Truncate(l.file.Name(), 0); err != nil && !os.IsNotExist(err) {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// lockDir returns a per-user directory for lock files.
+func lockDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = filepath.Join(os.TempDir(), fmt.Sprintf("koneksi-drive-%d", os.Getuid()))
+	} else {
+		base = filepath.Join(base, "koneksi-drive")
+	}
+
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return "", fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	return base, nil
+}
+
+// lockName turns a directory ID into a safe file name.
+func lockName(directoryID string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == 0 {
+			return '_'
+		}
+		return r
+	}, directoryID)
+	if name == "" {
+		name = "default"
+	}
+	return name + ".lock"
+}
+
+func readOwner(f *os.File) (mountpoint string, pid int) {
+	data := make([]byte, 4096)
+	n, _ := f.ReadAt(data, 0)
+
+	lines := strings.SplitN(strings.TrimSpace(string(data[:n])), "\n", 2)
+	if len(lines) == 2 {
+		pid, _ = strconv.Atoi(lines[0])
+		mountpoint = lines[1]
+	}
+	return mountpoint, pid
+}
@@ -0,0 +1,115 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// IsStale reports whether mountpoint is left over from a FUSE process
+// that exited without unmounting, which makes every access fail with
+// "transport endpoint is not connected" (or "device not configured" on
+// macOS).
+func IsStale(mountpoint string) bool {
+	_, err := os.Stat(mountpoint)
+	if errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	return runtime.GOOS == "darwin" && errors.Is(err, syscall.ENXIO)
+}
+
+// CleanStale unmounts mountpoint if it is stale. It reports whether a
+// stale mount was found.
+func CleanStale(mountpoint string) (bool, error) {
+	if !IsStale(mountpoint) {
+		return false, nil
+	}
+
+	slog.Warn("mountpoint is left over from a previous instance, unmounting it",
+		"mountpoint", mountpoint)
+
+	err := unmount(mountpoint, false)
+	if err != nil {
+		// Processes that still have files or their working directory
+		// below the mountpoint make a regular unmount fail with EBUSY.
+		// A lazy unmount detaches it now and finishes once they let go.
+		slog.Debug("unmount failed, retrying lazily", "mountpoint", mountpoint, "error", err)
+		err = unmount(mountpoint, true)
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to clean up stale mount at %s: %w", mountpoint, err)
+	}
+	if IsStale(mountpoint) {
+		return true, fmt.Errorf("mountpoint %s is still not connected after unmounting", mountpoint)
+	}
+	return true, nil
+}
+
+// CheckMountpoint returns an error if something is already mounted on
+// mountpoint, which would otherwise make the mount fail with EBUSY.
+func CheckMountpoint(mountpoint string) error {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(mountpoint, &st); err != nil {
+		return nil
+	}
+	if err := syscall.Stat(filepath.Dir(mountpoint), &parent); err != nil {
+		return nil
+	}
+
+	if st.Dev != parent.Dev {
+		return fmt.Errorf("%s is already a mountpoint; unmount it first", mountpoint)
+	}
+	return nil
+}
+
+// CheckEmpty returns an error if mountpoint has files or folders, which
+// the mount would hide until it is unmounted.
+func CheckEmpty(mountpoint string) error {
+	f, err := os.Open(mountpoint)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	if names, _ := f.Readdirnames(1); len(names) > 0 {
+		return fmt.Errorf("%s is not empty; its contents would be hidden while mounted (use --nonempty to mount over them anyway)", mountpoint)
+	}
+	return nil
+}
+
+func unmount(mountpoint string, lazy bool) error {
+	var commands [][]string
+	switch {
+	case runtime.GOOS == "darwin" && lazy:
+		commands = [][]string{{"diskutil", "unmount", "force", mountpoint}}
+	case runtime.GOOS == "darwin":
+		commands = [][]string{{"umount", "-f", mountpoint}}
+	case lazy:
+		commands = [][]string{
+			{"fusermount", "-uz", mountpoint},
+			{"fusermount3", "-uz", mountpoint},
+			{"umount", "-l", mountpoint},
+		}
+	default:
+		commands = [][]string{
+			{"fusermount", "-u", mountpoint},
+			{"fusermount3", "-u", mountpoint},
+			{"umount", mountpoint},
+		}
+	}
+
+	var lastErr error
+	for _, args := range commands {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %v: %s", args[0], err, out)
+	}
+	return lastErr
+}
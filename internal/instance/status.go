@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Instance is a mount running on this host.
+type Instance struct {
+	PID        int
+	Mountpoint string
+	// Status is the last status the mount published, or nil.
+	Status json.RawMessage
+	// Control is the control socket of the mount, or "" if it serves
+	// none.
+	Control string
+}
+
+// WriteStatus publishes the state of the running mount as JSON for the
+// status command. The file is replaced atomically and removed by Release.
+func (l *Lock) WriteStatus(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.status + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.status)
+}
+
+// Running returns the mounts running on this host for the current user.
+func Running() ([]Instance, error) {
+	dir, err := lockDir()
+	if err != nil {
+		return nil, err
+	}
+
+	locks, err := filepath.Glob(filepath.Join(dir, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for _, name := range locks {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		mountpoint, pid := readOwner(f)
+		f.Close()
+
+		// Released locks are emptied; a crashed owner leaves its pid.
+		if pid <= 0 || !alive(pid) {
+			continue
+		}
+
+		inst := Instance{PID: pid, Mountpoint: mountpoint}
+		if data, err := os.ReadFile(statusPath(name)); err == nil && json.Valid(data) {
+			inst.Status = data
+		}
+		if _, err := os.Stat(controlPath(name)); err == nil {
+			inst.Control = controlPath(name)
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+func statusPath(lockFile string) string {
+	return strings.TrimSuffix(lockFile, ".lock") + ".status.json"
+}
+
+func controlPath(lockFile string) string {
+	return strings.TrimSuffix(lockFile, ".lock") + ".sock"
+}
+
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,199 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// awsKMS uses AWS KMS. Credentials are read from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and, for temporary ones, AWS_SESSION_TOKEN; the
+// region from AWS_REGION unless configured.
+type awsKMS struct {
+	client   *http.Client
+	endpoint string
+	region   string
+	key      string
+
+	accessKey, secretKey, sessionToken string
+}
+
+func newAWS(cfg *config.KMSConfig, client *http.Client) (*awsKMS, error) {
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, errors.New("AWS region is not set (encryption.kms.region or AWS_REGION)")
+	}
+	a := &awsKMS{
+		client:       client,
+		endpoint:     cfg.Address,
+		region:       region,
+		key:          cfg.Key,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if a.accessKey == "" || a.secretKey == "" {
+		return nil, errors.New("AWS credentials are not set (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://kms." + region + ".amazonaws.com/"
+	}
+	return a, nil
+}
+
+func (a *awsKMS) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob []byte
+	}
+	in := map[string]any{"KeyId": a.key, "Plaintext": key}
+	if err := a.call(ctx, "Encrypt", in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+func (a *awsKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	in := map[string]any{"KeyId": a.key, "CiphertextBlob": wrapped}
+	if err := a.call(ctx, "Decrypt", in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// call invokes the KMS action with the JSON protocol. Binary fields are
+// base64 in JSON, as encoding/json writes []byte.
+func (a *awsKMS) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	a.sign(req, body, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Type != "" {
+			return fmt.Errorf("%s: %s %s", resp.Status, e.Type, e.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// sign adds an AWS Signature Version 4 to req, whose body is body.
+func (a *awsKMS) sign(req *http.Request, body []byte, now time.Time) {
+	const service = "kms"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+
+	// Sign the host and every x-amz and content-type header.
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+			names = append(names, lk)
+		}
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, n := range names {
+		canonicalHeaders.WriteString(n + ":" + headers[n] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := day + "/" + a.region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+a.secretKey), day)
+	signingKey = hmacSHA256(signingKey, a.region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, credentialScope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	// url.Values.Encode sorts by key and escapes spaces as "+", which
+	// SigV4 wants as "%20".
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,185 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	gcpScope       = "https://www.googleapis.com/auth/cloudkms"
+	gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// gcpKMS uses Google Cloud KMS. The access token is taken from
+// GOOGLE_OAUTH_ACCESS_TOKEN, obtained with the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS, or asked from the metadata server when
+// running on Google Cloud, in that order.
+type gcpKMS struct {
+	client   *http.Client
+	endpoint string // URL of the key
+}
+
+func newGCP(cfg *config.KMSConfig, client *http.Client) (*gcpKMS, error) {
+	base := cfg.Address
+	if base == "" {
+		base = "https://cloudkms.googleapis.com"
+	}
+	// The key is a resource name such as
+	// projects/p/locations/global/keyRings/r/cryptoKeys/k.
+	return &gcpKMS{
+		client:   client,
+		endpoint: strings.TrimSuffix(base, "/") + "/v1/" + strings.Trim(cfg.Key, "/"),
+	}, nil
+}
+
+func (g *gcpKMS) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := g.call(ctx, "encrypt", map[string][]byte{"plaintext": key}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (g *gcpKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := g.call(ctx, "decrypt", map[string][]byte{"ciphertext": wrapped}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (g *gcpKMS) call(ctx context.Context, method string, in, out any) error {
+	token, err := g.token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a Google access token: %w", err)
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	return postJSON(ctx, g.client, g.endpoint+":"+method, header, in, out)
+}
+
+func (g *gcpKMS) token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return g.serviceAccountToken(ctx, path)
+	}
+	return g.metadataToken(ctx)
+}
+
+// serviceAccountToken exchanges a JWT signed with the service account key
+// in path for an access token (RFC 7523).
+func (g *gcpKMS) serviceAccountToken(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var account struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	if account.Type != "service_account" {
+		return "", fmt.Errorf("%s: not a service account key", path)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("%s: no private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%s: not an RSA key", path)
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]any{
+		"iss":   account.ClientEmail,
+		"scope": gcpScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return g.fetchToken(req)
+}
+
+func (g *gcpKMS) metadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", gcpMetadataURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, err := g.fetchToken(req)
+	if err != nil {
+		return "", fmt.Errorf("no credentials (GOOGLE_APPLICATION_CREDENTIALS) and no metadata server: %w", err)
+	}
+	return token, nil
+}
+
+func (g *gcpKMS) fetchToken(req *http.Request) (string, error) {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response without access token")
+	}
+	return token.AccessToken, nil
+}
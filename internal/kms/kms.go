@@ -0,0 +1,108 @@
+// Package kms wraps and unwraps keys with an external key management
+// service: HashiCorp Vault's transit engine, AWS KMS or Google Cloud KMS.
+// The key that encrypts content never leaves the machine in plaintext;
+// only its wrapped form is stored, and the service unwraps it for clients
+// allowed to use the wrapping key, so access can be granted and revoked
+// centrally (envelope encryption).
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// requestTimeout bounds each request to the service.
+const requestTimeout = 30 * time.Second
+
+// Provider wraps and unwraps keys with a key held by the service.
+type Provider interface {
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// New returns the provider configured in cfg.
+func New(cfg *config.KMSConfig) (Provider, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	switch cfg.Provider {
+	case "vault":
+		return newTransit(cfg, client)
+	case "aws":
+		return newAWS(cfg, client)
+	case "gcp":
+		return newGCP(cfg, client)
+	}
+	return nil, fmt.Errorf("unknown KMS provider %q", cfg.Provider)
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[string][]byte) // unwrapped keys by provider, key and wrapped form
+)
+
+// Unwrap unwraps wrapped with the key configured in cfg. Unwrapped keys
+// are kept in memory for the life of the process, so the service is asked
+// once per key rather than once per file or client.
+func Unwrap(ctx context.Context, cfg *config.KMSConfig, wrapped []byte) ([]byte, error) {
+	id := cfg.Provider + "\x00" + cfg.Key + "\x00" + string(wrapped)
+	cacheMu.Lock()
+	key, ok := cache[id]
+	cacheMu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	key, err = p.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%s KMS: failed to unwrap key: %w", cfg.Provider, err)
+	}
+	cacheMu.Lock()
+	cache[id] = key
+	cacheMu.Unlock()
+	return key, nil
+}
+
+// postJSON sends in as JSON to url with header and decodes the answer
+// into out.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
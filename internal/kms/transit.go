@@ -0,0 +1,79 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// transit uses the transit secrets engine of HashiCorp Vault. The token
+// is read from VAULT_TOKEN, and the address from VAULT_ADDR unless
+// configured.
+type transit struct {
+	client *http.Client
+	base   string // URL of the key's mount
+	key    string
+	header http.Header
+}
+
+func newTransit(cfg *config.KMSConfig, client *http.Client) (*transit, error) {
+	addr := cfg.Address
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("Vault address is not set (encryption.kms.address or VAULT_ADDR)")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN is not set")
+	}
+
+	mount := cfg.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	header := http.Header{"X-Vault-Token": {token}}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		header.Set("X-Vault-Namespace", ns)
+	}
+	return &transit{
+		client: client,
+		base:   strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(mount, "/"),
+		key:    url.PathEscape(cfg.Key),
+		header: header,
+	}, nil
+}
+
+func (t *transit) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	in := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}
+	if err := postJSON(ctx, t.client, t.base+"/encrypt/"+t.key, t.header, in, &resp); err != nil {
+		return nil, err
+	}
+	// The ciphertext is text of the form vault:v1:..., kept as it is.
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (t *transit) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	in := map[string]string{"ciphertext": string(wrapped)}
+	if err := postJSON(ctx, t.client, t.base+"/decrypt/"+t.key, t.header, in, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
@@ -0,0 +1,128 @@
+// Package notify shows desktop notifications, through the freedesktop
+// notification service on Linux and BSD or Notification Center on macOS,
+// so problems that would otherwise only appear in logs get noticed.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	// repeatAfter is how long the same notification is not shown again,
+	// so a failing file does not flood the desktop.
+	repeatAfter = 10 * time.Minute
+	// sendTimeout bounds showing one notification.
+	sendTimeout = 5 * time.Second
+)
+
+// Notifier shows desktop notifications. A nil *Notifier shows nothing.
+type Notifier struct {
+	send func(ctx context.Context, title, body string) error
+
+	mu    sync.Mutex
+	shown map[string]time.Time // by key
+	wg    sync.WaitGroup
+}
+
+// New returns a notifier if notifications are enabled in cfg and the
+// desktop supports them, or nil.
+func New(cfg *config.NotificationsConfig) *Notifier {
+	if !cfg.Enabled {
+		return nil
+	}
+	send := desktopSender()
+	if send == nil {
+		slog.Warn("desktop notifications are not available: notify-send, gdbus or osascript not found")
+		return nil
+	}
+	return &Notifier{
+		send:  send,
+		shown: make(map[string]time.Time),
+	}
+}
+
+// Notify shows a notification in the background, unless one with the
+// same key was shown recently.
+func (n *Notifier) Notify(key, title, body string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if last, ok := n.shown[key]; ok && time.Since(last) < repeatAfter {
+		n.mu.Unlock()
+		return
+	}
+	n.shown[key] = time.Now()
+	n.mu.Unlock()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		if err := n.send(ctx, title, body); err != nil {
+			slog.Debug("failed to show notification", "title", title, "error", err)
+		}
+	}()
+}
+
+// Wait waits for notifications being shown, so commands can exit.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+// desktopSender returns a function showing notifications on this system,
+// or nil.
+func desktopSender() func(ctx context.Context, title, body string) error {
+	if runtime.GOOS == "darwin" {
+		osascript, err := exec.LookPath("osascript")
+		if err != nil {
+			return nil
+		}
+		return func(ctx context.Context, title, body string) error {
+			script := fmt.Sprintf("display notification %s with title %s", appleString(body), appleString(title))
+			return exec.CommandContext(ctx, osascript, "-e", script).Run()
+		}
+	}
+
+	if notifySend, err := exec.LookPath("notify-send"); err == nil {
+		return func(ctx context.Context, title, body string) error {
+			return exec.CommandContext(ctx, notifySend, "--app-name=koneksi-drive", "--", title, body).Run()
+		}
+	}
+	// gdbus comes with GLib, which most desktops have even without
+	// notify-send.
+	if gdbus, err := exec.LookPath("gdbus"); err == nil {
+		return func(ctx context.Context, title, body string) error {
+			return exec.CommandContext(ctx, gdbus, "call", "--session",
+				"--dest", "org.freedesktop.Notifications",
+				"--object-path", "/org/freedesktop/Notifications",
+				"--method", "org.freedesktop.Notifications.Notify",
+				`"koneksi-drive"`, "0", `""`, variantString(title), variantString(body),
+				"@as []", "@a{sv} {}", "-1").Run()
+		}
+	}
+	return nil
+}
+
+// appleString quotes s for AppleScript.
+func appleString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// variantString quotes s as a GVariant text string.
+func variantString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// CheckQuota warns when more than threshold percent of the storage quota
+// is used. It returns api.ErrQuotaUnsupported if the server reports no
+// quota, so callers can stop checking.
+func (n *Notifier) CheckQuota(ctx context.Context, client *api.Client, threshold float64) error {
+	if n == nil || threshold <= 0 {
+		return nil
+	}
+	q, err := client.Quota(ctx)
+	if err != nil {
+		return err
+	}
+	used := q.Percent()
+	if used < threshold {
+		return nil
+	}
+	slog.Warn("storage quota nearly full", "used", q.Used, "limit", q.Limit, "percent", int(used))
+	n.Notify("quota", "Koneksi storage nearly full",
+		fmt.Sprintf("%.0f%% of the quota is used (%.1f of %.1f GiB).", used, gib(q.Used), gib(q.Limit)))
+	return nil
+}
+
+func gib(n int64) float64 {
+	return float64(n) / (1 << 30)
+}
@@ -0,0 +1,273 @@
+// Package recovery keeps the changes of files being written through a
+// mount on disk until they are uploaded, so changes not uploaded when
+// the mount crashed or lost the server are uploaded on the next start or
+// with the recover command instead of being lost.
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+)
+
+const (
+	contentPrefix = "content-"
+	entrySuffix   = ".json"
+)
+
+// Journal is the directory holding the pending changes of one remote
+// directory: a content file for each file being written and, once the
+// content file exists, an entry describing it.
+type Journal struct {
+	dir string
+}
+
+// Entry describes the pending changes of a remote file.
+type Entry struct {
+	ID      string `json:"-"`    // from the content file name
+	Path    string `json:"path"` // remote path
+	Content string `json:"content"`
+	// Append is set when the content is to be added to the end of the
+	// remote file rather than replace it.
+	Append bool `json:"append,omitempty"`
+	// BaseSize and BaseModified describe the version the changes were
+	// made to; appended content goes after BaseSize bytes.
+	BaseSize     int64     `json:"base_size"`
+	BaseModified time.Time `json:"base_modified"`
+	// Created is when the changes were started; a remote file modified
+	// later was changed by someone else in the meantime.
+	Created time.Time `json:"created"`
+}
+
+// OpenConfigured returns the journal of the configured remote directory,
+// in the staging directory or else the cache directory, or nil if
+// mount.recovery is disabled or neither directory is configured.
+func OpenConfigured(cfg *config.Config) (*Journal, error) {
+	var dir string
+	switch {
+	case !cfg.Mount.Recovery:
+		return nil, nil
+	case cfg.Mount.StagingDir != "":
+		dir = cfg.Mount.StagingDir
+	case cfg.Cache.Enabled && cfg.Cache.Directory != "":
+		dir = cfg.Cache.Directory
+	default:
+		return nil, nil
+	}
+	return Open(dir, cfg.API.DirectoryID)
+}
+
+// Open returns the journal of the remote directory namespace below dir,
+// creating it if needed.
+func Open(dir, namespace string) (*Journal, error) {
+	j := &Journal{dir: filepath.Join(dir, "pending", url.PathEscape(namespace))}
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recovery journal: %w", err)
+	}
+	return j, nil
+}
+
+// Dir returns the journal directory.
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// Create creates a content file.
+func (j *Journal) Create() (*os.File, error) {
+	return os.CreateTemp(j.dir, contentPrefix+"*")
+}
+
+// Record writes e, whose content file exists, to the journal.
+func (j *Journal) Record(e *Entry, content string) error {
+	e.Content = filepath.Base(content)
+	e.ID = strings.TrimPrefix(e.Content, contentPrefix)
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(j.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.entryFile(e))
+}
+
+// Remove drops e and its content file, if still there.
+func (j *Journal) Remove(e *Entry) error {
+	if err := os.Remove(j.ContentFile(e)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return j.Forget(e)
+}
+
+// Forget drops e, leaving its content file to the caller.
+func (j *Journal) Forget(e *Entry) error {
+	if err := os.Remove(j.entryFile(e)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ContentFile returns the path of the content file of e.
+func (j *Journal) ContentFile(e *Entry) string {
+	return filepath.Join(j.dir, e.Content)
+}
+
+func (j *Journal) entryFile(e *Entry) string {
+	return filepath.Join(j.dir, e.ID+entrySuffix)
+}
+
+// Entries returns the pending changes, oldest first.
+func (j *Journal) Entries() ([]*Entry, error) {
+	names, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, de := range names {
+		name := de.Name()
+		if !strings.HasSuffix(name, entrySuffix) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(j.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("bad journal entry %s: %w", name, err)
+		}
+		e.ID = strings.TrimSuffix(name, entrySuffix)
+		entries = append(entries, &e)
+	}
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Created.Before(entries[b].Created) })
+	return entries, nil
+}
+
+// Clean removes the content files without an entry, left by a mount that
+// stopped before it recorded them, and returns the entries. It must not
+// run while the remote directory is mounted.
+func (j *Journal) Clean() ([]*Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		recorded[e.Content] = true
+	}
+
+	names, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range names {
+		if name := de.Name(); !recorded[name] && (strings.HasPrefix(name, contentPrefix) || strings.HasPrefix(name, ".entry-")) {
+			os.Remove(filepath.Join(j.dir, name))
+		}
+	}
+	return entries, nil
+}
+
+// Resume uploads the pending changes of e and drops them from the
+// journal. If the remote file was modified after the changes were
+// started, they are uploaded next to it instead, as a copy named like
+// "notes.recovered-20260102-150405.txt"; so is appended content that can
+// no longer be appended. Changes the malware scan rejects are dropped. It
+// returns the remote path written.
+func (j *Journal) Resume(ctx context.Context, storage api.StorageBackend, up *upload.Uploader, e *Entry) (string, error) {
+	f, err := os.Open(j.ContentFile(e))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := st.Size()
+
+	current, err := storage.Stat(ctx, e.Path)
+	if err != nil && !api.IsNotFound(err) {
+		return "", err
+	}
+	changed := current != nil && current.Modified.After(e.Created)
+
+	target := e.Path
+	switch {
+	case changed:
+		target = recoveredName(e.Path, e.Created)
+	case e.Append && e.BaseSize > 0:
+		if current == nil || current.Size != e.BaseSize {
+			target = recoveredName(e.Path, e.Created)
+			break
+		}
+		// Appended content is scanned on its own, all there is of it.
+		if err := up.Scan(e.Path, f, size); err != nil {
+			return "", j.dropRejected(e, err)
+		}
+		err := api.Append(ctx, storage, e.Path, e.BaseSize, f, size)
+		if err == nil {
+			return target, j.Remove(e)
+		}
+		if !errors.Is(err, api.ErrAppendUnsupported) && !errors.Is(err, api.ErrAppendMismatch) {
+			return "", err
+		}
+		target = recoveredName(e.Path, e.Created)
+	}
+
+	if _, err := up.Upload(ctx, target, f, size, nil); err != nil {
+		return "", j.dropRejected(e, err)
+	}
+	return target, j.Remove(e)
+}
+
+// dropRejected removes e from the journal if err is the malware scan
+// rejecting its changes, which would be rejected again on every retry,
+// and returns err, joined with any error removing it.
+func (j *Journal) dropRejected(e *Entry, err error) error {
+	if upload.IsRejected(err) {
+		return errors.Join(err, j.Remove(e))
+	}
+	return err
+}
+
+// recoveredName returns the remote path changes to p made at t are
+// uploaded to when p changed in the meantime.
+func recoveredName(p string, t time.Time) string {
+	dir, name := path.Split(p)
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	return dir + base + ".recovered-" + t.Format("20060102-150405") + ext
+}
@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// clamdChunk is the size of the chunks content is streamed to clamd in.
+const clamdChunk = 64 << 10
+
+// clamd streams content to a ClamAV daemon with the INSTREAM command,
+// over TCP (host:port) or a unix socket (an absolute path).
+type clamd struct {
+	address string
+}
+
+func (c *clamd) Scan(ctx context.Context, name string, r io.ReaderAt, size int64) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(c.address, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Each chunk is preceded by its length as a 4-byte big-endian
+	// integer; a zero length ends the stream.
+	w := bufio.NewWriterSize(conn, clamdChunk+4)
+	w.WriteString("zINSTREAM\x00")
+	buf := make([]byte, clamdChunk)
+	content := io.NewSectionReader(r, 0, size)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			binary.Write(w, binary.BigEndian, uint32(n))
+			if _, err := w.Write(buf[:n]); err != nil {
+				break // reported by Flush
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint32(0))
+	if err := w.Flush(); err != nil {
+		// clamd closes the connection when the stream exceeds its
+		// StreamMaxLength; its reply says so.
+		if reply, rerr := readClamdReply(conn); rerr == nil && reply != "" {
+			return "", fmt.Errorf("clamd: %s", reply)
+		}
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return threatName(reply), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// readClamdReply reads the null-terminated reply to a z-prefixed command,
+// such as "stream: OK" or "stream: Eicar-Signature FOUND".
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// execScanner runs a command for every upload. The content is passed on
+// standard input, or as a temporary file replacing "{}" in the arguments.
+// Exit status 0 means clean and 1 a threat, whose name the command may
+// print as the first line of its output; anything else is an error. This
+// is how clamscan and clamdscan report.
+type execScanner struct {
+	command []string
+}
+
+func (s *execScanner) Scan(ctx context.Context, name string, r io.ReaderAt, size int64) (string, error) {
+	content := io.NewSectionReader(r, 0, size)
+	args := slices.Clone(s.command[1:])
+
+	var stdin io.Reader = content
+	if i := slices.Index(args, "{}"); i >= 0 {
+		tmp, err := os.CreateTemp("", "koneksi-scan-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, content)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		for i := range args {
+			if args[i] == "{}" {
+				args[i] = tmp.Name()
+			}
+		}
+		stdin = nil
+	}
+
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), "KONEKSI_PATH="+name)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		line, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+		if threat := threatName(line); threat != "" {
+			return threat, nil
+		}
+		return "malware", nil
+	}
+	if exit == nil {
+		return "", err // the command could not be run
+	}
+	if msg := firstLine(&stderr); msg != "" {
+		return "", fmt.Errorf("%s: %w: %s", s.command[0], err, msg)
+	}
+	return "", fmt.Errorf("%s: %w", s.command[0], err)
+}
+
+// threatName returns the threat named in a line of scanner output. ClamAV
+// reports threats as "<file>: <threat> FOUND".
+func threatName(line string) string {
+	line = strings.TrimSpace(line)
+	if found, ok := strings.CutSuffix(line, " FOUND"); ok {
+		if i := strings.LastIndex(found, ": "); i >= 0 {
+			found = found[i+2:]
+		}
+		return strings.TrimSpace(found)
+	}
+	return line
+}
+
+func firstLine(r io.Reader) string {
+	sc := bufio.NewScanner(r)
+	if sc.Scan() {
+		return strings.TrimSpace(sc.Text())
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// icap sends content to an ICAP server (RFC 3507), such as c-icap with
+// ClamAV or a commercial gateway, as the body of an HTTP response to be
+// modified (RESPMOD). The address is an icap:// URL naming the service.
+type icap struct {
+	address string
+}
+
+func (c *icap) Scan(ctx context.Context, name string, r io.ReaderAt, size int64) (string, error) {
+	u, err := url.Parse(c.address)
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reqHdr := "GET " + (&url.URL{Path: name}).EscapedPath() + " HTTP/1.1\r\nHost: koneksi-drive\r\n\r\n"
+	resHdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", size)
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n",
+		c.address, u.Host, len(reqHdr), len(reqHdr)+len(resHdr))
+	w.WriteString(reqHdr)
+	w.WriteString(resHdr)
+	// The body is sent as a single chunk.
+	if size > 0 {
+		fmt.Fprintf(w, "%x\r\n", size)
+		if _, err := io.Copy(w, io.NewSectionReader(r, 0, size)); err != nil {
+			return "", fmt.Errorf("icap: %w", err)
+		}
+		w.WriteString("\r\n")
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	status, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+
+	// 204 means the content is returned unmodified, so it is clean.
+	_, code, _ := strings.Cut(status, " ")
+	code, _, _ = strings.Cut(code, " ")
+	switch code {
+	case "204":
+		return "", nil
+	case "200":
+	default:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+
+	if threat := icapThreat(header); threat != "" {
+		return threat, nil
+	}
+	// Without a threat header, the server blocked the content if it
+	// replaced the response with one that is not a 200, such as a block
+	// page.
+	offset, ok := encapsulatedOffset(header.Get("Encapsulated"), "res-hdr")
+	if !ok {
+		return "blocked by the ICAP server", nil
+	}
+	if _, err := io.CopyN(io.Discard, br, offset); err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	if _, rest, _ := strings.Cut(line, " "); !strings.HasPrefix(rest, "200") {
+		return "blocked by the ICAP server", nil
+	}
+	return "", nil
+}
+
+// icapThreat returns the threat named in the headers ICAP servers report
+// findings in, e.g. "X-Infection-Found: Type=0; Resolution=2; Threat=Eicar;".
+func icapThreat(header textproto.MIMEHeader) string {
+	if found := header.Get("X-Infection-Found"); found != "" {
+		for _, field := range strings.Split(found, ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(field), "Threat="); ok {
+				return threat
+			}
+		}
+		return found
+	}
+	if id := header.Get("X-Virus-ID"); id != "" {
+		return id
+	}
+	if found := header.Get("X-Violations-Found"); found != "" {
+		return found
+	}
+	return ""
+}
+
+// encapsulatedOffset returns the offset of part in an Encapsulated header
+// such as "res-hdr=0, res-body=123".
+func encapsulatedOffset(encapsulated, part string) (int64, bool) {
+	for _, field := range strings.Split(encapsulated, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if k == part {
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,32 @@
+// Package scan checks content for malware before it is uploaded, by
+// running a command, such as clamscan, or asking a clamd or ICAP server.
+package scan
+
+import (
+	"context"
+	"io"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// Scanner checks content for malware.
+type Scanner interface {
+	// Scan returns the name of the threat found in the first size bytes
+	// of r, or "" if the content is clean. name is the path the content
+	// is uploaded to, for the scanner's logs.
+	Scan(ctx context.Context, name string, r io.ReaderAt, size int64) (string, error)
+}
+
+// New returns the scanner configured in cfg, or nil if scanning is off.
+// cfg is expected to have been validated by config.Load.
+func New(cfg *config.ScanConfig) Scanner {
+	switch cfg.Scanner {
+	case "exec":
+		return &execScanner{command: cfg.Command}
+	case "clamd":
+		return &clamd{address: cfg.Address}
+	case "icap":
+		return &icap{address: cfg.Address}
+	}
+	return nil
+}
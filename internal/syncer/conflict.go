@@ -0,0 +1,185 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+)
+
+// ConflictPolicy decides which version of a file wins when it changed
+// both locally and on the server since the last sync.
+type ConflictPolicy string
+
+const (
+	// PolicyNewerWins keeps the version modified last.
+	PolicyNewerWins ConflictPolicy = "newer-wins"
+	// PolicyLargerWins keeps the larger version, or the newer one if
+	// both have the same size.
+	PolicyLargerWins ConflictPolicy = "larger-wins"
+	// PolicyKeepBoth keeps both versions on both sides, storing the local
+	// one under a conflict name.
+	PolicyKeepBoth ConflictPolicy = "keep-both"
+	// PolicyInteractive asks through Options.Resolve.
+	PolicyInteractive ConflictPolicy = "interactive"
+)
+
+// Resolution is the outcome chosen for a conflict.
+type Resolution int
+
+const (
+	// ResolveSkip leaves both versions alone; the conflict comes up
+	// again on the next sync.
+	ResolveSkip Resolution = iota
+	// ResolveLocal uploads the local version over the remote one.
+	ResolveLocal
+	// ResolveRemote downloads the remote version over the local one.
+	ResolveRemote
+	// ResolveBoth keeps both versions.
+	ResolveBoth
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolveSkip:
+		return "skip"
+	case ResolveLocal:
+		return "local"
+	case ResolveRemote:
+		return "remote"
+	case ResolveBoth:
+		return "keep-both"
+	}
+	return "unknown"
+}
+
+// Conflict describes a file changed on both sides since the last sync.
+type Conflict struct {
+	Path           string    `json:"path"`
+	LocalSize      int64     `json:"local_size"`
+	LocalModified  time.Time `json:"local_modified"`
+	RemoteSize     int64     `json:"remote_size"`
+	RemoteModified time.Time `json:"remote_modified"`
+}
+
+// resolve applies the configured policy to c.
+func (e *Engine) resolve(c Conflict) Resolution {
+	switch e.opts.Conflict {
+	case PolicyNewerWins:
+		return newer(c)
+	case PolicyLargerWins:
+		switch {
+		case c.LocalSize > c.RemoteSize:
+			return ResolveLocal
+		case c.LocalSize < c.RemoteSize:
+			return ResolveRemote
+		}
+		return newer(c)
+	case PolicyInteractive:
+		if e.opts.Resolve == nil {
+			return ResolveSkip
+		}
+		return e.opts.Resolve(c)
+	}
+	return ResolveBoth
+}
+
+func newer(c Conflict) Resolution {
+	if c.RemoteModified.After(c.LocalModified) {
+		return ResolveRemote
+	}
+	return ResolveLocal
+}
+
+// conflictName returns the name the local version of rel is kept under
+// when both versions are kept, e.g. "notes.conflict-20260102-150405.txt".
+func conflictName(rel string, now time.Time) string {
+	dir, name := path.Split(rel)
+	ext := path.Ext(name)
+	if ext == name {
+		// Dot files such as ".profile" have no extension.
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	return dir + base + ".conflict-" + now.Format("20060102-150405") + ext
+}
+
+// DefaultReportPath returns the file conflicts are recorded in when
+// Options.ReportFile is empty.
+func DefaultReportPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "koneksi-drive", "sync", "conflicts.jsonl"), nil
+}
+
+// reportEntry is one line of the conflict report.
+type reportEntry struct {
+	Time       time.Time `json:"time"`
+	LocalDir   string    `json:"local_dir"`
+	RemoteDir  string    `json:"remote_dir"`
+	Policy     string    `json:"policy"`
+	Resolution string    `json:"resolution"`
+	Conflict
+}
+
+// report appends the conflicts of plan to the conflict report and tells
+// the hooks about them.
+func (e *Engine) report(plan *Plan) error {
+	if len(plan.Conflicts) == 0 {
+		return nil
+	}
+	for _, rc := range plan.Conflicts {
+		e.opts.Hooks.Fire(hooks.Event{
+			Event: hooks.Conflict,
+			Path:  e.remotePath(rc.Path),
+			Local: e.localPath(rc.Path),
+			Details: map[string]any{
+				"policy":          string(e.opts.Conflict),
+				"resolution":      rc.Resolution.String(),
+				"local_size":      rc.LocalSize,
+				"local_modified":  rc.LocalModified,
+				"remote_size":     rc.RemoteSize,
+				"remote_modified": rc.RemoteModified,
+			},
+		})
+	}
+
+	name := e.opts.ReportFile
+	if name == "" {
+		var err error
+		if name, err = DefaultReportPath(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	now := time.Now()
+	for _, rc := range plan.Conflicts {
+		if err := enc.Encode(reportEntry{
+			Time:       now,
+			LocalDir:   e.localDir,
+			RemoteDir:  e.remoteDir,
+			Policy:     string(e.opts.Conflict),
+			Resolution: rc.Resolution.String(),
+			Conflict:   rc.Conflict,
+		}); err != nil {
+			return fmt.Errorf("failed to write conflict report: %w", err)
+		}
+	}
+	return nil
+}
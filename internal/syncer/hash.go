@@ -0,0 +1,37 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// localHash returns the hex SHA-256 of a local file, computing it at most
+// once per sync run.
+func (e *Engine) localHash(rel string, le *localEntry) (string, error) {
+	if le.hash != "" {
+		return le.hash, nil
+	}
+
+	hash, err := hashFile(e.localPath(rel))
+	if err != nil {
+		return "", err
+	}
+	le.hash = hash
+	return hash, nil
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
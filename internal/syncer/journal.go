@@ -0,0 +1,159 @@
+package syncer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journalSuffix is appended to the state file's name for the journal of
+// the sync being applied.
+const journalSuffix = ".journal"
+
+// journal records the progress of applying a plan, one line per step,
+// each flushed to disk before the sync goes on. If the sync is cut short,
+// even by a crash, the next run restores the state of the actions that
+// completed, so their files are not transferred again, and cleans up
+// after the action that was under way.
+type journal struct {
+	f *os.File
+}
+
+// journalRecord is one line of the journal: an action starting, or the
+// changes to the state an action made once it completed.
+type journalRecord struct {
+	Begin *Action      `json:"begin,omitempty"`
+	Done  *baseChanges `json:"done,omitempty"`
+}
+
+func createJournal(name string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f}, nil
+}
+
+func (j *journal) begin(action Action) error {
+	return j.write(journalRecord{Begin: &action})
+}
+
+func (j *journal) done(changes baseChanges) error {
+	return j.write(journalRecord{Done: &changes})
+}
+
+func (j *journal) write(rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// close closes the journal, deleting it if it is no longer needed.
+func (j *journal) close(remove bool) {
+	j.f.Close()
+	if remove {
+		os.Remove(j.f.Name())
+	}
+}
+
+// readJournal returns the records of the journal name. A last line cut
+// short by a crash is ignored.
+func readJournal(name string) ([]journalRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []journalRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// recover finishes up after a sync that was interrupted while applying
+// its plan: the changes to the state recorded for the actions it
+// completed are made and saved, and an action cut short is undone where
+// it leaves files in between.
+func (e *Engine) recover() error {
+	name := e.base.path + journalSuffix
+	records, err := readJournal(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sync journal: %w", err)
+	}
+
+	var open *Action
+	completed := 0
+	for _, rec := range records {
+		switch {
+		case rec.Begin != nil:
+			open = rec.Begin
+		case rec.Done != nil:
+			e.base.replay(*rec.Done)
+			open = nil
+			completed++
+		}
+	}
+	if open != nil {
+		e.undo(*open)
+	}
+	e.base.take()
+
+	if err := e.base.save(); err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	slog.Info("resuming interrupted sync", "completed_actions", completed)
+	return nil
+}
+
+// undo cleans up after action was cut short. Downloads leave a temporary
+// file behind, and keeping both versions may have moved the local version
+// aside before the remote one took its place. Anything else is done again
+// by the next plan if still needed.
+func (e *Engine) undo(action Action) {
+	switch action.Kind {
+	case ActionDownload, ActionKeepBoth:
+		dest := e.localPath(action.Path)
+		prefix := "." + filepath.Base(dest) + syncTempInfix
+		names, _ := os.ReadDir(filepath.Dir(dest))
+		for _, de := range names {
+			if strings.HasPrefix(de.Name(), prefix) {
+				os.Remove(filepath.Join(filepath.Dir(dest), de.Name()))
+			}
+		}
+	}
+
+	if action.Kind == ActionKeepBoth {
+		dest := e.localPath(action.Path)
+		if _, err := os.Lstat(dest); os.IsNotExist(err) {
+			if err := os.Rename(e.localPath(action.Copy), dest); err != nil && !os.IsNotExist(err) {
+				slog.Warn("failed to restore local version of interrupted sync", "path", action.Path, "copy", action.Copy, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package syncer
+
+import (
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// detectMoves pairs new local files with remote files scheduled for
+// deletion that have the same size and content hash, replacing each pair
+// with a server-side move. Remote files without a hash are never used as
+// move sources since their content cannot be verified.
+func (e *Engine) detectMoves(local map[string]*localEntry, remote map[string]api.FileInfo, uploads, deletes []Action) (moves, remainingUploads, remainingDeletes []Action, err error) {
+	// Index deletion candidates by size; includes files inside deleted
+	// directories, which only appear in deletes via their top directory.
+	bySize := make(map[int64][]string)
+	for rel, re := range remote {
+		if re.IsDir || re.Hash == "" {
+			continue
+		}
+		if _, exists := local[rel]; exists {
+			continue
+		}
+		if !deleted(rel, deletes) {
+			continue
+		}
+		bySize[re.Size] = append(bySize[re.Size], rel)
+	}
+	if len(bySize) == 0 {
+		return nil, uploads, deletes, nil
+	}
+
+	used := make(map[string]bool)
+	for _, up := range uploads {
+		if _, exists := remote[up.Path]; exists {
+			// Content update in place, not a new path.
+			remainingUploads = append(remainingUploads, up)
+			continue
+		}
+
+		candidates := bySize[up.Size]
+		if len(candidates) == 0 {
+			remainingUploads = append(remainingUploads, up)
+			continue
+		}
+
+		hash, err := e.localHash(up.Path, local[up.Path])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		source := ""
+		for _, rel := range candidates {
+			if !used[rel] && strings.EqualFold(remote[rel].Hash, hash) {
+				source = rel
+				break
+			}
+		}
+		if source == "" {
+			remainingUploads = append(remainingUploads, up)
+			continue
+		}
+
+		used[source] = true
+		moves = append(moves, Action{Kind: ActionMove, Path: up.Path, From: source, Size: up.Size})
+	}
+
+	// Moved sources no longer need deleting. Directory deletes stay and
+	// run after the moves have emptied them of reused content.
+	for _, del := range deletes {
+		if !used[del.Path] {
+			remainingDeletes = append(remainingDeletes, del)
+		}
+	}
+
+	return moves, remainingUploads, remainingDeletes, nil
+}
+
+// deleted reports whether rel or one of its parents is being deleted.
+func deleted(rel string, deletes []Action) bool {
+	for _, del := range deletes {
+		if rel == del.Path || strings.HasPrefix(rel, del.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
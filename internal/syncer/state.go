@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+)
+
+// baseline records the state of every file after the last successful
+// sync, which tells a change on one side apart from changes on both.
+type baseline struct {
+	path  string
+	Files map[string]baseEntry `json:"files"`
+	// Listed is when a sync last listed the whole remote tree.
+	Listed time.Time `json:"listed,omitempty"`
+
+	changes baseChanges // made by set and forget since the last take
+}
+
+// baseChanges are changes to a baseline, as recorded in the journal.
+type baseChanges struct {
+	Set    map[string]baseEntry `json:"set,omitempty"`
+	Forget []string             `json:"forget,omitempty"` // applied before Set
+}
+
+type baseEntry struct {
+	Size           int64     `json:"size"`
+	LocalModified  time.Time `json:"local_modified"`
+	RemoteModified time.Time `json:"remote_modified"`
+	Hash           string    `json:"hash,omitempty"`       // remote hash, if provided
+	LocalHash      string    `json:"local_hash,omitempty"` // hex SHA-256 of the local file, if computed
+	Dir            bool      `json:"dir,omitempty"`
+}
+
+// localChanged reports whether the local file differs from the baseline.
+func (b baseEntry) localChanged(le *localEntry) bool {
+	return le.size != b.Size || !le.modTime.Equal(b.LocalModified)
+}
+
+// remoteChanged reports whether the remote file differs from the baseline.
+func (b baseEntry) remoteChanged(re api.FileInfo) bool {
+	if re.Size != b.Size || !re.Modified.Equal(b.RemoteModified) {
+		return true
+	}
+	return re.Hash != "" && b.Hash != "" && re.Hash != b.Hash
+}
+
+// defaultStatePath derives the baseline file of a sync from its roots.
+func defaultStatePath(localDir, remoteDir string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(localDir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs + "\x00" + remoteDir))
+	return filepath.Join(dir, "koneksi-drive", "sync", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// loadBaseline reads the baseline at path. A missing file gives an empty
+// baseline, as for a first sync.
+func loadBaseline(path string) (*baseline, error) {
+	b := &baseline{path: path, Files: make(map[string]baseEntry)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state %s: %w", path, err)
+	}
+	if b.Files == nil {
+		b.Files = make(map[string]baseEntry)
+	}
+	return b, nil
+}
+
+// save writes the baseline atomically.
+func (b *baseline) save() error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+func (b *baseline) set(rel string, le *localEntry, re api.FileInfo) {
+	entry := baseEntry{
+		Size:           le.size,
+		LocalModified:  le.modTime,
+		RemoteModified: re.Modified,
+		Hash:           re.Hash,
+		LocalHash:      le.hash,
+		Dir:            le.isDir,
+	}
+	b.Files[rel] = entry
+
+	if b.changes.Set == nil {
+		b.changes.Set = make(map[string]baseEntry)
+	}
+	b.changes.Set[rel] = entry
+}
+
+// remoteEntries adds the remote state recorded for everything below the
+// directory rel to entries, keyed by path relative to the sync root.
+func (b *baseline) remoteEntries(rel, remoteDir string, entries map[string]api.FileInfo) {
+	for p, be := range b.Files {
+		if rel != "." && !strings.HasPrefix(p, rel+"/") {
+			continue
+		}
+		entries[p] = api.FileInfo{
+			Name:     path.Base(p),
+			Size:     be.Size,
+			IsDir:    be.Dir,
+			Modified: be.RemoteModified,
+			Path:     path.Join(remoteDir, p),
+			Hash:     be.Hash,
+		}
+	}
+}
+
+// forget removes rel and everything below it.
+func (b *baseline) forget(rel string) {
+	for p := range b.Files {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			delete(b.Files, p)
+		}
+	}
+
+	for p := range b.changes.Set {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			delete(b.changes.Set, p)
+		}
+	}
+	b.changes.Forget = append(b.changes.Forget, rel)
+}
+
+// take returns the changes made since the last call.
+func (b *baseline) take() baseChanges {
+	c := b.changes
+	b.changes = baseChanges{}
+	return c
+}
+
+// replay makes the changes c, taken from another baseline.
+func (b *baseline) replay(c baseChanges) {
+	for _, rel := range c.Forget {
+		b.forget(rel)
+	}
+	for rel, entry := range c.Set {
+		b.Files[rel] = entry
+	}
+}
@@ -0,0 +1,907 @@
+// Package syncer mirrors a local directory tree into a Koneksi directory
+// without going through the FUSE mount.
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/cache"
+	"github.com/koneksi/koneksi-drive/internal/chunker"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+)
+
+// ActionKind identifies what an Action does on the remote side.
+type ActionKind int
+
+const (
+	ActionMkdir ActionKind = iota
+	ActionMove
+	ActionUpload
+	ActionDownload
+	ActionKeepBoth
+	ActionDelete
+	ActionMkdirLocal
+	ActionDeleteLocal
+)
+
+func (k ActionKind) String() string {
+	switch k {
+	case ActionMkdir:
+		return "mkdir"
+	case ActionMove:
+		return "move"
+	case ActionUpload:
+		return "upload"
+	case ActionDownload:
+		return "download"
+	case ActionKeepBoth:
+		return "keep-both"
+	case ActionDelete:
+		return "delete"
+	case ActionMkdirLocal:
+		return "mkdir-local"
+	case ActionDeleteLocal:
+		return "delete-local"
+	}
+	return "unknown"
+}
+
+// Action is a single change required to bring the remote tree in line
+// with the local one, or the local tree in line with the remote one when
+// pulling. Paths are relative to the sync roots and use
+// forward slashes.
+type Action struct {
+	Kind ActionKind
+	Path string
+	From string // source path for ActionMove
+	Copy string // name the local version is kept under for ActionKeepBoth
+	Size int64
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case ActionMove:
+		return fmt.Sprintf("%-6s %s -> %s", a.Kind, a.From, a.Path)
+	case ActionKeepBoth:
+		return fmt.Sprintf("%-6s %s (local version as %s)", a.Kind, a.Path, path.Base(a.Copy))
+	}
+	return fmt.Sprintf("%-6s %s", a.Kind, a.Path)
+}
+
+// Options controls how a sync is planned.
+type Options struct {
+	// Delete removes remote entries that no longer exist locally.
+	Delete bool
+	// DetectMoves turns a delete+upload pair with identical content into
+	// a server-side move. Only applies when Delete is set, since a move
+	// removes the source path.
+	DetectMoves bool
+	// Pull also applies changes made on the server since the last sync to
+	// the local tree: new and changed remote files are downloaded, and
+	// deletions are mirrored in both directions.
+	Pull bool
+	// Conflict decides what happens to files changed on both sides since
+	// the last sync. Empty means PolicyKeepBoth.
+	Conflict ConflictPolicy
+	// Resolve is asked about each conflict under PolicyInteractive. If it
+	// is nil, conflicts are skipped.
+	Resolve func(Conflict) Resolution
+	// StateFile stores what the last sync left on both sides. Empty means
+	// a file in the user cache directory derived from the sync roots.
+	StateFile string
+	// ReportFile is appended a line for every conflict. Empty means
+	// DefaultReportPath.
+	ReportFile string
+	// Rescan is how long the remote state recorded by a sync is trusted.
+	// Until then, remote directories with nothing changed below them
+	// locally are not listed again, unless pulling. Zero lists the whole
+	// remote tree on every run.
+	Rescan time.Duration
+	// Cache is the content cache shared with mounts of the same
+	// directory, or nil. Downloads are served from it and added to it,
+	// and uploaded files are stored in it, so content transferred once
+	// is not transferred again by a mount or a later sync.
+	Cache *cache.Cache
+	// Hooks is told about transferred and deleted files, conflicts and
+	// finished runs; may be nil.
+	Hooks *hooks.Hooks
+}
+
+// Plan is the ordered list of actions for one sync run.
+type Plan struct {
+	Actions   []Action
+	Conflicts []ResolvedConflict
+
+	local  map[string]*localEntry
+	remote map[string]api.FileInfo
+	synced []string  // files already equal on both sides
+	listed time.Time // when the whole remote tree was listed; zero if partly taken from the baseline
+}
+
+// ResolvedConflict is a conflict and the resolution chosen for it.
+type ResolvedConflict struct {
+	Conflict
+	Resolution Resolution
+}
+
+// Bytes returns the number of bytes the plan will upload.
+func (p *Plan) Bytes() int64 {
+	var total int64
+	for _, a := range p.Actions {
+		if a.Kind == ActionUpload || a.Kind == ActionKeepBoth {
+			total += a.Size
+		}
+	}
+	return total
+}
+
+// Engine plans and applies one-way syncs from a local directory to a
+// remote directory.
+type Engine struct {
+	storage   api.StorageBackend
+	uploader  *upload.Uploader
+	opts      Options
+	localDir  string
+	remoteDir string
+
+	base *baseline
+}
+
+type localEntry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+	hash    string
+}
+
+func NewEngine(storage api.StorageBackend, uploader *upload.Uploader, localDir, remoteDir string, opts Options) *Engine {
+	return &Engine{
+		storage:   storage,
+		uploader:  uploader,
+		opts:      opts,
+		localDir:  localDir,
+		remoteDir: path.Clean("/" + remoteDir),
+	}
+}
+
+// Plan compares both trees and returns the actions needed to make the
+// remote tree match the local one. With Options.Pull, changes made on the
+// server since the last sync are brought to the local tree as well.
+func (e *Engine) Plan(ctx context.Context) (*Plan, error) {
+	if err := e.loadBaseline(); err != nil {
+		return nil, err
+	}
+
+	local, err := e.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := e.trustedDirs(local)
+	listed := time.Now()
+	remote, rootExists, err := e.scanRemote(ctx, trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing remote root is treated as a first sync rather than as
+	// everything having been deleted on the server.
+	pull := e.opts.Pull && rootExists
+
+	plan := &Plan{local: local, remote: remote}
+	if len(trusted) == 0 {
+		plan.listed = listed
+	}
+	var mkdirs, uploads, deletes, localMkdirs, downloads, localDeletes []Action
+	var conflicts []Conflict
+
+	if !rootExists {
+		mkdirs = append(mkdirs, Action{Kind: ActionMkdir, Path: "."})
+	}
+
+	// kept collects local paths that stay, and their parents, so that a
+	// directory deleted on the server is only removed locally once
+	// nothing below it needs keeping.
+	kept := make(map[string]bool)
+	keep := func(rel string) {
+		for ; rel != "." && !kept[rel]; rel = path.Dir(rel) {
+			kept[rel] = true
+		}
+	}
+
+	for rel, le := range local {
+		if le.isDir {
+			continue
+		}
+		re, exists := remote[rel]
+		base, known := e.base.Files[rel]
+
+		switch {
+		case exists && re.IsDir:
+			// Type changed; replace the remote entry.
+			deletes = append(deletes, Action{Kind: ActionDelete, Path: rel})
+			uploads = append(uploads, Action{Kind: ActionUpload, Path: rel, Size: le.size})
+		case !exists && pull && known && !base.localChanged(le):
+			// Deleted on the server and unchanged here.
+			localDeletes = append(localDeletes, Action{Kind: ActionDeleteLocal, Path: rel})
+			continue
+		case !exists:
+			uploads = append(uploads, Action{Kind: ActionUpload, Path: rel, Size: le.size})
+		default:
+			changed, err := e.changed(rel, le, re)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case !changed:
+				plan.synced = append(plan.synced, rel)
+			case known && base.localChanged(le) && base.remoteChanged(re), pull && !known:
+				conflicts = append(conflicts, Conflict{
+					Path:           rel,
+					LocalSize:      le.size,
+					LocalModified:  le.modTime,
+					RemoteSize:     re.Size,
+					RemoteModified: re.Modified,
+				})
+			case pull && known && !base.localChanged(le):
+				downloads = append(downloads, Action{Kind: ActionDownload, Path: rel, Size: re.Size})
+			default:
+				uploads = append(uploads, Action{Kind: ActionUpload, Path: rel, Size: le.size})
+			}
+		}
+		keep(rel)
+	}
+
+	// Directories deleted on the server are removal candidates; every
+	// other local directory is kept.
+	var removable []string
+	for rel, le := range local {
+		if !le.isDir {
+			continue
+		}
+		re, exists := remote[rel]
+		_, known := e.base.Files[rel]
+
+		switch {
+		case exists && !re.IsDir:
+			deletes = append(deletes, Action{Kind: ActionDelete, Path: rel})
+			mkdirs = append(mkdirs, Action{Kind: ActionMkdir, Path: rel})
+		case exists:
+			plan.synced = append(plan.synced, rel)
+		case pull && known:
+			removable = append(removable, rel)
+			continue
+		default:
+			mkdirs = append(mkdirs, Action{Kind: ActionMkdir, Path: rel})
+		}
+		keep(rel)
+	}
+	for _, rel := range removable {
+		if kept[rel] {
+			mkdirs = append(mkdirs, Action{Kind: ActionMkdir, Path: rel})
+		} else {
+			localDeletes = append(localDeletes, Action{Kind: ActionDeleteLocal, Path: rel})
+		}
+	}
+
+	var resolved []Action
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	now := time.Now()
+	for _, c := range conflicts {
+		res := e.resolve(c)
+		plan.Conflicts = append(plan.Conflicts, ResolvedConflict{Conflict: c, Resolution: res})
+
+		switch res {
+		case ResolveLocal:
+			uploads = append(uploads, Action{Kind: ActionUpload, Path: c.Path, Size: c.LocalSize})
+		case ResolveRemote:
+			resolved = append(resolved, Action{Kind: ActionDownload, Path: c.Path, Size: c.RemoteSize})
+		case ResolveBoth:
+			resolved = append(resolved, Action{Kind: ActionKeepBoth, Path: c.Path, Copy: conflictName(c.Path, now), Size: c.LocalSize})
+		}
+	}
+
+	// Remote entries missing locally are new on the server when pulling
+	// and not seen before; otherwise they were deleted locally.
+	remoteKept := make(map[string]bool)
+	candidates := make(map[string]bool)
+	for rel, re := range remote {
+		if _, exists := local[rel]; exists {
+			continue
+		}
+		base, known := e.base.Files[rel]
+
+		switch {
+		case pull && !known && re.IsDir:
+			localMkdirs = append(localMkdirs, Action{Kind: ActionMkdirLocal, Path: rel})
+		case pull && !re.IsDir && (!known || base.remoteChanged(re)):
+			// New on the server, or changed there after being deleted
+			// here; the change wins.
+			downloads = append(downloads, Action{Kind: ActionDownload, Path: rel, Size: re.Size})
+		case pull || e.opts.Delete:
+			candidates[rel] = true
+			continue
+		default:
+			continue
+		}
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			remoteKept[dir] = true
+		}
+	}
+	for rel := range candidates {
+		if remoteKept[rel] {
+			continue
+		}
+		// Only the topmost deleted directory needs deleting.
+		if parent := path.Dir(rel); parent != "." && candidates[parent] && !remoteKept[parent] {
+			continue
+		}
+		deletes = append(deletes, Action{Kind: ActionDelete, Path: rel, Size: remote[rel].Size})
+	}
+
+	var moves []Action
+	if (e.opts.Delete || pull) && e.opts.DetectMoves {
+		moves, uploads, deletes, err = e.detectMoves(local, remote, uploads, deletes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Files under retention are neither replaced, moved nor deleted on
+	// the server; the local changes wait for the retention to expire.
+	uploads = dropRetained(uploads, remote, func(a Action) string { return a.Path })
+	moves = dropRetained(moves, remote, func(a Action) string { return a.From })
+	deletes = dropRetained(deletes, remote, func(a Action) string { return a.Path })
+
+	// Parents before children for mkdir, deepest first for delete.
+	sort.Slice(mkdirs, func(i, j int) bool { return mkdirs[i].Path < mkdirs[j].Path })
+	sort.Slice(moves, func(i, j int) bool { return moves[i].Path < moves[j].Path })
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Path < uploads[j].Path })
+	sort.Slice(localMkdirs, func(i, j int) bool { return localMkdirs[i].Path < localMkdirs[j].Path })
+	sort.Slice(downloads, func(i, j int) bool { return downloads[i].Path < downloads[j].Path })
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].Path > deletes[j].Path })
+	sort.Slice(localDeletes, func(i, j int) bool { return localDeletes[i].Path > localDeletes[j].Path })
+
+	plan.Actions = append(plan.Actions, mkdirs...)
+	plan.Actions = append(plan.Actions, moves...)
+	plan.Actions = append(plan.Actions, uploads...)
+	plan.Actions = append(plan.Actions, localMkdirs...)
+	plan.Actions = append(plan.Actions, downloads...)
+	plan.Actions = append(plan.Actions, resolved...)
+	plan.Actions = append(plan.Actions, deletes...)
+	plan.Actions = append(plan.Actions, localDeletes...)
+	return plan, nil
+}
+
+// Apply executes the plan in order. progress, if non-nil, is called
+// before each action. Apply stops at the first failing action. Conflicts
+// are recorded in the report first, and the state of every file synced
+// is saved for the next run even if an action fails. Progress is
+// journaled, so the next run also resumes a sync that was killed.
+func (e *Engine) Apply(ctx context.Context, plan *Plan, progress func(Action)) (err error) {
+	if err := e.report(plan); err != nil {
+		slog.Warn("failed to record sync conflicts", "error", err)
+	}
+
+	for rel := range e.base.Files {
+		_, inLocal := plan.local[rel]
+		_, inRemote := plan.remote[rel]
+		if !inLocal && !inRemote {
+			delete(e.base.Files, rel)
+		}
+	}
+	for _, rel := range plan.synced {
+		e.base.set(rel, plan.local[rel], plan.remote[rel])
+	}
+	if !plan.listed.IsZero() {
+		e.base.Listed = plan.listed
+	}
+	// Unchanged files are found in sync again if this run is lost.
+	e.base.take()
+
+	j, err := createJournal(e.base.path + journalSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create sync journal: %w", err)
+	}
+	done := 0
+	defer func() {
+		saveErr := e.base.save()
+		if saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save sync state: %w", saveErr)
+		}
+		// Runs with nothing to do, as most are in watch mode, are not
+		// reported.
+		if len(plan.Actions) > 0 || len(plan.Conflicts) > 0 || err != nil {
+			details := map[string]any{"actions": done, "planned": len(plan.Actions), "conflicts": len(plan.Conflicts)}
+			if err != nil {
+				details["error"] = err.Error()
+			}
+			e.opts.Hooks.Fire(hooks.Event{Event: hooks.SyncFinished, Path: e.remoteDir, Local: e.localDir, Details: details})
+		}
+		// After a failed action the journal stays, so the next run can
+		// clean up after it.
+		j.close(err == nil)
+	}()
+
+	for _, action := range plan.Actions {
+		if progress != nil {
+			progress(action)
+		}
+		if err := j.begin(action); err != nil {
+			return fmt.Errorf("failed to write sync journal: %w", err)
+		}
+		if err := e.apply(ctx, plan, action); err != nil {
+			return fmt.Errorf("%s %s: %w", action.Kind, action.Path, err)
+		}
+		if err := j.done(e.base.take()); err != nil {
+			return fmt.Errorf("failed to write sync journal: %w", err)
+		}
+		done++
+	}
+	return nil
+}
+
+func (e *Engine) apply(ctx context.Context, plan *Plan, action Action) error {
+	remotePath := e.remotePath(action.Path)
+
+	switch action.Kind {
+	case ActionMkdir:
+		if err := e.storage.Mkdir(ctx, remotePath); err != nil {
+			return err
+		}
+		if action.Path != "." {
+			e.base.set(action.Path, plan.local[action.Path], api.FileInfo{IsDir: true})
+		}
+		return nil
+	case ActionMkdirLocal:
+		if err := os.MkdirAll(e.localPath(action.Path), 0755); err != nil {
+			return err
+		}
+		e.base.set(action.Path, &localEntry{isDir: true}, plan.remote[action.Path])
+		return nil
+	case ActionMove:
+		if err := e.storage.Move(ctx, e.remotePath(action.From), remotePath); err != nil {
+			return err
+		}
+		e.base.forget(action.From)
+		return e.record(ctx, action.Path, plan.local[action.Path])
+	case ActionUpload:
+		info, err := e.upload(ctx, action.Path)
+		if err != nil {
+			return err
+		}
+		e.base.set(action.Path, plan.local[action.Path], *info)
+		e.fire(hooks.Upload, action.Path, info.Size)
+		return nil
+	case ActionDownload:
+		tmp, err := e.fetch(ctx, action.Path, plan.remote[action.Path])
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, e.localPath(action.Path)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		e.fire(hooks.Download, action.Path, plan.remote[action.Path].Size)
+		return e.recordLocal(action.Path, plan.remote[action.Path])
+	case ActionKeepBoth:
+		// Fetch first so a failed download leaves the local file alone.
+		tmp, err := e.fetch(ctx, action.Path, plan.remote[action.Path])
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(e.localPath(action.Path), e.localPath(action.Copy)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, e.localPath(action.Path)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := e.recordLocal(action.Path, plan.remote[action.Path]); err != nil {
+			return err
+		}
+		e.fire(hooks.Download, action.Path, plan.remote[action.Path].Size)
+		// An interrupted upload is retried as a new file by the next sync.
+		info, err := e.upload(ctx, action.Copy)
+		if err != nil {
+			return err
+		}
+		copyInfo, err := os.Stat(e.localPath(action.Copy))
+		if err != nil {
+			return err
+		}
+		e.base.set(action.Copy, &localEntry{size: copyInfo.Size(), modTime: copyInfo.ModTime()}, *info)
+		e.fire(hooks.Upload, action.Copy, info.Size)
+		return nil
+	case ActionDelete:
+		if err := e.storage.Delete(ctx, remotePath); err != nil {
+			return err
+		}
+		e.base.forget(action.Path)
+		e.opts.Hooks.Fire(hooks.Event{Event: hooks.Delete, Path: remotePath})
+		return nil
+	case ActionDeleteLocal:
+		err := os.Remove(e.localPath(action.Path))
+		if err != nil && !os.IsNotExist(err) {
+			if !plan.local[action.Path].isDir {
+				return err
+			}
+			// Entries the sync does not handle, such as symlinks, keep
+			// the directory alive.
+			slog.Warn("failed to remove local directory", "path", action.Path, "error", err)
+			return nil
+		}
+		e.base.forget(action.Path)
+		return nil
+	}
+	return fmt.Errorf("unknown action %d", action.Kind)
+}
+
+// dropRetained returns actions without those changing a remote file under
+// retention, or deleting a folder holding one. target returns the remote
+// path an action changes.
+func dropRetained(actions []Action, remote map[string]api.FileInfo, target func(Action) string) []Action {
+	now := time.Now()
+	var retained []string
+	for rel, re := range remote {
+		if re.Retained(now) {
+			retained = append(retained, rel)
+		}
+	}
+	if len(retained) == 0 {
+		return actions
+	}
+
+	kept := actions[:0]
+next:
+	for _, a := range actions {
+		rel := target(a)
+		for _, held := range retained {
+			if held == rel || strings.HasPrefix(held, rel+"/") {
+				slog.Warn("not changing file under retention on the server", "path", held, "until", *remote[held].RetainUntil)
+				continue next
+			}
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// upload sends the local file rel to the server and returns the server's
+// view of the new version.
+func (e *Engine) upload(ctx context.Context, rel string) (*api.FileInfo, error) {
+	remotePath := e.remotePath(rel)
+
+	f, err := os.Open(e.localPath(rel))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if e.opts.Cache != nil {
+		return e.uploadCached(ctx, remotePath, f)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.uploader.Upload(ctx, remotePath, f, info.Size(), nil); err != nil {
+		return nil, err
+	}
+	return e.storage.Stat(ctx, remotePath)
+}
+
+// uploadCached uploads a copy of f made in the cache directory, which
+// then becomes the cached copy of remotePath, so the cache holds exactly
+// what was sent even if f changes meanwhile. Large files are diffed
+// against the cached copy of the previous version, as by the mount.
+func (e *Engine) uploadCached(ctx context.Context, remotePath string, f *os.File) (*api.FileInfo, error) {
+	snapshot, err := e.opts.Cache.TempFile()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(snapshot.Name())
+
+	size, err := io.Copy(snapshot, f)
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	var base []chunker.Chunk
+	if e.uploader.Chunked(remotePath, size) {
+		base, _ = e.opts.Cache.Chunks(remotePath, e.uploader.Chunker())
+	}
+	chunks, err := e.uploader.Upload(ctx, remotePath, snapshot, size, base)
+	snapshot.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := e.storage.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := e.opts.Cache.Adopt(remotePath, info.Modified, info.Hash, snapshot.Name())
+	if err != nil {
+		slog.Debug("failed to cache uploaded file", "path", remotePath, "error", err)
+		e.opts.Cache.Remove(remotePath)
+		return info, nil
+	}
+	cached.Close()
+	if chunks != nil {
+		e.opts.Cache.SetChunks(remotePath, chunks)
+	}
+	return info, nil
+}
+
+// openRemote returns the content of the remote version re of rel, from
+// the cache if it holds that version. Downloads are added to the cache.
+func (e *Engine) openRemote(ctx context.Context, rel string, re api.FileInfo) (io.ReadCloser, error) {
+	remotePath := e.remotePath(rel)
+	if e.opts.Cache == nil {
+		return e.storage.Read(ctx, remotePath)
+	}
+
+	if f, ok := e.opts.Cache.Open(remotePath, re.Size, re.Modified, re.Hash); ok {
+		return f, nil
+	}
+
+	body, err := e.storage.Read(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return e.opts.Cache.Fill(remotePath, re.Size, re.Modified, re.Hash, body)
+}
+
+// fetch downloads the remote version of rel into a temporary file next to
+// its local path and gives it the remote modification time.
+func (e *Engine) fetch(ctx context.Context, rel string, re api.FileInfo) (string, error) {
+	dest := e.localPath(rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+
+	body, err := e.openRemote(ctx, rel, re)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+syncTempInfix+"*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), time.Now(), re.Modified)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// record stores le and the current remote state of rel as in sync.
+func (e *Engine) record(ctx context.Context, rel string, le *localEntry) error {
+	info, err := e.storage.Stat(ctx, e.remotePath(rel))
+	if err != nil {
+		return err
+	}
+	e.base.set(rel, le, *info)
+	return nil
+}
+
+// recordLocal stores the current local state of rel and re as in sync.
+func (e *Engine) recordLocal(rel string, re api.FileInfo) error {
+	info, err := os.Stat(e.localPath(rel))
+	if err != nil {
+		return err
+	}
+	e.base.set(rel, &localEntry{size: info.Size(), modTime: info.ModTime()}, re)
+	return nil
+}
+
+func (e *Engine) loadBaseline() error {
+	name := e.opts.StateFile
+	if name == "" {
+		var err error
+		if name, err = defaultStatePath(e.localDir, e.remoteDir); err != nil {
+			return fmt.Errorf("failed to locate sync state: %w", err)
+		}
+	}
+
+	base, err := loadBaseline(name)
+	if err != nil {
+		return err
+	}
+	e.base = base
+	return e.recover()
+}
+
+// changed reports whether a file present on both sides needs uploading.
+func (e *Engine) changed(rel string, le *localEntry, re api.FileInfo) (bool, error) {
+	if base, known := e.base.Files[rel]; known && !base.localChanged(le) && !base.remoteChanged(re) {
+		// Equal after the last sync and untouched on both sides since.
+		return false, nil
+	}
+	if le.size != re.Size {
+		return true, nil
+	}
+	if re.Hash != "" {
+		hash, err := e.localHash(rel, le)
+		if err != nil {
+			return false, err
+		}
+		return !strings.EqualFold(hash, re.Hash), nil
+	}
+	// Without a remote hash, fall back to modification times.
+	return le.modTime.After(re.Modified), nil
+}
+
+func (e *Engine) scanLocal() (map[string]*localEntry, error) {
+	entries := make(map[string]*localEntry)
+
+	err := filepath.WalkDir(e.localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == e.localDir {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			// Symlinks, sockets and devices are not synced.
+			return nil
+		}
+		if isSyncTemp(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(e.localDir, p)
+		if err != nil {
+			return err
+		}
+
+		le := &localEntry{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			isDir:   d.IsDir(),
+		}
+		// The hash of a file unchanged since the last sync is known.
+		if base, known := e.base.Files[filepath.ToSlash(rel)]; known && !le.isDir && !base.localChanged(le) {
+			le.hash = base.LocalHash
+		}
+		entries[filepath.ToSlash(rel)] = le
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", e.localDir, err)
+	}
+
+	return entries, nil
+}
+
+// scanRemote lists the remote tree. A missing remote directory is reported
+// through exists rather than as an error, since the sync creates it. The
+// content of trusted directories is taken from the baseline instead of
+// being listed.
+func (e *Engine) scanRemote(ctx context.Context, trusted map[string]bool) (entries map[string]api.FileInfo, exists bool, err error) {
+	entries = make(map[string]api.FileInfo)
+	if trusted["."] {
+		e.base.remoteEntries(".", e.remoteDir, entries)
+		return entries, true, nil
+	}
+
+	err = api.Walk(ctx, e.storage, e.remoteDir, func(p string, info api.FileInfo) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, e.remoteDir), "/")
+		entries[rel] = info
+		if info.IsDir && trusted[rel] {
+			e.base.remoteEntries(rel, e.remoteDir, entries)
+			return api.SkipDir
+		}
+		return nil
+	})
+	if api.IsNotFound(err) && len(entries) == 0 {
+		return entries, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list %s: %w", e.remoteDir, err)
+	}
+
+	return entries, true, nil
+}
+
+// trustedDirs returns the directories, "." being the sync root, whose
+// remote content is taken from the baseline instead of listed: nothing
+// was added, changed or removed below them locally since the last sync,
+// which listed the whole remote tree less than Options.Rescan ago.
+// Pulling needs the changes made on the server, so nothing is trusted
+// then.
+func (e *Engine) trustedDirs(local map[string]*localEntry) map[string]bool {
+	if e.opts.Pull || e.opts.Rescan <= 0 || time.Since(e.base.Listed) >= e.opts.Rescan {
+		return nil
+	}
+
+	// A change marks the directory it is in and every parent.
+	dirty := make(map[string]bool)
+	mark := func(rel string) {
+		for !dirty[rel] {
+			dirty[rel] = true
+			if rel == "." {
+				return
+			}
+			rel = path.Dir(rel)
+		}
+	}
+	for rel, le := range local {
+		base, known := e.base.Files[rel]
+		switch {
+		case !known || base.Dir != le.isDir:
+			mark(rel)
+		case !le.isDir && base.localChanged(le):
+			mark(path.Dir(rel))
+		}
+	}
+	for rel := range e.base.Files {
+		if _, exists := local[rel]; !exists {
+			mark(path.Dir(rel))
+		}
+	}
+
+	trusted := make(map[string]bool)
+	if !dirty["."] {
+		trusted["."] = true
+	}
+	for rel, le := range local {
+		if le.isDir && !dirty[rel] {
+			trusted[rel] = true
+		}
+	}
+	return trusted
+}
+
+// syncTempInfix marks downloads in progress, which are written next to
+// their destination and renamed into place when complete.
+const syncTempInfix = ".sync-"
+
+func isSyncTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, syncTempInfix)
+}
+
+// fire tells the hooks about an event concerning the file rel.
+func (e *Engine) fire(event, rel string, size int64) {
+	e.opts.Hooks.Fire(hooks.Event{Event: event, Path: e.remotePath(rel), Local: e.localPath(rel), Size: size})
+}
+
+func (e *Engine) localPath(rel string) string {
+	return filepath.Join(e.localDir, filepath.FromSlash(rel))
+}
+
+func (e *Engine) remotePath(rel string) string {
+	return path.Join(e.remoteDir, rel)
+}
@@ -0,0 +1,135 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions controls Engine.Watch.
+type WatchOptions struct {
+	// Delay is how long the local tree has to stay quiet after a change
+	// before it is synced, so a burst of writes results in a single run.
+	Delay time.Duration
+	// PollInterval is how often the remote tree is checked for changes.
+	// Zero disables polling; only useful together with Options.Pull.
+	PollInterval time.Duration
+	// RetryInterval is how long to wait before retrying a failed run when
+	// nothing else triggers one.
+	RetryInterval time.Duration
+}
+
+// Watch syncs once and then again whenever the local tree changes or the
+// poll interval passes, until ctx is done. progress is passed to Apply;
+// done, if non-nil, is called after every run with its plan or error.
+// A run in progress when ctx is done is finished first. Failed runs are
+// retried; Watch only returns early if the local tree cannot be watched.
+func (e *Engine) Watch(ctx context.Context, opts WatchOptions, progress func(Action), done func(*Plan, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, e.localDir); err != nil {
+		return err
+	}
+
+	// A nil channel blocks forever, which disables polling.
+	var poll <-chan time.Time
+	if opts.PollInterval > 0 {
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	// The first run happens right away.
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	runCtx := context.WithoutCancel(ctx)
+	run := func() {
+		plan, err := e.Plan(runCtx)
+		if err == nil {
+			err = e.Apply(runCtx, plan, progress)
+		}
+		if done != nil {
+			done(plan, err)
+		}
+		if err != nil {
+			slog.Error("sync failed, retrying", "error", err, "retry_in", opts.RetryInterval)
+			resetTimer(timer, opts.RetryInterval)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-timer.C:
+			run()
+
+		case <-poll:
+			run()
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isSyncTemp(filepath.Base(event.Name)) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// New directories are watched as well; watching one that
+				// is not a directory fails harmlessly.
+				if err := watchTree(watcher, event.Name); err != nil {
+					slog.Debug("failed to watch new entry", "path", event.Name, "error", err)
+				}
+			}
+			resetTimer(timer, opts.Delay)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Usually a queue overflow, after which events were lost; a
+			// full run catches up.
+			slog.Warn("file watcher error", "error", err)
+			resetTimer(timer, opts.Delay)
+		}
+	}
+}
+
+// watchTree adds root and every directory below it to watcher.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != root && errors.Is(err, fs.ErrNotExist) {
+				// Removed while walking.
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return watcher.Add(p)
+	})
+}
+
+// resetTimer restarts t to fire after d, discarding a pending expiry.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,300 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	// schema is the version of the report format.
+	schema = 1
+	// interval is how often reports are sent at most.
+	interval = 24 * time.Hour
+	// sendTimeout bounds sending a report.
+	sendTimeout = 10 * time.Second
+)
+
+// Report is what is sent: counts summed over the mounts since the last
+// report, and the platform they ran on.
+type Report struct {
+	Schema    int    `json:"schema"`
+	Version   string `json:"version"` // of this program
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GoVersion string `json:"go_version"`
+
+	Mounts       int64            `json:"mounts"`
+	MountLengths map[string]int64 `json:"mount_lengths"` // mounts by how long they ran, e.g. "1h-8h"
+	Features     map[string]int64 `json:"features"`      // mounts by the optional features they used
+
+	Calls       map[string]int64            `json:"api_calls"`   // by operation, e.g. "read"
+	Errors      map[string]int64            `json:"api_errors"`  // failed calls by class, e.g. "timeout"
+	Latency     map[string]map[string]int64 `json:"api_latency"` // calls by operation and latency bucket, e.g. "<250ms"
+	CacheHits   int64                       `json:"cache_hits"`
+	CacheMisses int64                       `json:"cache_misses"`
+}
+
+// Mount is the statistics of a mount session.
+type Mount struct {
+	Duration    time.Duration
+	Calls       map[string]int64
+	Errors      map[string]int64
+	Latency     map[string][]int64 // by api.LatencyBuckets
+	CacheHits   int64
+	CacheMisses int64
+}
+
+func newReport() *Report {
+	r := &Report{
+		Schema:       schema,
+		MountLengths: make(map[string]int64),
+		Features:     make(map[string]int64),
+		Calls:        make(map[string]int64),
+		Errors:       make(map[string]int64),
+		Latency:      make(map[string]map[string]int64),
+	}
+	r.stamp()
+	return r
+}
+
+// stamp sets the platform of the report to the current one.
+func (r *Report) stamp() {
+	r.Schema = schema
+	r.Version = "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		r.Version = info.Main.Version
+	}
+	r.OS = runtime.GOOS
+	r.Arch = runtime.GOARCH
+	r.GoVersion = runtime.Version()
+}
+
+// add sums the statistics of a mount with configuration cfg into r.
+func (r *Report) add(cfg *config.Config, m Mount) {
+	r.Mounts++
+	r.MountLengths[lengthBucket(m.Duration)]++
+	for _, f := range features(cfg) {
+		r.Features[f]++
+	}
+	for op, n := range m.Calls {
+		r.Calls[op] += n
+	}
+	for class, n := range m.Errors {
+		r.Errors[class] += n
+	}
+	for op, buckets := range m.Latency {
+		if r.Latency[op] == nil {
+			r.Latency[op] = make(map[string]int64)
+		}
+		for i, n := range buckets {
+			if n > 0 {
+				r.Latency[op][latencyBucket(i)] += n
+			}
+		}
+	}
+	r.CacheHits += m.CacheHits
+	r.CacheMisses += m.CacheMisses
+}
+
+// merge sums the counts of o into r.
+func (r *Report) merge(o *Report) {
+	r.Mounts += o.Mounts
+	for _, m := range []struct{ dst, src map[string]int64 }{
+		{r.MountLengths, o.MountLengths},
+		{r.Features, o.Features},
+		{r.Calls, o.Calls},
+		{r.Errors, o.Errors},
+	} {
+		for k, n := range m.src {
+			m.dst[k] += n
+		}
+	}
+	for op, buckets := range o.Latency {
+		if r.Latency[op] == nil {
+			r.Latency[op] = make(map[string]int64)
+		}
+		for b, n := range buckets {
+			r.Latency[op][b] += n
+		}
+	}
+	r.CacheHits += o.CacheHits
+	r.CacheMisses += o.CacheMisses
+}
+
+// lengthBucket names the bucket of mount durations d falls in.
+func lengthBucket(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return "<1h"
+	case d < 8*time.Hour:
+		return "1h-8h"
+	case d < 24*time.Hour:
+		return "8h-1d"
+	}
+	return ">1d"
+}
+
+// latencyBucket names bucket i of api.LatencyBuckets.
+func latencyBucket(i int) string {
+	if i < len(api.LatencyBuckets) {
+		return "<" + api.LatencyBuckets[i].String()
+	}
+	return ">" + api.LatencyBuckets[len(api.LatencyBuckets)-1].String()
+}
+
+// features lists the optional features cfg turns on, by the names of
+// their settings.
+func features(cfg *config.Config) []string {
+	var f []string
+	add := func(name string, on bool) {
+		if on {
+			f = append(f, name)
+		}
+	}
+	add("api.backend="+cfg.API.Backend, true)
+	add("cache", cfg.Cache.Enabled)
+	add("cache.directory", cfg.Cache.Enabled && cfg.Cache.Directory != "")
+	add("encryption", cfg.Encryption.Enabled)
+	add("hooks", len(cfg.Hooks) > 0)
+	add("notifications", cfg.Notifications.Enabled)
+	add("mount.read_only", cfg.Mount.ReadOnly)
+	add("mount.offline", cfg.Mount.Offline)
+	add("mount.mirror", cfg.Mount.Mirror)
+	add("mount.overlay_dir", cfg.Mount.OverlayDir != "")
+	add("mount.append_only", cfg.Mount.AppendOnly)
+	add("mount.recovery", cfg.Mount.Recovery)
+	add("mount.hard", cfg.Mount.Hard)
+	add("mount.listing_ttl", cfg.Mount.ListingTTL > 0)
+	add("mount.io_rules", len(cfg.Mount.IORules) > 0)
+	add("mount.process_rules", len(cfg.Mount.ProcessRules) > 0)
+	add("mount.consistency="+cfg.Mount.Consistency, true)
+	add("upload.delta", cfg.Upload.Delta)
+	add("upload.scan", cfg.Upload.Scan.Scanner != "" && cfg.Upload.Scan.Scanner != "off")
+	return f
+}
+
+// Record adds the statistics of a mount with configuration cfg to the
+// next report, if telemetry is enabled.
+func Record(cfg *config.Config, m Mount) error {
+	// Nothing is written for users who never opted in.
+	if s, err := Load(); err != nil || !s.Active() {
+		return err
+	}
+	return update(func(s *State) error {
+		if !s.Active() {
+			return nil
+		}
+		if s.Pending == nil {
+			s.Pending = newReport()
+			s.PendingSince = time.Now()
+		}
+		s.Pending.add(cfg, m)
+		return nil
+	})
+}
+
+// Preview returns the report that would be sent next: the statistics
+// collected so far, or none yet.
+func Preview() (*Report, error) {
+	s, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	r := s.Pending
+	if r == nil {
+		r = newReport()
+	}
+	r.stamp()
+	return r, nil
+}
+
+// Endpoint returns the URL reports are sent to.
+func Endpoint(cfg *config.Config) string {
+	if cfg.Telemetry.Endpoint != "" {
+		return cfg.Telemetry.Endpoint
+	}
+	return strings.TrimSuffix(cfg.API.BaseURL, "/") + "/api/telemetry"
+}
+
+// SendDue sends the next report to endpoint if telemetry is enabled and
+// the report covers a day or more, and then starts the next one. A report
+// that fails to send is kept and sent later.
+func SendDue(ctx context.Context, endpoint string) error {
+	if s, err := Load(); err != nil || !s.Active() {
+		return err
+	}
+
+	// Taken out while sending, so mounts ending meanwhile do not wait
+	// for the server to record theirs.
+	var r *Report
+	var since time.Time
+	err := update(func(s *State) error {
+		if !s.Active() || s.Pending == nil || time.Since(s.PendingSince) < interval {
+			return nil
+		}
+		r, since = s.Pending, s.PendingSince
+		s.Pending, s.PendingSince = nil, time.Time{}
+		return nil
+	})
+	if err != nil || r == nil {
+		return err
+	}
+
+	r.stamp()
+	sendErr := send(ctx, endpoint, r)
+	err = update(func(s *State) error {
+		if sendErr == nil {
+			s.LastSent = time.Now()
+			return nil
+		}
+		if !s.Active() {
+			return nil
+		}
+		if s.Pending != nil {
+			r.merge(s.Pending)
+		}
+		s.Pending, s.PendingSince = r, since
+		return nil
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	return err
+}
+
+// send POSTs r to endpoint as JSON. Reports carry no credentials.
+func send(ctx context.Context, endpoint string, r *Report) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send telemetry: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send telemetry: %s", resp.Status)
+	}
+	return nil
+}
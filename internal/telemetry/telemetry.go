@@ -0,0 +1,125 @@
+// Package telemetry collects anonymous usage statistics of mounts, such
+// as how many API calls of each kind they made, how those failed and how
+// long they took, and reports them once a day to help the maintainers
+// decide what to work on. Nothing is collected or sent unless the user
+// opted in with "telemetry enable"; no paths, names, directory IDs,
+// hostnames or credentials are ever part of a report.
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// State is the choice of the user and the statistics collected since the
+// last report.
+type State struct {
+	Enabled  bool      `json:"enabled"`
+	Changed  time.Time `json:"changed,omitempty"`   // when it was last enabled or disabled
+	LastSent time.Time `json:"last_sent,omitempty"` // when the last report was sent
+	// Pending is the next report, collected since PendingSince.
+	Pending      *Report   `json:"pending,omitempty"`
+	PendingSince time.Time `json:"pending_since,omitempty"`
+}
+
+// OptedOut reports whether the DO_NOT_TRACK environment variable turns
+// telemetry off regardless of the user's choice.
+func OptedOut() bool {
+	v := os.Getenv("DO_NOT_TRACK")
+	return v != "" && v != "0"
+}
+
+// Active reports whether statistics are collected and sent.
+func (s *State) Active() bool {
+	return s.Enabled && !OptedOut()
+}
+
+// statePath returns the file the state is kept in.
+func statePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "koneksi-drive", "telemetry.json"), nil
+}
+
+// Load reads the state. Telemetry is disabled until enabled.
+func Load() (*State, error) {
+	name, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+	return load(name)
+}
+
+func load(name string) (*State, error) {
+	s := &State{}
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry state: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse telemetry state %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// update changes the state with fn and saves it, while keeping other
+// processes, such as mounts ending at the same time, from doing the same.
+func update(fn func(*State) error) error {
+	name, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	s, err := load(name)
+	if err != nil {
+		return err
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// SetEnabled records the user's choice. Disabling drops the statistics
+// collected and not sent yet.
+func SetEnabled(enabled bool) error {
+	return update(func(s *State) error {
+		s.Enabled = enabled
+		s.Changed = time.Now()
+		if !enabled {
+			s.Pending = nil
+			s.PendingSince = time.Time{}
+		}
+		return nil
+	})
+}
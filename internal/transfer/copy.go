@@ -0,0 +1,232 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+)
+
+// Copy copies the remote file or folder src to dstPath in dst, the
+// storage of the directory dstID. The server copies files itself when it
+// can; otherwise their content is streamed from one directory to the
+// other without being staged on local disk. As with Put, a file copied
+// onto an existing folder is placed inside it, and a folder's contents
+// always end up at dstPath itself.
+//
+// The Transfer's storage reads src and its uploader, if any, decides the
+// content types stored at dstPath.
+func (t *Transfer) Copy(ctx context.Context, dstID string, dst api.StorageBackend, src, dstPath string) (*Summary, error) {
+	src = path.Clean("/" + src)
+	dstPath = path.Clean("/" + dstPath)
+
+	isDir := src == "/"
+	var root *api.FileInfo
+	if !isDir {
+		var err error
+		if root, err = t.storage.Stat(ctx, src); err != nil {
+			return nil, err
+		}
+		isDir = root.IsDir
+	}
+
+	if !isDir {
+		remote, err := dst.Stat(ctx, dstPath)
+		if err != nil && !api.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && remote.IsDir {
+			dstPath = path.Join(dstPath, path.Base(src))
+			if remote, err = dst.Stat(ctx, dstPath); err != nil && !api.IsNotFound(err) {
+				return nil, err
+			}
+		}
+		if t.directoryID == dstID && dstPath == src {
+			return nil, fmt.Errorf("cannot copy %s onto itself", src)
+		}
+
+		remotes := map[string]api.FileInfo{}
+		if remote != nil {
+			remotes[""] = *remote
+		}
+		files := map[string]api.FileInfo{"": *root}
+		return t.copy(ctx, dstID, dst, src, dstPath, nil, files, remotes)
+	}
+
+	if t.directoryID == dstID &&
+		(dstPath == src || strings.HasPrefix(dstPath, strings.TrimSuffix(src, "/")+"/")) {
+		return nil, fmt.Errorf("cannot copy %s into itself", src)
+	}
+
+	sources, err := scanRemote(ctx, t.storage, src)
+	if err != nil {
+		return nil, err
+	}
+	delete(sources, "")
+
+	var dirs []string
+	files := make(map[string]api.FileInfo)
+	for rel, info := range sources {
+		if info.IsDir {
+			dirs = append(dirs, rel)
+		} else {
+			files[rel] = info
+		}
+	}
+	sort.Strings(dirs)
+
+	remotes, err := scanRemote(ctx, dst, dstPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := remotes[""]; !ok {
+		if err := mkdirAll(ctx, dst, dstPath); err != nil {
+			return nil, err
+		}
+	}
+	return t.copy(ctx, dstID, dst, src, dstPath, dirs, files, remotes)
+}
+
+func (t *Transfer) copy(ctx context.Context, dstID string, dst api.StorageBackend, src, dstPath string, dirs []string, files, remotes map[string]api.FileInfo) (*Summary, error) {
+	for _, rel := range dirs {
+		if re, ok := remotes[rel]; ok && re.IsDir {
+			continue
+		}
+		if err := dst.Mkdir(ctx, path.Join(dstPath, rel)); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", path.Join(dstPath, rel), err)
+		}
+	}
+
+	state, err := t.openState("copy", t.directoryID+":"+src, dstID+":"+dstPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var jobs []job
+	skipped := 0
+	for _, rel := range rels {
+		info := files[rel]
+		j := job{rel: rel, size: info.Size, modTime: info.Modified}
+
+		re, exists := remotes[rel]
+		switch {
+		case state.isDone(j) && exists:
+			skipped++
+		case !exists || re.IsDir:
+			jobs = append(jobs, j)
+		case t.opts.Checksum:
+			if t.sameContent(dst, path.Join(src, rel), path.Join(dstPath, rel), info, re) {
+				skipped++
+			} else {
+				jobs = append(jobs, j)
+			}
+		case re.Size == info.Size && !info.Modified.After(re.Modified):
+			skipped++
+		default:
+			jobs = append(jobs, j)
+		}
+	}
+
+	return t.run(state, jobs, skipped, func(j job) (bool, error) {
+		info := files[j.rel]
+		srcPath := path.Join(src, j.rel)
+		target := path.Join(dstPath, j.rel)
+
+		if re, ok := remotes[j.rel]; ok && re.IsDir {
+			return false, fmt.Errorf("%s is a folder on the server", target)
+		}
+		if re, ok := remotes[j.rel]; ok {
+			if err := api.CheckRetention(&re); err != nil {
+				return false, err
+			}
+		}
+		if err := t.copyFile(ctx, dstID, dst, srcPath, target, info); err != nil {
+			return false, err
+		}
+		t.opts.Hooks.Fire(hooks.Event{Event: hooks.Upload, Path: target, Size: info.Size, Details: map[string]any{
+			"from_directory": t.directoryID,
+			"from_path":      srcPath,
+		}})
+		return false, nil
+	})
+}
+
+// copyFile copies one file, by the storage if it can and by streaming its
+// content otherwise.
+func (t *Transfer) copyFile(ctx context.Context, dstID string, dst api.StorageBackend, srcPath, dstPath string, info api.FileInfo) error {
+	err := api.ErrCopyUnsupported
+	from, fromClient := t.storage.(*api.Client)
+	to, toClient := dst.(*api.Client)
+	switch {
+	case t.directoryID == dstID:
+		err = t.storage.Copy(ctx, srcPath, dstPath)
+	case fromClient && toClient:
+		err = from.CopyTo(ctx, to, srcPath, dstPath)
+	}
+	if err == nil {
+		if t.opts.Progress != nil {
+			t.opts.Progress.Transferred(info.Size)
+		}
+		return nil
+	}
+	if !errors.Is(err, api.ErrCopyUnsupported) {
+		return err
+	}
+
+	body, err := t.storage.Read(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	contentType := ""
+	if t.uploader != nil {
+		contentType = t.uploader.ContentType(dstPath, nil, 0)
+	}
+	r := &progressReader{r: body, progress: t.opts.Progress}
+	if err := dst.Write(ctx, dstPath, contentType, r); err != nil {
+		return err
+	}
+
+	if t.opts.Checksum && info.Hash != "" && t.hashesComparable(dst, srcPath, dstPath) {
+		copied, err := dst.Stat(ctx, dstPath)
+		if err != nil {
+			return err
+		}
+		if copied.Hash != "" && !strings.EqualFold(copied.Hash, info.Hash) {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", dstPath, info.Hash, copied.Hash)
+		}
+	}
+	return nil
+}
+
+// sameContent reports whether the server hashes of two files show the
+// same content.
+func (t *Transfer) sameContent(dst api.StorageBackend, srcPath, dstPath string, a, b api.FileInfo) bool {
+	return a.Hash != "" && t.hashesComparable(dst, srcPath, dstPath) &&
+		a.Size == b.Size && strings.EqualFold(a.Hash, b.Hash)
+}
+
+// hashesComparable reports whether the server hashes of two files can be
+// compared. Hashes of encrypted files are those of the ciphertext.
+func (t *Transfer) hashesComparable(dst api.StorageBackend, srcPath, dstPath string) bool {
+	return !encrypted(t.storage, srcPath) && !encrypted(dst, dstPath)
+}
+
+// encrypted reports whether the content of filePath is encrypted by the
+// Koneksi client of storage. Layers encrypting content drop its hashes.
+func encrypted(storage api.StorageBackend, filePath string) bool {
+	c, ok := storage.(*api.Client)
+	return ok && c.Encrypted(filePath)
+}
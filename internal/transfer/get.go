@@ -0,0 +1,194 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+)
+
+// partSuffix marks downloads in progress. An interrupted download is
+// continued from the end of its part file.
+const partSuffix = ".koneksi-part"
+
+// Get copies the remote file or folder src to the local path dst. A file
+// copied onto an existing local directory is placed inside it; a folder's
+// contents always end up in dst itself.
+func (t *Transfer) Get(ctx context.Context, src, dst string) (*Summary, error) {
+	src = path.Clean("/" + src)
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	isDir := src == "/"
+	var root *api.FileInfo
+	if !isDir {
+		if root, err = t.storage.Stat(ctx, src); err != nil {
+			return nil, err
+		}
+		isDir = root.IsDir
+	}
+
+	if !isDir {
+		if info, err := os.Stat(dst); err == nil && info.IsDir() {
+			dst = filepath.Join(dst, path.Base(src))
+		}
+		files := map[string]api.FileInfo{"": *root}
+		return t.get(ctx, src, dst, nil, files)
+	}
+
+	remotes, err := scanRemote(ctx, t.storage, src)
+	if err != nil {
+		return nil, err
+	}
+	delete(remotes, "")
+
+	var dirs []string
+	files := make(map[string]api.FileInfo)
+	for rel, info := range remotes {
+		if info.IsDir {
+			dirs = append(dirs, rel)
+		} else {
+			files[rel] = info
+		}
+	}
+	sort.Strings(dirs)
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, err
+	}
+	return t.get(ctx, src, dst, dirs, files)
+}
+
+func (t *Transfer) get(ctx context.Context, src, dst string, dirs []string, files map[string]api.FileInfo) (*Summary, error) {
+	for _, rel := range dirs {
+		if err := os.MkdirAll(filepath.Join(dst, filepath.FromSlash(rel)), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := t.openState("get", src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var jobs []job
+	skipped := 0
+	for _, rel := range rels {
+		info := files[rel]
+		j := job{rel: rel, size: info.Size, modTime: info.Modified}
+
+		local, err := os.Stat(filepath.Join(dst, filepath.FromSlash(rel)))
+		switch {
+		case state.isDone(j) && err == nil:
+			skipped++
+		case err != nil || t.opts.Checksum:
+			jobs = append(jobs, j)
+		case local.Size() == info.Size && !info.Modified.After(local.ModTime()):
+			skipped++
+		default:
+			jobs = append(jobs, j)
+		}
+	}
+
+	return t.run(state, jobs, skipped, func(j job) (bool, error) {
+		info := files[j.rel]
+		remotePath := path.Join(src, j.rel)
+		localPath := filepath.Join(dst, filepath.FromSlash(j.rel))
+
+		if t.opts.Checksum && info.Hash != "" {
+			if hash, err := hashFile(localPath); err == nil && strings.EqualFold(hash, info.Hash) {
+				return true, nil
+			}
+		}
+
+		if err := t.download(ctx, remotePath, localPath, info); err != nil {
+			return false, err
+		}
+		t.opts.Hooks.Fire(hooks.Event{Event: hooks.Download, Path: remotePath, Local: localPath, Size: info.Size})
+		return false, nil
+	})
+}
+
+// download fetches remotePath into localPath through a part file,
+// continuing a previous partial download if there is one.
+func (t *Transfer) download(ctx context.Context, remotePath, localPath string, info api.FileInfo) error {
+	part := localPath + partSuffix
+
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > info.Size {
+		offset = 0
+	}
+
+	if offset < info.Size {
+		body, partial, err := api.ReadFrom(ctx, t.storage, remotePath, offset)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		if !partial {
+			offset = 0
+		}
+		if err := f.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if t.opts.Progress != nil && offset > 0 {
+			t.opts.Progress.Transferred(offset)
+		}
+
+		r := &progressReader{r: body, progress: t.opts.Progress}
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+	} else if t.opts.Progress != nil {
+		t.opts.Progress.Transferred(info.Size)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if t.opts.Checksum && info.Hash != "" {
+		hash, err := hashFile(part)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(hash, info.Hash) {
+			os.Remove(part)
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", remotePath, info.Hash, hash)
+		}
+	}
+
+	if err := os.Rename(part, localPath); err != nil {
+		return err
+	}
+	// Matching modification times let the next run skip the file.
+	return os.Chtimes(localPath, info.Modified, info.Modified)
+}
@@ -0,0 +1,225 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+)
+
+// Put copies the local file or directory src to the remote path dst. A
+// file copied onto an existing remote folder is placed inside it; a
+// directory's contents always end up at dst itself, so rerunning the same
+// command resumes instead of nesting a second copy.
+func (t *Transfer) Put(ctx context.Context, src, dst string) (*Summary, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	dst = path.Clean("/" + dst)
+
+	if !info.IsDir() {
+		remote, err := t.storage.Stat(ctx, dst)
+		if err != nil && !api.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && remote.IsDir {
+			dst = path.Join(dst, filepath.Base(src))
+			if remote, err = t.storage.Stat(ctx, dst); err != nil && !api.IsNotFound(err) {
+				return nil, err
+			}
+		}
+
+		remotes := map[string]api.FileInfo{}
+		if remote != nil {
+			remotes[""] = *remote
+		}
+		j := job{size: info.Size(), modTime: info.ModTime()}
+		return t.put(ctx, src, dst, nil, []job{j}, remotes)
+	}
+
+	dirs, files, err := scanLocal(src)
+	if err != nil {
+		return nil, err
+	}
+
+	remotes, err := scanRemote(ctx, t.storage, dst)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := remotes[""]; !ok {
+		if err := mkdirAll(ctx, t.storage, dst); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.put(ctx, src, dst, dirs, files, remotes)
+}
+
+func (t *Transfer) put(ctx context.Context, src, dst string, dirs []string, files []job, remotes map[string]api.FileInfo) (*Summary, error) {
+	for _, rel := range dirs {
+		if re, ok := remotes[rel]; ok && re.IsDir {
+			continue
+		}
+		if err := t.storage.Mkdir(ctx, path.Join(dst, rel)); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", path.Join(dst, rel), err)
+		}
+	}
+
+	state, err := t.openState("put", src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []job
+	skipped := 0
+	for _, j := range files {
+		re, exists := remotes[j.rel]
+		switch {
+		case state.isDone(j):
+			skipped++
+		case !exists || re.IsDir || t.opts.Checksum:
+			jobs = append(jobs, j)
+		case re.Size == j.size && !j.modTime.After(re.Modified):
+			skipped++
+		default:
+			jobs = append(jobs, j)
+		}
+	}
+
+	return t.run(state, jobs, skipped, func(j job) (bool, error) {
+		localPath := filepath.Join(src, filepath.FromSlash(j.rel))
+		remotePath := path.Join(dst, j.rel)
+
+		if re, ok := remotes[j.rel]; ok && re.IsDir {
+			return false, fmt.Errorf("%s is a folder on the server", remotePath)
+		}
+		if re, ok := remotes[j.rel]; ok {
+			if err := api.CheckRetention(&re); err != nil {
+				return false, err
+			}
+		}
+		if re, ok := remotes[j.rel]; ok && t.opts.Checksum && re.Hash != "" && re.Size == j.size {
+			hash, err := hashFile(localPath)
+			if err != nil {
+				return false, err
+			}
+			if strings.EqualFold(hash, re.Hash) {
+				return true, nil
+			}
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		r := &progressReaderAt{r: f, progress: t.opts.Progress}
+		if _, err := t.uploader.Upload(ctx, remotePath, r, j.size, nil); err != nil {
+			return false, err
+		}
+		t.opts.Hooks.Fire(hooks.Event{Event: hooks.Upload, Path: remotePath, Local: localPath, Size: j.size})
+		return false, nil
+	})
+}
+
+// mkdirAll creates remote folder p and any missing parents.
+func mkdirAll(ctx context.Context, storage api.StorageBackend, p string) error {
+	if p == "/" {
+		return nil
+	}
+
+	info, err := storage.Stat(ctx, p)
+	if err == nil {
+		if !info.IsDir {
+			return fmt.Errorf("%s is a file on the server", p)
+		}
+		return nil
+	}
+	if !api.IsNotFound(err) {
+		return err
+	}
+
+	if err := mkdirAll(ctx, storage, path.Dir(p)); err != nil {
+		return err
+	}
+	return storage.Mkdir(ctx, p)
+}
+
+// scanLocal lists the directories and regular files below root. Both are
+// sorted, so parents come before their children.
+func scanLocal(root string) ([]string, []job, error) {
+	var dirs []string
+	var files []job
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			dirs = append(dirs, rel)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			// Symlinks, sockets and devices are not copied.
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, job{rel: rel, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	sort.Strings(dirs)
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	return dirs, files, nil
+}
+
+// scanRemote lists the remote tree below root keyed by relative path. The
+// root itself is stored under "" if it exists.
+func scanRemote(ctx context.Context, storage api.StorageBackend, root string) (map[string]api.FileInfo, error) {
+	entries := make(map[string]api.FileInfo)
+
+	err := api.Walk(ctx, storage, root, func(p string, info api.FileInfo) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		entries[rel] = info
+		return nil
+	})
+	if api.IsNotFound(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+
+	entries[""] = api.FileInfo{Path: root, IsDir: true}
+	return entries, nil
+}
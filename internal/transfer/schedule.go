@@ -0,0 +1,62 @@
+package transfer
+
+import "sync"
+
+// largeFileSize is the size from which a file counts as large for
+// scheduling, the default size from which uploads are chunked.
+const largeFileSize = 16 << 20
+
+// scheduler hands jobs to the workers of a transfer so a few large files
+// cannot hold every worker while small files wait: as long as small files
+// are pending, large ones may only occupy some of the workers, leaving a
+// quarter of them, and at least one, for the small ones. Large files are
+// preferred for the workers they may use, as they take longest, and any
+// class gets every worker once the other has none left. Within a class,
+// files keep their order.
+type scheduler struct {
+	mu           sync.Mutex
+	small, large []job // pending
+	runningLarge int
+	largeSlots   int // workers large files may use while small ones wait
+}
+
+func newScheduler(jobs []job, concurrency int) *scheduler {
+	s := &scheduler{largeSlots: concurrency - (concurrency+3)/4}
+	for _, j := range jobs {
+		if j.size >= largeFileSize {
+			s.large = append(s.large, j)
+		} else {
+			s.small = append(s.small, j)
+		}
+	}
+	return s
+}
+
+// next returns the job for a worker that became free, or false when no
+// jobs are left.
+func (s *scheduler) next() (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var j job
+	switch {
+	case len(s.large) > 0 && (s.runningLarge < s.largeSlots || len(s.small) == 0):
+		j, s.large = s.large[0], s.large[1:]
+		s.runningLarge++
+	case len(s.small) > 0:
+		j, s.small = s.small[0], s.small[1:]
+	default:
+		return job{}, false
+	}
+	return j, true
+}
+
+// done is called when a job returned by next has finished.
+func (s *scheduler) done(j job) {
+	if j.size < largeFileSize {
+		return
+	}
+	s.mu.Lock()
+	s.runningLarge--
+	s.mu.Unlock()
+}
@@ -0,0 +1,97 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFile records the files a transfer has completed, one JSON object
+// per line, so an interrupted run can skip them without comparing them
+// again. Appending keeps updates cheap for large trees.
+type stateFile struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	done map[string]stateRecord
+}
+
+type stateRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func openState(path string) (*stateFile, error) {
+	s := &stateFile{
+		path: path,
+		done: make(map[string]stateRecord),
+	}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec stateRecord
+			// A line cut short by a crash is simply ignored.
+			if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+				s.done[rec.Path] = rec
+			}
+		}
+		f.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create transfer state directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transfer state: %w", err)
+	}
+	s.file = f
+
+	return s, nil
+}
+
+// isDone reports whether j was completed by an earlier run and the source
+// has not changed since.
+func (s *stateFile) isDone(j job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.done[j.rel]
+	return ok && rec.Size == j.size && rec.ModTime.Equal(j.modTime)
+}
+
+func (s *stateFile) markDone(j job) error {
+	rec := stateRecord{Path: j.rel, Size: j.size, ModTime: j.modTime}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done[j.rel] = rec
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// close keeps the state for a later run.
+func (s *stateFile) close() error {
+	return s.file.Close()
+}
+
+// remove deletes the state once the transfer is complete.
+func (s *stateFile) remove() error {
+	s.file.Close()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package transfer copies files and directory trees between the local
+// filesystem and Koneksi, or between two Koneksi directories, with
+// parallel workers. Files that are already up to date are skipped, and
+// interrupted runs resume where they stopped.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/hooks"
+	"github.com/koneksi/koneksi-drive/internal/upload"
+)
+
+// Options controls a transfer.
+type Options struct {
+	// Concurrency is the number of files transferred in parallel.
+	Concurrency int
+	// Checksum compares content hashes instead of sizes and
+	// modification times to decide whether a file is up to date.
+	Checksum bool
+	// StateFile records completed files so an interrupted transfer can
+	// be resumed. Empty means a file in the user cache directory derived
+	// from the source and destination.
+	StateFile string
+	// Progress receives updates while the transfer runs; may be nil.
+	Progress Progress
+	// Hooks is told about every file transferred; may be nil.
+	Hooks *hooks.Hooks
+}
+
+// Progress receives updates from a running transfer. Transferred and
+// FileDone are called from several goroutines.
+type Progress interface {
+	// Start is called once with the number and total size of the files
+	// that need transferring.
+	Start(files int, bytes int64)
+	// Transferred reports n more bytes moved.
+	Transferred(n int64)
+	// FileDone reports a finished file. skipped is set when the file
+	// turned out to be up to date; err is nil on success.
+	FileDone(rel string, size int64, skipped bool, err error)
+	// Finish is called when all files are done.
+	Finish()
+}
+
+// Summary describes a finished transfer.
+type Summary struct {
+	Files   int   // files transferred
+	Skipped int   // files already up to date
+	Failed  int   // files that could not be transferred
+	Bytes   int64 // bytes transferred
+}
+
+// Transfer runs put, get and copy operations for the storage of one
+// directory.
+type Transfer struct {
+	directoryID string
+	storage     api.StorageBackend
+	uploader    *upload.Uploader
+	opts        Options
+}
+
+func New(directoryID string, storage api.StorageBackend, uploader *upload.Uploader, opts Options) *Transfer {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &Transfer{
+		directoryID: directoryID,
+		storage:     storage,
+		uploader:    uploader,
+		opts:        opts,
+	}
+}
+
+// job is one file to transfer. rel is its path relative to the roots,
+// using forward slashes; it is empty when a single file is copied.
+type job struct {
+	rel     string
+	size    int64
+	modTime time.Time // of the source, recorded in the state file
+}
+
+// run transfers jobs with the configured number of workers, keeping some
+// of them for small files while large ones are transferred (see
+// scheduler). transfer returns skipped=true when a closer look shows the
+// file is already up to date. A failing file does not stop the others;
+// the state file is kept so the transfer can be resumed, and removed once
+// everything succeeded.
+func (t *Transfer) run(state *stateFile, jobs []job, skipped int, transfer func(job) (bool, error)) (*Summary, error) {
+	summary := &Summary{Skipped: skipped}
+
+	var total int64
+	for _, j := range jobs {
+		total += j.size
+	}
+	if t.opts.Progress != nil {
+		t.opts.Progress.Start(len(jobs), total)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sched := newScheduler(jobs, t.opts.Concurrency)
+
+	for i := 0; i < t.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := sched.next()
+				if !ok {
+					return
+				}
+				upToDate, err := transfer(j)
+				sched.done(j)
+				if err == nil {
+					if stateErr := state.markDone(j); stateErr != nil {
+						slog.Warn("failed to update transfer state", "error", stateErr)
+					}
+				}
+				if t.opts.Progress != nil {
+					if upToDate {
+						t.opts.Progress.Transferred(j.size)
+					}
+					t.opts.Progress.FileDone(j.rel, j.size, upToDate, err)
+				}
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Failed++
+				case upToDate:
+					summary.Skipped++
+				default:
+					summary.Files++
+					summary.Bytes += j.size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if t.opts.Progress != nil {
+		t.opts.Progress.Finish()
+	}
+
+	if summary.Failed > 0 {
+		state.close()
+		return summary, fmt.Errorf("%d of %d files failed; run the same command again to resume",
+			summary.Failed, len(jobs))
+	}
+	if err := state.remove(); err != nil {
+		slog.Warn("failed to remove transfer state", "error", err)
+	}
+	return summary, nil
+}
+
+// progressReader reports bytes read to the transfer's progress.
+type progressReader struct {
+	r        io.Reader
+	progress Progress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.progress != nil {
+		p.progress.Transferred(int64(n))
+	}
+	return n, err
+}
+
+// progressReaderAt reports progress for uploads. Chunked uploads read
+// the file more than once, so only reads beyond the furthest offset seen
+// so far count.
+type progressReaderAt struct {
+	r        io.ReaderAt
+	progress Progress
+
+	mu       sync.Mutex
+	furthest int64
+}
+
+func (p *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.r.ReadAt(b, off)
+	if p.progress == nil {
+		return n, err
+	}
+
+	p.mu.Lock()
+	end := off + int64(n)
+	var delta int64
+	if end > p.furthest {
+		delta = end - p.furthest
+		p.furthest = end
+	}
+	p.mu.Unlock()
+
+	if delta > 0 {
+		p.progress.Transferred(delta)
+	}
+	return n, err
+}
+
+// hashFile returns the hex SHA-256 of a local file.
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// defaultStatePath derives the state file for a transfer from its
+// direction, source and destination, so rerunning the same command finds
+// the state of the interrupted run.
+func defaultStatePath(op, src, dst string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(op + "\x00" + src + "\x00" + dst))
+	return filepath.Join(dir, "koneksi-drive", "transfers", hex.EncodeToString(sum[:8])+".jsonl"), nil
+}
+
+func (t *Transfer) openState(op, src, dst string) (*stateFile, error) {
+	name := t.opts.StateFile
+	if name == "" {
+		var err error
+		if name, err = defaultStatePath(op, src, dst); err != nil {
+			return nil, fmt.Errorf("failed to locate transfer state: %w", err)
+		}
+	}
+	return openState(name)
+}
@@ -0,0 +1,39 @@
+package upload
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// ContentType determines the MIME type to store for remotePath. Configured
+// overrides win, then the extension, then sniffing the first bytes of r.
+// r may be nil when there is no content yet.
+func (u *Uploader) ContentType(remotePath string, r io.ReaderAt, size int64) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(remotePath), "."))
+
+	if ext != "" {
+		if contentType, ok := u.cfg.ContentTypes[ext]; ok {
+			return contentType
+		}
+		if contentType := mime.TypeByExtension("." + ext); contentType != "" {
+			return contentType
+		}
+	}
+
+	if r == nil || size == 0 {
+		return "application/octet-stream"
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
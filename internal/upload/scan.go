@@ -0,0 +1,136 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// RejectedError reports that the malware scanner found a threat in
+// content, which was not uploaded.
+type RejectedError struct {
+	Path        string
+	Threat      string
+	Quarantined string // file the content was kept in, with the quarantine action
+}
+
+func (e *RejectedError) Error() string {
+	msg := fmt.Sprintf("upload of %s rejected: %s found", e.Path, e.Threat)
+	if e.Quarantined != "" {
+		msg += "; quarantined as " + e.Quarantined
+	}
+	return msg
+}
+
+// IsRejected reports whether err is a *RejectedError.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// Scanning reports whether uploads are scanned for malware.
+func (u *Uploader) Scanning() bool {
+	return u.scanner != nil
+}
+
+// Scan checks the first size bytes of r, to be uploaded to remotePath,
+// for malware. It returns a *RejectedError if a threat is found, after
+// quarantining the content if configured. If the scanner fails, the
+// content is refused too unless upload.scan.fail_open is set.
+func (u *Uploader) Scan(remotePath string, r io.ReaderAt, size int64) error {
+	if u.scanner == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Scan.Timeout)
+	defer cancel()
+
+	threat, err := u.scanner.Scan(ctx, remotePath, r, size)
+	if err != nil {
+		if u.cfg.Scan.FailOpen {
+			slog.Warn("malware scan failed, uploading anyway", "path", remotePath, "error", err)
+			return nil
+		}
+		return fmt.Errorf("malware scan failed: %w", err)
+	}
+	if threat == "" {
+		return nil
+	}
+
+	rejected := &RejectedError{Path: remotePath, Threat: threat}
+	if u.cfg.Scan.Action == "quarantine" {
+		name, err := u.quarantine(remotePath, threat, r, size)
+		if err != nil {
+			slog.Error("failed to quarantine content", "path", remotePath, "error", err)
+		}
+		rejected.Quarantined = name
+	}
+	attrs := []any{"path", remotePath, "threat", threat}
+	if rejected.Quarantined != "" {
+		attrs = append(attrs, "quarantined", rejected.Quarantined)
+	}
+	slog.Warn("upload rejected by malware scan", attrs...)
+	return rejected
+}
+
+// quarantineRecord describes quarantined content, in a file next to it.
+type quarantineRecord struct {
+	Path   string    `json:"path"`
+	Threat string    `json:"threat"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+}
+
+// quarantine copies content rejected for threat into the quarantine
+// directory, as a file named after the time and remotePath, and returns
+// its name. The file is not readable by others.
+func (u *Uploader) quarantine(remotePath, threat string, r io.ReaderAt, size int64) (string, error) {
+	dir := u.cfg.Scan.QuarantineDir
+	if dir == "" {
+		var err error
+		if dir, err = DefaultQuarantineDir(); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	f, err := os.CreateTemp(dir, now.Format("20060102-150405")+"-*-"+path.Base(remotePath))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, io.NewSectionReader(r, 0, size))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		var record []byte
+		record, err = json.MarshalIndent(quarantineRecord{Path: remotePath, Threat: threat, Size: size, Time: now}, "", "  ")
+		if err == nil {
+			err = os.WriteFile(f.Name()+".json", record, 0o600)
+		}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// DefaultQuarantineDir returns the directory rejected content is kept in
+// when upload.scan.quarantine_dir is empty.
+func DefaultQuarantineDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "koneksi-drive", "quarantine"), nil
+}
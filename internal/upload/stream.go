@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// UploadStream replaces the content of remotePath with everything read
+// from r, whose size is not known up front, and returns the number of
+// bytes uploaded.
+//
+// Content of up to buffer bytes is held in memory and uploaded as Upload
+// does. Longer content is streamed to the server as it is read, without
+// chunking; verification then compares the hash computed on the way. As
+// scanners need the whole content, longer content is staged in a
+// temporary file instead when uploads are scanned.
+func (u *Uploader) UploadStream(ctx context.Context, remotePath string, r io.Reader, buffer int64) (int64, error) {
+	head, err := io.ReadAll(io.LimitReader(r, buffer+1))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(head)) <= buffer {
+		_, err := u.Upload(ctx, remotePath, bytes.NewReader(head), int64(len(head)), nil)
+		return int64(len(head)), err
+	}
+
+	rest := io.MultiReader(bytes.NewReader(head), r)
+	if u.Scanning() {
+		return u.uploadStaged(ctx, remotePath, rest)
+	}
+
+	contentType := u.ContentType(remotePath, bytes.NewReader(head), int64(len(head)))
+	h := sha256.New()
+	counter := &countingReader{r: io.TeeReader(rest, h)}
+	if err := u.storage.Write(ctx, remotePath, contentType, counter); err != nil {
+		return counter.n, err
+	}
+	if u.cfg.Verify {
+		if err := u.verifyHash(ctx, remotePath, hex.EncodeToString(h.Sum(nil)), counter.n); err != nil {
+			return counter.n, err
+		}
+	}
+	return counter.n, nil
+}
+
+// uploadStaged copies r to a temporary file and uploads that.
+func (u *Uploader) uploadStaged(ctx context.Context, remotePath string, r io.Reader) (int64, error) {
+	f, err := os.CreateTemp("", "koneksi-stream-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage content for scanning: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage content for scanning: %w", err)
+	}
+	_, err = u.Upload(ctx, remotePath, f, size, nil)
+	return size, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,172 @@
+// Package upload sends file content to Koneksi, using chunked uploads
+// for large files so unchanged or already stored chunks are skipped.
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/koneksi/koneksi-drive/internal/api"
+	"github.com/koneksi/koneksi-drive/internal/chunker"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/scan"
+)
+
+// Uploader is shared by the mount and the sync engine.
+type Uploader struct {
+	storage api.StorageBackend
+	chunks  chunkStore // nil if storage does not store chunks
+	cfg     *config.UploadConfig
+	scanner scan.Scanner // nil if uploads are not scanned
+}
+
+// chunkStore is implemented by storage that keeps content as chunks, such
+// as the Koneksi client, for chunked uploads.
+type chunkStore interface {
+	Encrypted(filePath string) bool
+	MissingChunks(ctx context.Context, hashes []string) ([]string, error)
+	UploadChunk(ctx context.Context, hash string, data io.Reader, size int64) error
+	CommitManifest(ctx context.Context, filePath, contentType string, size int64, chunks []api.ChunkRef) error
+}
+
+var _ chunkStore = (*api.Client)(nil)
+
+func New(storage api.StorageBackend, cfg *config.UploadConfig) *Uploader {
+	chunks, _ := storage.(chunkStore)
+	return &Uploader{
+		storage: storage,
+		chunks:  chunks,
+		cfg:     cfg,
+		scanner: scan.New(&cfg.Scan),
+	}
+}
+
+// Chunker returns the configured chunker.
+func (u *Uploader) Chunker() chunker.Chunker {
+	if u.cfg.Chunker == "cdc" {
+		return chunker.NewFastCDC(int(u.cfg.ChunkSize))
+	}
+	return chunker.Fixed{Size: int(u.cfg.ChunkSize)}
+}
+
+// Chunked reports whether remotePath, of the given size, is uploaded in
+// chunks. Encrypted content, and content of storage that does not keep
+// chunks, is always uploaded whole.
+func (u *Uploader) Chunked(remotePath string, size int64) bool {
+	return u.cfg.Delta && size >= u.cfg.DeltaMinSize && u.chunks != nil && !u.chunks.Encrypted(remotePath)
+}
+
+// Upload replaces the content of remotePath with the first size bytes of
+// r. base is the chunk signature of the previous remote content, if known;
+// chunks found in it are assumed to be stored already. When a chunked
+// upload was used, the chunk signature of the new content is returned.
+//
+// With a malware scanner configured, the content is scanned first and
+// not uploaded if a threat is found in it (see Scan). With verification
+// enabled, the stored content is checked afterwards and a *VerifyError is
+// returned if it differs.
+func (u *Uploader) Upload(ctx context.Context, remotePath string, r io.ReaderAt, size int64, base []chunker.Chunk) ([]chunker.Chunk, error) {
+	if err := u.Scan(remotePath, r, size); err != nil {
+		return nil, err
+	}
+
+	chunks, err := u.upload(ctx, remotePath, r, size, base)
+	if err != nil || !u.cfg.Verify {
+		return chunks, err
+	}
+
+	if err := u.verify(ctx, remotePath, r, size); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+func (u *Uploader) upload(ctx context.Context, remotePath string, r io.ReaderAt, size int64, base []chunker.Chunk) ([]chunker.Chunk, error) {
+	contentType := u.ContentType(remotePath, r, size)
+
+	if u.Chunked(remotePath, size) {
+		chunks, err := u.uploadChunked(ctx, remotePath, contentType, r, size, base)
+		if !errors.Is(err, api.ErrChunkedUploadUnsupported) {
+			return chunks, err
+		}
+	}
+
+	return nil, u.storage.Write(ctx, remotePath, contentType, io.NewSectionReader(r, 0, size))
+}
+
+// uploadChunked uploads the chunks of r the server does not have and
+// commits a manifest describing the whole file.
+func (u *Uploader) uploadChunked(ctx context.Context, remotePath, contentType string, r io.ReaderAt, size int64, base []chunker.Chunk) ([]chunker.Chunk, error) {
+	chunks, err := u.Chunker().Split(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks unchanged since the base need no upload; of the rest, the
+	// server may already store some from other files.
+	candidates := chunker.Changed(base, chunks)
+	if len(candidates) > 0 {
+		hashes := make([]string, len(candidates))
+		for i, c := range candidates {
+			hashes[i] = c.Hash
+		}
+		missing, err := u.chunks.MissingChunks(ctx, hashes)
+		if err != nil {
+			return nil, err
+		}
+		candidates = selectChunks(candidates, missing)
+	}
+
+	if err := u.uploadChunks(ctx, r, candidates); err != nil {
+		return nil, err
+	}
+
+	refs := make([]api.ChunkRef, len(chunks))
+	for i, c := range chunks {
+		refs[i] = api.ChunkRef{Hash: c.Hash, Size: c.Size}
+	}
+
+	err = u.chunks.CommitManifest(ctx, remotePath, contentType, size, refs)
+
+	// The base may not be stored as chunks on the server, for example if
+	// it was uploaded whole. Send what is missing and try once more.
+	var missing *api.MissingChunksError
+	if errors.As(err, &missing) {
+		if err := u.uploadChunks(ctx, r, selectChunks(chunks, missing.Hashes)); err != nil {
+			return nil, err
+		}
+		err = u.chunks.CommitManifest(ctx, remotePath, contentType, size, refs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+func (u *Uploader) uploadChunks(ctx context.Context, r io.ReaderAt, chunks []chunker.Chunk) error {
+	for _, c := range chunks {
+		if err := u.chunks.UploadChunk(ctx, c.Hash, io.NewSectionReader(r, c.Offset, c.Size), c.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectChunks returns one chunk for each of the given hashes.
+func selectChunks(chunks []chunker.Chunk, hashes []string) []chunker.Chunk {
+	wanted := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		wanted[h] = true
+	}
+
+	var selected []chunker.Chunk
+	for _, c := range chunks {
+		if wanted[c.Hash] {
+			selected = append(selected, c)
+			delete(wanted, c.Hash)
+		}
+	}
+	return selected
+}
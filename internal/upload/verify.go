@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// VerifyError reports that the content stored on the server differs from
+// the content that was uploaded.
+type VerifyError struct {
+	Path   string
+	Local  string // hex SHA-256 of the uploaded content
+	Remote string // hex SHA-256 of the stored content
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("upload verification failed for %s: local sha256 %s, remote %s",
+		e.Path, e.Local, e.Remote)
+}
+
+// verify checks that remotePath holds the first size bytes of r. The
+// hash reported by the server is used when available; otherwise the file
+// is downloaded again and hashed.
+func (u *Uploader) verify(ctx context.Context, remotePath string, r io.ReaderAt, size int64) error {
+	local, err := hashReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	return u.verifyHash(ctx, remotePath, local, size)
+}
+
+// verifyHash checks that remotePath holds size bytes with the hex SHA-256
+// local.
+func (u *Uploader) verifyHash(ctx context.Context, remotePath, local string, size int64) error {
+	info, err := u.storage.Stat(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("upload verification failed for %s: %w", remotePath, err)
+	}
+
+	remote := info.Hash
+	if remote == "" || info.Size != size {
+		body, err := u.storage.Read(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("upload verification failed for %s: %w", remotePath, err)
+		}
+		remote, err = hashReader(body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("upload verification failed for %s: %w", remotePath, err)
+		}
+	}
+
+	if remote != local {
+		err := &VerifyError{Path: remotePath, Local: local, Remote: remote}
+		slog.Error("uploaded content does not match", "path", remotePath,
+			"local_sha256", local, "remote_sha256", remote)
+		return err
+	}
+
+	slog.Debug("upload verified", "path", remotePath, "sha256", local)
+	return nil
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
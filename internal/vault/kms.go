@@ -0,0 +1,88 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/koneksi/koneksi-drive/internal/config"
+	"github.com/koneksi/koneksi-drive/internal/kms"
+)
+
+// LoadKeys returns the current identities configured in cfg: those in the
+// identity file and the one wrapped by the key management service.
+func LoadKeys(cfg *config.EncryptionConfig) ([]age.Identity, error) {
+	var identities []age.Identity
+	if cfg.IdentityFile != "" {
+		ids, err := LoadIdentities(cfg.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, ids...)
+	}
+	if cfg.KMS.Provider != "" {
+		id, err := unwrapIdentity(&cfg.KMS)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no encryption keys configured (encryption.identity_file or encryption.kms)")
+	}
+	return identities, nil
+}
+
+// unwrapIdentity reads the wrapped key file and has the service unwrap
+// the identity in it. The identity is only ever held in memory.
+func unwrapIdentity(cfg *config.KMSConfig) (age.Identity, error) {
+	data, err := os.ReadFile(cfg.WrappedKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wrapped key: %w", err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a wrapped key: %w", cfg.WrappedKeyFile, err)
+	}
+	key, err := kms.Unwrap(context.Background(), cfg, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	id, err := age.ParseX25519Identity(string(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: unwrapped key is not an age identity: %w", cfg.WrappedKeyFile, err)
+	}
+	return id, nil
+}
+
+// WrapIdentity has the service configured in cfg wrap id and writes the
+// result to the wrapped key file, which must not exist yet.
+func WrapIdentity(cfg *config.KMSConfig, id *age.X25519Identity) (err error) {
+	p, err := kms.New(cfg)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(cfg.WrappedKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(cfg.WrappedKeyFile)
+		}
+	}()
+
+	wrapped, err := p.Wrap(context.Background(), []byte(id.String()))
+	if err != nil {
+		return fmt.Errorf("%s KMS: failed to wrap key: %w", cfg.Provider, err)
+	}
+	_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(wrapped))
+	return err
+}
@@ -0,0 +1,246 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+// Filename encryption modes, as set in encryption.filenames.
+const (
+	NamesOff           = "off"
+	NamesObfuscate     = "obfuscate"
+	NamesKeepExtension = "keep-extension"
+	NamesOrdered       = "ordered"
+)
+
+const (
+	// nameTagSize is the size of the tag that starts an obfuscated name
+	// and serves as the nonce of its encryption.
+	nameTagSize = 16
+	// orderedDigits is the number of base32 digits encoding each byte of
+	// an ordered name; each byte maps to a code below 1<<15.
+	orderedDigits = 3
+	// minNameKeySize is the least length of the filename key.
+	minNameKeySize = 32
+)
+
+// nameDigits are the digits of encrypted names: digits and lowercase
+// letters only, in ascending ASCII order, so encrypted names survive
+// servers that ignore case and sort like the bytes they encode.
+const nameDigits = "0123456789abcdefghijklmnopqrstuv"
+
+var nameEncoding = base32.NewEncoding(nameDigits).WithPadding(base32.NoPadding)
+
+// ErrBadName is returned for a stored name that was not encrypted with the
+// filename key, such as a file uploaded without filename encryption.
+var ErrBadName = errors.New("name was not encrypted with the filename key")
+
+// Names encrypts file and folder names. Encryption is deterministic, as a
+// path must map to the same stored path every time it is looked up:
+// equal names encrypt to equal names, wherever they are.
+type Names struct {
+	mode  string
+	block cipher.Block
+	mac   []byte
+}
+
+// OpenNames reads the filename key, or returns nil if names are not
+// encrypted.
+func OpenNames(cfg *config.EncryptionConfig) (*Names, error) {
+	if !cfg.Enabled || cfg.Filenames == "" || cfg.Filenames == NamesOff {
+		return nil, nil
+	}
+	secret, err := os.ReadFile(cfg.FilenameKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filename key: %w", err)
+	}
+	return NewNames(cfg.Filenames, bytes.TrimSpace(secret))
+}
+
+// NewNames returns the names of mode encrypted with keys derived from
+// secret.
+func NewNames(mode string, secret []byte) (*Names, error) {
+	switch mode {
+	case NamesObfuscate, NamesKeepExtension, NamesOrdered:
+	default:
+		return nil, fmt.Errorf("unknown filename encryption mode %q", mode)
+	}
+	if len(secret) < minNameKeySize {
+		return nil, fmt.Errorf("filename key must be at least %d bytes", minNameKeySize)
+	}
+	block, err := aes.NewCipher(deriveKey(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &Names{mode: mode, block: block, mac: deriveKey(secret, "authentication")}, nil
+}
+
+func deriveKey(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("koneksi-drive filename " + purpose))
+	return m.Sum(nil)
+}
+
+// EncryptPath encrypts each name in the slash-separated path p.
+func (n *Names) EncryptPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		if name != "" {
+			parts[i] = n.Encrypt(name)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// DecryptPath decrypts each name in the slash-separated path p.
+func (n *Names) DecryptPath(p string) (string, error) {
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		if name == "" {
+			continue
+		}
+		plain, err := n.Decrypt(name)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = plain
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Encrypt returns the stored form of a file or folder name.
+func (n *Names) Encrypt(name string) string {
+	switch n.mode {
+	case NamesKeepExtension:
+		stem, ext := splitExt(name)
+		return n.obfuscate(stem) + ext
+	case NamesOrdered:
+		return n.order(name)
+	}
+	return n.obfuscate(name)
+}
+
+// Decrypt returns the name stored as name.
+func (n *Names) Decrypt(name string) (string, error) {
+	switch n.mode {
+	case NamesKeepExtension:
+		stem, ext := splitExt(name)
+		plain, err := n.reveal(stem)
+		return plain + ext, err
+	case NamesOrdered:
+		return n.unorder(name)
+	}
+	return n.reveal(name)
+}
+
+// splitExt splits name before its extension, if it has one. The name of
+// a dot file such as .bashrc is all stem.
+func splitExt(name string) (stem, ext string) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return name, ""
+	}
+	return name[:i], name[i:]
+}
+
+// obfuscate encrypts name as synthetic-IV encryption does: the MAC of the
+// name is the nonce of its encryption, so the result is deterministic and
+// tampering is detected.
+func (n *Names) obfuscate(name string) string {
+	m := hmac.New(sha256.New, n.mac)
+	m.Write([]byte(name))
+	tag := m.Sum(nil)[:nameTagSize]
+
+	out := make([]byte, nameTagSize+len(name))
+	copy(out, tag)
+	cipher.NewCTR(n.block, tag).XORKeyStream(out[nameTagSize:], []byte(name))
+	return nameEncoding.EncodeToString(out)
+}
+
+func (n *Names) reveal(name string) (string, error) {
+	raw, err := nameEncoding.DecodeString(name)
+	if err != nil || len(raw) < nameTagSize {
+		return "", ErrBadName
+	}
+	tag := raw[:nameTagSize]
+	plain := make([]byte, len(raw)-nameTagSize)
+	cipher.NewCTR(n.block, tag).XORKeyStream(plain, raw[nameTagSize:])
+
+	m := hmac.New(sha256.New, n.mac)
+	m.Write(plain)
+	if !hmac.Equal(m.Sum(nil)[:nameTagSize], tag) {
+		return "", ErrBadName
+	}
+	return string(plain), nil
+}
+
+// order encrypts name so that encrypted names sort as the names do. Each
+// byte maps to a code by a strictly increasing function chosen by the key
+// and the bytes before it, and codes are written with a fixed number of
+// digits in ascending order. Besides the order, this shows the server the
+// length of names and which names share a beginning.
+func (n *Names) order(name string) string {
+	var out strings.Builder
+	for i := 0; i < len(name); i++ {
+		code := n.codes(name[:i])[name[i]]
+		for d := orderedDigits - 1; d >= 0; d-- {
+			out.WriteByte(nameDigits[code>>(5*d)&31])
+		}
+	}
+	return out.String()
+}
+
+func (n *Names) unorder(name string) (string, error) {
+	if len(name)%orderedDigits != 0 {
+		return "", ErrBadName
+	}
+	plain := make([]byte, 0, len(name)/orderedDigits)
+	for i := 0; i < len(name); i += orderedDigits {
+		var code int
+		for _, c := range []byte(name[i : i+orderedDigits]) {
+			d := strings.IndexByte(nameDigits, c)
+			if d < 0 {
+				return "", ErrBadName
+			}
+			code = code<<5 | d
+		}
+		codes := n.codes(string(plain))
+		b := 0
+		for b < len(codes) && codes[b] < code {
+			b++
+		}
+		if b == len(codes) || codes[b] != code {
+			return "", ErrBadName
+		}
+		plain = append(plain, byte(b))
+	}
+	return string(plain), nil
+}
+
+// codes returns the codes of the byte values following prefix in a name:
+// each code exceeds the one before by 1 to 128, drawn from the key stream
+// for prefix.
+func (n *Names) codes(prefix string) *[256]int {
+	m := hmac.New(sha256.New, n.mac)
+	m.Write([]byte("ordered\x00" + prefix))
+	stream := make([]byte, 256)
+	cipher.NewCTR(n.block, m.Sum(nil)[:aes.BlockSize]).XORKeyStream(stream, stream)
+
+	var codes [256]int
+	code := -1
+	for i, r := range stream {
+		code += int(r&127) + 1
+		codes[i] = code
+	}
+	return &codes
+}
@@ -0,0 +1,259 @@
+// Package vault encrypts file content before it leaves the machine and
+// decrypts it on the way back. Content is stored in the age format
+// (https://age-encryption.org/v1), so files downloaded from the server
+// can be decrypted with the age command line tool, or with
+// "koneksi-drive decrypt", without this program or its configuration.
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	// chunkSize is the plaintext size of a chunk of an age payload, each
+	// of which carries a tag of tagSize bytes.
+	chunkSize = 64 << 10
+	tagSize   = 16
+	// nonceSize is the size of the nonce that starts an age payload.
+	nonceSize = 16
+	// ageIntro starts every age file.
+	ageIntro = "age-encryption.org/v1\n"
+)
+
+// ErrNotEncrypted is returned when decrypting content that is not in the
+// age format, such as a file uploaded without encryption.
+var ErrNotEncrypted = errors.New("content is not age-encrypted")
+
+// Vault holds the keys content is encrypted to and decrypted with.
+type Vault struct {
+	identities []age.Identity // current keys
+	retired    []age.Identity // old keys, only decrypted with
+	recipients []age.Recipient
+	headerSize int64  // of the age header written for recipients
+	names      *Names // nil if names are not encrypted
+	paths      []string
+	markers    bool
+}
+
+// Open reads the keys configured for encryption, or returns nil if
+// encryption is disabled.
+func Open(cfg *config.EncryptionConfig) (*Vault, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	identities, err := LoadKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []age.Recipient
+	for _, id := range identities {
+		if x, ok := id.(*age.X25519Identity); ok {
+			recipients = append(recipients, x.Recipient())
+		}
+	}
+	if len(cfg.Recipients) > 0 {
+		extra, err := age.ParseRecipients(strings.NewReader(strings.Join(cfg.Recipients, "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption.recipients: %w", err)
+		}
+		recipients = append(recipients, extra...)
+	}
+
+	v := &Vault{identities: identities, recipients: recipients, paths: cfg.Paths, markers: cfg.Markers}
+	for _, path := range cfg.RetiredIdentityFiles {
+		retired, err := LoadIdentities(path)
+		if err != nil {
+			return nil, err
+		}
+		v.retired = append(v.retired, retired...)
+	}
+	if v.headerSize, err = v.measureHeader(); err != nil {
+		return nil, err
+	}
+	if v.names, err = OpenNames(cfg); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Names returns the encryption of file and folder names, or nil if names
+// are stored as they are.
+func (v *Vault) Names() *Names {
+	return v.names
+}
+
+// Scope returns the folders whose content is encrypted and whether
+// folders holding a marker file are as well. With neither, everything is.
+func (v *Vault) Scope() (paths []string, markers bool) {
+	return v.paths, v.markers
+}
+
+// LoadIdentities reads the age identities (secret keys) in path, one per
+// line, as written by age-keygen.
+func LoadIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// measureHeader returns the size of the header of content encrypted to
+// the recipients of v. The header of an X25519 recipient has a fixed
+// size, so encrypting nothing tells the size for every file.
+func (v *Vault) measureHeader() (int64, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, v.recipients...)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	// An empty payload is the nonce and one empty chunk.
+	return int64(buf.Len()) - nonceSize - tagSize, nil
+}
+
+// Encrypt returns a reader of the encrypted content of r.
+func (v *Vault) Encrypt(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := age.Encrypt(pw, v.recipients...)
+		if err == nil {
+			_, err = io.Copy(w, r)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// Decrypt returns a reader of the decrypted content of r, encrypted to a
+// current or a retired key.
+func (v *Vault) Decrypt(r io.Reader) (io.Reader, error) {
+	return Decrypt(r, append(slices.Clip(v.identities), v.retired...))
+}
+
+// Rotating reports whether retired keys are configured, so content may be
+// encrypted to other keys than those used for uploads.
+func (v *Vault) Rotating() bool {
+	return len(v.retired) > 0
+}
+
+// Decrypt returns a reader of the content of r decrypted with one of
+// identities. Corrupted or tampered content fails the read.
+func Decrypt(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if intro, _ := br.Peek(len(ageIntro)); string(intro) != ageIntro {
+		return nil, ErrNotEncrypted
+	}
+
+	plain, err := age.Decrypt(br, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("content was not encrypted to any of the given keys")
+	}
+	return plain, err
+}
+
+// PlainSize returns the size of the content of a file of size bytes on
+// the server, encrypted by v.
+func (v *Vault) PlainSize(size int64) int64 {
+	return plainSize(size, v.headerSize)
+}
+
+func plainSize(size, headerSize int64) int64 {
+	payload := size - headerSize - nonceSize
+	if payload < tagSize {
+		return 0
+	}
+	chunks := (payload + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	return payload - chunks*tagSize
+}
+
+// HeaderPeek is how much of the start of stored content Inspect needs to
+// see: the header of content encrypted to up to 40 X25519 recipients.
+const HeaderPeek = 4 << 10
+
+// Header describes the age header of stored content.
+type Header struct {
+	Size int64
+	// Current is set if the content is encrypted to a current key and to
+	// as many recipients as uploads are now. Content encrypted before the
+	// keys were rotated or recipients added is not.
+	Current bool
+}
+
+// PlainSize returns the size of the content of a file of size bytes
+// stored with header h.
+func (h Header) PlainSize(size int64) int64 {
+	return plainSize(size, h.Size)
+}
+
+// Inspect reads the header at the start of stored content, of which
+// prefix holds at least the first HeaderPeek bytes or all of it.
+func (v *Vault) Inspect(prefix []byte) (Header, error) {
+	if !bytes.HasPrefix(prefix, []byte(ageIntro)) {
+		return Header{}, ErrNotEncrypted
+	}
+	i := bytes.Index(prefix, []byte("\n--- "))
+	if i < 0 {
+		return Header{}, fmt.Errorf("age header longer than %d bytes", HeaderPeek)
+	}
+	end := bytes.IndexByte(prefix[i+1:], '\n')
+	if end < 0 {
+		return Header{}, fmt.Errorf("age header longer than %d bytes", HeaderPeek)
+	}
+	h := Header{Size: int64(i + 1 + end + 1)}
+
+	// Unwrapping the file key with the current keys tells whether they
+	// can decrypt the content, and counts its recipients on the way.
+	probe := &stanzaCounter{identities: v.identities}
+	_, err := age.Decrypt(bytes.NewReader(prefix), probe)
+	var noMatch *age.NoIdentityMatchError
+	switch {
+	case errors.As(err, &noMatch):
+	case err != nil:
+		return Header{}, err
+	default:
+		h.Current = probe.stanzas == len(v.recipients)
+	}
+	return h, nil
+}
+
+// stanzaCounter unwraps file keys with identities, counting the stanzas
+// of the header.
+type stanzaCounter struct {
+	identities []age.Identity
+	stanzas    int
+}
+
+func (c *stanzaCounter) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	c.stanzas = len(stanzas)
+	for _, id := range c.identities {
+		key, err := id.Unwrap(stanzas)
+		if !errors.Is(err, age.ErrIncorrectIdentity) {
+			return key, err
+		}
+	}
+	return nil, age.ErrIncorrectIdentity
+}
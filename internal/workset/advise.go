@@ -0,0 +1,277 @@
+package workset
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/koneksi-drive/internal/config"
+)
+
+const (
+	// headroom is how much larger than the working set a cache should be,
+	// so reading a few more files does not push out the ones in use.
+	headroom = 1.25
+	// pinDays is on how many days a file must have been read to be
+	// suggested for keeping in the cache in preference to others.
+	pinDays = 3
+	// maxPins bounds the suggested patterns.
+	maxPins = 10
+	// minRandomReads is how many separate stretches a file must have
+	// been read in to tell its reads are random.
+	minRandomReads = 4
+
+	minReadahead = 1 << 20
+	maxReadahead = 64 << 20
+	minBlockSize = 16 << 10
+	maxBlockSize = 1 << 20
+)
+
+// Advice is what the working set of a recent period suggests changing.
+// Zero fields suggest keeping the current setting.
+type Advice struct {
+	Files    int   // files read in the period
+	Size     int64 // their size
+	Cached   int64 // the size of those the content cache holds, rather than blocks of them
+	Read     int64 // bytes read from them since recording started
+	Opens    int64
+	Hot      []HotFile // the files opened most, most first
+	Patterns []Pin     // io_rules patterns to keep in the cache with high priority
+
+	CacheSize       int64    // for cache.max_size
+	Readahead       int64    // for mount.stream_readahead
+	ReadaheadRun    int64    // bytes read in a row from large files, on average
+	BlockExtensions []string // to add to mount.block_extensions
+	BlockSize       int64    // for mount.block_size
+	BlockRun        int64    // bytes read in a row from files read at random, on average
+}
+
+// HotFile is a file of the working set.
+type HotFile struct {
+	Path string
+	File
+}
+
+// Pin is a pattern of files read regularly.
+type Pin struct {
+	Pattern string
+	Files   int // files of the working set it matches
+	Days    int // the most days one of them was read on
+}
+
+// Advise derives settings for cfg from the files of s read within window
+// before now.
+func Advise(s *Set, cfg *config.Config, window time.Duration, now time.Time) Advice {
+	var a Advice
+	var recent []HotFile
+	for p, f := range s.Files {
+		if now.Sub(f.Last) > window {
+			continue
+		}
+		recent = append(recent, HotFile{Path: p, File: *f})
+		a.Files++
+		a.Size += f.Size
+		a.Read += f.BytesRead
+		a.Opens += f.Opens
+		if cached(cfg, p) {
+			a.Cached += f.Size
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		if recent[i].Opens != recent[j].Opens {
+			return recent[i].Opens > recent[j].Opens
+		}
+		return recent[i].Path < recent[j].Path
+	})
+	a.Hot = recent
+
+	if cfg.Cache.Enabled {
+		need := int64(float64(a.Cached) * headroom)
+		// A smaller cache only once a whole period was recorded: the
+		// first days of recording say little about what is not needed.
+		shrink := need < cfg.Cache.MaxSize/4 && now.Sub(s.Since) >= window
+		if need > cfg.Cache.MaxSize || shrink {
+			a.CacheSize = roundSize(max(need, 256<<20))
+			if a.CacheSize == cfg.Cache.MaxSize {
+				a.CacheSize = 0
+			}
+		}
+		a.Patterns = pins(recent, cfg, cfg.Cache.MaxSize/10)
+	}
+
+	a.adviseReadahead(recent, cfg)
+	a.adviseBlocks(recent, cfg)
+	return a
+}
+
+// adviseReadahead compares how far large files are read in a row with how
+// far streams fetch ahead: fetching far beyond where readers stop wastes
+// downloads, and long sequential reads go faster with more fetched ahead.
+func (a *Advice) adviseReadahead(recent []HotFile, cfg *config.Config) {
+	var read, runs int64
+	for _, f := range recent {
+		if f.Size < cfg.Mount.StreamMinSize || cfg.Mount.StreamMinSize == 0 || blockRead(cfg, f.Path) {
+			continue
+		}
+		read += f.BytesRead
+		runs += f.Runs
+	}
+	if runs == 0 {
+		return
+	}
+	a.ReadaheadRun = read / runs
+
+	current := cfg.Mount.StreamReadahead
+	var want int64
+	switch {
+	case a.ReadaheadRun < current/2:
+		want = max(pow2(a.ReadaheadRun), minReadahead)
+	case a.ReadaheadRun > 8*current:
+		want = min(4*current, maxReadahead)
+	}
+	if want != current {
+		a.Readahead = want
+	}
+}
+
+// adviseBlocks looks for large files read in short stretches here and
+// there, which reading in blocks serves without downloading them whole.
+func (a *Advice) adviseBlocks(recent []HotFile, cfg *config.Config) {
+	exts := make(map[string]bool)
+	var read, runs int64
+	for _, f := range recent {
+		if f.Runs < minRandomReads || f.Coverage() > 0.5 || f.RunLength() > maxBlockSize {
+			continue
+		}
+		if f.Size < max(16*cfg.Mount.BlockSize, 16<<20) {
+			continue
+		}
+		read += f.BytesRead
+		runs += f.Runs
+		if ext := strings.TrimPrefix(path.Ext(f.Path), "."); ext != "" && !blockRead(cfg, f.Path) {
+			exts[strings.ToLower(ext)] = true
+		}
+	}
+	for ext := range exts {
+		a.BlockExtensions = append(a.BlockExtensions, ext)
+	}
+	sort.Strings(a.BlockExtensions)
+	if runs == 0 {
+		return
+	}
+	a.BlockRun = read / runs
+
+	want := min(max(pow2(a.BlockRun), minBlockSize), maxBlockSize)
+	if cfg.Mount.BlockSize == 0 || want > 2*cfg.Mount.BlockSize || want < cfg.Mount.BlockSize/2 {
+		a.BlockSize = want
+	}
+}
+
+// pins suggests patterns for the files of recent read on several days,
+// no larger than limit and matching no io_rules pattern yet: a folder's
+// when it holds several, otherwise their own paths.
+func pins(recent []HotFile, cfg *config.Config, limit int64) []Pin {
+	byDir := make(map[string][]HotFile)
+	for _, f := range recent {
+		if f.Days >= pinDays && f.Size <= limit && config.MatchIORule(cfg.Mount.IORules, f.Path).Pattern == "" {
+			dir := path.Dir(f.Path)
+			byDir[dir] = append(byDir[dir], f)
+		}
+	}
+
+	var patterns []Pin
+	for dir, files := range byDir {
+		if len(files) >= 3 {
+			pin := Pin{Pattern: pattern(dir, "*"), Files: len(files)}
+			for _, f := range files {
+				pin.Days = max(pin.Days, f.Days)
+			}
+			patterns = append(patterns, pin)
+			continue
+		}
+		for _, f := range files {
+			patterns = append(patterns, Pin{Pattern: pattern(dir, escape(path.Base(f.Path))), Files: 1, Days: f.Days})
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Days != patterns[j].Days {
+			return patterns[i].Days > patterns[j].Days
+		}
+		return patterns[i].Pattern < patterns[j].Pattern
+	})
+	if len(patterns) > maxPins {
+		patterns = patterns[:maxPins]
+	}
+	return patterns
+}
+
+// pattern returns an io_rules pattern for name in the folder dir, which
+// matches below the mount root only.
+func pattern(dir, name string) string {
+	if dir == "/" {
+		return "/" + name
+	}
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	for i, part := range parts {
+		parts[i] = escape(part)
+	}
+	return strings.Join(parts, "/") + "/" + name
+}
+
+// escape quotes the characters patterns treat specially in name.
+func escape(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// cached reports whether the content cache keeps the remote file p when
+// it is read, rather than its blocks being kept in memory or its content
+// being streamed.
+func cached(cfg *config.Config, p string) bool {
+	rule := config.MatchIORule(cfg.Mount.IORules, p)
+	return !rule.NoCache && rule.Read != "stream" && !blockRead(cfg, p)
+}
+
+// blockRead reports whether the mount reads the remote file p in blocks
+// while it is not cached.
+func blockRead(cfg *config.Config, p string) bool {
+	if cfg.Mount.BlockSize == 0 || cfg.Mount.CacheOnly() {
+		return false
+	}
+	if rule := config.MatchIORule(cfg.Mount.IORules, p); rule.Read != "" {
+		return rule.Read == "blocks"
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))
+	for _, e := range cfg.Mount.BlockExtensions {
+		if e == "*" || strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// pow2 returns the smallest power of two of at least n.
+func pow2(n int64) int64 {
+	p := int64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// roundSize rounds n up to a size that reads well in a configuration:
+// a multiple of 256MB, or of a GB from 4GB.
+func roundSize(n int64) int64 {
+	unit := int64(256 << 20)
+	if n >= 4<<30 {
+		unit = 1 << 30
+	}
+	return (n + unit - 1) / unit * unit
+}
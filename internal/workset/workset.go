@@ -0,0 +1,199 @@
+// Package workset records which files of a directory mounts read, how
+// much of them and in which pattern, across mount sessions, so that the
+// advise command can recommend cache and read settings from what is
+// actually used instead of guesses.
+package workset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// slices is how many equal parts of a file are told apart when
+	// recording which of its content was read.
+	slices = 64
+	// retention is how long files not read anymore stay recorded.
+	retention = 30 * 24 * time.Hour
+	// maxFiles bounds the files recorded; the least recently read ones
+	// are dropped first.
+	maxFiles = 20000
+)
+
+// File is what was recorded about reading a file.
+type File struct {
+	Size      int64     `json:"size"`
+	Opens     int64     `json:"opens"` // times opened and read
+	Reads     int64     `json:"reads"`
+	BytesRead int64     `json:"bytes_read"`
+	Runs      int64     `json:"runs"`    // stretches of reads each continuing where the last ended
+	Covered   uint64    `json:"covered"` // the parts of the file read, one bit per slice
+	Days      int       `json:"days"`    // days the file was read on
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
+
+// Coverage returns the fraction of the file that was read.
+func (f *File) Coverage() float64 {
+	if f.Size == 0 {
+		return 1
+	}
+	return float64(bits.OnesCount64(f.Covered)) / slices
+}
+
+// RunLength returns how many bytes were read in a row on average.
+func (f *File) RunLength() int64 {
+	if f.Runs == 0 {
+		return 0
+	}
+	return f.BytesRead / f.Runs
+}
+
+// Set is the working set recorded for a directory.
+type Set struct {
+	Since time.Time        `json:"since"` // when recording started
+	Files map[string]*File `json:"files"` // by remote path
+}
+
+// Path returns the file the working set of directoryID is kept in.
+func Path(directoryID string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(directoryID))
+	return filepath.Join(dir, "koneksi-drive", "workset", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// Load reads the working set kept in name. A missing file gives an empty
+// set.
+func Load(name string) (*Set, error) {
+	s := &Set{Files: make(map[string]*File)}
+
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read working set: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse working set %s: %w", name, err)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]*File)
+	}
+	return s, nil
+}
+
+// Save drops files not read for too long, or beyond the most recently read
+// maxFiles, and writes the set to name atomically.
+func (s *Set) Save(name string, now time.Time) error {
+	s.prune(now)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *Set) prune(now time.Time) {
+	for p, f := range s.Files {
+		if now.Sub(f.Last) > retention {
+			delete(s.Files, p)
+		}
+	}
+	if len(s.Files) <= maxFiles {
+		return
+	}
+
+	paths := make([]string, 0, len(s.Files))
+	for p := range s.Files {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return s.Files[paths[i]].Last.After(s.Files[paths[j]].Last) })
+	for _, p := range paths[maxFiles:] {
+		delete(s.Files, p)
+	}
+}
+
+// Read records a read of n bytes at off from the remote file p of size
+// bytes. opened tells the first read after opening the file, and
+// continued a read starting where the previous one of the same open file
+// ended.
+func (s *Set) Read(p string, size, off int64, n int, opened, continued bool, now time.Time) {
+	if s.Since.IsZero() {
+		s.Since = now
+	}
+	f := s.Files[p]
+	if f == nil {
+		f = &File{First: now}
+		s.Files[p] = f
+	}
+	if f.Size != size {
+		// Another version: what was read of the old one says little.
+		f.Size = size
+		f.Covered = 0
+	}
+
+	if f.Last.IsZero() || !sameDay(f.Last, now) {
+		f.Days++
+	}
+	f.Last = now
+	if opened {
+		f.Opens++
+	}
+	if !continued {
+		f.Runs++
+	}
+	f.Reads++
+
+	end := min(off+int64(n), size)
+	if end <= off {
+		return
+	}
+	f.BytesRead += end - off
+	// The slices overlapping [off, end); with files smaller than slices
+	// bytes, one byte covers several.
+	first, last := off*slices/size, (end*slices-1)/size
+	for i := first; i <= last; i++ {
+		f.Covered |= 1 << i
+	}
+}
+
+// Rename moves what was recorded about the file oldPath, or about the
+// files below the folder oldPath, to newPath.
+func (s *Set) Rename(oldPath, newPath string) {
+	moved := make(map[string]*File)
+	for p, f := range s.Files {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			delete(s.Files, p)
+			moved[newPath+strings.TrimPrefix(p, oldPath)] = f
+		}
+	}
+	for p, f := range moved {
+		s.Files[p] = f
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}